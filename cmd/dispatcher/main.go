@@ -32,6 +32,14 @@ type specification struct {
 	ProjectID      string `envconfig:"PROJECT_ID" required:"true"`
 	ResultTopicID  string `envconfig:"RESULT_TOPIC_ID" required:"true"`
 	SubscriptionID string `envconfig:"SUBSCRIPTION_ID" required:"true"`
+	Name           string `envconfig:"NAME"`
+	Region         string `envconfig:"REGION"`
+	EgressIP       string `envconfig:"EGRESS_IP"`
+
+	BreakerThreshold int           `envconfig:"BREAKER_THRESHOLD" default:"10"`
+	BreakerCooldown  time.Duration `envconfig:"BREAKER_COOLDOWN" default:"5m"`
+
+	MaxRetryAfter time.Duration `envconfig:"MAX_RETRY_AFTER" default:"10m"`
 
 	WorkerName   string                 `envconfig:"WORKER_NAME" required:"true"`
 	WorkerConfig dispatch.WorkerOptions `envconfig:"WORKER_CONFIG" required:"true"`
@@ -68,6 +76,14 @@ func main() {
 		ProjectID:      spec.ProjectID,
 		SubscriptionID: spec.SubscriptionID,
 		ResultTopicID:  spec.ResultTopicID,
+		Name:           spec.Name,
+		Region:         spec.Region,
+		EgressIP:       spec.EgressIP,
+
+		BreakerThreshold: spec.BreakerThreshold,
+		BreakerCooldown:  spec.BreakerCooldown,
+
+		MaxRetryAfter: spec.MaxRetryAfter,
 	}, worker)
 	if err != nil {
 		log.Fatal(err)
@@ -15,27 +15,97 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
 	"github.com/praetorian-inc/trident/pkg/auth/cloudflare"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/rpc"
 	"github.com/praetorian-inc/trident/pkg/scheduler"
 	"github.com/praetorian-inc/trident/pkg/server"
 )
 
-type specification struct {
-	// trident server configuration options
+// databaseSpec holds the options needed to connect to the database, which
+// are all the migrate command reads.
+type databaseSpec struct {
 	LogLevel           string `envconfig:"LOG_LEVEL" default:"INFO"`
-	AdminListenerPort  int    `envconfig:"ADMIN_LISTENING_PORT" default:"9999"`
 	DBConnectionString string `envconfig:"DB_CONNECTION_STRING" required:"true"`
 
+	// database connection pool, and the retries of idempotent queries after
+	// a transient error (a negative count disables them)
+	DBMaxOpenConns    int           `envconfig:"DB_MAX_OPEN_CONNS" default:"20"`
+	DBMaxIdleConns    int           `envconfig:"DB_MAX_IDLE_CONNS" default:"5"`
+	DBConnMaxLifetime time.Duration `envconfig:"DB_CONN_MAX_LIFETIME" default:"30m"`
+	DBRetries         int           `envconfig:"DB_RETRIES" default:"3"`
+	DBRetryDelay      time.Duration `envconfig:"DB_RETRY_DELAY" default:"200ms"`
+
+	// apply pending schema migrations on start, otherwise refuse to start
+	// until they are applied with the migrate command
+	DBAutoMigrate bool `envconfig:"DB_AUTO_MIGRATE" default:"true"`
+}
+
+// options returns the options the database is opened with.
+func (d databaseSpec) options() db.Options {
+	return db.Options{
+		MaxOpenConns:    d.DBMaxOpenConns,
+		MaxIdleConns:    d.DBMaxIdleConns,
+		ConnMaxLifetime: d.DBConnMaxLifetime,
+		Retries:         d.DBRetries,
+		RetryDelay:      d.DBRetryDelay,
+		AutoMigrate:     d.DBAutoMigrate,
+	}
+}
+
+type specification struct {
+	databaseSpec
+
+	// trident server configuration options
+	AdminListenerPort int `envconfig:"ADMIN_LISTENING_PORT" default:"9999"`
+
+	// largest campaign request accepted in bytes, once decompressed, -1 to
+	// accept any size
+	MaxRequestBody int64 `envconfig:"MAX_REQUEST_BODY" default:"268435456"`
+
+	// serve the gRPC transport (streaming results) on this port, 0 to
+	// disable it
+	GRPCListenerPort int `envconfig:"GRPC_LISTENING_PORT"`
+
+	// serve the read-only dashboard at /ui, also enabled by --web-ui
+	WebUI bool `envconfig:"WEB_UI"`
+
+	// audit log of every guess, without passwords: "on", "hashed" to record
+	// HMAC-SHA256 digests of the usernames, or empty to disable it
+	Audit scheduler.AuditMode `envconfig:"AUDIT_LOG"`
+
+	// secret the usernames of the hashed audit log are keyed with
+	AuditSecret string `envconfig:"AUDIT_SECRET"`
+
+	// webhook sent a notification of every valid result, at least once
+	NotifyURL string `envconfig:"NOTIFY_WEBHOOK_URL"`
+
+	// shared secret the notifications are signed with, unsigned if empty
+	NotifySecret string `envconfig:"NOTIFY_WEBHOOK_SECRET"`
+
+	// results written to the database per batch, the longest a result waits
+	// for its batch, and the results held waiting to be written before new
+	// results are left in pubsub
+	IngestBatchSize     int           `envconfig:"INGEST_BATCH_SIZE" default:"5000"`
+	IngestFlushInterval time.Duration `envconfig:"INGEST_FLUSH_INTERVAL" default:"3s"`
+	IngestBuffer        int           `envconfig:"INGEST_BUFFER" default:"5000"`
+
 	// cloudflare configuration options
 	AuthDomain string `envconfig:"CF_AUTH_DOMAIN"`
 	PolicyAUD  string `envconfig:"CF_AUDIENCE"`
@@ -45,6 +115,9 @@ type specification struct {
 	TopicID        string `envconfig:"TOPIC_ID" required:"true"`
 	SubscriptionID string `envconfig:"SUBSCRIPTION_ID" required:"true"`
 
+	// worker regions and their geo tags, as JSON (ex: {"europe-west3":["de","eu"]})
+	Regions scheduler.Regions `envconfig:"REGIONS"`
+
 	// redis configuration options
 	RedisURI      string `envconfig:"REDIS_URI" required:"true"`
 	RedisPassword string `envconfig:"REDIS_PASSWORD"`
@@ -53,7 +126,12 @@ type specification struct {
 var spec specification
 
 func init() {
-	err := envconfig.Process("orchestrator", &spec)
+	var target interface{} = &spec
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		// migrations only need the database
+		target = &spec.databaseSpec
+	}
+	err := envconfig.Process("orchestrator", target)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -63,6 +141,8 @@ func init() {
 		log.Fatal(err)
 	}
 
+	flag.BoolVar(&spec.WebUI, "web-ui", spec.WebUI, "serve the read-only dashboard at /ui")
+
 	log.SetLevel(level)
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
@@ -72,9 +152,14 @@ func init() {
 }
 
 func main() {
-	finish := make(chan bool)
+	flag.Parse()
 
-	db, err := db.New(spec.DBConnectionString)
+	if flag.Arg(0) == "migrate" {
+		migrate(flag.Args()[1:])
+		return
+	}
+
+	db, err := db.New(spec.DBConnectionString, spec.options())
 	if err != nil {
 		log.WithFields(log.Fields{
 			"connectionstring": spec.DBConnectionString,
@@ -89,14 +174,24 @@ func main() {
 		SubscriptionID: spec.SubscriptionID,
 		RedisURI:       spec.RedisURI,
 		RedisPassword:  spec.RedisPassword,
+		Regions:        spec.Regions,
+		Audit:          spec.Audit,
+		AuditSecret:    spec.AuditSecret,
+		NotifyURL:      spec.NotifyURL,
+		NotifySecret:   spec.NotifySecret,
+
+		IngestBatchSize:     spec.IngestBatchSize,
+		IngestFlushInterval: spec.IngestFlushInterval,
+		IngestBuffer:        spec.IngestBuffer,
 	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	s := &server.Server{
-		DB:  db,
-		Sch: sch,
+		DB:          db,
+		Sch:         sch,
+		MaxBodySize: spec.MaxRequestBody,
 	}
 
 	log.WithFields(log.Fields{
@@ -119,28 +214,78 @@ func main() {
 	// Insert authenication middleware to verify JWTs on all requests
 	r.Use(cloudflare.Verifier(spec.AuthDomain, spec.PolicyAUD))
 
+	// accept gzip compressed request bodies, e.g. large campaigns
+	r.Use(server.Decompress)
+
 	// routes
 	r.Get("/healthz", s.HealthzHandler)
+	r.Get("/stats", s.StatsHandler)
+	r.Get("/workers", s.WorkersHandler)
 	r.Post("/campaign/status", s.StatusUpdateHandler)
+	r.Post("/campaign/status/all", s.StatusUpdateAllHandler)
 	r.Post("/campaign", s.CampaignHandler)
+	r.Post("/campaign/preview", s.CampaignPreviewHandler)
+	r.Post("/campaign/users", s.CampaignUsersHandler)
+	r.Post("/campaign/seek", s.CampaignSeekHandler)
+	r.Post("/campaign/resolve", s.CampaignResolveHandler)
 	r.Post("/results", s.ResultsHandler)
 	r.Get("/list", s.CampaignListHandler)
 	r.Post("/describe", s.CampaignDescribeHandler)
+	r.Post("/audit", s.AuditHandler)
+	if spec.WebUI {
+		r.Get("/ui", s.WebUIHandler)
+	}
 
 	go func() {
 		log.Printf("starting server on port %d", spec.AdminListenerPort)
 		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", spec.AdminListenerPort), r))
 	}()
 
+	if spec.GRPCListenerPort != 0 {
+		g := grpc.NewServer(grpc.StreamInterceptor(cloudflare.StreamVerifier(spec.AuthDomain, spec.PolicyAUD)))
+		(&rpc.Server{DB: db}).Register(g)
+
+		go func() {
+			log.Printf("starting grpc server on port %d", spec.GRPCListenerPort)
+			lis, err := net.Listen("tcp", fmt.Sprintf(":%d", spec.GRPCListenerPort))
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Fatal(g.Serve(lis))
+		}()
+	}
+
 	go func() {
 		log.Printf("starting scheduler task production to %s", spec.TopicID)
 		sch.ProduceTasks()
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	consumed := make(chan struct{})
 	go func() {
 		log.Printf("starting scheduler result consumption from %s", spec.SubscriptionID)
-		log.Fatal(sch.ConsumeResults())
+		err := sch.ConsumeResults(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		close(consumed)
+	}()
+
+	go func() {
+		log.Printf("starting result retention every %s", scheduler.RetentionInterval)
+		sch.PurgeExpired()
+	}()
+
+	go func() {
+		log.Printf("starting deadline checks every %s", scheduler.DeadlineInterval)
+		sch.EnforceDeadlines()
 	}()
 
-	<-finish
+	// on shutdown, stop taking results and write the ones already taken
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	log.Printf("shutting down, writing the results received")
+	cancel()
+	<-consumed
 }
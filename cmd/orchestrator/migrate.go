@@ -0,0 +1,79 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const migrateUsage = `usage: orchestrator migrate <command>
+
+commands:
+  status           print the schema version of the database and of this build
+  up               apply the pending migrations
+  down <version>   revert the migrations newer than the version`
+
+// migrate runs the migrate command with the provided arguments.
+func migrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	tdb, err := db.Open(spec.DBConnectionString, spec.options())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tdb.Close() // nolint:errcheck
+
+	switch {
+	case args[0] == "status" && len(args) == 1:
+		version, err := tdb.SchemaVersion()
+		if err != nil {
+			log.Fatalf("error reading the schema version: %s", err)
+		}
+		fmt.Printf("database schema version: %d\n", version)
+		fmt.Printf("required by this build:  %d\n", db.SchemaVersion)
+	case args[0] == "up" && len(args) == 1:
+		applied, err := tdb.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("error migrating up: %s", err)
+		}
+		if len(applied) == 0 {
+			fmt.Printf("schema is up to date at version %d\n", db.SchemaVersion)
+		}
+	case args[0] == "down" && len(args) == 2:
+		target, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("error parsing version %q: %s", args[1], err)
+		}
+		reverted, err := tdb.MigrateDown(target)
+		for _, m := range reverted {
+			fmt.Printf("reverted %d: %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatalf("error migrating down: %s", err)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
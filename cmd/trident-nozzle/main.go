@@ -29,8 +29,18 @@ import (
 	"github.com/praetorian-inc/trident/pkg/nozzle"
 
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/generic"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/gitlab"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/jenkins"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ldap"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mail"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ntlm"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
 	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/salesforce"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/vmware"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/windows"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/wordpress"
 )
 
 var (
@@ -8,9 +8,11 @@ require (
 	github.com/cloudflare/cloudflared v0.0.0-20200820175612-810d268c99ac
 	github.com/coreos/go-oidc/v3 v3.0.0-alpha.1
 	github.com/go-chi/chi v4.1.2+incompatible
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-openapi/strfmt v0.19.5 // indirect
 	github.com/go-redis/redis/v7 v7.4.0
 	github.com/golang/gddo v0.0.0-20200715224205-051695c33a3f
+	github.com/hirochachacha/go-smb2 v1.0.2
 	github.com/jedib0t/go-pretty v4.3.0+incompatible
 	github.com/jinzhu/gorm v1.9.16
 	github.com/kelseyhightower/envconfig v1.4.0
@@ -18,6 +20,10 @@ require (
 	github.com/mattn/go-runewidth v0.0.9 // indirect
 	github.com/sirupsen/logrus v1.6.0
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.7.1
+	github.com/xeipuuv/gojsonschema v1.2.0
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
 	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
+	google.golang.org/grpc v1.30.0
 )
@@ -0,0 +1,48 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloudflare
+
+import (
+	"github.com/coreos/go-oidc/v3/oidc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// StreamVerifier returns a gRPC interceptor that is used to verify the
+// Cloudflare access token of each stream
+func StreamVerifier(authDomain string, policyAUD string) grpc.StreamServerInterceptor {
+	return VerifyStreamToken(NewVerifier(authDomain, policyAUD))
+}
+
+// VerifyStreamToken is a gRPC interceptor to verify a CF Access token, which
+// Cloudflare forwards as the cf-access-jwt-assertion metadata
+func VerifyStreamToken(verifier *oidc.IDTokenVerifier) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		tokens := md.Get("cf-access-jwt-assertion")
+		if len(tokens) == 0 || tokens[0] == "" {
+			return status.Error(codes.Unauthenticated, "No token on the request")
+		}
+
+		// Verify the access token
+		_, err := verifier.Verify(ss.Context(), tokens[0])
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "Invalid token: %s", err)
+		}
+		return handler(srv, ss)
+	}
+}
@@ -28,6 +28,16 @@ import (
 
 // Verifier returns a function that is used to verify the Cloudflare access token
 func Verifier(authDomain string, policyAUD string) func(http.Handler) http.Handler {
+	verifier := NewVerifier(authDomain, policyAUD)
+
+	return func(next http.Handler) http.Handler {
+		return VerifyToken(verifier)(next)
+	}
+}
+
+// NewVerifier returns the verifier of Cloudflare access tokens issued by the
+// team domain for the application audience
+func NewVerifier(authDomain string, policyAUD string) *oidc.IDTokenVerifier {
 	u, err := url.Parse(authDomain)
 	if err != nil {
 		log.Fatalf("authDomain not a valid url: %s", err)
@@ -44,11 +54,7 @@ func Verifier(authDomain string, policyAUD string) func(http.Handler) http.Handl
 
 	ctx := context.TODO()
 	keySet := oidc.NewRemoteKeySet(ctx, certsURL)
-	verifier := oidc.NewVerifier(authDomain, keySet, config)
-
-	return func(next http.Handler) http.Handler {
-		return VerifyToken(verifier)(next)
-	}
+	return oidc.NewVerifier(authDomain, keySet, config)
 }
 
 // VerifyToken is a middleware to verify a CF Access token
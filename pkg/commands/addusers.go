@@ -0,0 +1,126 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var addUsersCmd = &cobra.Command{
+	Use:   "add-users",
+	Short: "append users to a running campaign",
+	Long: `can be used to append additional usernames to an existing campaign.
+	users already in the campaign are skipped and every campaign password is
+	scheduled for the new users within the campaign's remaining window.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		addUsersPost(cmd, args)
+	},
+}
+
+// addUsersResponse mirrors the report returned by the orchestrator's
+// /campaign/users endpoint
+type addUsersResponse struct {
+	Added      int  `json:"added"`
+	Duplicates int  `json:"duplicates"`
+	Scheduled  int  `json:"scheduled"`
+	Dropped    int  `json:"dropped"`
+	FitsWindow bool `json:"fits_window"`
+}
+
+func init() {
+	addUsersCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	err := addUsersCmd.MarkFlagRequired("campaign")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	addUsersCmd.Flags().StringVarP(&flagUsernameFile, "userfile", "u", "",
+		"file of usernames to append (newline separated)")
+	err = addUsersCmd.MarkFlagRequired("userfile")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	addUsersCmd.Flags().BoolVar(&flagSkipBadLines, "skip-bad-lines", false,
+		"leave out lines of the user file which are too long, not UTF-8, or hold NUL bytes, instead of aborting")
+	addUsersCmd.Flags().BoolVar(&flagNoComments, "no-comments", false,
+		"keep lines of the user file starting with the comment-prefix (default #)")
+
+	campaignCmd.AddCommand(addUsersCmd)
+}
+
+// addUsersPost will read the provided user file and post it to the
+// orchestrator, which dedups and schedules the new users
+func addUsersPost(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	campaignID := mustResolveCampaign(campaignRef)
+
+	users, err := readLines(flagUsernameFile, newLineOptions(flagSkipBadLines, flagNoComments))
+	if err != nil {
+		log.Fatalf("error reading lines from user file: %s", err)
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ID":    campaignID,
+		"Users": users,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := newRequest("POST", orchestrator+"/campaign/users", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	// handle the results from the server
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error adding users to campaign: %s", err)
+	}
+
+	var res addUsersResponse
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+
+	fmt.Printf("Users added:        %d\n", res.Added)
+	fmt.Printf("Duplicates skipped: %d\n", res.Duplicates)
+	fmt.Printf("Attempts scheduled: %d\n", res.Scheduled)
+	if !res.FitsWindow {
+		log.Warnf("%d attempts did not fit in the remaining campaign window and were dropped", res.Dropped)
+	}
+}
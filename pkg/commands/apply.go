@@ -0,0 +1,159 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// path to a YAML or JSON manifest listing the campaigns to create
+	flagManifestFile string
+)
+
+var campaignApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "create every campaign listed in a manifest file",
+	Long: `can be used to create several campaigns from a single YAML or JSON
+manifest. every campaign is validated before any is sent, and all of them are
+sent after a single confirmation`,
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignApply(cmd, args)
+	},
+}
+
+func init() {
+	campaignApplyCmd.Flags().StringVarP(&flagManifestFile, "file", "f", "",
+		"manifest of campaigns (YAML or JSON)")
+	err := campaignApplyCmd.MarkFlagRequired("file")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	campaignCmd.AddCommand(campaignApplyCmd)
+}
+
+// readManifest reads the campaign specs from a manifest of the form:
+//
+//	campaigns:
+//	  - userfile: users.txt
+//	    passfile: passwords.txt
+//	    auth-provider: okta
+//	    interval: 1h
+//
+// The keys of each campaign match the create flags. Relative user, password and
+// exclude-users paths are resolved against the manifest's directory.
+func readManifest(path string) ([]campaignSpec, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	err := v.ReadInConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []campaignSpec
+	err = v.UnmarshalKey("campaigns", &specs)
+	if err != nil {
+		return nil, err
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("manifest %s does not list any campaigns", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range specs {
+		if specs[i].Provider == "" {
+			specs[i].Provider = "okta"
+		}
+		for _, p := range []*string{&specs[i].UserFile, &specs[i].PassFile, &specs[i].UserPass, &specs[i].Exclude} {
+			if *p != "" && !filepath.IsAbs(*p) && !strings.HasPrefix(*p, secretPrefix) {
+				*p = filepath.Join(dir, *p)
+			}
+		}
+	}
+	return specs, nil
+}
+
+func campaignApply(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	specs, err := readManifest(flagManifestFile)
+	if err != nil {
+		log.Fatalf("error reading manifest: %s", err)
+	}
+
+	// validate every campaign before sending any of them
+	campaigns := make([]*campaignRequest, len(specs))
+	var summaries []string
+	var invalid int
+	for i, spec := range specs {
+		var summary string
+		campaigns[i], summary, err = spec.build()
+		if err == nil && spec.Runtime > 0 {
+			err = checkMaxRuntime(orchestrator, campaigns[i], spec.Runtime)
+		}
+		if err != nil {
+			log.Errorf("campaign %d: %s", i+1, err)
+			invalid++
+			continue
+		}
+		for _, w := range checkCredentialFiles(campaigns[i].Provider, campaigns[i].Users, campaigns[i].Passwords) {
+			log.Warnf("campaign %d: %s", i+1, w)
+		}
+		summaries = append(summaries, summary)
+	}
+	if invalid > 0 {
+		log.Fatalf("%d of %d campaigns are invalid, none were sent", invalid, len(specs))
+	}
+
+	var attempts int
+	for i, summary := range summaries {
+		fmt.Printf("\n(%d/%d)%s", i+1, len(summaries), summary)
+		attempts += campaigns[i].attempts()
+	}
+	fmt.Printf("%d campaigns, %d attempts in total\n\n", len(campaigns), attempts)
+	if !confirm(fmt.Sprintf("Send %d campaigns?", len(campaigns))) {
+		log.Printf("not sending campaigns")
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"#", "provider", "campaign id", "name", "result"})
+
+	var failed int
+	for i, campaign := range campaigns {
+		created, err := sendCampaign(orchestrator, campaign)
+		if err != nil {
+			failed++
+			t.AppendRow(table.Row{i + 1, campaign.Provider, "", campaign.Name, err})
+			continue
+		}
+		t.AppendRow(table.Row{i + 1, campaign.Provider, created.ID, created.Name, "created"})
+	}
+	t.Render()
+
+	if failed > 0 {
+		log.Fatalf("%d of %d campaigns failed", failed, len(campaigns))
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// the ID or name of the campaign to export the audit log of, empty for
+	// every campaign
+	auditCampaign string
+
+	// the desired format for the exported audit log (csv, json)
+	auditOutputFormat string
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "top-level command for the attempt audit log",
+	Long: `used by an operator to retrieve the append-only audit log of every
+	credential guess, which the orchestrator records when AUDIT_LOG is set.
+	The audit log never contains passwords.`,
+}
+
+var auditExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export the audit log",
+	Long: `writes the audit log of a campaign, or of every campaign, to stdout
+	so it can be handed to the client as evidence of what was sprayed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		auditExport(cmd, args)
+	},
+}
+
+func init() {
+	auditExportCmd.Flags().StringVarP(&auditCampaign, "campaign", "c", "",
+		"the identifier or name of the campaign to export (default: every campaign)")
+	auditExportCmd.Flags().StringVarP(&auditOutputFormat, "output-format", "o", "csv",
+		"output format (csv, json)")
+
+	auditCmd.AddCommand(auditExportCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// auditExport requests the audit log from the orchestrator and writes it to
+// stdout as CSV or JSON.
+func auditExport(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	if auditOutputFormat != "csv" && auditOutputFormat != "json" {
+		log.Fatalf("unknown output format %q", auditOutputFormat)
+	}
+
+	var campaignID uint
+	if auditCampaign != "" {
+		campaignID = mustResolveCampaign(auditCampaign)
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"campaign_id": campaignID,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := newRequest("POST", orchestrator+"/audit", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error exporting audit log: %s", err)
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("error reading response body: %s", err)
+	}
+
+	if auditOutputFormat == "json" {
+		fmt.Print(string(respBody))
+		return
+	}
+
+	var entries []db.AuditEntry
+	err = json.Unmarshal(respBody, &entries)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	err = w.Write([]string{
+		"id", "timestamp", "campaign_id", "username", "username_hashed",
+		"provider", "region", "result",
+	})
+	if err != nil {
+		log.Fatalf("error writing csv: %s", err)
+	}
+	for _, e := range entries {
+		err = w.Write([]string{
+			strconv.FormatUint(uint64(e.ID), 10),
+			e.Timestamp.Format(time.RFC3339Nano),
+			strconv.FormatUint(uint64(e.CampaignID), 10),
+			e.Username,
+			strconv.FormatBool(e.UsernameHashed),
+			e.Provider,
+			e.Region,
+			string(e.Result),
+		})
+		if err != nil {
+			log.Fatalf("error writing csv: %s", err)
+		}
+	}
+	w.Flush()
+	if err = w.Error(); err != nil {
+		log.Fatalf("error writing csv: %s", err)
+	}
+}
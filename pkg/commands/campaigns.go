@@ -15,7 +15,13 @@
 package commands
 
 import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var campaignCmd = &cobra.Command{
@@ -26,6 +32,68 @@ var campaignCmd = &cobra.Command{
 	specific ongoing campaigns`,
 }
 
+// do not show progress while campaigns are uploaded
+var flagQuiet bool
+
 func init() {
+	campaignCmd.PersistentFlags().BoolVarP(&flagQuiet, "quiet", "q", false,
+		"do not show progress while campaigns are uploaded")
+
 	rootCmd.AddCommand(campaignCmd)
 }
+
+// resolveCampaign returns the ID of the campaign referred to by ref, which is
+// either a campaign ID or a campaign name. Names are resolved by the
+// orchestrator.
+func resolveCampaign(orchestrator, ref string) (uint, error) {
+	if id, err := strconv.ParseUint(ref, 10, 0); err == nil {
+		return uint(id), nil
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"name": ref,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := newRequest("POST", orchestrator+"/campaign/resolve", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return 0, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return 0, err
+	}
+
+	var resolved struct {
+		ID uint `json:"id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&resolved)
+	if err != nil {
+		return 0, err
+	}
+	return resolved.ID, nil
+}
+
+// mustResolveCampaign resolves the campaign flag of a command, exiting if the
+// campaign cannot be found.
+func mustResolveCampaign(ref string) uint {
+	id, err := resolveCampaign(viper.GetString("orchestrator-url"), ref)
+	if err != nil {
+		log.Fatalf("error resolving campaign %q: %s", ref, err)
+	}
+	return id
+}
@@ -17,7 +17,6 @@ package commands
 import (
 	"bytes"
 	"encoding/json"
-	"net/http"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -36,8 +35,8 @@ var cancelCommand = &cobra.Command{
 }
 
 func init() {
-	cancelCommand.Flags().UintVarP(&campaignID, "campaign", "c", 0,
-		"the identifier of the campaign.")
+	cancelCommand.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
 	err := cancelCommand.MarkFlagRequired("campaign")
 	if err != nil {
 		log.Fatalf("issue during argument parsing: %s", err)
@@ -60,7 +59,7 @@ func updateStatus(cID uint, status db.CampaignStatus) {
 		log.Fatalf("error encoding cancel json request: %s", err)
 	}
 
-	req, err := http.NewRequest("POST", orchestrator+"/campaign/status", buf)
+	req, err := newRequest("POST", orchestrator+"/campaign/status", buf)
 
 	if err != nil {
 		log.Fatalf("error during request creation: %s", err)
@@ -72,20 +71,66 @@ func updateStatus(cID uint, status db.CampaignStatus) {
 		log.Fatalf("error during authentication: %s", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
 		log.Fatalf("error sending request: %s", err)
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
 	// handle the results from the server
-	if resp.StatusCode != 200 {
-		log.Fatalf("error cancelling campaign from server: %d", resp.StatusCode)
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error cancelling campaign from server: %s", err)
+	}
+}
+
+// updateAllStatus sets the status of every campaign which can be moved to the
+// provided status and returns the number of campaigns updated.
+func updateAllStatus(status db.CampaignStatus) int64 {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(map[string]interface{}{
+		"Status": status,
+	})
+	if err != nil {
+		log.Fatalf("error encoding status json request: %s", err)
+	}
+
+	req, err := newRequest("POST", orchestrator+"/campaign/status/all", buf)
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error updating campaigns from server: %s", err)
+	}
+
+	var updated struct {
+		Count int64 `json:"count"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&updated)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
 	}
+	return updated.Count
 }
 
 // cancelPost will post the parameters update the Status
 // of the campaign specified by the provided ID to CampaignStatusCancelled
 func cancelPost(cmd *cobra.Command, args []string) {
-	updateStatus(campaignID, db.CampaignStatusCancelled)
+	updateStatus(mustResolveCampaign(campaignRef), db.CampaignStatusCancelled)
 }
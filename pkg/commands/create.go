@@ -17,17 +17,33 @@ package commands
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/pwned"
+	"github.com/praetorian-inc/trident/pkg/schema"
+	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 var (
@@ -37,9 +53,40 @@ var (
 	// path to file containing passwords to test(newline separated)
 	flagPasswordFile string
 
+	// flagUserPasswords is a JSON file or directory of per-user passwords
+	flagUserPasswords string
+
+	// a single username and password to test instead of the files, the
+	// password is prompted for if unset
+	flagSingleUser string
+	flagSinglePass string
+
+	// sort the password file by its optional weight column
+	flagWeighted bool
+
+	// sort the password file by how often each password was breached
+	flagPrioritizeBreached bool
+
+	// expand brace and range patterns in the user file
+	flagExpandPatterns bool
+
+	// path to file containing usernames to remove from the user list
+	flagExcludeUsers string
+
+	// ID or name of the campaign whose valid users are removed from the user
+	// list
+	flagExcludeValidFrom string
+
+	// unique name of the campaign, generated by the orchestrator if unset
+	flagName string
+
 	// string with RFC3339Nano date format, default is time.Now()
 	flagNotBefore string
 
+	// string with RFC3339Nano date format, the campaign is stopped at this
+	// time even if tasks remain
+	flagDeadline string
+
 	// duration describing the window for the campaign to take place in,
 	// used to compute NotAfter
 	flagActiveWindow time.Duration
@@ -47,21 +94,211 @@ var (
 	// duration used to throttle individual requests by this much
 	flagScheduleInterval time.Duration
 
+	// maximum random delay added to each request
+	flagJitter time.Duration
+
+	// seed for the RNG used for ordering and jitter, random if unset
+	flagSeed int64
+
+	// the campaign is completed once this many credentials are valid
+	flagStopAfterValid int
+
+	// flagAttemptLimit caps the campaign's requests in any hour
+	flagAttemptLimit int
+
+	// flagPriority weights the campaign's share of the orchestrator's turns
+	flagPriority int
+
+	// flagAbortOnWAF pauses the campaign when a WAF or captcha challenges it
+	flagAbortOnWAF bool
+
+	// flagWAFThreshold is the challenge rate which pauses the campaign
+	flagWAFThreshold float64
+
+	// periods (RFC3339 start/end) during which no requests may be made
+	flagBlackouts []string
+
+	// recurring daily ranges (15:04-15:04) during which no requests may be
+	// made, in the time zone flagQuietHoursLocation
+	flagQuietHours         []string
+	flagQuietHoursLocation string
+
+	// move notbefore out of a blackout instead of warning that the first
+	// attempt will wait for it to end
+	flagSnapToWindow bool
+
 	// authentication provider to select for target, provider metadata is
 	// read from the config file
 	flagProvider string
+
+	// geo tag of the target (e.g. a country code), used to prefer worker
+	// regions near the target
+	flagTargetGeo string
+
+	// rotate the attempts through the worker regions instead of picking a
+	// region at random for each attempt
+	flagRandomizeWorkers bool
+
+	// weight the worker regions by the campaign's recent record in each
+	flagAdaptiveRegions bool
+
+	// the worker regions allowed to send attempts, each with an optional
+	// relative weight (ex: us-central1=3)
+	flagWorkerRegions []string
+
+	// store the provider's response to valid credentials with the results
+	flagCaptureOnValid bool
+
+	// store the usernames hashed with a campaign salt on the orchestrator
+	flagHashUsernames bool
+
+	// purge the results this long after the campaign's window ends
+	flagRetain time.Duration
+
+	// delete the campaign along with its results once flagRetain has passed
+	flagPurgeCampaign bool
+
+	// domain appended to bare usernames, and the template bare usernames are
+	// rewritten with
+	flagAppendDomain string
+	flagUserFormat   string
+
+	// check the usernames against the provider's username_format and either
+	// abort or drop the malformed ones
+	flagValidateUsernames string
+
+	// file to write the planned schedule to (.ics or CSV)
+	flagScheduleOut string
+
+	// number of planned attempts to print before sending
+	flagPreview int
+
+	// refuse campaigns whose full schedule would run for longer than this
+	flagMaxRuntime time.Duration
+
+	// print the summary (and write the schedule) without creating the
+	// campaign
+	flagDryRun bool
+
+	// print only the summary, without the preflight, prompts, or the
+	// schedule
+	flagSummaryOnly bool
+
+	// text (the default) or json, which prints the created campaign's ID
+	// to stdout and everything else to stderr
+	flagCreateOutput string
+
+	// do not check the orchestrator is reachable before reading the files
+	flagSkipPreflight bool
+
+	// the Idempotency-Key sent with the campaign, generated if unset
+	flagIdempotencyKey string
+
+	// do not ask for confirmation when the user and password files look
+	// swapped
+	flagSkipFileCheck bool
+
+	// leave out the bad lines of the user and password files with a warning
+	// instead of aborting, see badLine
+	flagSkipBadLines bool
+
+	// keep the lines of the user and password files which start with the
+	// comment prefix
+	flagNoComments bool
+
+	// send the campaign without asking, which requires flagConfirmHash
+	flagYes bool
+
+	// the config hash printed in the summary, which must match the
+	// campaign's before it is sent
+	flagConfirmHash string
+)
+
+// messageOut receives the campaign summary. It is stderr when stdout holds
+// machine-readable output.
+var messageOut io.Writer = os.Stdout
+
+// createdCampaign is printed by campaign create with --output-format json.
+type createdCampaign struct {
+	CampaignID uint   `json:"campaign_id"`
+	Name       string `json:"name"`
+}
+
+// compressThreshold is the size above which campaign bodies are sent gzip
+// compressed
+const compressThreshold = 64 << 10
+
+// preflightTimeout bounds the preflight request to the orchestrator
+const preflightTimeout = 10 * time.Second
+
+const (
+	// providerIntervalKey is the provider config key holding the safe
+	// interval between guesses against a single user
+	providerIntervalKey = "default_interval"
+
+	// providerLockoutKey is the provider config key holding the number of
+	// failed logins which locks an account
+	providerLockoutKey = "lockout_threshold"
+
+	// providerUsernameKey is the provider config key holding the format
+	// usernames must have, see usernamePattern
+	providerUsernameKey = "username_format"
+
+	// providerMaxConcurrentKey is the provider config key holding the most
+	// requests in flight at once against the provider, shared by every
+	// campaign against it
+	providerMaxConcurrentKey = "max_concurrent"
 )
 
+// lockoutSensitive lists the providers which authenticate directly against
+// domain accounts. For these, an interval below the provider's
+// default_interval is refused rather than warned about.
+var lockoutSensitive = map[string]bool{
+	"rdp": true,
+	"smb": true,
+}
+
+// unverifiedTLS lists the providers which skip certificate verification unless
+// insecure_skip_verify is false.
+var unverifiedTLS = map[string]bool{
+	"adfs": true,
+}
+
 const (
 	campaignSummary = `
 [Campaign Summary]
+Name: %s
 Not Before: %s
+First attempt: %s
 Not After: %s
+Estimated end: %s
+Deadline: %s
 Interval: %s
+Jitter: %s
+Attempt limit: %s
+Max concurrent: %s
+Priority: %s
+Seed: %s
+Lockout threshold: %s
+Stop after: %s
+Abort on WAF: %s
 Username count: %d
+Pattern expansion: %s
+Username rewrite: %s
+Excluded users: %d
+Username format: %s
 Password count: %d
+Password order: %s
 Provider: %s
 Metadata: %v
+Target geo: %s
+Worker regions: %s
+Capture on valid: %t
+Hashed usernames: %t
+Retention: %s
+Blackouts: %s
+Quiet hours: %s
+Config hash: %s
 
 `
 )
@@ -78,64 +315,569 @@ var campaignCreateCmd = &cobra.Command{
 func init() {
 	defaultNotBefore := time.Now().Format(time.RFC3339Nano)
 
-	// required arguments
+	// required arguments, unless --user-passwords is used
 
 	campaignCreateCmd.Flags().StringVarP(&flagUsernameFile, "userfile", "u", "",
 		"file of usernames (newline separated)")
-	err := campaignCreateCmd.MarkFlagRequired("userfile")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
 
-	}
+	campaignCreateCmd.Flags().StringVar(&flagSingleUser, "single-user", "",
+		"test a single username instead of the user and password files")
+	campaignCreateCmd.Flags().StringVar(&flagSinglePass, "single-pass", "",
+		"the password for --single-user, prompted for without echo if unset")
 
 	campaignCreateCmd.Flags().StringVarP(&flagPasswordFile, "passfile", "p", "",
 		"file of passwords (newline separated)")
-	err = campaignCreateCmd.MarkFlagRequired("passfile")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
 
-	}
+	campaignCreateCmd.Flags().StringVar(&flagUserPasswords, "user-passwords", "",
+		"JSON file or directory of each user's own passwords, instead of userfile and passfile")
 
 	// optional arguments
 
+	// default: an adjective-noun name chosen by the orchestrator
+	campaignCreateCmd.Flags().StringVar(&flagName, "name", "",
+		"a unique name for the campaign, usable in place of its ID")
+
+	campaignCreateCmd.Flags().BoolVar(&flagExpandPatterns, "expand-patterns", false,
+		"expand {a,b} and [001-050] patterns in the user file into one username each")
+
+	campaignCreateCmd.Flags().StringVar(&flagExcludeUsers, "exclude-users", "",
+		"file of usernames to remove from the userfile (newline separated)")
+
+	campaignCreateCmd.Flags().StringVar(&flagExcludeValidFrom, "exclude-valid-from", "",
+		"remove users with a valid credential in this campaign (ID or name)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagWeighted, "weighted", false,
+		"sort the passfile (password<TAB>weight lines) so the heaviest passwords are sprayed first")
+
+	campaignCreateCmd.Flags().BoolVar(&flagPrioritizeBreached, "prioritize-breached", false,
+		"sort the passfile so the passwords seen most often in breaches are sprayed first (sends 5-character SHA-1 prefixes to the range API)")
+
 	// default: time.Now()
 	campaignCreateCmd.Flags().StringVarP(&flagNotBefore, "notbefore", "b", defaultNotBefore,
 		"requests will not start before this time")
 
 	// default: 4 weeks = 672 hours, lol
-	campaignCreateCmd.Flags().DurationVarP(&flagActiveWindow, "window", "w", 672*time.Hour,
+	campaignCreateCmd.Flags().DurationVarP(&flagActiveWindow, "window", "w", defaultWindow,
 		"a duration that this campaign will be active (ex: 4w)")
 
+	campaignCreateCmd.Flags().StringVar(&flagDeadline, "deadline", "",
+		"stop the campaign at this time even if attempts remain")
+
 	// default: 1 second
-	campaignCreateCmd.Flags().DurationVarP(&flagScheduleInterval, "interval", "i", time.Second,
+	campaignCreateCmd.Flags().DurationVarP(&flagScheduleInterval, "interval", "i", defaultInterval,
 		"requests will happen with this interval between them")
 
+	campaignCreateCmd.Flags().DurationVarP(&flagJitter, "jitter", "j", 0,
+		"each request is delayed by a random duration up to this value")
+
+	campaignCreateCmd.Flags().IntVar(&flagAttemptLimit, "attempt-limit-global", 0,
+		"never make more than this many requests in any hour, across all users (0 for no limit)")
+
+	campaignCreateCmd.Flags().IntVar(&flagPriority, "priority", 0,
+		"share of the orchestrator's dispatch turns relative to the other campaigns, 1-10 (0 for the default of 1)")
+
+	// default: a random seed chosen by the orchestrator
+	campaignCreateCmd.Flags().Int64Var(&flagSeed, "seed", 0,
+		"seed for the random user ordering and jitter, for reproducible schedules")
+
+	campaignCreateCmd.Flags().IntVar(&flagStopAfterValid, "stop-after-valid", 0,
+		"complete the campaign once this many credentials are valid (0 runs every attempt)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagAbortOnWAF, "abort-on-waf", false,
+		"pause the campaign when too many attempts are challenged by a WAF or captcha")
+
+	campaignCreateCmd.Flags().Float64Var(&flagWAFThreshold, "waf-threshold", defaultWAFThreshold,
+		"fraction of recent attempts challenged which pauses the campaign with --abort-on-waf")
+
 	// default: okta
 	campaignCreateCmd.Flags().StringVarP(&flagProvider, "auth-provider", "a", "okta",
 		"this is the authentication platform you are attacking")
 
+	campaignCreateCmd.Flags().StringArrayVar(&flagBlackouts, "blackout", nil,
+		"a start/end pair of RFC3339 times with no activity, may be repeated")
+
+	campaignCreateCmd.Flags().StringArrayVar(&flagQuietHours, "quiet-hours", nil,
+		"a daily start-end range of times (e.g. 01:00-05:00) with no activity, may be repeated")
+	campaignCreateCmd.Flags().StringVar(&flagQuietHoursLocation, "quiet-hours-location", "",
+		"the IANA time zone of the quiet hours (default UTC)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagSnapToWindow, "snap-to-window", false,
+		"move notbefore to the end of a blackout it falls inside")
+
+	campaignCreateCmd.Flags().StringVar(&flagTargetGeo, "target-geo", "",
+		"prefer worker regions tagged with this geo (ex: de)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagRandomizeWorkers, "randomize-workers", false,
+		"spread attempts evenly across the worker regions, never sending consecutive attempts from the same region")
+
+	campaignCreateCmd.Flags().BoolVar(&flagAdaptiveRegions, "adaptive-regions", false,
+		"favor the worker regions whose recent attempts were not rate limited or challenged by the target")
+
+	campaignCreateCmd.Flags().StringSliceVar(&flagWorkerRegions, "worker-regions", nil,
+		"only send attempts from these worker regions, each with an optional weight (ex: us-central1=3,europe-west3)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagCaptureOnValid, "capture-on-valid", false,
+		"store the provider's response (headers and the start of the body, password redacted) with valid results")
+
+	campaignCreateCmd.Flags().BoolVar(&flagHashUsernames, "hash-usernames", false,
+		"store the usernames in the campaign and its results hashed with a campaign salt, "+
+			"results -u <userfile> shows them again. Tasks queued in Redis still hold the plaintext "+
+			"usernames until they are sent, as late as the end of the campaign")
+
+	campaignCreateCmd.Flags().DurationVar(&flagRetain, "retain", 0,
+		"purge the campaign's results this long after its window ends or its deadline passes (ex: 168h)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagPurgeCampaign, "purge-campaign", false,
+		"delete the campaign itself along with its results once --retain has passed")
+
+	campaignCreateCmd.Flags().StringVar(&flagAppendDomain, "append-domain", "",
+		"append @<domain> to each username without a domain")
+	campaignCreateCmd.Flags().StringVar(&flagUserFormat, "user-format", "",
+		"rewrite each username without a domain with this template, e.g. {username}@example.org")
+	campaignCreateCmd.Flags().StringVar(&flagValidateUsernames, "validate-usernames", "",
+		"check usernames against the provider's username_format and abort, or with =drop leave out, the malformed ones")
+	campaignCreateCmd.Flags().Lookup("validate-usernames").NoOptDefVal = validateAbort
+	campaignCreateCmd.Flags().StringVar(&flagScheduleOut, "schedule-out", "",
+		"write the planned attempt times to this file (.ics for a calendar, otherwise CSV)")
+	campaignCreateCmd.Flags().IntVar(&flagPreview, "preview", 0,
+		"print the first N planned attempts with their times and credentials before sending")
+
+	campaignCreateCmd.Flags().DurationVar(&flagMaxRuntime, "max-runtime", 0,
+		"refuse the campaign if its full schedule would run longer than this, regardless of the window (ex: 72h)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagDryRun, "dry-run", false,
+		"print the campaign summary without creating the campaign")
+
+	campaignCreateCmd.Flags().StringVarP(&flagCreateOutput, "output-format", "o", "text",
+		"output format (text, json)")
+
+	campaignCreateCmd.Flags().BoolVar(&flagSummaryOnly, "summary-only", false,
+		"print only the campaign summary and exit, without checks, prompts, or creating the campaign")
+
+	campaignCreateCmd.Flags().BoolVar(&flagSkipFileCheck, "skip-file-check", false,
+		"do not ask for confirmation when the user and password files look swapped")
+
+	campaignCreateCmd.Flags().BoolVar(&flagSkipBadLines, "skip-bad-lines", false,
+		"leave out lines of the user and password files which are too long, not UTF-8, or hold NUL bytes, "+
+			"instead of aborting")
+	campaignCreateCmd.Flags().BoolVar(&flagNoComments, "no-comments", false,
+		"keep lines of the user and password files starting with the comment-prefix (default #), "+
+			"for lists where it is a valid character")
+
+	campaignCreateCmd.Flags().BoolVarP(&flagYes, "yes", "y", false,
+		"send the campaign without asking for confirmation, requires --confirm-hash")
+
+	campaignCreateCmd.Flags().StringVar(&flagConfirmHash, "confirm-hash", "",
+		"the config hash from the campaign summary, the campaign is refused unless it matches")
+
+	campaignCreateCmd.Flags().BoolVar(&flagSkipPreflight, "skip-preflight", false,
+		"do not check that the orchestrator is reachable and accepts the auth token before building the campaign")
+
+	campaignCreateCmd.Flags().StringVar(&flagIdempotencyKey, "idempotency-key", "",
+		"the key identifying this create request, repeat the key from a failed attempt to retry it without "+
+			"creating the campaign twice (default a random UUID)")
+
 	campaignCmd.AddCommand(campaignCreateCmd)
 }
 
+// maxLineLength is the longest line readLines accepts
+const maxLineLength = bufio.MaxScanTokenSize
+
+// defaultCommentPrefix starts the comment lines of user and password files,
+// unless the config sets comment-prefix
+const defaultCommentPrefix = "#"
+
+// lineOptions controls which lines of a file readLines keeps.
+type lineOptions struct {
+	// SkipBad leaves out bad lines, see badLine, instead of returning an
+	// error
+	SkipBad bool
+
+	// Comments leaves out the lines which start with the comment prefix
+	// once leading and trailing whitespace is trimmed
+	Comments bool
+}
+
+// newLineOptions returns the lineOptions of the flags shared by the commands
+// which read user and password files.
+func newLineOptions(skipBad, noComments bool) lineOptions {
+	return lineOptions{SkipBad: skipBad, Comments: !noComments}
+}
+
+// commentPrefix returns the prefix of comment lines, comment-prefix from the
+// config or defaultCommentPrefix.
+func commentPrefix() string {
+	if prefix := viper.GetString("comment-prefix"); prefix != "" {
+		return prefix
+	}
+	return defaultCommentPrefix
+}
+
 // readLines reads a whole file into memory
-// and returns a slice of its lines.
-func readLines(path string) ([]string, error) {
+// and returns a slice of its lines. A path of the form secret:<name> reads the
+// named list from the secret store instead. A bad line, see badLine, is an
+// error naming the line, unless opts.SkipBad is set, in which case it is
+// logged and left out. Comment lines are left out with opts.Comments.
+func readLines(path string, opts lineOptions) ([]string, error) {
+	lines, _, err := readNumberedLines(path, opts)
+	return lines, err
+}
+
+// readNumberedLines is readLines, also returning the line number each line was
+// read from, for errors about the content of a line.
+func readNumberedLines(path string, opts lineOptions) ([]string, []int, error) {
+	if strings.HasPrefix(path, secretPrefix) {
+		lines, err := secretLines(strings.TrimPrefix(path, secretPrefix))
+		numbers := make([]int, len(lines))
+		for i := range numbers {
+			numbers[i] = i + 1
+		}
+		return lines, numbers, err
+	}
+
 	file, err := os.Open(path) //nolint:gosec
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer file.Close() // nolint:errcheck,gosec
 
+	prefix := commentPrefix()
 	var lines []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	var numbers []int
+	var n, skipped, comments int
+	reader := bufio.NewReader(file)
+	for {
+		line, tooLong, err := readLine(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s line %d: %w (%d lines read before it)", path, n+1, err, len(lines))
+		}
+		n++
+
+		if reason := badLine(line, tooLong); reason != "" {
+			if !opts.SkipBad {
+				return nil, nil, fmt.Errorf("%s line %d: %s (%d lines read before it, use --skip-bad-lines to "+
+					"leave out bad lines)", path, n, reason, len(lines))
+			}
+			log.Warnf("skipping line %d of %s: %s", n, path, reason)
+			skipped++
+			continue
+		}
+		if opts.Comments && strings.HasPrefix(strings.TrimSpace(line), prefix) {
+			comments++
+			continue
+		}
+		lines = append(lines, line)
+		numbers = append(numbers, n)
+	}
+	if skipped > 0 {
+		log.Warnf("skipped %d bad lines of %s, read %d", skipped, path, len(lines))
+	}
+	if comments > 0 {
+		log.Infof("skipped %d comment lines of %s starting with %q (use --no-comments to keep them)",
+			comments, path, prefix)
+	}
+	return lines, numbers, nil
+}
+
+// readLine returns the next line of the reader without its line ending, and
+// whether it is longer than maxLineLength. Such a line is read to its end but
+// not kept.
+func readLine(reader *bufio.Reader) (string, bool, error) {
+	var buf []byte
+	var n int
+	for {
+		chunk, more, err := reader.ReadLine()
+		if err != nil {
+			return "", false, err
+		}
+		if n += len(chunk); n <= maxLineLength {
+			buf = append(buf, chunk...)
+		}
+		if !more {
+			break
+		}
+	}
+	return string(buf), n > maxLineLength, nil
+}
+
+// badLine returns why the line cannot be a username or password, or "" if it
+// can. Corrupted lines of machine-generated lists are usually far too long,
+// not valid UTF-8, or hold NUL bytes.
+func badLine(line string, tooLong bool) string {
+	switch {
+	case tooLong:
+		return fmt.Sprintf("longer than %d bytes", maxLineLength)
+	case !utf8.ValidString(line):
+		return "not valid UTF-8"
+	case strings.ContainsRune(line, 0):
+		return "holds a NUL byte"
 	}
-	return lines, scanner.Err()
+	return ""
 }
 
+// readUserPasswords reads the passwords of each user from either a JSON file
+// mapping each username to a list of passwords, or a directory holding a file
+// of passwords (newline separated) per user, named after the username. Users
+// without any password are left out.
+func readUserPasswords(path string, opts lineOptions) (db.UserPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	passwords := make(db.UserPasswords)
+	if info.IsDir() {
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+				continue
+			}
+			lines, err := readLines(filepath.Join(path, f.Name()), opts)
+			if err != nil {
+				return nil, err
+			}
+			passwords[f.Name()] = lines
+		}
+	} else {
+		b, err := ioutil.ReadFile(path) //nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(b, &passwords)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a JSON object of username to passwords: %w", path, err)
+		}
+	}
+
+	for u, p := range passwords {
+		if u == "" || len(p) == 0 {
+			delete(passwords, u)
+		}
+	}
+	return passwords, nil
+}
+
+// usernamePlaceholder is replaced by the username in a --user-format template
+const usernamePlaceholder = "{username}"
+
+// userFormat returns the template bare usernames are rewritten with, built
+// from either a domain to append or a template.
+func userFormat(domain, format string) (string, error) {
+	switch {
+	case domain != "" && format != "":
+		return "", fmt.Errorf("append-domain cannot be combined with user-format")
+	case domain != "":
+		return usernamePlaceholder + "@" + strings.TrimPrefix(domain, "@"), nil
+	case format != "" && !strings.Contains(format, usernamePlaceholder):
+		return "", fmt.Errorf("user-format %q does not contain %s", format, usernamePlaceholder)
+	}
+	return format, nil
+}
+
+// formatUsers rewrites the bare usernames, those without an @ or a DOMAIN\
+// prefix, with the template. The passwords of a rewritten user in
+// userPasswords, if set, move to the new name. It returns the users with the
+// number rewritten and the first rewrite as an example.
+func formatUsers(format string, users []string, userPasswords db.UserPasswords) ([]string, int, string) {
+	var n int
+	var example string
+	formatted := make([]string, 0, len(users))
+	for _, u := range users {
+		if strings.ContainsAny(u, `@\`) || strings.TrimSpace(u) == "" {
+			formatted = append(formatted, u)
+			continue
+		}
+		f := strings.ReplaceAll(format, usernamePlaceholder, strings.TrimSpace(u))
+		if userPasswords != nil {
+			userPasswords[f] = userPasswords[u]
+			delete(userPasswords, u)
+		}
+		if n == 0 {
+			example = fmt.Sprintf("%s -> %s", u, f)
+		}
+		formatted = append(formatted, f)
+		n++
+	}
+	return formatted, n, example
+}
+
+// excludeUsers removes the excluded usernames, compared case-insensitively,
+// from users and returns the remaining users with the number removed.
+func excludeUsers(users, excluded []string) ([]string, int) {
+	if len(excluded) == 0 {
+		return users, 0
+	}
+
+	skip := make(map[string]bool, len(excluded))
+	for _, u := range excluded {
+		skip[strings.ToLower(strings.TrimSpace(u))] = true
+	}
+
+	kept := make([]string, 0, len(users))
+	for _, u := range users {
+		if !skip[strings.ToLower(strings.TrimSpace(u))] {
+			kept = append(kept, u)
+		}
+	}
+	return kept, len(users) - len(kept)
+}
+
+// validUsers returns the usernames with a valid credential in the campaign,
+// referred to by ID or name. If the campaign hashes usernames, the ones among
+// users are returned in plaintext, see usernameTable.
+func validUsers(campaign string, users []string) ([]string, error) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	campaignID, err := resolveCampaign(orchestrator, campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ReturnedFields": []string{"username"},
+		"Filter": map[string]interface{}{
+			"campaign_id": campaignID,
+			"valid":       true,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []db.Result
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	var table map[string]string
+	c, err := fetchCampaign(orchestrator, campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if c.HashUsernames {
+		table = usernameTable(c, users)
+	}
+
+	valid := make([]string, 0, len(results))
+	for _, r := range results {
+		if table != nil {
+			r.Username = table[r.Username]
+		}
+		if r.Username != "" {
+			valid = append(valid, r.Username)
+		}
+	}
+	return valid, nil
+}
+
+// readWeightedPasswords reads a password file whose lines may carry a weight
+// after the last tab (e.g. "Summer2020!\t1500") and returns the passwords
+// sorted by descending weight. Passwords without a tab have a weight of zero,
+// and equal weights keep their file order, so a plain list is returned
+// unchanged. A weight which is not a finite, positive number is an error
+// naming the line.
+func readWeightedPasswords(path string, opts lineOptions) ([]string, error) {
+	lines, numbers, err := readNumberedLines(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := make([]float64, len(lines))
+	for i, line := range lines {
+		sep := strings.LastIndex(line, "\t")
+		if sep < 0 {
+			continue
+		}
+		field := strings.TrimSpace(line[sep+1:])
+		w, err := strconv.ParseFloat(field, 64)
+		if err != nil || w <= 0 || math.IsInf(w, 0) || math.IsNaN(w) {
+			return nil, fmt.Errorf("%s line %d: weight %q is not a positive number", path, numbers[i], field)
+		}
+		lines[i], weights[i] = line[:sep], w
+	}
+
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return weights[order[a]] > weights[order[b]]
+	})
+
+	passwords := make([]string, len(lines))
+	for i, j := range order {
+		passwords[i] = lines[j]
+	}
+	return passwords, nil
+}
+
+// prioritizeBreached sorts the passwords by descending exposure count, as
+// reported by the k-anonymity range API at pwned-passwords-url, and returns
+// the number of passwords which were breached. Equal counts keep their file
+// order. Ranges are cached in the user's cache directory.
+func prioritizeBreached(passwords []string) ([]string, int, error) {
+	url := viper.GetString("pwned-passwords-url")
+	if url == "" {
+		url = pwned.DefaultURL
+	}
+	var cacheDir string
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheDir = filepath.Join(dir, "trident", "pwned")
+	}
+	client := pwned.NewClient(url, cacheDir)
+
+	counts := make(map[string]int, len(passwords))
+	var breached int
+	for _, p := range passwords {
+		count, err := client.Count(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		counts[p] = count
+		if count > 0 {
+			breached++
+		}
+	}
+
+	sorted := append([]string{}, passwords...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return counts[sorted[a]] > counts[sorted[b]]
+	})
+	return sorted, breached, nil
+}
+
+// confirm asks the operator a yes/no question on stderr, so the prompt never
+// mixes with the data on stdout.
 func confirm(s string) bool {
-	fmt.Printf("%s [y/N]: ", s)
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", s)
 
 	reader := bufio.NewReader(os.Stdin)
 	r, err := reader.ReadString('\n')
@@ -150,67 +892,1256 @@ func confirm(s string) bool {
 	return false
 }
 
-func campaignCreate(cmd *cobra.Command, args []string) {
-	orchestrator := viper.GetString("orchestrator-url")
-	providers := viper.GetStringMap("providers")
+// readPassword prompts for a password on stderr and reads it from the
+// terminal without echoing it. The terminal is restored if the client is
+// interrupted at the prompt.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", errors.New("stdin is not a terminal")
+	}
+	state, err := terminal.GetState(fd)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	type result struct {
+		b   []byte
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		b, err := terminal.ReadPassword(fd)
+		read <- result{b, err}
+	}()
+
+	select {
+	case r := <-read:
+		fmt.Fprintln(os.Stderr)
+		return string(r.b), r.err
+	case <-cmdContext.Done():
+		_ = terminal.Restore(fd, state)
+		fmt.Fprintln(os.Stderr)
+		return "", errInterrupted
+	}
+}
+
+// parseBlackout parses a blackout period in the start/end form, where both
+// times are formatted as RFC3339.
+func parseBlackout(s string) (db.Blackout, error) {
+	var b db.Blackout
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return b, fmt.Errorf("blackout %q is not in the start/end form", s)
+	}
+
+	var err error
+	b.Start, err = time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return b, err
+	}
+	b.End, err = time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return b, err
+	}
+	if !b.End.After(b.Start) {
+		return b, fmt.Errorf("blackout %q ends before it starts", s)
+	}
+	return b, nil
+}
+
+// formatBlackouts returns a human readable list of blackouts.
+func formatBlackouts(blackouts db.Blackouts) string {
+	if len(blackouts) == 0 {
+		return "none"
+	}
+	var s []string
+	for _, b := range blackouts {
+		s = append(s, fmt.Sprintf("%s - %s", b.Start, b.End))
+	}
+	return strings.Join(s, ", ")
+}
+
+// parseQuietHours parses daily quiet hours in the start-end form, where both
+// are times of day formatted as 15:04, in the named time zone. It returns nil
+// if there are no ranges.
+func parseQuietHours(ranges []string, location string) (*db.QuietHours, error) {
+	if len(ranges) == 0 {
+		if location != "" {
+			return nil, fmt.Errorf("quiet-hours-location requires quiet-hours")
+		}
+		return nil, nil
+	}
+
+	q := &db.QuietHours{Location: location}
+	for _, s := range ranges {
+		parts := strings.SplitN(s, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("quiet hours %q are not in the start-end form", s)
+		}
+		q.Ranges = append(q.Ranges, db.QuietRange{
+			Start: strings.TrimSpace(parts[0]),
+			End:   strings.TrimSpace(parts[1]),
+		})
+	}
+	return q, nil
+}
+
+// formatQuietHours returns a human readable description of quiet hours.
+func formatQuietHours(q *db.QuietHours) string {
+	if q == nil {
+		return "none"
+	}
+	var s []string
+	for _, r := range q.Ranges {
+		s = append(s, r.String())
+	}
+	location := q.Location
+	if location == "" {
+		location = "UTC"
+	}
+	return fmt.Sprintf("%s daily (%s)", strings.Join(s, ", "), location)
+}
+
+// parseWorkerRegions parses worker regions in the region=weight form, where
+// the weight is optional and defaults to 1. It returns nil if there are no
+// regions, which allows attempts from any region.
+func parseWorkerRegions(specs []string) (db.WorkerRegions, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
 
-	users, err := readLines(flagUsernameFile)
+	regions := make(db.WorkerRegions, len(specs))
+	for _, s := range specs {
+		parts := strings.SplitN(s, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			return nil, fmt.Errorf("worker region %q has no name", s)
+		}
+		weight := 1.0
+		if len(parts) == 2 {
+			var err error
+			weight, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil || weight <= 0 || math.IsInf(weight, 0) {
+				return nil, fmt.Errorf("worker region %q does not have a positive weight", s)
+			}
+		}
+		if _, ok := regions[name]; ok {
+			return nil, fmt.Errorf("worker region %q is repeated", name)
+		}
+		regions[name] = weight
+	}
+	return regions, nil
+}
+
+// formatWorkerRegions returns a human readable list of worker regions and
+// the share of attempts each is expected to send.
+func formatWorkerRegions(regions db.WorkerRegions) string {
+	var total float64
+	names := make([]string, 0, len(regions))
+	for name, weight := range regions {
+		names = append(names, name)
+		total += weight
+	}
+	sort.Strings(names)
+
+	var s []string
+	for _, name := range names {
+		s = append(s, fmt.Sprintf("%s (%.0f%%)", name, regions[name]/total*100))
+	}
+	return strings.Join(s, ", ")
+}
+
+// providerDefaults holds the pacing hints declared in a provider's config.
+// They are consumed by the client and never sent to the nozzle.
+type providerDefaults struct {
+	// Interval is the safe interval between guesses against a single user
+	Interval time.Duration
+
+	// LockoutThreshold is the number of failed logins which locks an account
+	LockoutThreshold int
+
+	// UsernameFormat is the format usernames must have, if known
+	UsernameFormat string
+
+	// MaxConcurrent is the most requests in flight at once against the
+	// provider across every campaign, 0 for no limit
+	MaxConcurrent int
+}
+
+// configuredProviders returns the sorted names under providers in the config
+// file, or "none".
+func configuredProviders() string {
+	var names []string
+	for name := range viper.GetStringMap("providers") {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// checkProviderConfig returns an error naming what is missing if the provider
+// does not exist, or its metadata lacks an option the provider requires.
+// Without it the campaign would be accepted and every attempt would fail on
+// the workers.
+func checkProviderConfig(name string, metadata map[string]interface{}) error {
+	opts, err := nozzle.Describe(name)
 	if err != nil {
-		log.Fatalf("error reading lines from user file: %s", err)
+		return fmt.Errorf("unknown auth-provider %q (providers: %s)", name, strings.Join(nozzle.Drivers(), ", "))
+	}
+
+	var missing []string
+	for _, opt := range opts {
+		if v, ok := metadata[opt.Name]; opt.Required && (!ok || v == "") {
+			missing = append(missing, opt.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if !viper.IsSet("providers." + name) {
+		return fmt.Errorf("auth-provider %q is not configured, add providers.%s with its %s option to the config "+
+			"file (configured providers: %s)", name, name, strings.Join(missing, ", "), configuredProviders())
+	}
+	return fmt.Errorf("auth-provider %q is missing the required %s option in providers.%s of the config file "+
+		"(options are shown by providers list %s)", name, strings.Join(missing, ", "), name, name)
+}
+
+// providerConfig splits the config of the named provider into the nozzle
+// metadata and the provider defaults. Nozzle options are strings, so other
+// scalars (e.g. starttls: true) are sent in their string form.
+func providerConfig(name string) (map[string]interface{}, providerDefaults) {
+	key := "providers." + name
+	metadata := make(map[string]interface{})
+	for k, v := range viper.GetStringMap(key) {
+		switch k {
+		case providerIntervalKey, providerLockoutKey, providerUsernameKey, providerMaxConcurrentKey:
+			continue
+		}
+		if _, ok := v.(string); !ok {
+			v = fmt.Sprint(v)
+		}
+		metadata[k] = v
 	}
 
-	passwords, err := readLines(flagPasswordFile)
+	// viper does not look up the keys of a map in the environment, so the
+	// provider's known options are looked up by name, e.g.
+	// TRIDENT_PROVIDERS_OKTA_SUBDOMAIN, and take precedence over the config
+	opts, _ := nozzle.Describe(name)
+	for _, opt := range opts {
+		if strings.HasPrefix(opt.Name, nozzle.HeaderPrefix) {
+			continue
+		}
+		if v, ok := os.LookupEnv(envName(key + "." + opt.Name)); ok {
+			metadata[opt.Name] = v
+		}
+	}
+
+	defaults := providerDefaults{
+		Interval:         viper.GetDuration(key + "." + providerIntervalKey),
+		LockoutThreshold: viper.GetInt(key + "." + providerLockoutKey),
+		UsernameFormat:   viper.GetString(key + "." + providerUsernameKey),
+		MaxConcurrent:    viper.GetInt(key + "." + providerMaxConcurrentKey),
+	}
+	if defaults.UsernameFormat == "" && emailUsernames[name] {
+		defaults.UsernameFormat = "email"
+	}
+	return metadata, defaults
+}
+
+// guessesPerWindow returns the number of guesses a single user receives
+// within the provider's safe interval when the campaign uses interval.
+func guessesPerWindow(defaults providerDefaults, interval time.Duration) int {
+	if interval <= 0 {
+		return math.MaxInt32
+	}
+	n := defaults.Interval / interval
+	if defaults.Interval%interval != 0 {
+		n++
+	}
+	return int(n)
+}
+
+// campaignSpec describes a campaign to create. It is filled from the create
+// flags or from an entry of an apply manifest, whose keys match the flag names.
+type campaignSpec struct {
+	Name      string        `mapstructure:"name"`
+	UserFile  string        `mapstructure:"userfile"`
+	PassFile  string        `mapstructure:"passfile"`
+	UserPass  string        `mapstructure:"user-passwords"`
+	User      string        `mapstructure:"single-user"`
+	Pass      string        `mapstructure:"single-pass"`
+	Expand    bool          `mapstructure:"expand-patterns"`
+	Exclude   string        `mapstructure:"exclude-users"`
+	ExcludeID string        `mapstructure:"exclude-valid-from"`
+	Weighted  bool          `mapstructure:"weighted"`
+	Breached  bool          `mapstructure:"prioritize-breached"`
+	NotBefore string        `mapstructure:"notbefore"`
+	Deadline  string        `mapstructure:"deadline"`
+	Window    time.Duration `mapstructure:"window"`
+	Interval  time.Duration `mapstructure:"interval"`
+	Jitter    time.Duration `mapstructure:"jitter"`
+	Limit     int           `mapstructure:"attempt-limit-global"`
+	Priority  int           `mapstructure:"priority"`
+	Seed      int64         `mapstructure:"seed"`
+	StopAfter int           `mapstructure:"stop-after-valid"`
+	AbortWAF  bool          `mapstructure:"abort-on-waf"`
+	WAFRate   float64       `mapstructure:"waf-threshold"`
+	Provider  string        `mapstructure:"auth-provider"`
+	TargetGeo string        `mapstructure:"target-geo"`
+	Randomize bool          `mapstructure:"randomize-workers"`
+	Adaptive  bool          `mapstructure:"adaptive-regions"`
+	Regions   []string      `mapstructure:"worker-regions"`
+	Capture   bool          `mapstructure:"capture-on-valid"`
+	HashUsers bool          `mapstructure:"hash-usernames"`
+	Retain    time.Duration `mapstructure:"retain"`
+	Purge     bool          `mapstructure:"purge-campaign"`
+	SkipBad   bool          `mapstructure:"skip-bad-lines"`
+	NoComment bool          `mapstructure:"no-comments"`
+	Validate  string        `mapstructure:"validate-usernames"`
+	Domain    string        `mapstructure:"append-domain"`
+	UserFmt   string        `mapstructure:"user-format"`
+	Blackouts []string      `mapstructure:"blackout"`
+	Quiet     []string      `mapstructure:"quiet-hours"`
+	QuietLoc  string        `mapstructure:"quiet-hours-location"`
+	Snap      bool          `mapstructure:"snap-to-window"`
+	Runtime   time.Duration `mapstructure:"max-runtime"`
+}
+
+// campaignRequest is the body of a campaign creation request, as described by
+// schema.Campaign.
+type campaignRequest struct {
+	Name             string                 `json:"name,omitempty"`
+	NotBefore        time.Time              `json:"not_before"`
+	NotAfter         time.Time              `json:"not_after"`
+	Deadline         *time.Time             `json:"deadline"`
+	Status           db.CampaignStatus      `json:"status"`
+	ScheduleInterval time.Duration          `json:"schedule_interval"`
+	Jitter           time.Duration          `json:"jitter"`
+	AttemptLimit     int                    `json:"attempt_limit,omitempty"`
+	MaxConcurrent    int                    `json:"max_concurrent,omitempty"`
+	Priority         int                    `json:"priority,omitempty"`
+	Seed             int64                  `json:"seed"`
+	StopAfterValid   int                    `json:"stop_after_valid"`
+	AbortOnWAF       float64                `json:"abort_on_waf,omitempty"`
+	Users            []string               `json:"users"`
+	Passwords        []string               `json:"passwords,omitempty"`
+	UserPasswords    db.UserPasswords       `json:"user_passwords,omitempty"`
+	Provider         string                 `json:"provider"`
+	ProviderMetadata map[string]interface{} `json:"provider_metadata"`
+	TargetGeo        string                 `json:"target_geo"`
+	RandomizeWorkers bool                   `json:"randomize_workers,omitempty"`
+	AdaptiveRegions  bool                   `json:"adaptive_regions,omitempty"`
+	WorkerRegions    db.WorkerRegions       `json:"worker_regions,omitempty"`
+	CaptureOnValid   bool                   `json:"capture_on_valid,omitempty"`
+	HashUsernames    bool                   `json:"hash_usernames,omitempty"`
+	Retain           time.Duration          `json:"retain,omitempty"`
+	PurgeCampaign    bool                   `json:"purge_campaign,omitempty"`
+	Blackouts        db.Blackouts           `json:"blackouts"`
+	QuietHours       *db.QuietHours         `json:"quiet_hours,omitempty"`
+
+	// hash is the config hash of the campaign, see configHash
+	hash string
+}
+
+// configHashLength is the number of hex digits of the config hash shown in
+// the summary
+const configHashLength = 12
+
+// configHash returns a short hash of what the campaign guesses and when: its
+// users, passwords, provider and provider metadata, and schedule. The start
+// is only hashed when it was given, since it otherwise defaults to the time
+// the campaign is built, so the same files and flags give the same hash on
+// every run.
+func (c *campaignRequest) configHash(startGiven bool) string {
+	config := struct {
+		Users            []string               `json:"users"`
+		Passwords        []string               `json:"passwords"`
+		UserPasswords    db.UserPasswords       `json:"user_passwords"`
+		Provider         string                 `json:"provider"`
+		ProviderMetadata map[string]interface{} `json:"provider_metadata"`
+		NotBefore        *time.Time             `json:"not_before"`
+		Window           time.Duration          `json:"window"`
+		Deadline         *time.Time             `json:"deadline"`
+		ScheduleInterval time.Duration          `json:"schedule_interval"`
+		Jitter           time.Duration          `json:"jitter"`
+		AttemptLimit     int                    `json:"attempt_limit"`
+		Blackouts        db.Blackouts           `json:"blackouts"`
+		QuietHours       *db.QuietHours         `json:"quiet_hours"`
+	}{
+		Users:            c.Users,
+		Passwords:        c.Passwords,
+		UserPasswords:    c.UserPasswords,
+		Provider:         c.Provider,
+		ProviderMetadata: c.ProviderMetadata,
+		Window:           c.NotAfter.Sub(c.NotBefore),
+		Deadline:         c.Deadline,
+		ScheduleInterval: c.ScheduleInterval,
+		Jitter:           c.Jitter,
+		AttemptLimit:     c.AttemptLimit,
+		Blackouts:        c.Blackouts,
+		QuietHours:       c.QuietHours,
+	}
+	if startGiven {
+		notBefore := c.NotBefore.UTC()
+		config.NotBefore = &notBefore
+	}
+
+	// map keys are marshalled in sorted order, so the encoding is stable
+	b, err := json.Marshal(config)
 	if err != nil {
-		log.Fatalf("error reading lines from password file: %s", err)
+		log.Fatalf("error hashing campaign config: %s", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])[:configHashLength]
+}
+
+// checkConfigHash reports whether the hash given with --confirm-hash matches
+// the campaign's config hash.
+func checkConfigHash(given, hash string) bool {
+	return strings.EqualFold(strings.TrimSpace(given), hash)
+}
+
+const (
+	// defaultInterval is used when neither the spec nor the provider config
+	// sets an interval
+	defaultInterval = time.Second
+
+	// defaultWindow is used when the spec does not set a window (4 weeks)
+	defaultWindow = 672 * time.Hour
+
+	// defaultWAFThreshold is the challenge rate which pauses a campaign with
+	// abort-on-waf when the spec does not set one
+	defaultWAFThreshold = 0.2
+
+	// maxPriority is the highest campaign priority the orchestrator accepts
+	maxPriority = 10
+)
+
+// attempts returns the number of attempts the campaign requests.
+func (c *campaignRequest) attempts() int {
+	if c.UserPasswords == nil {
+		return len(c.Users) * len(c.Passwords)
 	}
+	var n int
+	for _, u := range c.Users {
+		n += len(c.UserPasswords[u])
+	}
+	return n
+}
 
-	parsedNotBefore, err := time.Parse(time.RFC3339Nano, flagNotBefore)
+// rounds returns the number of rounds of the campaign, one password per user
+// each.
+func (c *campaignRequest) rounds() int {
+	if c.UserPasswords == nil {
+		return len(c.Passwords)
+	}
+	var n int
+	for _, p := range c.UserPasswords {
+		if len(p) > n {
+			n = len(p)
+		}
+	}
+	return n
+}
+
+// estimatedEnd describes when the last round of the campaign starts, deferring
+// each round for the blackouts and quiet hours like the scheduler does. The
+// attempt limit may push the end further out.
+func (c *campaignRequest) estimatedEnd(first time.Time) string {
+	exclusions := &db.Campaign{Blackouts: c.Blackouts, QuietHours: c.QuietHours}
+	rounds := c.rounds()
+	t := first
+	for i := 0; i < rounds; i++ {
+		if i > 0 {
+			t = exclusions.Defer(t.Add(c.ScheduleInterval), c.Jitter)
+		}
+		if t.After(c.NotAfter) {
+			return fmt.Sprintf("%s (%d of %d rounds do not fit in the window)", c.NotAfter, rounds-i, rounds)
+		}
+	}
+	end := t.Add(c.Jitter).String()
+	if c.AttemptLimit > 0 {
+		end += " or later with the attempt limit"
+	}
+	return end
+}
+
+// lineOptions returns the options the spec's user and password files are read
+// with.
+func (spec campaignSpec) lineOptions() lineOptions {
+	return newLineOptions(spec.SkipBad, spec.NoComment)
+}
+
+// build reads the spec's user and password files and applies the provider
+// defaults. It returns the request, validated against the campaign schema,
+// along with a human readable summary.
+func (spec campaignSpec) build() (*campaignRequest, string, error) {
+	metadata, defaults := providerConfig(spec.Provider)
+	if err := checkProviderConfig(spec.Provider, metadata); err != nil {
+		return nil, "", err
+	}
+
+	interval := spec.Interval
+	intervalNote := ""
+	switch {
+	case interval == 0 && defaults.Interval > 0:
+		interval = defaults.Interval
+		intervalNote = fmt.Sprintf(" (%s default)", spec.Provider)
+	case interval == 0:
+		interval = defaultInterval
+	case defaults.Interval > interval && lockoutSensitive[spec.Provider]:
+		return nil, "", fmt.Errorf("interval %s is below the %s default of %s",
+			interval, spec.Provider, defaults.Interval)
+	case defaults.Interval > interval:
+		log.Warnf("interval %s is below the %s default of %s",
+			interval, spec.Provider, defaults.Interval)
+		if defaults.LockoutThreshold > 0 &&
+			guessesPerWindow(defaults, interval) >= defaults.LockoutThreshold {
+			log.Warnf("each user will receive at least %d guesses within %s, "+
+				"which meets the %s lockout threshold of %d",
+				guessesPerWindow(defaults, interval), defaults.Interval,
+				spec.Provider, defaults.LockoutThreshold)
+		}
+	}
+
+	insecure := unverifiedTLS[spec.Provider]
+	if v, err := strconv.ParseBool(fmt.Sprint(metadata["insecure_skip_verify"])); err == nil {
+		insecure = v
+	}
+	if insecure {
+		log.Warnf("TLS certificate verification is disabled for the %s provider "+
+			"(set insecure_skip_verify to false to enable it)", spec.Provider)
+	}
+	if weak, _ := strconv.ParseBool(fmt.Sprint(metadata["allow_weak_tls"])); weak {
+		log.Warnf("TLS versions older than 1.2 and insecure cipher suites are allowed for the %s provider by allow_weak_tls",
+			spec.Provider)
+	}
+
+	if defaults.MaxConcurrent < 0 {
+		return nil, "", fmt.Errorf("%s of the %s provider is negative", providerMaxConcurrentKey, spec.Provider)
+	}
+
+	lockoutNote := "unknown"
+	if defaults.LockoutThreshold > 0 {
+		lockoutNote = fmt.Sprintf("%d (%s default)", defaults.LockoutThreshold, spec.Provider)
+	}
+
+	var users []string
+	var userPasswords db.UserPasswords
+	var err error
+	switch {
+	case spec.User != "" && (spec.UserFile != "" || spec.PassFile != "" || spec.UserPass != ""):
+		return nil, "", fmt.Errorf("single-user cannot be combined with userfile, passfile, or user-passwords")
+	case spec.User != "" && (spec.Weighted || spec.Breached):
+		return nil, "", fmt.Errorf("single-user cannot be combined with weighted or prioritize-breached")
+	case spec.User != "" && spec.Pass == "":
+		return nil, "", fmt.Errorf("single-pass is required with single-user")
+	case spec.User != "":
+		users = []string{spec.User}
+	case spec.Pass != "":
+		return nil, "", fmt.Errorf("single-pass requires single-user")
+	case spec.UserPass != "" && (spec.UserFile != "" || spec.PassFile != ""):
+		return nil, "", fmt.Errorf("user-passwords cannot be combined with userfile or passfile")
+	case spec.Breached && (spec.UserPass != "" || spec.Weighted):
+		return nil, "", fmt.Errorf("prioritize-breached cannot be combined with user-passwords or weighted")
+	case spec.UserPass != "":
+		userPasswords, err = readUserPasswords(spec.UserPass, spec.lineOptions())
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading user passwords: %w", err)
+		}
+		for u := range userPasswords {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+	case spec.UserFile == "" || spec.PassFile == "":
+		return nil, "", fmt.Errorf("userfile and passfile are required unless user-passwords or single-user is set")
+	default:
+		users, err = readLines(spec.UserFile, spec.lineOptions())
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading lines from user file: %w", err)
+		}
+	}
+
+	expandNote := "off"
+	if spec.Expand {
+		if spec.UserFile == "" {
+			return nil, "", fmt.Errorf("expand-patterns requires userfile")
+		}
+		var patterns int
+		users, patterns, err = expandPatterns(users)
+		if err != nil {
+			return nil, "", err
+		}
+		expandNote = fmt.Sprintf("%d patterns expanded to %d usernames", patterns, len(users))
+	}
+
+	format, err := userFormat(spec.Domain, spec.UserFmt)
+	if err != nil {
+		return nil, "", err
+	}
+	formatNote := "none"
+	if format != "" {
+		var formatted int
+		var example string
+		users, formatted, example = formatUsers(format, users, userPasswords)
+		formatNote = fmt.Sprintf("%s (%d of %d usernames", format, formatted, len(users))
+		if formatted > 0 {
+			formatNote += ", e.g. " + example
+		}
+		formatNote += ")"
+	}
+
+	var excluded []string
+	if spec.Exclude != "" {
+		excluded, err = readLines(spec.Exclude, spec.lineOptions())
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading lines from exclude file: %w", err)
+		}
+	}
+	if spec.ExcludeID != "" {
+		valid, err := validUsers(spec.ExcludeID, users)
+		if err != nil {
+			return nil, "", fmt.Errorf("error fetching valid users of campaign %s: %w", spec.ExcludeID, err)
+		}
+		excluded = append(excluded, valid...)
+	}
+	users, excludedCount := excludeUsers(users, excluded)
+
+	usernameNote := "not validated"
+	if spec.Validate != "" {
+		if spec.Validate != validateAbort && spec.Validate != validateDrop {
+			return nil, "", fmt.Errorf("validate-usernames must be %s or %s", validateAbort, validateDrop)
+		}
+		if defaults.UsernameFormat == "" {
+			return nil, "", fmt.Errorf("validate-usernames requires a %s for the %s provider",
+				providerUsernameKey, spec.Provider)
+		}
+		var malformed []string
+		users, malformed, err = validateUsernames(defaults.UsernameFormat, users, userPasswords)
+		if err != nil {
+			return nil, "", err
+		}
+		for i, u := range malformed {
+			if i == maxMalformedShown {
+				log.Warnf("... and %d more malformed usernames", len(malformed)-i)
+				break
+			}
+			log.Warnf("username %q is not a valid %s username", u, defaults.UsernameFormat)
+		}
+		if len(malformed) > 0 && spec.Validate == validateAbort {
+			return nil, "", fmt.Errorf("%d usernames don't match the %s username format of the %s provider "+
+				"(use --validate-usernames=%s to leave them out)",
+				len(malformed), defaults.UsernameFormat, spec.Provider, validateDrop)
+		}
+		usernameNote = fmt.Sprintf("%s (%d malformed dropped)", defaults.UsernameFormat, len(malformed))
+	}
+
+	var passwords []string
+	passwordCount, passwordOrder := 0, "file order"
+	if userPasswords != nil {
+		// excluded users keep no passwords, so they are never scheduled
+		kept := make(db.UserPasswords, len(users))
+		for _, u := range users {
+			kept[u] = userPasswords[u]
+			passwordCount += len(kept[u])
+		}
+		userPasswords, passwordOrder = kept, "per user"
+	} else if spec.User != "" {
+		passwords, passwordCount, passwordOrder = []string{spec.Pass}, 1, "single password"
+	} else {
+		readPasswords := readLines
+		if spec.Weighted {
+			readPasswords, passwordOrder = readWeightedPasswords, "by descending weight"
+		}
+		passwords, err = readPasswords(spec.PassFile, spec.lineOptions())
+		if err != nil {
+			return nil, "", fmt.Errorf("error reading lines from password file: %w", err)
+		}
+		passwordCount = len(passwords)
+		if spec.Breached {
+			var breached int
+			passwords, breached, err = prioritizeBreached(passwords)
+			if err != nil {
+				return nil, "", fmt.Errorf("error looking up breached passwords: %w", err)
+			}
+			passwordOrder = fmt.Sprintf("by breach exposure (%d of %d breached)", breached, passwordCount)
+		}
+	}
+
+	notBefore := time.Now().Round(0)
+	if spec.NotBefore != "" {
+		notBefore, err = time.Parse(time.RFC3339Nano, spec.NotBefore)
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing notBefore time: %w", err)
+		}
+	}
+
+	var blackouts db.Blackouts
+	for _, s := range spec.Blackouts {
+		b, err := parseBlackout(s)
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing blackout: %w", err)
+		}
+		blackouts = append(blackouts, b)
+	}
+
+	quietHours, err := parseQuietHours(spec.Quiet, spec.QuietLoc)
 	if err != nil {
-		log.Fatalf("error parsing notBefore time: %s", err)
+		return nil, "", fmt.Errorf("error parsing quiet hours: %w", err)
+	}
+	if quietHours != nil {
+		gap, err := quietHours.Validate()
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing quiet hours: %w", err)
+		}
+		if spec.Jitter >= gap {
+			return nil, "", fmt.Errorf("jitter %s must be shorter than the %s between quiet hours", spec.Jitter, gap)
+		}
+	}
+
+	// the first round waits for any blackout or quiet hours notbefore falls
+	// inside
+	firstAttempt := (&db.Campaign{Blackouts: blackouts, QuietHours: quietHours}).Defer(notBefore, spec.Jitter)
+	if firstAttempt.After(notBefore) {
+		if spec.Snap {
+			notBefore = firstAttempt
+		} else {
+			log.Warnf("notbefore %s falls inside a blackout or quiet hours, the first attempt will wait until %s "+
+				"(use --snap-to-window to start the campaign there)", notBefore, firstAttempt)
+		}
 	}
 
 	// duration math. NotAfter = NotBefore + ActiveWindow
-	parsedNotAfter := parsedNotBefore.Add(flagActiveWindow)
+	window := spec.Window
+	if window == 0 {
+		window = defaultWindow
+	}
+	notAfter := notBefore.Add(window)
 
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"not_before":        parsedNotBefore,
-		"not_after":         parsedNotAfter,
-		"status":            db.CampaignStatusActive,
-		"schedule_interval": flagScheduleInterval,
-		"users":             users,
-		"passwords":         passwords,
-		"provider":          flagProvider,
-		"provider_metadata": providers[flagProvider],
-	})
+	var deadline *time.Time
+	deadlineNote := "none"
+	if spec.Deadline != "" {
+		parsedDeadline, err := time.Parse(time.RFC3339Nano, spec.Deadline)
+		if err != nil {
+			return nil, "", fmt.Errorf("error parsing deadline time: %w", err)
+		}
+		if !parsedDeadline.After(notBefore) {
+			return nil, "", fmt.Errorf("deadline %s is not after notbefore %s", parsedDeadline, notBefore)
+		}
+		deadline = &parsedDeadline
+		deadlineNote = parsedDeadline.String()
+	}
+
+	var abortOnWAF float64
+	abortNote := "no"
+	if spec.AbortWAF {
+		abortOnWAF = spec.WAFRate
+		if abortOnWAF == 0 {
+			abortOnWAF = defaultWAFThreshold
+		}
+		if abortOnWAF < 0 || abortOnWAF > 1 {
+			return nil, "", fmt.Errorf("waf-threshold %v is not between 0 and 1", abortOnWAF)
+		}
+		abortNote = fmt.Sprintf("pause when %.0f%% of recent attempts are challenged", abortOnWAF*100)
+	}
+
+	if spec.Retain < 0 {
+		return nil, "", fmt.Errorf("retain %s is negative", spec.Retain)
+	}
+	if spec.Priority < 0 || spec.Priority > maxPriority {
+		return nil, "", fmt.Errorf("priority %d is not between 0 and %d", spec.Priority, maxPriority)
+	}
+	if spec.Randomize && spec.Adaptive {
+		return nil, "", fmt.Errorf("randomize-workers cannot be combined with adaptive-regions")
+	}
+	if spec.Purge && spec.Retain == 0 {
+		return nil, "", fmt.Errorf("purge-campaign requires retain")
+	}
+	if spec.HashUsers && spec.Capture {
+		// the provider's response may well hold the username
+		return nil, "", fmt.Errorf("hash-usernames cannot be combined with capture-on-valid")
+	}
+
+	workerRegions, err := parseWorkerRegions(spec.Regions)
 	if err != nil {
-		log.Fatalf("error during JSON marshalling for request body: %s", err)
+		return nil, "", fmt.Errorf("error parsing worker regions: %w", err)
 	}
 
-	// print summary of campaign and prompt user to accept
-	fmt.Printf(campaignSummary, parsedNotBefore, parsedNotAfter, flagScheduleInterval,
-		len(users), len(passwords), flagProvider, providers[flagProvider])
-	if !confirm("Send campaign?") {
-		log.Printf("not sending campaign")
-		return
+	req := &campaignRequest{
+		Name:             spec.Name,
+		NotBefore:        notBefore,
+		NotAfter:         notAfter,
+		Deadline:         deadline,
+		Status:           db.CampaignStatusActive,
+		ScheduleInterval: interval,
+		Jitter:           spec.Jitter,
+		AttemptLimit:     spec.Limit,
+		MaxConcurrent:    defaults.MaxConcurrent,
+		Priority:         spec.Priority,
+		Seed:             spec.Seed,
+		StopAfterValid:   spec.StopAfter,
+		AbortOnWAF:       abortOnWAF,
+		Users:            users,
+		Passwords:        passwords,
+		UserPasswords:    userPasswords,
+		Provider:         spec.Provider,
+		ProviderMetadata: metadata,
+		TargetGeo:        spec.TargetGeo,
+		RandomizeWorkers: spec.Randomize,
+		AdaptiveRegions:  spec.Adaptive,
+		WorkerRegions:    workerRegions,
+		CaptureOnValid:   spec.Capture,
+		HashUsernames:    spec.HashUsers,
+		Retain:           spec.Retain,
+		PurgeCampaign:    spec.Purge,
+		Blackouts:        blackouts,
+		QuietHours:       quietHours,
 	}
+	req.hash = req.configHash(spec.NotBefore != "")
 
-	req, err := http.NewRequest("POST", orchestrator+"/campaign", bytes.NewBuffer(requestBody))
+	// catch invalid campaigns before they reach the orchestrator
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("error during JSON marshalling for request body: %w", err)
+	}
+	err = schema.ValidateCampaign(body)
 	if err != nil {
-		log.Fatalf("error during request creation: %s", err)
+		return nil, "", fmt.Errorf("invalid campaign: %w", err)
 	}
 
+	name := spec.Name
+	if name == "" {
+		name = "generated"
+	}
+
+	seed := "random"
+	if spec.Seed != 0 {
+		seed = fmt.Sprint(spec.Seed)
+	}
+
+	maxConcurrentNote := "none"
+	if defaults.MaxConcurrent > 0 {
+		maxConcurrentNote = fmt.Sprintf("%d (%s default, shared with other campaigns)", defaults.MaxConcurrent,
+			spec.Provider)
+	}
+
+	priorityNote := "1 (default)"
+	if spec.Priority > 0 {
+		priorityNote = strconv.Itoa(spec.Priority)
+	}
+
+	attemptLimit := "none"
+	if spec.Limit > 0 {
+		attemptLimit = fmt.Sprintf("%d per hour", spec.Limit)
+		// the interval alone allows every user one guess per interval
+		if perHour := float64(len(users)) * float64(time.Hour) / float64(interval); float64(spec.Limit) < perHour {
+			log.Infof("the attempt limit of %d per hour is more restrictive than the interval "+
+				"(up to %.0f per hour) and will pace the campaign", spec.Limit, perHour)
+		}
+	}
+
+	stopAfter := "every attempt"
+	if spec.StopAfter > 0 {
+		stopAfter = fmt.Sprintf("%d valid credentials", spec.StopAfter)
+	}
+
+	targetGeo := spec.TargetGeo
+	if targetGeo == "" {
+		targetGeo = "any"
+	}
+	regionNote := "random for each attempt"
+	switch {
+	case spec.Randomize:
+		regionNote = "rotated, no region twice in a row"
+	case spec.Adaptive:
+		regionNote = "random, weighted toward regions the target does not block"
+	}
+	if len(workerRegions) > 0 {
+		regionNote += ", only from " + formatWorkerRegions(workerRegions)
+	}
+
+	retention := "keep results"
+	if spec.Retain > 0 {
+		at, _ := (&db.Campaign{NotAfter: notAfter, Deadline: deadline, Retain: spec.Retain}).PurgeAt()
+		retention = fmt.Sprintf("purge results at %s", at)
+		if spec.Purge {
+			retention += ", and delete the campaign"
+		}
+	}
+
+	summary := fmt.Sprintf(campaignSummary, name, notBefore, firstAttempt, notAfter, req.estimatedEnd(firstAttempt), deadlineNote,
+		interval.String()+intervalNote, spec.Jitter, attemptLimit, maxConcurrentNote, priorityNote, seed, lockoutNote,
+		stopAfter, abortNote, len(users), expandNote, formatNote, excludedCount, usernameNote, passwordCount, passwordOrder,
+		spec.Provider, metadata, targetGeo, regionNote, spec.Capture, spec.HashUsers, retention, formatBlackouts(blackouts),
+		formatQuietHours(quietHours), req.hash)
+	return req, summary, nil
+}
+
+// preflight makes an authenticated request to the orchestrator's health check
+// so an unreachable orchestrator or a rejected auth token is reported before
+// the campaign is built and confirmed. Redirects are not followed, since an
+// expired token is answered with a redirect to the login page.
+func preflight(orchestrator string) error {
+	req, err := newRequest("GET", orchestrator+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return fmt.Errorf("unable to fetch auth token: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout: preflightTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if cmdContext.Err() != nil {
+			return errInterrupted
+		}
+		return fmt.Errorf("orchestrator is unreachable: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("orchestrator rejected the auth token (%d)", resp.StatusCode)
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		return fmt.Errorf("orchestrator redirected to %s, the auth token may have expired",
+			resp.Header.Get("Location"))
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("orchestrator health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// newIdempotencyKey returns a random version 4 UUID.
+func newIdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// sendCampaign submits a campaign creation request to the orchestrator and
+// returns the created campaign. The request carries an Idempotency-Key, from
+// --idempotency-key or generated, so the orchestrator creates the campaign at
+// most once however many times the request is sent. If no response is
+// received the key is logged, so the create may be retried with it.
+func sendCampaign(orchestrator string, campaign *campaignRequest) (*db.Campaign, error) {
+	requestBody, err := json.Marshal(campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	key := flagIdempotencyKey
+	if key == "" {
+		key, err = newIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := postCompressed(orchestrator, requestBody, key)
+	var oerr *orchestratorError
+	if err != nil && !errors.As(err, &oerr) {
+		log.Warnf("the orchestrator may have created the campaign before the request failed, "+
+			"its idempotency key is %s", key)
+	}
+	return created, err
+}
+
+// postCompressed sends the campaign body compressed if it is large, falling
+// back to sending it uncompressed to orchestrators which do not accept it.
+func postCompressed(orchestrator string, requestBody []byte, key string) (*db.Campaign, error) {
+	if len(requestBody) > compressThreshold {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		_, err := zw.Write(requestBody)
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		created, err := postCampaign(orchestrator, compressed.Bytes(), "gzip", key)
+		var rejected *rejectedEncoding
+		if !errors.As(err, &rejected) {
+			return created, tooLarge(err, len(requestBody))
+		}
+		log.Infof("orchestrator does not accept compressed campaigns (%s), sending it uncompressed", rejected)
+	}
+	created, err := postCampaign(orchestrator, requestBody, "", key)
+	return created, tooLarge(err, len(requestBody))
+}
+
+// tooLarge explains a campaign refused by the orchestrator for its size, which
+// is the size of the uncompressed body whether or not it was compressed.
+func tooLarge(err error, size int) error {
+	var oerr *orchestratorError
+	if !errors.As(err, &oerr) || oerr.Code != http.StatusRequestEntityTooLarge {
+		return err
+	}
+	return fmt.Errorf("the campaign (%d bytes) is larger than the orchestrator accepts, raise "+
+		"ORCHESTRATOR_MAX_REQUEST_BODY on the orchestrator or split the users into several campaigns: %w", size, err)
+}
+
+// rejectedEncoding is returned by postCampaign when the orchestrator could
+// not read a compressed body. Orchestrators without support for compression
+// answer a gzip body as badly-formed JSON.
+type rejectedEncoding struct {
+	msg string
+}
+
+func (e *rejectedEncoding) Error() string {
+	return e.msg
+}
+
+// postCampaign sends the campaign body, with the Content-Encoding encoding if
+// set and the Idempotency-Key key, and returns the created campaign.
+func postCampaign(orchestrator string, requestBody []byte, encoding, key string) (*db.Campaign, error) {
+	body := newProgress("uploading campaign", bytes.NewReader(requestBody), int64(len(requestBody)))
+	req, err := newRequest("POST", orchestrator+"/campaign", body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	req.Header.Set("Idempotency-Key", key)
+
 	// add the authentication token to the request
 	err = authenticator.Auth(req)
 	if err != nil {
-		log.Fatalf("error during authentication: %s", err)
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		log.Fatalf("error sending request: %s", err)
+		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
 	log.Debug(resp)
-	log.Info("successfully created campaign")
+	err = responseError(resp)
+	var oerr *orchestratorError
+	if encoding != "" && errors.As(err, &oerr) && (oerr.Code == http.StatusUnsupportedMediaType ||
+		oerr.Code == http.StatusBadRequest && strings.Contains(oerr.Message, "badly-formed JSON")) {
+		return nil, &rejectedEncoding{msg: oerr.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var created db.Campaign
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func campaignCreate(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	switch flagCreateOutput {
+	case "text":
+	case "json":
+		messageOut = os.Stderr
+	default:
+		log.Fatalf("unknown output format %q", flagCreateOutput)
+	}
+
+	spec := campaignSpec{
+		Name:      flagName,
+		UserFile:  flagUsernameFile,
+		Expand:    flagExpandPatterns,
+		PassFile:  flagPasswordFile,
+		UserPass:  flagUserPasswords,
+		User:      flagSingleUser,
+		Pass:      flagSinglePass,
+		Exclude:   flagExcludeUsers,
+		ExcludeID: flagExcludeValidFrom,
+		Weighted:  flagWeighted,
+		Breached:  flagPrioritizeBreached,
+		NotBefore: flagNotBefore,
+		Deadline:  flagDeadline,
+		Window:    flagActiveWindow,
+		Jitter:    flagJitter,
+		Limit:     flagAttemptLimit,
+		Priority:  flagPriority,
+		Seed:      flagSeed,
+		StopAfter: flagStopAfterValid,
+		AbortWAF:  flagAbortOnWAF,
+		WAFRate:   flagWAFThreshold,
+		Provider:  flagProvider,
+		TargetGeo: flagTargetGeo,
+		Randomize: flagRandomizeWorkers,
+		Adaptive:  flagAdaptiveRegions,
+		Regions:   flagWorkerRegions,
+		Capture:   flagCaptureOnValid,
+		HashUsers: flagHashUsernames,
+		Retain:    flagRetain,
+		Purge:     flagPurgeCampaign,
+		SkipBad:   flagSkipBadLines,
+		NoComment: flagNoComments,
+		Validate:  flagValidateUsernames,
+		Domain:    flagAppendDomain,
+		UserFmt:   flagUserFormat,
+		Blackouts: flagBlackouts,
+		Quiet:     flagQuietHours,
+		QuietLoc:  flagQuietHoursLocation,
+		Snap:      flagSnapToWindow,
+		Runtime:   flagMaxRuntime,
+	}
+	// an unset interval falls back to the provider's default_interval
+	if cmd.Flags().Changed("interval") {
+		spec.Interval = flagScheduleInterval
+	}
+	// an unset notbefore starts the campaign when it is built, and is left
+	// out of the config hash
+	if !cmd.Flags().Changed("notbefore") {
+		spec.NotBefore = ""
+	}
+
+	if flagSummaryOnly && (flagScheduleOut != "" || flagPreview != 0 || spec.Runtime > 0) {
+		log.Fatal("summary-only cannot be combined with schedule-out, preview, or max-runtime")
+	}
+	if flagPreview < 0 {
+		log.Fatalf("preview must not be negative, got %d", flagPreview)
+	}
+
+	if !flagSkipPreflight && !flagSummaryOnly {
+		err := preflight(orchestrator)
+		if err != nil {
+			log.Fatalf("preflight failed: %s (use --skip-preflight to bypass)", err)
+		}
+	}
+
+	if spec.User != "" && spec.Pass == "" {
+		pass, err := readPassword(fmt.Sprintf("Password for %s: ", spec.User))
+		if err != nil {
+			log.Fatalf("error reading password: %s (use --single-pass)", err)
+		}
+		if pass == "" {
+			log.Fatal("no password entered")
+		}
+		spec.Pass = pass
+	}
+
+	campaign, summary, err := spec.build()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// print summary of campaign and prompt user to accept
+	fmt.Fprint(messageOut, summary)
+	if flagSummaryOnly {
+		return
+	}
+
+	warnings := checkCredentialFiles(campaign.Provider, campaign.Users, campaign.Passwords)
+	for _, w := range warnings {
+		log.Warn(w)
+	}
+	if len(warnings) > 0 && !flagSkipFileCheck && !flagDryRun {
+		if flagYes {
+			log.Fatal("the user and password files may be mixed up (use --skip-file-check to send anyway)")
+		}
+		if !confirm("The user and password files may be mixed up. Continue anyway?") {
+			log.Printf("not sending campaign")
+			return
+		}
+	}
+
+	if spec.Runtime > 0 {
+		err = checkMaxRuntime(orchestrator, campaign, spec.Runtime)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if flagScheduleOut != "" {
+		preview, err := previewSchedule(orchestrator, campaign, 0)
+		if err != nil {
+			log.Fatalf("error previewing schedule: %s", err)
+		}
+		err = writeSchedule(flagScheduleOut, campaign.Provider, preview)
+		if err != nil {
+			log.Fatalf("error writing schedule: %s", err)
+		}
+		log.Infof("wrote %d planned attempts to %s (seed %d)",
+			len(preview.Attempts), flagScheduleOut, preview.Seed)
+		if preview.Dropped > 0 {
+			log.Warnf("%d attempts do not fit in the window and will not be made", preview.Dropped)
+		}
+
+		// the campaign must use the previewed seed to follow the schedule
+		campaign.Seed = preview.Seed
+	}
+
+	if flagPreview > 0 {
+		preview, err := previewSchedule(orchestrator, campaign, flagPreview)
+		if err != nil {
+			log.Fatalf("error previewing schedule: %s", err)
+		}
+		printPreview(campaign, preview)
+		log.Infof("showing %d of %d planned attempts (seed %d)",
+			len(preview.Attempts), preview.Scheduled, preview.Seed)
+		if preview.Dropped > 0 {
+			log.Warnf("%d attempts do not fit in the window and will not be made", preview.Dropped)
+		}
+
+		// the campaign must use the previewed seed to follow the schedule
+		campaign.Seed = preview.Seed
+	}
+
+	if flagDryRun {
+		log.Printf("dry run, not sending campaign")
+		return
+	}
+	if flagConfirmHash != "" && !checkConfigHash(flagConfirmHash, campaign.hash) {
+		log.Fatalf("config hash %s does not match the campaign's %s, check the summary before sending",
+			flagConfirmHash, campaign.hash)
+	}
+	if flagYes && flagConfirmHash == "" {
+		log.Fatalf("--yes requires --confirm-hash %s, check the summary before sending", campaign.hash)
+	}
+	if !flagYes && !confirm("Send campaign?") {
+		log.Printf("not sending campaign")
+		return
+	}
+
+	created, err := sendCampaign(orchestrator, campaign)
+	var oerr *orchestratorError
+	if err != nil && !errors.As(err, &oerr) {
+		log.Fatalf("error sending campaign: %s, run the command again with --idempotency-key "+
+			"to retry without creating the campaign twice", err)
+	}
+	if err != nil {
+		log.Fatalf("error sending campaign: %s", err)
+	}
+	log.Infof("successfully created campaign %d (%s)", created.ID, created.Name)
+
+	if flagCreateOutput == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(createdCampaign{CampaignID: created.ID, Name: created.Name})
+		if err != nil {
+			log.Fatalf("error writing output: %s", err)
+		}
+	}
 }
@@ -0,0 +1,99 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWeightedPasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trident")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	var tests = []struct {
+		name      string
+		content   string
+		passwords []string
+		err       string
+	}{
+		{
+			name:      "weighted",
+			content:   "Password1\t10\nSummer2020!\t1500\nWelcome1\n",
+			passwords: []string{"Summer2020!", "Password1", "Welcome1"},
+		},
+		{
+			name:      "commas",
+			content:   "Summer,2020\nWinter,2021\t2\n# a comment\nSpring\t1.5\n",
+			passwords: []string{"Winter,2021", "Spring", "Summer,2020"},
+		},
+		{
+			name:    "zero",
+			content: "Password1\t10\nSummer2020!\t0\n",
+			err:     "line 2: weight \"0\" is not a positive number",
+		},
+		{
+			name:    "negative",
+			content: "# a comment\nPassword1\t-1\n",
+			err:     "line 2: weight \"-1\" is not a positive number",
+		},
+		{
+			name:    "nan",
+			content: "Password1\tNaN\n",
+			err:     "line 1: weight \"NaN\" is not a positive number",
+		},
+		{
+			name:    "inf",
+			content: "Password1\t+Inf\n",
+			err:     "line 1: weight \"+Inf\" is not a positive number",
+		},
+		{
+			name:    "not a number",
+			content: "Password1\tmany\n",
+			err:     "line 1: weight \"many\" is not a positive number",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(test.name, " ", "-"))
+			err := ioutil.WriteFile(path, []byte(test.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			passwords, err := readWeightedPasswords(path, newLineOptions(false, false))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(passwords, test.passwords) {
+				t.Errorf("expected %q, got %q", test.passwords, passwords)
+			}
+		})
+	}
+}
@@ -18,7 +18,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"sort"
+	"time"
 
 	"github.com/praetorian-inc/trident/pkg/db"
 
@@ -28,8 +29,8 @@ import (
 )
 
 var (
-	// identifier for the campaign
-	campaignID uint
+	// identifier or name of the campaign
+	campaignRef string
 )
 
 var describeCmd = &cobra.Command{
@@ -42,8 +43,8 @@ var describeCmd = &cobra.Command{
 }
 
 func init() {
-	describeCmd.Flags().UintVarP(&campaignID, "campaign", "c", 0,
-		"the identifier of the campaign.")
+	describeCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
 	err := describeCmd.MarkFlagRequired("campaign")
 	if err != nil {
 		log.Fatalf("issue during argument parsing: %d", err)
@@ -52,68 +53,208 @@ func init() {
 	campaignCmd.AddCommand(describeCmd)
 }
 
-// describeGet will retrieve the parameters that make up the given campaign
-// and print the parameters to the CLI
-func describeGet(cmd *cobra.Command, args []string) {
-	orchestrator := viper.GetString("orchestrator-url")
-
-	var flagFilter = fmt.Sprintf("{\"id\":%d}", campaignID)
-
-	var filter map[string]interface{}
-	err := json.Unmarshal([]byte(flagFilter), &filter)
-	if err != nil {
-		log.Fatalf("error during JSON unmarshalling: %s", err)
-	}
-
+// fetchCampaign returns the campaign with the provided ID, along with its
+// result and error counts.
+func fetchCampaign(orchestrator string, campaignID uint) (*db.Campaign, error) {
 	// build our request to the orchestrator.
 	// return all fields (*) and the filter is the campaignID
 	requestBody, err := json.Marshal(map[string]interface{}{
-		"Filter": filter,
+		"Filter": map[string]interface{}{"id": campaignID},
 	})
 	if err != nil {
-		log.Fatalf("error during JSON marshalling for request body: %s", err)
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", orchestrator+"/describe", bytes.NewBuffer(requestBody))
+	req, err := newRequest("POST", orchestrator+"/describe", bytes.NewBuffer(requestBody))
 	if err != nil {
-		log.Fatalf("error during request creation: %s", err)
+		return nil, err
 	}
 
 	// add Cloudflare Access token to our request
 	err = authenticator.Auth(req)
 	if err != nil {
-		log.Fatalf("error during authentication: %s", err)
+		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
-		log.Fatalf("error sending request: %s", err)
+		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
-	// handle the results from the server
-	if resp.StatusCode != 200 {
-		log.Fatalf("error returning results from server: %d", resp.StatusCode)
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
 	}
 
 	var campaign db.Campaign
 	err = json.NewDecoder(resp.Body).Decode(&campaign)
 	if err != nil {
-		log.Fatalf("error parsing response json: %s", err)
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// describeGet will retrieve the parameters that make up the given campaign
+// and print the parameters to the CLI
+func describeGet(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	campaignID := mustResolveCampaign(campaignRef)
+
+	campaign, err := fetchCampaign(orchestrator, campaignID)
+	if err != nil {
+		log.Fatalf("error describing campaign: %s", err)
 	}
 
 	fmt.Printf("-------------------------------------------\n")
-	fmt.Printf("Campaign #%d Parameters:\n", campaignID)
+	if campaign.Name != "" {
+		fmt.Printf("Campaign #%d (%s) Parameters:\n", campaignID, campaign.Name)
+	} else {
+		fmt.Printf("Campaign #%d Parameters:\n", campaignID)
+	}
 	fmt.Printf("-------------------------------------------\n")
 	fmt.Printf("Start Time:     %s\n", campaign.NotBefore)
 	fmt.Printf("End Time:       %s\n", campaign.NotAfter)
-	if campaign.Status != "" {
+	if campaign.Deadline != nil {
+		fmt.Printf("Deadline:       %s\n", campaign.Deadline)
+	}
+	fmt.Printf("Interval:       %s\n", campaign.ScheduleInterval)
+	fmt.Printf("Jitter:         %s\n", campaign.Jitter)
+	if campaign.AttemptLimit > 0 {
+		fmt.Printf("Attempt Limit:  %d per hour\n", campaign.AttemptLimit)
+		if campaign.ThrottledUntil != nil && campaign.ThrottledUntil.After(time.Now()) {
+			fmt.Printf("Throttled:      attempt limit reached, deferring requests until %s\n",
+				campaign.ThrottledUntil)
+		}
+	}
+	if campaign.MaxConcurrent > 0 {
+		fmt.Printf("Concurrency:    at most %d requests in flight against %s, shared with other campaigns\n",
+			campaign.MaxConcurrent, campaign.Provider)
+	}
+	if campaign.Priority > 0 {
+		fmt.Printf("Priority:       %d\n", campaign.Priority)
+	}
+	if campaign.DuplicatesSuppressed > 0 {
+		fmt.Printf("Duplicates:     %d attempts suppressed\n", campaign.DuplicatesSuppressed)
+	}
+	fmt.Printf("Seed:           %d\n", campaign.Seed)
+	if campaign.Status == db.CampaignStatusScheduled {
+		fmt.Printf("Status:         %s (starts at %s)\n", campaign.Status, campaign.NotBefore)
+	} else if campaign.Status != "" {
 		fmt.Printf("Status:         %s\n", campaign.Status)
 	} else {
 		fmt.Printf("Status:         %s\n", db.CampaignStatusActive)
 	}
+	if campaign.StopAfterValid > 0 {
+		fmt.Printf("Stop After:     %d valid\n", campaign.StopAfterValid)
+	}
+	if campaign.AbortOnWAF > 0 {
+		fmt.Printf("Abort on WAF:   %.0f%% of recent attempts challenged\n", campaign.AbortOnWAF*100)
+	}
+	if campaign.StopReason != "" {
+		fmt.Printf("Stop Reason:    %s\n", campaign.StopReason)
+	}
 	fmt.Printf("User Count:     %d\n", len(campaign.Users))
-	fmt.Printf("Password Count: %d\n", len(campaign.Passwords))
+	if campaign.UserPasswords != nil {
+		var n int
+		for _, p := range campaign.UserPasswords {
+			n += len(p)
+		}
+		fmt.Printf("Password Count: %d (per user)\n", n)
+	} else {
+		fmt.Printf("Password Count: %d\n", len(campaign.Passwords))
+	}
 	fmt.Printf("Provider:       %s\n", campaign.Provider)
 	fmt.Printf("Metadata:       %s\n", campaign.ProviderMetadata)
+	if len(campaign.Blackouts) > 0 {
+		// blackouts which have ended no longer affect the campaign
+		now := time.Now()
+		var ended int
+		fmt.Printf("Blackouts:\n")
+		for _, b := range campaign.Blackouts {
+			switch {
+			case !b.End.After(now):
+				ended++
+			case b.Start.After(now):
+				fmt.Printf("                %s - %s\n", b.Start, b.End)
+			default:
+				fmt.Printf("                %s - %s (in progress)\n", b.Start, b.End)
+			}
+		}
+		if ended > 0 {
+			fmt.Printf("                %d ended\n", ended)
+		}
+	}
+	if campaign.QuietHours != nil {
+		fmt.Printf("Quiet Hours:    %s\n", formatQuietHours(campaign.QuietHours))
+	}
+	if campaign.TargetGeo != "" {
+		fmt.Printf("Target Geo:     %s\n", campaign.TargetGeo)
+	}
+	if campaign.RandomizeWorkers {
+		fmt.Printf("Workers:        rotated across regions\n")
+	}
+	if campaign.AdaptiveRegions {
+		fmt.Printf("Workers:        weighted by recent blocks in each region\n")
+	}
+	if len(campaign.WorkerRegions) > 0 {
+		fmt.Printf("Regions:        %s\n", formatWorkerRegions(campaign.WorkerRegions))
+	}
+	if len(campaign.RegionWeights) > 0 {
+		names := make([]string, 0, len(campaign.RegionWeights))
+		for name := range campaign.RegionWeights {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("Region Weights:\n")
+		for _, name := range names {
+			s := campaign.RegionWeights[name]
+			fmt.Printf("                %s %.2f (%.1f succeeded, %.1f blocked recently)\n",
+				name, s.Weight, s.Succeeded, s.Blocked)
+		}
+	}
+	if campaign.CaptureOnValid {
+		fmt.Printf("Capture:        responses to valid credentials\n")
+	}
+	if campaign.HashUsernames {
+		fmt.Printf("Usernames:      hashed with the campaign salt (results -u <userfile> shows them)\n")
+	}
+	if campaign.PurgedAt != nil {
+		fmt.Printf("Retention:      results purged at %s\n", campaign.PurgedAt)
+	} else if at, ok := campaign.PurgeAt(); ok {
+		if campaign.PurgeCampaign {
+			fmt.Printf("Retention:      campaign and results deleted at %s\n", at)
+		} else {
+			fmt.Printf("Retention:      results purged at %s\n", at)
+		}
+	}
+	if campaign.Cursor != nil {
+		c := campaign.Cursor
+		fmt.Printf("Cursor:         %d of %d attempts\n", c.Offset, campaignAttempts(campaign))
+		if c.Username != "" {
+			fmt.Printf("                last %s / %s", c.Username, c.Password)
+			if c.Region != "" {
+				fmt.Printf(" via %s", c.Region)
+			}
+			fmt.Printf(" at %s\n", c.UpdatedAt)
+		}
+	}
+	if len(campaign.Stats) > 0 {
+		fmt.Printf("Results:\n")
+		for _, status := range db.ResultStatuses {
+			fmt.Printf("                %-14s %d\n", status+":", campaign.Stats[status])
+		}
+	}
+	if len(campaign.Errors) > 0 {
+		categories := make([]string, 0, len(campaign.Errors))
+		for category := range campaign.Errors {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+		fmt.Printf("Errors:\n")
+		for _, category := range categories {
+			fmt.Printf("                %-20s %d\n", category+":", campaign.Errors[category])
+		}
+	}
 }
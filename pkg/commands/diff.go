@@ -0,0 +1,248 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// output format of campaign diff, table or json
+var flagDiffOutput string
+
+var campaignDiffCmd = &cobra.Command{
+	Use:   "diff <before> <after>",
+	Short: "compare the valid credentials of two campaigns",
+	Long: `compares the valid credentials found by two campaigns against the same
+target, such as a re-spray after a remediation window. each credential valid in
+either campaign is reported as added (only valid in the second), removed (only
+valid in the first), or unchanged (valid in both). a removed credential also
+shows the second campaign's verdict on it, so a rotated password (invalid) can
+be told apart from one the second campaign never tried. campaigns are given by
+identifier or name.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignDiff(cmd, args)
+	},
+}
+
+func init() {
+	campaignDiffCmd.Flags().StringVarP(&flagDiffOutput, "output-format", "o", "table",
+		"output format: table or json")
+	campaignCmd.AddCommand(campaignDiffCmd)
+}
+
+// credential is a username and password pair.
+type credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// credentialChange is a credential which was valid in at least one of the
+// compared campaigns. Before and After are its status in each campaign, empty
+// if the campaign has no verdict on it.
+type credentialChange struct {
+	credential
+	Before db.ResultStatus `json:"before"`
+	After  db.ResultStatus `json:"after"`
+}
+
+// campaignDifference is the result of campaign diff.
+type campaignDifference struct {
+	Before    uint               `json:"before"`
+	After     uint               `json:"after"`
+	Added     []credentialChange `json:"added"`
+	Removed   []credentialChange `json:"removed"`
+	Unchanged []credentialChange `json:"unchanged"`
+}
+
+// fetchVerdicts returns the status of each credential the campaign has a
+// verdict on. When a credential was guessed more than once, a valid result
+// wins over any other, and any verdict wins over an error.
+func fetchVerdicts(orchestrator string, campaignID uint, validOnly bool) (map[credential]db.ResultStatus, error) {
+	filter := map[string]interface{}{
+		"campaign_id": campaignID,
+	}
+	if validOnly {
+		filter["valid"] = true
+	}
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ReturnedFields": []string{"username", "password", "valid", "status"},
+		"Filter":         filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []db.Result
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	verdicts := make(map[credential]db.ResultStatus)
+	for _, r := range results {
+		c := credential{Username: r.Username, Password: r.Password}
+		prev, ok := verdicts[c]
+		switch {
+		case !ok, prev == db.ResultStatusError, r.Valid:
+			verdicts[c] = r.Status
+		}
+	}
+	return verdicts, nil
+}
+
+// isValidStatus reports whether the status is that of a correct credential.
+func isValidStatus(s db.ResultStatus) bool {
+	return s == db.ResultStatusValid || s == db.ResultStatusValidExpired
+}
+
+// diffVerdicts compares the valid credentials of the before campaign with
+// every verdict of the after campaign. Each list is sorted by username and
+// password.
+func diffVerdicts(before, after map[credential]db.ResultStatus) *campaignDifference {
+	d := &campaignDifference{
+		Added:     []credentialChange{},
+		Removed:   []credentialChange{},
+		Unchanged: []credentialChange{},
+	}
+	for c, status := range before {
+		if !isValidStatus(status) {
+			continue
+		}
+		change := credentialChange{credential: c, Before: status, After: after[c]}
+		if isValidStatus(change.After) {
+			d.Unchanged = append(d.Unchanged, change)
+		} else {
+			d.Removed = append(d.Removed, change)
+		}
+	}
+	for c, status := range after {
+		if isValidStatus(status) && !isValidStatus(before[c]) {
+			d.Added = append(d.Added, credentialChange{credential: c, After: status})
+		}
+	}
+
+	for _, changes := range [][]credentialChange{d.Added, d.Removed, d.Unchanged} {
+		sort.Slice(changes, func(i, j int) bool {
+			if changes[i].Username != changes[j].Username {
+				return changes[i].Username < changes[j].Username
+			}
+			return changes[i].Password < changes[j].Password
+		})
+	}
+	return d
+}
+
+// campaignDiff prints the difference between the valid credentials of two
+// campaigns to the CLI.
+func campaignDiff(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	switch flagDiffOutput {
+	case "table", "json":
+	default:
+		log.Fatalf("unknown output format %q", flagDiffOutput)
+	}
+
+	beforeID := mustResolveCampaign(args[0])
+	afterID := mustResolveCampaign(args[1])
+	if beforeID == afterID {
+		log.Fatalf("campaign %d cannot be compared with itself", beforeID)
+	}
+
+	// only the valid credentials of the first campaign are compared, but
+	// every verdict of the second is needed to tell a rotated password from
+	// one that was not tried
+	before, err := fetchVerdicts(orchestrator, beforeID, true)
+	if err != nil {
+		log.Fatalf("error retrieving results of campaign %d: %s", beforeID, err)
+	}
+	after, err := fetchVerdicts(orchestrator, afterID, false)
+	if err != nil {
+		log.Fatalf("error retrieving results of campaign %d: %s", afterID, err)
+	}
+
+	d := diffVerdicts(before, after)
+	d.Before, d.After = beforeID, afterID
+
+	if flagDiffOutput == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(d)
+		if err != nil {
+			log.Fatalf("error encoding difference: %s", err)
+		}
+		return
+	}
+
+	var rotated, untried int
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"change", "username", "password", "before", "after"})
+	for _, group := range []struct {
+		name    string
+		changes []credentialChange
+	}{
+		{"added", d.Added},
+		{"removed", d.Removed},
+		{"unchanged", d.Unchanged},
+	} {
+		for _, c := range group.changes {
+			after := string(c.After)
+			switch {
+			case c.After == db.ResultStatusInvalid:
+				rotated++
+			case c.After == "" && group.name == "removed":
+				after = "not tried"
+				untried++
+			}
+			t.AppendRow(table.Row{group.name, c.Username, c.Password, c.Before, after})
+		}
+	}
+	t.Render()
+
+	fmt.Printf("%d added, %d removed (%d now invalid, %d not tried), %d unchanged\n",
+		len(d.Added), len(d.Removed), rotated, untried, len(d.Unchanged))
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedUsers bounds the number of usernames --expand-patterns may
+// produce, so a typo in a range cannot build a campaign of millions of users
+const maxExpandedUsers = 100000
+
+// expandPatterns expands the brace and range patterns of each line, e.g.
+// {john,jane}.doe@example.org or user[001-050]@example.org. A line may hold
+// several patterns, which expand to every combination. It returns the
+// usernames and the number of lines which held a pattern.
+func expandPatterns(lines []string) ([]string, int, error) {
+	var expanded []string
+	var patterns int
+	for _, line := range lines {
+		parts, err := parsePattern(line)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error expanding %q: %w", line, err)
+		}
+
+		count := 1
+		for _, p := range parts {
+			count *= len(p)
+			if len(expanded)+count > maxExpandedUsers {
+				return nil, 0, fmt.Errorf("patterns expand to more than %d usernames", maxExpandedUsers)
+			}
+		}
+		if strings.ContainsAny(line, "{[") {
+			patterns++
+		}
+
+		combos := []string{""}
+		for _, p := range parts {
+			next := make([]string, 0, len(combos)*len(p))
+			for _, prefix := range combos {
+				for _, s := range p {
+					next = append(next, prefix+s)
+				}
+			}
+			combos = next
+		}
+		expanded = append(expanded, combos...)
+	}
+	return expanded, patterns, nil
+}
+
+// parsePattern splits a line into its parts, each of which is a list of
+// alternatives: a literal is a single alternative, {a,b} lists them, and
+// [lo-hi] holds the numbers from lo to hi, zero padded if lo and hi have the
+// same number of digits.
+func parsePattern(line string) ([][]string, error) {
+	var parts [][]string
+	literal := ""
+	for i := 0; i < len(line); i++ {
+		var open, close byte
+		switch line[i] {
+		case '{':
+			open, close = '{', '}'
+		case '[':
+			open, close = '[', ']'
+		default:
+			literal += string(line[i])
+			continue
+		}
+
+		end := strings.IndexByte(line[i+1:], close)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated %c", open)
+		}
+		body := line[i+1 : i+1+end]
+		if strings.ContainsAny(body, "{[") {
+			return nil, fmt.Errorf("nested patterns are not supported")
+		}
+		i += end + 1
+
+		var alternatives []string
+		var err error
+		if open == '{' {
+			alternatives = strings.Split(body, ",")
+		} else {
+			alternatives, err = expandRange(body)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if literal != "" {
+			parts = append(parts, []string{literal})
+			literal = ""
+		}
+		parts = append(parts, alternatives)
+	}
+	if literal != "" {
+		parts = append(parts, []string{literal})
+	}
+	return parts, nil
+}
+
+// expandRange returns the numbers of a lo-hi range.
+func expandRange(body string) ([]string, error) {
+	bounds := strings.SplitN(body, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("range [%s] is not in the lo-hi form", body)
+	}
+	lo, err := strconv.Atoi(bounds[0])
+	if err != nil || lo < 0 {
+		return nil, fmt.Errorf("range [%s] has an invalid start", body)
+	}
+	hi, err := strconv.Atoi(bounds[1])
+	if err != nil || hi < lo {
+		return nil, fmt.Errorf("range [%s] has an invalid end", body)
+	}
+	if hi-lo >= maxExpandedUsers {
+		return nil, fmt.Errorf("range [%s] expands to more than %d usernames", body, maxExpandedUsers)
+	}
+
+	format := "%d"
+	if len(bounds[0]) == len(bounds[1]) {
+		format = fmt.Sprintf("%%0%dd", len(bounds[0]))
+	}
+	numbers := make([]string, 0, hi-lo+1)
+	for n := lo; n <= hi; n++ {
+		numbers = append(numbers, fmt.Sprintf(format, n))
+	}
+	return numbers, nil
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/schema"
+)
+
+var (
+	// file the campaign definition is written to or read from
+	flagExportFile string
+
+	// name of the imported campaign, in place of the exported name
+	flagImportName string
+
+	// new start time of the imported campaign (RFC3339)
+	flagImportNotBefore string
+)
+
+var campaignExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "write a campaign definition to a file",
+	Long: `can be used to save the definition of a campaign, for example to move it
+to another orchestrator with import. the results and the live state of the
+campaign are not exported, and the provider is referenced by name only.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignExport(cmd, args)
+	},
+}
+
+var campaignImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "create a campaign from an exported definition",
+	Long: `can be used to create a campaign from a file written by export. the
+provider configuration is read from the local config, so the campaign uses the
+destination's provider settings.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignImport(cmd, args)
+	},
+}
+
+func init() {
+	campaignExportCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	campaignExportCmd.Flags().StringVarP(&flagExportFile, "file", "f", "",
+		"file to write the campaign definition to")
+	for _, flag := range []string{"campaign", "file"} {
+		err := campaignExportCmd.MarkFlagRequired(flag)
+		if err != nil {
+			log.Fatalf("issue during argument parsing: %s", err)
+		}
+	}
+
+	campaignImportCmd.Flags().StringVarP(&flagExportFile, "file", "f", "",
+		"file of an exported campaign definition")
+	campaignImportCmd.Flags().StringVar(&flagImportName, "name", "",
+		"name of the imported campaign, in place of the exported name")
+	campaignImportCmd.Flags().StringVarP(&flagImportNotBefore, "notbefore", "b", "",
+		"move the campaign to start at this time, keeping its window (format: 2006-01-02T15:04:05Z07:00)")
+	err := campaignImportCmd.MarkFlagRequired("file")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	campaignCmd.AddCommand(campaignExportCmd)
+	campaignCmd.AddCommand(campaignImportCmd)
+}
+
+// exportCampaign returns the definition of a campaign as a creation request.
+// The provider metadata is left out, since it belongs to the orchestrator's
+// config rather than the campaign.
+func exportCampaign(c *db.Campaign) *campaignRequest {
+	return &campaignRequest{
+		Name:             c.Name,
+		NotBefore:        c.NotBefore,
+		NotAfter:         c.NotAfter,
+		Deadline:         c.Deadline,
+		Status:           db.CampaignStatusActive,
+		ScheduleInterval: c.ScheduleInterval,
+		Jitter:           c.Jitter,
+		AttemptLimit:     c.AttemptLimit,
+		Priority:         c.Priority,
+		Seed:             c.Seed,
+		StopAfterValid:   c.StopAfterValid,
+		AbortOnWAF:       c.AbortOnWAF,
+		Users:            c.Users,
+		Passwords:        c.Passwords,
+		UserPasswords:    c.UserPasswords,
+		Provider:         c.Provider,
+		TargetGeo:        c.TargetGeo,
+		RandomizeWorkers: c.RandomizeWorkers,
+		AdaptiveRegions:  c.AdaptiveRegions,
+		WorkerRegions:    c.WorkerRegions,
+		CaptureOnValid:   c.CaptureOnValid,
+		Retain:           c.Retain,
+		PurgeCampaign:    c.PurgeCampaign,
+		Blackouts:        c.Blackouts,
+		QuietHours:       c.QuietHours,
+	}
+}
+
+// move moves the campaign to start at notBefore, moving its end and deadline
+// by the same amount so the window keeps its length.
+func (c *campaignRequest) move(notBefore time.Time) {
+	shift := notBefore.Sub(c.NotBefore)
+	c.NotBefore = notBefore
+	c.NotAfter = c.NotAfter.Add(shift)
+	if c.Deadline != nil {
+		deadline := c.Deadline.Add(shift)
+		c.Deadline = &deadline
+	}
+}
+
+// campaignExport writes the definition of a campaign to the export file.
+func campaignExport(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	campaign, err := fetchCampaign(orchestrator, mustResolveCampaign(campaignRef))
+	if err != nil {
+		log.Fatalf("error describing campaign: %s", err)
+	}
+	if campaign.HashUsernames {
+		log.Fatalf("campaign %d stores hashed usernames, which cannot be exported", campaign.ID)
+	}
+
+	b, err := json.MarshalIndent(exportCampaign(campaign), "", "  ")
+	if err != nil {
+		log.Fatalf("error encoding campaign: %s", err)
+	}
+	err = ioutil.WriteFile(flagExportFile, append(b, '\n'), 0600)
+	if err != nil {
+		log.Fatalf("error writing campaign: %s", err)
+	}
+	log.Infof("exported campaign %d to %s", campaign.ID, flagExportFile)
+}
+
+// readExport reads an exported campaign and fills in the provider metadata
+// from the local config. With a non-zero notBefore, the campaign is moved to
+// start then and its end and deadline are moved by the same amount.
+func readExport(path, name string, notBefore time.Time) (*campaignRequest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var campaign campaignRequest
+	err = json.Unmarshal(b, &campaign)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing campaign: %w", err)
+	}
+
+	if name != "" {
+		campaign.Name = name
+	}
+	campaign.Status = db.CampaignStatusActive
+	campaign.ProviderMetadata, _ = providerConfig(campaign.Provider)
+	if len(campaign.ProviderMetadata) == 0 {
+		log.Warnf("the %s provider has no config, its defaults will be used", campaign.Provider)
+	}
+
+	if !notBefore.IsZero() {
+		campaign.move(notBefore)
+	}
+	if !campaign.NotAfter.After(time.Now()) {
+		return nil, fmt.Errorf("the campaign window ended at %s, use --notbefore to move it", campaign.NotAfter)
+	}
+
+	body, err := json.Marshal(&campaign)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.ValidateCampaign(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid campaign: %w", err)
+	}
+	return &campaign, nil
+}
+
+// campaignImport creates a campaign from the export file.
+func campaignImport(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	var notBefore time.Time
+	if flagImportNotBefore != "" {
+		var err error
+		notBefore, err = time.Parse(time.RFC3339Nano, flagImportNotBefore)
+		if err != nil {
+			log.Fatalf("error parsing notBefore time: %s", err)
+		}
+	}
+
+	campaign, err := readExport(flagExportFile, flagImportName, notBefore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := campaign.Name
+	if name == "" {
+		name = "generated"
+	}
+	fmt.Printf("\n[Imported Campaign]\nName: %s\nNot Before: %s\nNot After: %s\n"+
+		"Provider: %s\nMetadata: %v\nUsername count: %d\nAttempts: %d\n\n",
+		name, campaign.NotBefore, campaign.NotAfter, campaign.Provider,
+		campaign.ProviderMetadata, len(campaign.Users), campaign.attempts())
+	if !confirm("Send campaign?") {
+		log.Printf("not sending campaign")
+		return
+	}
+
+	created, err := sendCampaign(orchestrator, campaign)
+	if err != nil {
+		log.Fatalf("error sending campaign: %s", err)
+	}
+	log.Infof("successfully imported campaign %d (%s)", created.ID, created.Name)
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// swappedThreshold is the share of lines which must look wrong before the user
+// and password files are reported
+const swappedThreshold = 0.5
+
+// emailUsernames lists the providers whose usernames are email addresses
+var emailUsernames = map[string]bool{
+	"o365":       true,
+	"salesforce": true,
+}
+
+// the values of --validate-usernames
+const (
+	// validateAbort refuses the campaign if any username is malformed
+	validateAbort = "abort"
+
+	// validateDrop leaves the malformed usernames out of the campaign
+	validateDrop = "drop"
+)
+
+// maxMalformedShown bounds the number of malformed usernames reported
+const maxMalformedShown = 10
+
+// usernameFormats holds the patterns of the named username formats. A upn
+// may have a domain without a dot, a sam (down-level logon name) may carry a
+// NetBIOS domain and holds at most 20 characters.
+var usernameFormats = map[string]*regexp.Regexp{
+	"email": regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s.]+$`),
+	"upn":   regexp.MustCompile(`^[^@\\\s]+@[^@\\\s]+$`),
+	"sam":   regexp.MustCompile(`^([^@\\\s]+\\)?[^@\\\s"/\[\]:;|=,+*?<>]{1,20}$`),
+}
+
+// usernamePattern returns the pattern of a username_format, which is either
+// email, upn, sam, or a regular expression.
+func usernamePattern(format string) (*regexp.Regexp, error) {
+	if re, ok := usernameFormats[format]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username_format %q: %w", format, err)
+	}
+	return re, nil
+}
+
+// normalizeUsername trims the space around a username and, for the email and
+// upn formats, lower cases its domain.
+func normalizeUsername(format, u string) string {
+	u = strings.TrimSpace(u)
+	if format != "email" && format != "upn" {
+		return u
+	}
+	i := strings.LastIndex(u, "@")
+	if i < 0 {
+		return u
+	}
+	return u[:i+1] + strings.ToLower(u[i+1:])
+}
+
+// validateUsernames normalizes the users and splits them into those matching
+// the username format and the malformed ones. The passwords of a renamed user
+// in userPasswords, if set, move to the normalized name.
+func validateUsernames(format string, users []string, userPasswords db.UserPasswords) ([]string, []string, error) {
+	pattern, err := usernamePattern(format)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var valid, malformed []string
+	for _, u := range users {
+		n := normalizeUsername(format, u)
+		if !pattern.MatchString(n) {
+			malformed = append(malformed, u)
+			continue
+		}
+		if userPasswords != nil && n != u {
+			userPasswords[n] = userPasswords[u]
+			delete(userPasswords, u)
+		}
+		valid = append(valid, n)
+	}
+	return valid, malformed, nil
+}
+
+// checkCredentialFiles applies a few heuristics to the usernames and passwords
+// of a campaign and returns a warning for each one that suggests the user and
+// password files were mixed up. Passwords may be nil if the campaign has
+// per-user passwords.
+func checkCredentialFiles(provider string, users, passwords []string) []string {
+	var warnings []string
+	if len(users) == 0 {
+		return nil
+	}
+
+	emails := countLines(users, isEmail)
+	if emailUsernames[provider] && share(emails, len(users)) < swappedThreshold {
+		warnings = append(warnings, fmt.Sprintf(
+			"only %d of %d usernames are email addresses, which the %s provider expects",
+			emails, len(users), provider))
+	}
+
+	if n := countLines(users, passwordLike); share(n, len(users)) >= swappedThreshold {
+		warnings = append(warnings, fmt.Sprintf(
+			"%d of %d usernames look like passwords", n, len(users)))
+	}
+
+	if len(passwords) > 0 {
+		n := countLines(passwords, isEmail)
+		if share(n, len(passwords)) >= swappedThreshold && n > emails {
+			warnings = append(warnings, fmt.Sprintf(
+				"%d of %d passwords are email addresses, the user and password files may be swapped",
+				n, len(passwords)))
+		}
+	}
+	return warnings
+}
+
+// countLines returns the number of lines matching f.
+func countLines(lines []string, f func(string) bool) int {
+	var n int
+	for _, l := range lines {
+		if f(l) {
+			n++
+		}
+	}
+	return n
+}
+
+// share returns n as a fraction of total.
+func share(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// isEmail returns true if s has the form of an email address.
+func isEmail(s string) bool {
+	i := strings.LastIndex(s, "@")
+	return i > 0 && strings.Contains(s[i+1:], ".") && !strings.ContainsAny(s, " \t")
+}
+
+// passwordLike returns true if s looks more like a password than a username:
+// it mixes upper case letters and digits, or has spaces or symbols which are
+// not found in email addresses and DOMAIN\user names.
+func passwordLike(s string) bool {
+	var upper, digit, symbol bool
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r):
+			if !strings.ContainsRune(`@.-_\'+`, r) {
+				symbol = true
+			}
+		}
+	}
+	return (upper && digit) || symbol
+}
@@ -16,9 +16,12 @@ package commands
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/jedib0t/go-pretty/table"
 	log "github.com/sirupsen/logrus"
@@ -37,8 +40,18 @@ var listCmd = &cobra.Command{
 	},
 }
 
+var (
+	// only list campaigns with this status
+	flagListStatus string
+
+	// only list campaigns active at some point between these times
+	flagListSince string
+	flagListUntil string
+)
+
 var listTableHeaderNames = []string{
 	"campaign id",
+	"name",
 	"provider",
 	"metadata",
 	"status",
@@ -47,6 +60,7 @@ var listTableHeaderNames = []string{
 
 var listTableHeaderFields = []string{
 	"id",
+	"name",
 	"provider",
 	"provider_metadata",
 	"status",
@@ -54,15 +68,86 @@ var listTableHeaderFields = []string{
 }
 
 func init() {
+	listCmd.Flags().StringVar(&flagListStatus, "status", "",
+		"only list campaigns with this status (scheduled, active, paused, cancelled, deadlineexceeded, completed)")
+	listCmd.Flags().StringVar(&flagListSince, "since", "",
+		"only list campaigns whose window ends after this RFC3339 time, or this long ago (ex: 72h)")
+	listCmd.Flags().StringVar(&flagListUntil, "until", "",
+		"only list campaigns whose window starts before this RFC3339 time, or this long ago (ex: 24h, +24h from now)")
+
 	campaignCmd.AddCommand(listCmd)
 }
 
+// parseStatus returns the CampaignStatus matching s, ignoring case.
+func parseStatus(s string) (db.CampaignStatus, error) {
+	for _, status := range db.CampaignStatuses {
+		if strings.EqualFold(s, string(status)) {
+			return status, nil
+		}
+	}
+	return "", fmt.Errorf("unknown campaign status %q", s)
+}
+
+// parseTimeFilter parses either an RFC3339 time, or a duration relative to
+// now. A duration is in the past unless it starts with a plus sign, so 72h is
+// three days ago and +24h is a day from now.
+func parseTimeFilter(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC3339 time nor a duration", s)
+	}
+	if strings.HasPrefix(s, "+") {
+		return now.Add(d), nil
+	}
+	return now.Add(-d), nil
+}
+
 // listGet will retrieve a list of the currently tracked campaigns
 // and print that list to the CLI
 func listGet(cmd *cobra.Command, args []string) {
 	orchestrator := viper.GetString("orchestrator-url")
 
-	req, err := http.NewRequest("GET", orchestrator+"/list", nil)
+	// the campaigns are filtered by the orchestrator
+	query := url.Values{}
+	if flagListStatus != "" {
+		status, err := parseStatus(flagListStatus)
+		if err != nil {
+			log.Fatal(err)
+		}
+		query.Set("status", string(status))
+	}
+	now := time.Now()
+	var since, until time.Time
+	for _, f := range []struct {
+		name  string
+		value string
+		t     *time.Time
+	}{
+		{"since", flagListSince, &since},
+		{"until", flagListUntil, &until},
+	} {
+		if f.value == "" {
+			continue
+		}
+		t, err := parseTimeFilter(f.value, now)
+		if err != nil {
+			log.Fatalf("error parsing --%s: %s", f.name, err)
+		}
+		*f.t = t
+		query.Set(f.name, t.Format(time.RFC3339Nano))
+	}
+	if !since.IsZero() && !until.IsZero() && until.Before(since) {
+		log.Fatalf("--until %s is before --since %s", until, since)
+	}
+
+	listURL := orchestrator + "/list"
+	if len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+	req, err := newRequest("GET", listURL, nil)
 	if err != nil {
 		log.Fatalf("error during request creation: %s", err)
 	}
@@ -73,12 +158,17 @@ func listGet(cmd *cobra.Command, args []string) {
 		log.Fatalf("error during authentication: %s", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doRequest(req)
 	if err != nil {
 		log.Fatalf("error sending request: %s", err)
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error listing campaigns: %s", err)
+	}
+
 	// handle the results from the server
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -101,18 +191,18 @@ func listGet(cmd *cobra.Command, args []string) {
 	t.AppendHeader(header)
 
 	for _, result := range results {
+		// Legacy handling for campaigns created pre-Status implementation
+		if result["status"] == "" {
+			result["status"] = string(db.CampaignStatusActive)
+		}
+
 		var row table.Row
 		for _, field := range listTableHeaderFields {
 			v, ok := result[field]
 			if !ok {
 				log.Fatal("there was an error retrieving results from the map")
 			}
-			// Legacy handling for campaigns created pre-Status implementation
-			if field == "status" && v == "" {
-				row = append(row, db.CampaignStatusActive)
-			} else {
-				row = append(row, v)
-			}
+			row = append(row, v)
 		}
 		t.AppendRows([]table.Row{row})
 	}
@@ -21,22 +21,24 @@ import (
 	"github.com/praetorian-inc/trident/pkg/db"
 )
 
+// pause or resume every campaign
+var flagAll bool
+
 var pauseCommand = &cobra.Command{
 	Use:   "pause",
 	Short: "pause campaign execution",
-	Long:  `can be used to temporarily pause a running campaign.`,
+	Long: `can be used to temporarily pause a running campaign. with --all, every
+active or scheduled campaign is paused at once.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pausePost(cmd, args)
 	},
 }
 
 func init() {
-	pauseCommand.Flags().UintVarP(&campaignID, "campaign", "c", 0,
-		"the identifier of the campaign.")
-	err := pauseCommand.MarkFlagRequired("campaign")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
-	}
+	pauseCommand.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	pauseCommand.Flags().BoolVar(&flagAll, "all", false,
+		"pause every active or scheduled campaign.")
 
 	campaignCmd.AddCommand(pauseCommand)
 }
@@ -44,5 +46,15 @@ func init() {
 // pausePost will post the parameters update the Status
 // of the campaign specified by the provided ID to CampaignStatusPaused
 func pausePost(cmd *cobra.Command, args []string) {
-	updateStatus(campaignID, db.CampaignStatusPaused)
+	if flagAll {
+		if campaignRef != "" {
+			log.Fatal("--all may not be combined with --campaign")
+		}
+		log.Infof("paused %d campaigns", updateAllStatus(db.CampaignStatusPaused))
+		return
+	}
+	if campaignRef == "" {
+		log.Fatal("one of --campaign or --all is required")
+	}
+	updateStatus(mustResolveCampaign(campaignRef), db.CampaignStatusPaused)
 }
@@ -0,0 +1,99 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// progressInterval is the minimum time between two progress updates
+const progressInterval = 100 * time.Millisecond
+
+// spinner is drawn in front of the progress
+var spinner = []rune(`|/-\`)
+
+// progress wraps a request body and reports how much of it has been read, i.e.
+// uploaded, on a single terminal line.
+type progress struct {
+	r     io.Reader
+	out   io.Writer
+	label string
+	total int64
+	read  int64
+	frame int
+	last  time.Time
+	done  bool
+}
+
+// showProgress returns true unless progress was suppressed with --quiet or the
+// output is not a terminal.
+func showProgress() bool {
+	return !flagQuiet && isTerminal(os.Stdout) && isTerminal(os.Stderr)
+}
+
+// isTerminal returns true if f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress returns r, reporting its progress on stderr if showProgress.
+func newProgress(label string, r io.Reader, total int64) io.Reader {
+	if !showProgress() {
+		return r
+	}
+	return &progress{r: r, out: os.Stderr, label: label, total: total}
+}
+
+// Read reads from the wrapped reader and updates the progress line. Once the
+// whole body has been read, the line is finished.
+func (p *progress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	if p.done {
+		return n, err
+	}
+
+	// \x1b[K clears the rest of the line
+	switch {
+	case err == io.EOF:
+		p.done = true
+		fmt.Fprintf(p.out, "\r%s: %s sent, waiting for the orchestrator\x1b[K\n",
+			p.label, formatBytes(p.read))
+	case time.Since(p.last) >= progressInterval && p.total > 0:
+		p.last = time.Now()
+		p.frame = (p.frame + 1) % len(spinner)
+		fmt.Fprintf(p.out, "\r%c %s: %s of %s (%.0f%%)\x1b[K", spinner[p.frame], p.label,
+			formatBytes(p.read), formatBytes(p.total), 100*float64(p.read)/float64(p.total))
+	}
+	return n, err
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
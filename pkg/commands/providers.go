@@ -0,0 +1,362 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/generic"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/gitlab"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/jenkins"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ldap"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mail"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ntlm"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/salesforce"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/vmware"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/windows"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/wordpress"
+)
+
+var providersCmd = &cobra.Command{
+	Use:   "providers",
+	Short: "top-level command for the authentication providers",
+	Long: `used by an operator to discover the authentication providers campaigns
+	can target and how to configure them`,
+}
+
+var providersListCmd = &cobra.Command{
+	Use:   "list [provider]",
+	Short: "list the providers and their config options",
+	Long: `lists each provider compiled into the client with the config options it
+accepts under providers.<name> in config.yaml, marking the required ones. pass
+a provider name to list only its options.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		providersList(cmd, args)
+	},
+}
+
+var providersReplayCmd = &cobra.Command{
+	Use:   "replay provider",
+	Short: "classify saved responses with a provider",
+	Long: `replays a login against the provider offline, answering each request it
+sends with the next saved response instead of the network, and prints the
+verdict the provider reaches. a response is either the raw HTTP response, as
+printed by curl -i or saved from an intercepting proxy, or the capture of a
+valid result as JSON. pass one --response per request the login sends, in
+order. the provider is configured from providers.<name> in config.yaml, and
+--option overrides single options. only the HTTP providers can be replayed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		providersReplay(cmd, args)
+	},
+}
+
+var providersTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "check that a provider classifies a known good and a known bad credential",
+	Long: `makes exactly two logins from this machine, one with a known good and one
+with a known bad credential, and prints the verdict the provider reaches for
+each. the good credential must be classified as valid and the bad one as
+invalid, otherwise the command fails. use it before a real campaign to confirm
+a new provider config, such as generic-http patterns or a classifier, detects
+success and failure correctly. the provider is configured from
+providers.<name> in config.yaml, and --option overrides single options. point
+it at a test account or a local mock, since the bad login counts towards the
+account's lockout.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		providersTest(cmd, args)
+	},
+}
+
+var (
+	flagReplayResponses []string
+	flagReplayOptions   []string
+	flagReplayUsername  string
+	flagReplayPassword  string
+
+	flagTestProvider string
+	flagTestGood     string
+	flagTestBad      string
+	flagTestOptions  []string
+)
+
+func init() {
+	providersReplayCmd.Flags().StringArrayVarP(&flagReplayResponses, "response", "r", nil,
+		"file holding a saved response, repeated in the order the requests are sent")
+	providersReplayCmd.Flags().StringArrayVar(&flagReplayOptions, "option", nil,
+		"provider option as key=value, overriding the config")
+	providersReplayCmd.Flags().StringVar(&flagReplayUsername, "username", "replay@example.org",
+		"username passed to the provider")
+	providersReplayCmd.Flags().StringVar(&flagReplayPassword, "password", "replay",
+		"password passed to the provider")
+	_ = providersReplayCmd.MarkFlagRequired("response")
+
+	providersTestCmd.Flags().StringVarP(&flagTestProvider, "auth-provider", "a", "",
+		"the provider to test")
+	providersTestCmd.Flags().StringVar(&flagTestGood, "good", "",
+		"a valid credential as username:password")
+	providersTestCmd.Flags().StringVar(&flagTestBad, "bad", "",
+		"an invalid credential as username:password")
+	providersTestCmd.Flags().StringArrayVar(&flagTestOptions, "option", nil,
+		"provider option as key=value, overriding the config")
+	for _, flag := range []string{"auth-provider", "good", "bad"} {
+		_ = providersTestCmd.MarkFlagRequired(flag)
+	}
+
+	providersCmd.AddCommand(providersListCmd)
+	providersCmd.AddCommand(providersReplayCmd)
+	providersCmd.AddCommand(providersTestCmd)
+	rootCmd.AddCommand(providersCmd)
+}
+
+// providersList prints the providers and their config options to the CLI.
+func providersList(cmd *cobra.Command, args []string) {
+	names := nozzle.Drivers()
+	if len(args) == 1 {
+		names = args
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"provider", "option", "required", "description"})
+	for _, name := range names {
+		opts, err := nozzle.Describe(name)
+		if err != nil {
+			log.Fatalf("error describing provider: %s", err)
+		}
+		for i, opt := range opts {
+			provider := ""
+			if i == 0 {
+				provider = name
+			}
+			required := ""
+			if opt.Required {
+				required = "yes"
+			}
+			t.AppendRow(table.Row{provider, opt.Name, required, opt.Description})
+		}
+	}
+	t.Render()
+}
+
+// replayable reports whether the provider sends its requests through the
+// shared nozzle transport, which is the one the replayer answers. The ntlm
+// strategy of adfs negotiates on its own connection, so it is not.
+func replayable(name string, metadata map[string]string) bool {
+	opts, err := nozzle.Describe(name)
+	if err != nil {
+		return false
+	}
+	if name == "adfs" && metadata["strategy"] == "ntlm" {
+		return false
+	}
+	for _, opt := range opts {
+		if opt.Name == "keep_alive" {
+			return true
+		}
+	}
+	return false
+}
+
+// providerOptions returns the options of the provider from providers.<name>
+// in config.yaml, overridden by options given as key=value.
+func providerOptions(name string, overrides []string) map[string]string {
+	config, _ := providerConfig(name)
+	metadata := make(map[string]string)
+	for k, v := range config {
+		metadata[k] = fmt.Sprint(v)
+	}
+	for _, opt := range overrides {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			log.Fatalf("error parsing option %q: expected key=value", opt)
+		}
+		metadata[kv[0]] = kv[1]
+	}
+	return metadata
+}
+
+// providersReplay runs a login through the provider against the saved
+// responses and prints its verdict to the CLI.
+func providersReplay(cmd *cobra.Command, args []string) {
+	name := args[0]
+	metadata := providerOptions(name, flagReplayOptions)
+
+	if !replayable(name, metadata) {
+		log.Fatalf("error replaying provider %s: only providers sending their requests over HTTP can be replayed", name)
+	}
+
+	noz, err := nozzle.Open(name, metadata)
+	if err != nil {
+		log.Fatalf("error opening provider: %s", err)
+	}
+	r, err := nozzle.ReadReplayer(flagReplayResponses...)
+	if err != nil {
+		log.Fatalf("error reading responses: %s", err)
+	}
+
+	res, err := nozzle.ReplayLogin(noz, r, flagReplayUsername, flagReplayPassword)
+	for i, req := range r.Requests {
+		fmt.Printf("Request %d:  %s\n", i+1, req)
+	}
+	if n := len(flagReplayResponses) - len(r.Requests); n > 0 {
+		log.Warnf("%d saved responses were not requested", n)
+	}
+	if err != nil {
+		fmt.Printf("Status:     %s (%s)\n", db.ResultStatusError, nozzle.ErrorCategory(err))
+		fmt.Printf("Error:      %s\n", nozzle.SanitizeError(err, flagReplayPassword))
+		return
+	}
+
+	fmt.Printf("Status:     %s\n", responseStatus(res))
+	fmt.Printf("MFA:        %t\n", res.MFA)
+	if len(res.Metadata) > 0 {
+		b, err := json.MarshalIndent(res.Metadata, "", "  ")
+		if err != nil {
+			log.Fatalf("error encoding metadata: %s", err)
+		}
+		fmt.Printf("Metadata:   %s\n", b)
+	}
+}
+
+// responseStatus returns the status the orchestrator gives a result with the
+// provider's verdict.
+func responseStatus(res *event.AuthResponse) db.ResultStatus {
+	result := db.Result{
+		Valid:       res.Valid,
+		Locked:      res.Locked,
+		MFA:         res.MFA,
+		Expired:     res.Expired,
+		RateLimited: res.RateLimited,
+		WAF:         res.WAF,
+	}
+	return result.Classify()
+}
+
+// providerTest is one of the logins made by providers test and the statuses
+// it is expected to have.
+type providerTest struct {
+	Label    string
+	Username string
+	Password string
+	Expected []db.ResultStatus
+}
+
+// parseCredential splits a credential given as username:password at the first
+// colon, so the password may hold colons.
+func parseCredential(s string) (string, string, error) {
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", "", fmt.Errorf("expected username:password")
+	}
+	return kv[0], kv[1], nil
+}
+
+// providersTest logs in with the good and the bad credential and prints the
+// verdict of each, failing unless both were classified as expected.
+func providersTest(cmd *cobra.Command, args []string) {
+	name := flagTestProvider
+	metadata := providerOptions(name, flagTestOptions)
+	config := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		config[k] = v
+	}
+	if err := checkProviderConfig(name, config); err != nil {
+		log.Fatal(err)
+	}
+
+	noz, err := nozzle.Open(name, metadata)
+	if err != nil {
+		log.Fatalf("error opening provider: %s", err)
+	}
+
+	var tests []providerTest
+	for _, c := range []struct {
+		label, credential string
+		expected          []db.ResultStatus
+	}{
+		{"good", flagTestGood, []db.ResultStatus{db.ResultStatusValid, db.ResultStatusValidExpired}},
+		{"bad", flagTestBad, []db.ResultStatus{db.ResultStatusInvalid}},
+	} {
+		username, password, err := parseCredential(c.credential)
+		if err != nil {
+			log.Fatalf("error parsing --%s: %s", c.label, err)
+		}
+		tests = append(tests, providerTest{c.label, username, password, c.expected})
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"credential", "username", "status", "expected", "result", "detail"})
+	var failed int
+	for _, test := range tests {
+		var status db.ResultStatus
+		var details []string
+		res, err := noz.Login(test.Username, test.Password)
+		if err != nil {
+			status = db.ResultStatusError
+			details = append(details, fmt.Sprintf("%s: %s", nozzle.ErrorCategory(err),
+				nozzle.SanitizeError(err, test.Password)))
+		} else {
+			status = responseStatus(res)
+			if res.MFA {
+				details = append(details, "mfa")
+			}
+			if res.WAF != "" {
+				details = append(details, "waf: "+res.WAF)
+			}
+			if reason, ok := res.Metadata["reason"]; ok {
+				details = append(details, fmt.Sprint(reason))
+			}
+		}
+
+		result := "FAIL"
+		expected := make([]string, len(test.Expected))
+		for i, e := range test.Expected {
+			expected[i] = string(e)
+			if status == e {
+				result = "ok"
+			}
+		}
+		if result != "ok" {
+			failed++
+		}
+		t.AppendRow(table.Row{test.Label, test.Username, status, strings.Join(expected, " or "), result,
+			strings.Join(details, ", ")})
+	}
+	t.Render()
+
+	if failed > 0 {
+		log.Fatalf("the %s provider misclassified %d of %d credentials, fix its config before a campaign",
+			name, failed, len(tests))
+	}
+	fmt.Printf("the %s provider classified both credentials correctly\n", name)
+}
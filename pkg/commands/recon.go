@@ -0,0 +1,119 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+var (
+	// provider options as key=value, overriding the config
+	flagReconOptions []string
+
+	// output format of the probed endpoints, table or json
+	flagReconOutput string
+)
+
+var campaignReconCmd = &cobra.Command{
+	Use:   "recon <provider> [host]",
+	Short: "probe a target for legacy authentication endpoints",
+	Long: `probes the host for the endpoints of the provider which accept a password
+on their own, such as the basic authentication endpoints of Exchange or the
+WS-Trust endpoints of adfs, and reports which are enabled. these endpoints
+often bypass the MFA enforced by the identity provider, so a campaign may
+target them instead of the protected sign-in. no credentials are sent, and the
+requests are made from this machine rather than the workers. the host
+defaults to the provider's configured target. the provider is configured from
+providers.<name> in config.yaml, and --option overrides single options.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignRecon(cmd, args)
+	},
+}
+
+func init() {
+	campaignReconCmd.Flags().StringArrayVar(&flagReconOptions, "option", nil,
+		"provider option as key=value, overriding the config")
+	campaignReconCmd.Flags().StringVarP(&flagReconOutput, "output-format", "o", "table",
+		"output format: table or json")
+
+	campaignCmd.AddCommand(campaignReconCmd)
+}
+
+// campaignRecon probes the host for the provider's endpoints and prints them
+// to the CLI.
+func campaignRecon(cmd *cobra.Command, args []string) {
+	switch flagReconOutput {
+	case "table", "json":
+	default:
+		log.Fatalf("unknown output format %q", flagReconOutput)
+	}
+
+	name := args[0]
+	var host string
+	if len(args) == 2 {
+		host = args[1]
+	}
+	if _, err := nozzle.Describe(name); err != nil {
+		log.Fatalf("error probing %s: %s", name, err)
+	}
+
+	surfaces, err := nozzle.Probe(name, host, providerOptions(name, flagReconOptions))
+	if err != nil {
+		log.Fatalf("error probing %s: %s (providers which can probe: %s)", name, err,
+			strings.Join(nozzle.Probers(), ", "))
+	}
+
+	if flagReconOutput == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(surfaces)
+		if err != nil {
+			log.Fatalf("error encoding endpoints: %s", err)
+		}
+		return
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"endpoint", "url", "enabled", "auth", "password", "detail"})
+	var legacy []string
+	for _, s := range surfaces {
+		enabled, password := "no", ""
+		if s.Exposed {
+			enabled = "yes"
+		}
+		if s.Legacy && s.Exposed {
+			password = "yes"
+			legacy = append(legacy, s.Name)
+		}
+		t.AppendRow(table.Row{s.Name, s.Endpoint, enabled, strings.Join(s.Auth, " "), password, s.Detail})
+	}
+	t.Render()
+
+	if len(legacy) == 0 {
+		fmt.Println("No enabled endpoint accepts a password on its own.")
+		return
+	}
+	fmt.Printf("%d enabled endpoints accept a password on their own and may bypass MFA: %s\n",
+		len(legacy), strings.Join(legacy, ", "))
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/schema"
+)
+
+var (
+	// name of the replay campaign, in place of "<source>-replay"
+	flagReplayName string
+
+	// start time of the replay campaign (RFC3339), now if not set
+	flagReplayNotBefore string
+)
+
+// inconclusiveStatuses lists the result statuses of guesses which never got
+// an answer from the provider, so the credential was not actually tested.
+var inconclusiveStatuses = map[db.ResultStatus]bool{
+	db.ResultStatusError:       true,
+	db.ResultStatusRateLimited: true,
+	db.ResultStatusChallenged:  true,
+}
+
+var campaignReplayCmd = &cobra.Command{
+	Use:   "replay [campaign]",
+	Short: "re-submit the inconclusive attempts of a campaign",
+	Long: `can be used to create a new campaign from the attempts of a campaign which
+ended in an error, were rate limited, or were challenged by a WAF, so the
+credentials which were never tested can be tried again without re-spraying
+everything. attempts which also have a conclusive result are left out.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignReplay(cmd, args)
+	},
+}
+
+func init() {
+	campaignReplayCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	campaignReplayCmd.Flags().StringVar(&flagReplayName, "name", "",
+		"name of the replay campaign (default: the campaign's name followed by -replay)")
+	campaignReplayCmd.Flags().StringVarP(&flagReplayNotBefore, "notbefore", "b", "",
+		"start the replay at this time instead of now, keeping the campaign's window (format: 2006-01-02T15:04:05Z07:00)")
+	campaignCmd.AddCommand(campaignReplayCmd)
+}
+
+// inconclusiveAttempts returns the passwords of each user whose guesses in
+// the campaign only had inconclusive results, along with the users in the
+// order they were first attempted.
+func inconclusiveAttempts(orchestrator string, campaignID uint) ([]string, db.UserPasswords, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ReturnedFields": []string{"username", "password", "status"},
+		"Filter": map[string]interface{}{
+			"campaign_id": campaignID,
+		},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := newRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var results []db.Result
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, passwords := replayAttempts(results)
+	return users, passwords, nil
+}
+
+// replayAttempts picks the attempts to replay from the results of a campaign,
+// which the orchestrator returns newest first.
+func replayAttempts(results []db.Result) ([]string, db.UserPasswords) {
+	type attempt struct{ username, password string }
+	conclusive := make(map[attempt]bool)
+	for _, r := range results {
+		if !inconclusiveStatuses[r.Status] {
+			conclusive[attempt{r.Username, r.Password}] = true
+		}
+	}
+
+	var users []string
+	passwords := make(db.UserPasswords)
+	seen := make(map[attempt]bool)
+	for i := len(results) - 1; i >= 0; i-- {
+		a := attempt{results[i].Username, results[i].Password}
+		if conclusive[a] || seen[a] {
+			continue
+		}
+		seen[a] = true
+		if _, ok := passwords[a.username]; !ok {
+			users = append(users, a.username)
+		}
+		passwords[a.username] = append(passwords[a.username], a.password)
+	}
+	return users, passwords
+}
+
+// replayCampaign returns a campaign which retries the inconclusive attempts
+// of the source campaign with its settings and provider config, starting at
+// notBefore.
+func replayCampaign(source *db.Campaign, users []string, passwords db.UserPasswords,
+	name string, notBefore time.Time) (*campaignRequest, error) {
+	campaign := exportCampaign(source)
+	campaign.Users = users
+	campaign.Passwords = nil
+	campaign.UserPasswords = passwords
+
+	campaign.Name = name
+	if campaign.Name == "" && source.Name != "" {
+		campaign.Name = source.Name + "-replay"
+	}
+
+	if len(source.ProviderMetadata) > 0 {
+		err := json.Unmarshal(source.ProviderMetadata, &campaign.ProviderMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing provider metadata: %w", err)
+		}
+	}
+
+	campaign.move(notBefore)
+
+	body, err := json.Marshal(campaign)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.ValidateCampaign(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid campaign: %w", err)
+	}
+	return campaign, nil
+}
+
+// campaignReplay creates a campaign from the inconclusive attempts of the
+// provided campaign.
+func campaignReplay(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	if len(args) > 0 {
+		campaignRef = args[0]
+	}
+	if campaignRef == "" {
+		log.Fatal("a campaign is required, either as an argument or with --campaign")
+	}
+	campaignID := mustResolveCampaign(campaignRef)
+
+	notBefore := time.Now()
+	if flagReplayNotBefore != "" {
+		var err error
+		notBefore, err = time.Parse(time.RFC3339Nano, flagReplayNotBefore)
+		if err != nil {
+			log.Fatalf("error parsing notBefore time: %s", err)
+		}
+	}
+
+	source, err := fetchCampaign(orchestrator, campaignID)
+	if err != nil {
+		log.Fatalf("error describing campaign: %s", err)
+	}
+	switch source.Status {
+	case "", db.CampaignStatusActive, db.CampaignStatusScheduled, db.CampaignStatusPaused:
+		log.Warnf("campaign %d has not finished, only the attempts made so far are replayed", campaignID)
+	}
+
+	users, passwords, err := inconclusiveAttempts(orchestrator, campaignID)
+	if err != nil {
+		log.Fatalf("error retrieving results: %s", err)
+	}
+	if len(users) == 0 {
+		log.Fatalf("campaign %d has no inconclusive attempts to replay", campaignID)
+	}
+
+	campaign, err := replayCampaign(source, users, passwords, flagReplayName, notBefore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := campaign.Name
+	if name == "" {
+		name = "generated"
+	}
+	fmt.Printf("\n[Replay Campaign]\nName: %s\nSource Campaign: %d\nNot Before: %s\nNot After: %s\n"+
+		"Provider: %s\nUsername count: %d\nAttempts: %d\n\n",
+		name, campaignID, campaign.NotBefore, campaign.NotAfter, campaign.Provider,
+		len(campaign.Users), campaign.attempts())
+	if !confirm("Send campaign?") {
+		log.Printf("not sending campaign")
+		return
+	}
+
+	created, err := sendCampaign(orchestrator, campaign)
+	if err != nil {
+		log.Fatalf("error sending campaign: %s", err)
+	}
+	log.Infof("successfully created replay campaign %d (%s)", created.ID, created.Name)
+}
@@ -19,14 +19,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/jedib0t/go-pretty/table"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/sign"
 )
 
 var (
@@ -38,6 +42,18 @@ var (
 
 	// the desired format for output (csv, json, table)
 	flagOutputFormat string
+
+	// only return attempts which failed with an error
+	flagErrorsOnly bool
+
+	// write a detached signature of the output to this file
+	flagSign string
+
+	// keep printing new results as they are recorded
+	flagFollow bool
+
+	// the local user list of campaigns which hash usernames
+	flagResultsUserFile string
 )
 
 var (
@@ -48,6 +64,15 @@ var (
 		"username",
 		"password",
 		"valid",
+		"status",
+	}
+
+	// ErrorReturnedFields lists the fields shown by default with --errors-only
+	ErrorReturnedFields = []string{
+		"id",
+		"username",
+		"error_category",
+		"error",
 	}
 )
 
@@ -72,6 +97,18 @@ func init() {
 	// default: table (terminal friendly)
 	resultsCmd.Flags().StringVarP(&flagOutputFormat, "output-format", "o", "table",
 		"output format (table, csv, json)")
+
+	resultsCmd.Flags().BoolVar(&flagErrorsOnly, "errors-only", false,
+		"only return attempts which failed with an error (combined with --filter if set)")
+
+	resultsCmd.Flags().StringVar(&flagSign, "sign", "",
+		"write a detached signature of the output to this file, using the configured signing-key")
+
+	resultsCmd.Flags().BoolVar(&flagFollow, "follow", false,
+		"keep printing new results as they are recorded, until interrupted (requires the grpc transport)")
+
+	resultsCmd.Flags().StringVarP(&flagResultsUserFile, "userfile", "u", "",
+		"show the usernames of campaigns created with --hash-usernames, from this user list (newline separated)")
 	rootCmd.AddCommand(resultsCmd)
 }
 
@@ -88,38 +125,27 @@ func resultsGet(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatalf("error during JSON unmarshalling: %s", err)
 	}
-
-	// build our request to the orchestrator using the provided filter and
-	// fields
-	requestBody, err := json.Marshal(map[string]interface{}{
-		"ReturnedFields": fields,
-		"Filter":         filter,
-	})
-	if err != nil {
-		log.Fatalf("error during JSON marshalling for request body: %s", err)
-	}
-
-	req, err := http.NewRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
-	if err != nil {
-		log.Fatalf("error during request creation: %s", err)
+	if flagErrorsOnly {
+		// the default filter only returns valid credentials
+		if !cmd.Flags().Changed("filter") {
+			filter = map[string]interface{}{}
+		}
+		filter["status"] = db.ResultStatusError
 	}
 
-	// add Cloudflare Access token to our request
-	err = authenticator.Auth(req)
-	if err != nil {
-		log.Fatalf("error during authentication: %s", err)
+	if flagFollow && flagResultsUserFile != "" {
+		log.Fatal("follow cannot be combined with userfile")
 	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Fatalf("error sending request: %s", err)
+	if flagFollow {
+		followResults(fields, filter)
+		return
 	}
-	defer resp.Body.Close() // nolint:errcheck
 
-	// handle the results from the server
-	respBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("error reading response body: %s", err)
+	var respBody []byte
+	if orchestratorTransport() == transportGRPC {
+		respBody = streamedResults(fields, filter)
+	} else {
+		respBody = requestResults(orchestrator, fields, filter)
 	}
 
 	var results []map[string]interface{}
@@ -129,16 +155,37 @@ func resultsGet(cmd *cobra.Command, args []string) {
 		log.Fatalf("error parsing response json: %s", err)
 	}
 
+	if flagResultsUserFile != "" {
+		users, err := readLines(flagResultsUserFile, lineOptions{Comments: true})
+		if err != nil {
+			log.Fatalf("error reading lines from user file: %s", err)
+		}
+		err = unhashUsernames(orchestrator, users, results, filter)
+		if err != nil {
+			log.Fatalf("error showing hashed usernames: %s", err)
+		}
+		respBody, err = json.Marshal(results)
+		if err != nil {
+			log.Fatalf("error encoding results: %s", err)
+		}
+	}
+
 	if flagOutputFormat == "json" {
 		fmt.Print(string(respBody))
+		signResults(respBody, results, filter)
 		return
 	}
 
+	// the output is buffered so it can be signed
+	var out bytes.Buffer
 	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
+	t.SetOutputMirror(&out)
 
 	if flagReturnedFields == "*" {
 		fields = DefaultReturnedFields
+		if flagErrorsOnly {
+			fields = ErrorReturnedFields
+		}
 	}
 
 	header := make(table.Row, 0, len(fields))
@@ -161,8 +208,200 @@ func resultsGet(cmd *cobra.Command, args []string) {
 
 	if flagOutputFormat == "csv" {
 		t.RenderCSV()
+	} else {
+		t.Render()
+	}
+	_, err = os.Stdout.Write(out.Bytes())
+	if err != nil {
+		log.Fatalf("error writing results: %s", err)
+	}
+	signResults(out.Bytes(), results, filter)
+
+	reportStopped(orchestrator, results)
+}
+
+// usernameTable maps the event.HashUsername of each of the users, keyed by the
+// salt of a campaign which hashes usernames, back to the user.
+func usernameTable(c *db.Campaign, users []string) map[string]string {
+	table := make(map[string]string, len(users))
+	for _, u := range users {
+		table[event.HashUsername(c.UsernameSalt, u)] = u
+	}
+	return table
+}
+
+// unhashUsernames replaces the hashed usernames of results from campaigns which
+// hash usernames with the users they were hashed from, found by hashing the
+// local user list with each campaign's salt. Usernames which are not in the
+// list stay hashed. The campaign of a result is its campaign_id field, or the
+// campaign_id of the filter.
+func unhashUsernames(orchestrator string, users []string, results []map[string]interface{},
+	filter map[string]interface{}) error {
+	tables := make(map[uint]map[string]string)
+	var missing int
+	for _, result := range results {
+		id, ok := result["campaign_id"].(float64)
+		if !ok {
+			id, ok = filter["campaign_id"].(float64)
+		}
+		if !ok {
+			return fmt.Errorf("the campaign_id field must be returned or filtered on")
+		}
+
+		table, ok := tables[uint(id)]
+		if !ok {
+			c, err := fetchCampaign(orchestrator, uint(id))
+			if err != nil {
+				return err
+			}
+			if c.HashUsernames {
+				table = usernameTable(c, users)
+			}
+			tables[uint(id)] = table
+		}
+		if table == nil {
+			continue
+		}
+
+		hashed, ok := result["username"].(string)
+		if !ok {
+			continue
+		}
+		if u, ok := table[hashed]; ok {
+			result["username"] = u
+		} else {
+			missing++
+		}
+	}
+	if missing > 0 {
+		log.Warnf("%d results have a username which is not in %s and is shown hashed", missing,
+			flagResultsUserFile)
+	}
+	return nil
+}
+
+// requestResults requests the results matching the filter from the
+// orchestrator's REST API and returns the JSON response body.
+func requestResults(orchestrator string, fields []string, filter map[string]interface{}) []byte {
+	// build our request to the orchestrator using the provided filter and
+	// fields
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ReturnedFields": fields,
+		"Filter":         filter,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := newRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error retrieving results: %s", err)
+	}
+
+	// handle the results from the server
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("error reading response body: %s", err)
+	}
+	return respBody
+}
+
+// signResults writes a detached signature of the output to the --sign file,
+// if set. The signature names the campaigns in the results, or the campaign
+// in the filter if the campaign_id field was not returned.
+func signResults(output []byte, results []map[string]interface{}, filter map[string]interface{}) {
+	if flagSign == "" {
+		return
+	}
+
+	b, err := ioutil.ReadFile(viper.GetString("signing-key"))
+	if err != nil {
+		log.Fatalf("error reading signing key: %s", err)
+	}
+	key, err := sign.ParsePrivateKey(b)
+	if err != nil {
+		log.Fatalf("error parsing signing key: %s", err)
+	}
+
+	seen := make(map[uint]bool)
+	var ids []uint
+	for _, result := range results {
+		if id, ok := result["campaign_id"].(float64); ok && !seen[uint(id)] {
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	if id, ok := filter["campaign_id"].(float64); ok && len(ids) == 0 {
+		ids = append(ids, uint(id))
+	}
+
+	sig, err := sign.Sign(key, output, ids, time.Now())
+	if err != nil {
+		log.Fatalf("error signing results: %s", err)
+	}
+	err = ioutil.WriteFile(flagSign, sig, 0644) // nolint:gosec
+	if err != nil {
+		log.Fatalf("error writing signature: %s", err)
+	}
+	log.Infof("wrote signature to %s", flagSign)
+}
+
+// reportStopped logs the reason each campaign in the results was stopped
+// early, if it was. It is best effort: any error is logged and ignored.
+func reportStopped(orchestrator string, results []map[string]interface{}) {
+	ids := make(map[uint]bool)
+	for _, result := range results {
+		if id, ok := result["campaign_id"].(float64); ok {
+			ids[uint(id)] = true
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	req, err := newRequest("GET", orchestrator+"/list", nil)
+	if err != nil {
+		log.Debugf("error during request creation: %s", err)
+		return
+	}
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Debugf("error during authentication: %s", err)
 		return
 	}
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Debugf("error sending request: %s", err)
+		return
+	}
+	defer resp.Body.Close() // nolint:errcheck
 
-	t.Render()
+	var campaigns []db.Campaign
+	err = json.NewDecoder(resp.Body).Decode(&campaigns)
+	if err != nil {
+		log.Debugf("error parsing response json: %s", err)
+		return
+	}
+	for _, c := range campaigns {
+		if ids[c.ID] && c.StopReason != "" {
+			log.Infof("campaign %d is %s: %s", c.ID, c.Status, c.StopReason)
+		}
+	}
 }
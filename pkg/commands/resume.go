@@ -24,19 +24,18 @@ import (
 var resumeCommand = &cobra.Command{
 	Use:   "resume",
 	Short: "resume campaign execution",
-	Long:  `can be used to resume a paused campaign to re-enable spraying.`,
+	Long: `can be used to resume a paused campaign to re-enable spraying. with
+--all, every paused campaign is resumed at once.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		resumePost(cmd, args)
 	},
 }
 
 func init() {
-	resumeCommand.Flags().UintVarP(&campaignID, "campaign", "c", 0,
-		"the identifier of the campaign.")
-	err := resumeCommand.MarkFlagRequired("campaign")
-	if err != nil {
-		log.Fatalf("issue during argument parsing: %s", err)
-	}
+	resumeCommand.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	resumeCommand.Flags().BoolVar(&flagAll, "all", false,
+		"resume every paused campaign.")
 
 	campaignCmd.AddCommand(resumeCommand)
 }
@@ -44,5 +43,19 @@ func init() {
 // resumePost will post the parameters update the Status
 // of the campaign specified by the provided ID to CampaignStatusActive
 func resumePost(cmd *cobra.Command, args []string) {
-	updateStatus(campaignID, db.CampaignStatusActive)
+	if flagAll {
+		if campaignRef != "" {
+			log.Fatal("--all may not be combined with --campaign")
+		}
+		if !confirm("Resume every paused campaign?") {
+			log.Printf("not resuming campaigns")
+			return
+		}
+		log.Infof("resumed %d campaigns", updateAllStatus(db.CampaignStatusActive))
+		return
+	}
+	if campaignRef == "" {
+		log.Fatal("one of --campaign or --all is required")
+	}
+	updateStatus(mustResolveCampaign(campaignRef), db.CampaignStatusActive)
 }
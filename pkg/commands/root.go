@@ -15,18 +15,51 @@
 package commands
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/praetorian-inc/trident/pkg/auth"
 	"github.com/praetorian-inc/trident/pkg/auth/cloudflare"
 )
 
+// EnvPrefix prefixes the environment variables which override the config
+// file and the flags, e.g. TRIDENT_ORCHESTRATOR_URL or TRIDENT_INTERVAL.
+const EnvPrefix = "TRIDENT"
+
+// interruptGrace is how long an interrupted command has to fail its request
+// and report it before the client exits on its own, e.g. when the command
+// is waiting on a prompt rather than a request.
+const interruptGrace = 2 * time.Second
+
+// errInterrupted is returned by requests abandoned after an interrupt.
+var errInterrupted = errors.New("interrupted")
+
 var authenticator auth.Authenticator
 
+var (
+	flagConfig  string
+	flagTimeout time.Duration
+
+	// cmdContext is the context of the requests sent by a command. It is
+	// cancelled when the client is interrupted.
+	cmdContext = context.Background()
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "trident-cli",
@@ -34,27 +67,193 @@ var rootCmd = &cobra.Command{
 	Long: `used by an operator to input password spraying tasks into the
 	orchestrator which will be then handed out to the registered dispatch
 	nodes`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		err := applyEnv(cmd.Flags())
+		if err != nil {
+			return err
+		}
+		if flagTimeout < 0 {
+			return fmt.Errorf("--timeout must not be negative")
+		}
+		cmdContext = interruptContext()
+		return nil
+	},
+}
+
+// interruptContext returns a context which is cancelled on SIGINT or SIGTERM,
+// so a request in flight is abandoned and the command fails with a clear
+// error. If the command has not exited within interruptGrace, or on a second
+// signal, the client exits without waiting for it.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+		select {
+		case <-sig:
+		case <-time.After(interruptGrace):
+		}
+		log.Errorf("interrupted")
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// newRequest creates a request to be sent with doRequest, which is abandoned
+// when the client is interrupted.
+func newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(cmdContext, method, url, body)
+}
+
+// doRequest sends the request, giving up after --timeout if set. The timeout
+// covers reading the response body as well.
+func doRequest(req *http.Request) (*http.Response, error) {
+	client := &http.Client{Timeout: flagTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, requestError(err)
+	}
+	return resp, nil
+}
+
+// errorBodyLimit bounds how much of an error response is read for its
+// message.
+const errorBodyLimit = 4 << 10
+
+// orchestratorError is a response from the orchestrator other than 200,
+// with the message from its body.
+type orchestratorError struct {
+	Code    int
+	Message string
+}
+
+func (e *orchestratorError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// responseError returns an orchestratorError for a response other than 200,
+// or nil. The orchestrator writes its error messages as plain text. A 401 or
+// 403 comes from the auth proxy in front of it instead, whose page is of no
+// use on a terminal, as is any other HTML page.
+func responseError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	msg := strings.TrimSpace(string(b))
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		msg = "invalid or expired auth token"
+	case msg == "" || strings.HasPrefix(msg, "<"):
+		msg = strings.ToLower(http.StatusText(resp.StatusCode))
+	}
+	return &orchestratorError{Code: resp.StatusCode, Message: msg}
+}
+
+// requestError replaces the error of a request which was interrupted or
+// which ran past --timeout with one saying so.
+func requestError(err error) error {
+	var uerr *url.Error
+	switch {
+	case cmdContext.Err() != nil:
+		return errInterrupted
+	case flagTimeout > 0 && errors.As(err, &uerr) && uerr.Timeout():
+		return fmt.Errorf("no response within %s (--timeout): %w", flagTimeout, err)
+	}
+	return err
+}
+
+// envName returns the environment variable which sets the flag or config key
+// with the provided name.
+func envName(name string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// applyEnv sets every flag which was not given on the command line from its
+// environment variable, if set, so flags take precedence over the
+// environment.
+func applyEnv(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		env := envName(f.Name)
+		v, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		if serr := flags.Set(f.Name, v); serr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", v, env, serr)
+		}
+	})
+	return err
 }
 
 func init() {
-	// we want to support config directories in home or etc
-	viper.AddConfigPath("$HOME/.trident")
-	viper.AddConfigPath("/etc/trident")
+	// stdout only carries the data a command outputs (tables, JSON, IDs), so
+	// logs and prompts always go to stderr
+	log.SetOutput(os.Stderr)
+
+	// the config is read once the flags are parsed, so --config can name it
+	cobra.OnInitialize(initConfig)
 
-	// config file name is config.yaml
-	viper.SetConfigName("config")
+	rootCmd.PersistentFlags().StringVar(&flagConfig, "config", "",
+		"config file to use instead of searching ./, $HOME/.trident/, and /etc/trident/ for config.yaml")
+	rootCmd.PersistentFlags().DurationVar(&flagTimeout, "timeout", 0,
+		"give up on each request to the orchestrator after this long (0 waits indefinitely)")
+}
+
+// initConfig reads the config file and the secret store once the flags have
+// been parsed, and creates the authenticator from the result. The config file
+// is the one named by --config or TRIDENT_CONFIG, in that order, which must
+// exist. Otherwise the first config.yaml found in the working directory,
+// $HOME/.trident, or /etc/trident is used, if any.
+func initConfig() {
+	path := flagConfig
+	if path == "" {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Fatalf("config file %s does not exist", path)
+		} else if err != nil {
+			log.Fatalf("error reading config: %s", err)
+		}
+		viper.SetConfigFile(path)
+	} else {
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("$HOME/.trident")
+		viper.AddConfigPath("/etc/trident")
+		viper.SetConfigName("config")
+	}
 	viper.SetConfigType("yaml")
 
-	// read in environment variables that match
+	// read in environment variables that match, with the dashes and dots of
+	// the config keys replaced by underscores
+	viper.SetEnvPrefix(EnvPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	viper.AutomaticEnv()
 
-	// If a config file is found, read it in.
+	// If a config file is found, read it in. Without one, the config is
+	// taken from the environment alone.
 	err := viper.ReadInConfig()
-	if err != nil {
+	var notFound viper.ConfigFileNotFoundError
+	if errors.As(err, &notFound) {
+		log.Infof("no config file found, using the environment")
+	} else if err != nil {
 		log.Fatalf("error reading config: %s", err)
+	} else {
+		log.Infof("Using config file: %s", viper.ConfigFileUsed())
 	}
 
-	log.Infof("Using config file: %s", viper.ConfigFileUsed())
+	// merge in the config kept in the secret store, if any
+	err = loadSecrets()
+	if err != nil {
+		log.Fatalf("error loading secrets: %s", err)
+	}
 
 	// parse out the orchestrator server URL
 	url, err := url.Parse(viper.GetString("orchestrator-url"))
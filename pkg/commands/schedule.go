@@ -0,0 +1,217 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+)
+
+// icsTime is the UTC date-time format of iCalendar (RFC 5545).
+const icsTime = "20060102T150405Z"
+
+// previewSchedule asks the orchestrator for the schedule the campaign would
+// follow, or only its first limit attempts if limit is positive. The campaign
+// must be created with the returned seed for the schedule to hold.
+func previewSchedule(orchestrator string, campaign *campaignRequest, limit int) (*scheduler.Preview, error) {
+	requestBody, err := json.Marshal(campaign)
+	if err != nil {
+		return nil, err
+	}
+
+	url := orchestrator + "/campaign/preview"
+	if limit > 0 {
+		url += "?limit=" + strconv.Itoa(limit)
+	}
+	req, err := newRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var preview scheduler.Preview
+	err = json.NewDecoder(resp.Body).Decode(&preview)
+	if err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// plannedRuntime previews the full schedule of the campaign, ignoring its
+// window, and returns how long it would run from notbefore to its last
+// attempt, along with the seed it was planned with.
+func plannedRuntime(orchestrator string, campaign *campaignRequest) (time.Duration, int64, error) {
+	unbounded := *campaign
+	unbounded.NotAfter = campaign.NotBefore.AddDate(100, 0, 0)
+	unbounded.Deadline = nil
+
+	preview, err := previewSchedule(orchestrator, &unbounded, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(preview.Attempts) == 0 {
+		return 0, preview.Seed, nil
+	}
+	last := preview.Attempts[len(preview.Attempts)-1].Time
+	return last.Sub(campaign.NotBefore), preview.Seed, nil
+}
+
+// checkMaxRuntime returns an error if the full schedule of the campaign would
+// run for longer than max, regardless of its window. On success the campaign
+// takes the seed of the checked schedule, so it runs the schedule that was
+// checked.
+func checkMaxRuntime(orchestrator string, campaign *campaignRequest, max time.Duration) error {
+	runtime, seed, err := plannedRuntime(orchestrator, campaign)
+	if err != nil {
+		return fmt.Errorf("error computing the planned runtime: %w", err)
+	}
+	if runtime > max {
+		return fmt.Errorf("the campaign would run for %s (%.1f days, until %s), longer than max-runtime %s",
+			runtime.Round(time.Minute), runtime.Hours()/24, campaign.NotBefore.Add(runtime), max)
+	}
+	log.Infof("the campaign will run for %s, within max-runtime %s", runtime.Round(time.Minute), max)
+	campaign.Seed = seed
+	return nil
+}
+
+// printPreview prints the previewed attempts with the credential each will
+// try, looked up in the campaign's own lists, and how long after the first
+// attempt each is published.
+func printPreview(campaign *campaignRequest, preview *scheduler.Preview) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"#", "time", "after first", "username", "password", "regions"})
+	for i, a := range preview.Attempts {
+		var username, password string
+		if a.User >= 0 && a.User < len(campaign.Users) {
+			username = campaign.Users[a.User]
+		}
+		passwords := campaign.Passwords
+		if campaign.UserPasswords != nil {
+			passwords = campaign.UserPasswords[username]
+		}
+		if a.Password >= 0 && a.Password < len(passwords) {
+			password = passwords[a.Password]
+		}
+		t.AppendRow(table.Row{
+			i + 1,
+			a.Time.Local().Format(time.RFC3339),
+			a.Time.Sub(preview.Attempts[0].Time).Round(time.Second),
+			username,
+			password,
+			strings.Join(a.Regions, " "),
+		})
+	}
+	t.Render()
+}
+
+// writeSchedule writes the planned attempts to path, as an iCalendar file if
+// the path ends in .ics and as CSV otherwise. Credentials are never written.
+func writeSchedule(path, provider string, preview *scheduler.Preview) error {
+	f, err := os.Create(path) // nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		err = writeScheduleICS(f, provider, preview)
+	} else {
+		err = writeScheduleCSV(f, provider, preview)
+	}
+	if err != nil {
+		f.Close() // nolint:errcheck,gosec
+		return err
+	}
+	return f.Close()
+}
+
+// writeScheduleCSV writes one row per planned attempt.
+func writeScheduleCSV(w io.Writer, provider string, preview *scheduler.Preview) error {
+	c := csv.NewWriter(w)
+	err := c.Write([]string{"time", "provider", "regions"})
+	if err != nil {
+		return err
+	}
+	for _, a := range preview.Attempts {
+		err = c.Write([]string{
+			a.Time.UTC().Format(time.RFC3339Nano),
+			provider,
+			strings.Join(a.Regions, " "),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// writeScheduleICS writes one calendar event per planned attempt.
+func writeScheduleICS(w io.Writer, provider string, preview *scheduler.Preview) error {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+
+	stamp := time.Now().UTC().Format(icsTime)
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//Praetorian//Trident//EN")
+	for i, a := range preview.Attempts {
+		start := a.Time.UTC()
+		line("BEGIN:VEVENT")
+		line("UID:%d-%d@trident", preview.Seed, i)
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART:%s", start.Format(icsTime))
+		line("DTEND:%s", start.Add(time.Second).Format(icsTime))
+		line("SUMMARY:%s authentication attempt", provider)
+		if len(a.Regions) > 0 {
+			line("LOCATION:%s", strings.Join(a.Regions, " or "))
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
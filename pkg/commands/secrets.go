@@ -0,0 +1,203 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// secretPrefix marks a user or password list which is read from the
+	// lists of the secret store rather than from disk, e.g. -u secret:users
+	secretPrefix = "secret:"
+
+	// secretListsKey is the key of the secret document holding the lists
+	secretListsKey = "lists"
+)
+
+// secretLists holds the lists read from the secret store, keyed by name.
+var secretLists map[string]interface{}
+
+// loadSecrets fetches the secret document of the backend selected by
+// secrets.backend and merges it over the config, so that providers, the
+// orchestrator-url, or any other key can be kept out of the plaintext config.
+// The document's lists key holds user and password lists instead. The
+// document is only ever held in memory.
+//
+// The vault backend reads secrets.vault.path (e.g. secret/data/trident) from
+// secrets.vault.address or $VAULT_ADDR, using the token in $VAULT_TOKEN or
+// ~/.vault-token. The sops backend decrypts secrets.sops.file with the sops
+// binary.
+func loadSecrets() error {
+	var doc []byte
+	var err error
+
+	backend := viper.GetString("secrets.backend")
+	switch backend {
+	case "":
+		return nil
+	case "vault":
+		doc, err = vaultSecret(viper.GetString("secrets.vault.address"), viper.GetString("secrets.vault.path"))
+	case "sops":
+		doc, err = sopsSecret(viper.GetString("secrets.sops.file"))
+	default:
+		return fmt.Errorf("unknown secrets backend %q (vault or sops)", backend)
+	}
+	if err != nil {
+		return err
+	}
+
+	secret := viper.New()
+	secret.SetConfigType("yaml")
+	err = secret.ReadConfig(bytes.NewReader(doc))
+	if err != nil {
+		return fmt.Errorf("error parsing %s secret: %w", backend, err)
+	}
+
+	secretLists = secret.GetStringMap(secretListsKey)
+	settings := secret.AllSettings()
+	delete(settings, secretListsKey)
+	return viper.MergeConfigMap(settings)
+}
+
+// vaultSecret reads a secret from the Vault HTTP API and returns its data as
+// JSON. Both version 1 and version 2 KV secrets engines are supported.
+func vaultSecret(address, path string) ([]byte, error) {
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, fmt.Errorf("vault secrets backend requires secrets.vault.address or VAULT_ADDR")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("vault secrets backend requires secrets.vault.path")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("no vault token in VAULT_TOKEN or ~/.vault-token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	url := strings.TrimRight(address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading vault secret: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		return nil, fmt.Errorf("vault secret %s does not exist", path)
+	case 403:
+		return nil, fmt.Errorf("vault token is not permitted to read %s", path)
+	default:
+		return nil, fmt.Errorf("vault returned %d reading %s", resp.StatusCode, path)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&secret)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing vault response: %w", err)
+	}
+
+	// the KV version 2 engine nests the secret and its metadata under data
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("vault secret %s is empty", path)
+	}
+	return json.Marshal(data)
+}
+
+// sopsSecret decrypts a SOPS encrypted file to memory.
+func sopsSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sops secrets backend requires secrets.sops.file")
+	}
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("sops secret file %s does not exist: %w", path, err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("sops", "--decrypt", path) // nolint:gosec
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting %s with sops: %w: %s", path, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// secretLines returns a list from the secret store. A list may be stored as a
+// sequence or as a single newline separated string.
+func secretLines(name string) ([]string, error) {
+	if secretLists == nil {
+		return nil, fmt.Errorf("%s%s requires a secrets.backend with a %s key", secretPrefix, name, secretListsKey)
+	}
+	v, ok := secretLists[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("secret list %q does not exist", name)
+	}
+	switch list := v.(type) {
+	case string:
+		return strings.Split(strings.TrimRight(list, "\n"), "\n"), nil
+	case []interface{}:
+		lines := make([]string, 0, len(list))
+		for _, l := range list {
+			lines = append(lines, fmt.Sprint(l))
+		}
+		return lines, nil
+	}
+	return nil, fmt.Errorf("secret list %q is not a list", name)
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+)
+
+// the offset to move the cursor to, or a change of it with a sign
+var flagSeekTo string
+
+var campaignSeekCmd = &cobra.Command{
+	Use:   "seek [campaign]",
+	Short: "move a campaign's cursor within its schedule",
+	Long: `can be used to skip a problematic segment of a campaign, or to repeat one.
+the cursor counts the attempts published so far, and --to sets it to an offset
+in the schedule shown by preview (e.g. 1500), or moves it relative to its
+current offset (e.g. +200 or -50). the campaign's queued attempts are replaced
+by those from the new offset on, starting now.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignSeek(cmd, args)
+	},
+}
+
+func init() {
+	campaignSeekCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	campaignSeekCmd.Flags().StringVar(&flagSeekTo, "to", "",
+		"the offset to move the cursor to, or +N/-N to move it relative to its current offset")
+	err := campaignSeekCmd.MarkFlagRequired("to")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+	campaignCmd.AddCommand(campaignSeekCmd)
+}
+
+// campaignAttempts returns the number of attempts the campaign plans before
+// any are dropped by its window.
+func campaignAttempts(c *db.Campaign) int {
+	if c.UserPasswords == nil {
+		return len(c.Users) * len(c.Passwords)
+	}
+	var n int
+	for _, u := range c.Users {
+		n += len(c.UserPasswords[u])
+	}
+	return n
+}
+
+// seekOffset returns the offset described by to, which is either absolute or
+// relative to the current offset when it starts with a sign.
+func seekOffset(to string, current int) (int, error) {
+	n, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q", to)
+	}
+	if strings.HasPrefix(to, "+") || strings.HasPrefix(to, "-") {
+		n += current
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("offset %s moves the cursor before the start of the schedule", to)
+	}
+	return n, nil
+}
+
+// campaignSeek moves the cursor of the provided campaign after confirmation.
+func campaignSeek(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	if len(args) > 0 {
+		campaignRef = args[0]
+	}
+	if campaignRef == "" {
+		log.Fatal("a campaign is required, either as an argument or with --campaign")
+	}
+	campaignID := mustResolveCampaign(campaignRef)
+
+	campaign, err := fetchCampaign(orchestrator, campaignID)
+	if err != nil {
+		log.Fatalf("error describing campaign: %s", err)
+	}
+	var current int
+	if campaign.Cursor != nil {
+		current = campaign.Cursor.Offset
+	}
+
+	offset, err := seekOffset(flagSeekTo, current)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case offset > current:
+		fmt.Printf("Moving the cursor of campaign %d forward from %d to %d skips %d attempts.\n",
+			campaignID, current, offset, offset-current)
+	case offset < current:
+		fmt.Printf("Moving the cursor of campaign %d back from %d to %d repeats %d attempts.\n",
+			campaignID, current, offset, current-offset)
+	default:
+		fmt.Printf("The cursor of campaign %d is already at %d, its queued attempts will be replanned.\n",
+			campaignID, current)
+	}
+	fmt.Printf("The remaining attempts of the %d planned start now.\n", campaignAttempts(campaign))
+	if !confirm("Move the cursor?") {
+		log.Printf("not moving the cursor")
+		return
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ID":     campaignID,
+		"Offset": offset,
+	})
+	if err != nil {
+		log.Fatalf("error during JSON marshalling for request body: %s", err)
+	}
+
+	req, err := newRequest("POST", orchestrator+"/campaign/seek", bytes.NewBuffer(requestBody))
+	if err != nil {
+		log.Fatalf("error during request creation: %s", err)
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		log.Fatalf("error during authentication: %s", err)
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		log.Fatalf("error sending request: %s", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		log.Fatalf("error moving the cursor: %s", err)
+	}
+
+	var report scheduler.Report
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	if err != nil {
+		log.Fatalf("error parsing response json: %s", err)
+	}
+	log.Infof("moved the cursor of campaign %d to %d, scheduled %d attempts", campaignID, offset, report.Scheduled)
+	if report.Dropped > 0 {
+		log.Warnf("%d attempts no longer fit the campaign window and were dropped", report.Dropped)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "show the orchestrator's task queue and workers",
+	Long: `can be used to see whether a slow campaign is waiting on a backed-up
+queue or on a lack of workers. it shows the number of tasks scheduled but not
+yet published, for every campaign or for the --campaign given, and each
+dispatcher with the time of its last heartbeat.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		statsGet(cmd, args)
+	},
+}
+
+func init() {
+	statsCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"only show the queue of this campaign (identifier or name)")
+	campaignCmd.AddCommand(statsCmd)
+}
+
+// fetchStats returns the orchestrator's scheduler stats.
+func fetchStats(orchestrator string) (*scheduler.Stats, error) {
+	req, err := newRequest("GET", orchestrator+"/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats scheduler.Stats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// statsGet prints the task queue depth and the workers to the CLI.
+func statsGet(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	var campaignID uint
+	if campaignRef != "" {
+		campaignID = mustResolveCampaign(campaignRef)
+	}
+
+	stats, err := fetchStats(orchestrator)
+	if err != nil {
+		log.Fatalf("error retrieving stats: %s", err)
+	}
+
+	if campaignID != 0 {
+		fmt.Printf("Queue Depth:    %d (campaign %d), %d total\n",
+			stats.Queues[campaignID], campaignID, stats.QueueDepth)
+	} else {
+		fmt.Printf("Queue Depth:    %d\n", stats.QueueDepth)
+		ids := make([]uint, 0, len(stats.Queues))
+		for id := range stats.Queues {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		for _, id := range ids {
+			fmt.Printf("  campaign %-6d %d\n", id, stats.Queues[id])
+		}
+	}
+	if stats.IngestBuffer > 0 {
+		fmt.Printf("Ingest Backlog: %d of %d\n", stats.IngestBacklog, stats.IngestBuffer)
+	}
+	fmt.Printf("Workers:        %d live, %d seen in the last %s\n\n",
+		stats.WorkerCount, len(stats.Workers), scheduler.WorkerExpiry)
+
+	if stats.IngestBuffer > 0 && stats.IngestBacklog >= stats.IngestBuffer {
+		log.Warn("result ingestion is backed up, new results are held in pubsub until the database catches up")
+	}
+	if stats.WorkerCount == 0 {
+		log.Warnf("no dispatcher has sent a heartbeat in the last %s", scheduler.WorkerTimeout)
+	}
+	if len(stats.Workers) == 0 {
+		return
+	}
+
+	renderWorkers(stats.Workers, scheduler.WorkerTimeout)
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/rpc"
+)
+
+// the values of orchestrator-transport
+const (
+	// transportREST sends every request to the orchestrator's REST API
+	transportREST = "rest"
+
+	// transportGRPC sends the results requests to the orchestrator's gRPC
+	// service, which can stream them, and everything else to the REST API
+	transportGRPC = "grpc"
+)
+
+// orchestratorTransport returns the configured transport to the
+// orchestrator, rest by default.
+func orchestratorTransport() string {
+	transport := viper.GetString("orchestrator-transport")
+	switch transport {
+	case "":
+		return transportREST
+	case transportREST, transportGRPC:
+		return transport
+	}
+	log.Fatalf("unknown orchestrator-transport %q (use %s or %s)", transport, transportREST, transportGRPC)
+	return ""
+}
+
+// dialOrchestrator connects to the orchestrator's gRPC service at
+// orchestrator-grpc-address. Calls carry the same auth token as the REST
+// requests, and are sent over TLS unless orchestrator-grpc-insecure is set.
+func dialOrchestrator() *rpc.Client {
+	address := viper.GetString("orchestrator-grpc-address")
+	if address == "" {
+		log.Fatalf("orchestrator-grpc-address is required with orchestrator-transport: %s", transportGRPC)
+	}
+	insecure := viper.GetBool("orchestrator-grpc-insecure")
+
+	opts := []grpc.DialOption{
+		grpc.WithPerRPCCredentials(rpc.AuthCredentials(authenticator, insecure)),
+	}
+	if insecure {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(nil)))
+	}
+
+	c, err := rpc.Dial(cmdContext, address, opts...)
+	if err != nil {
+		log.Fatalf("error connecting to %s: %s", address, err)
+	}
+	return c
+}
+
+// streamResults requests the results matching the filter from the
+// orchestrator's gRPC service and calls handle with each, in the form the
+// REST API returns them. With follow, it keeps waiting for new results until
+// the client is interrupted.
+func streamResults(fields []string, filter map[string]interface{}, follow bool,
+	handle func(map[string]interface{})) error {
+	c := dialOrchestrator()
+	defer c.Close() // nolint:errcheck
+
+	ctx := cmdContext
+	if flagTimeout > 0 && !follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flagTimeout)
+		defer cancel()
+	}
+
+	stream, err := c.StreamResults(ctx, &rpc.ResultsRequest{
+		Query:  db.Query{ReturnedFields: fields, Filter: filter},
+		Follow: follow,
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			if cmdContext.Err() != nil {
+				return errInterrupted
+			}
+			return err
+		}
+
+		// the result is sent through JSON so it has the fields, and the
+		// names, of a REST response
+		b, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		var result map[string]interface{}
+		err = json.Unmarshal(b, &result)
+		if err != nil {
+			return err
+		}
+		handle(result)
+	}
+}
+
+// streamedResults requests the results matching the filter from the
+// orchestrator's gRPC service and returns them as a JSON array, like the body
+// of a REST response. The results are in the order they were recorded.
+func streamedResults(fields []string, filter map[string]interface{}) []byte {
+	results := []map[string]interface{}{}
+	err := streamResults(fields, filter, false, func(result map[string]interface{}) {
+		results = append(results, result)
+	})
+	if err != nil {
+		log.Fatalf("error retrieving results: %s", err)
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		log.Fatalf("error during JSON marshalling: %s", err)
+	}
+	return b
+}
+
+// followResults prints the results matching the filter as they arrive, one
+// JSON object or CSV row per result, until the client is interrupted.
+func followResults(fields []string, filter map[string]interface{}) {
+	if orchestratorTransport() != transportGRPC {
+		log.Fatalf("--follow requires orchestrator-transport: %s", transportGRPC)
+	}
+	if flagSign != "" {
+		log.Fatal("--follow cannot be combined with --sign")
+	}
+
+	var handle func(map[string]interface{})
+	switch flagOutputFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		handle = func(result map[string]interface{}) {
+			err := enc.Encode(result)
+			if err != nil {
+				log.Fatalf("error writing results: %s", err)
+			}
+		}
+	case "csv":
+		if flagReturnedFields == "*" {
+			fields = DefaultReturnedFields
+			if flagErrorsOnly {
+				fields = ErrorReturnedFields
+			}
+		}
+		w := csv.NewWriter(os.Stdout)
+		w.Write(fields) // nolint:errcheck
+		w.Flush()
+		handle = func(result map[string]interface{}) {
+			row := make([]string, len(fields))
+			for i, field := range fields {
+				row[i] = fmt.Sprint(result[field])
+			}
+			w.Write(row) // nolint:errcheck
+			w.Flush()
+			if err := w.Error(); err != nil {
+				log.Fatalf("error writing results: %s", err)
+			}
+		}
+	default:
+		log.Fatal("--follow prints csv or json, use --output-format")
+	}
+
+	err := streamResults(fields, filter, true, handle)
+	if err != nil && !errors.Is(err, errInterrupted) {
+		log.Fatalf("error streaming results: %s", err)
+	}
+}
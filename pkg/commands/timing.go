@@ -0,0 +1,350 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// weakSeparation is the separation of the clusters below which the timings
+// are not considered to leak which usernames are valid.
+const weakSeparation = 2.0
+
+// the flags of campaign analyze-timing
+var (
+	flagTimingCluster     string
+	flagTimingMinAttempts int
+	flagTimingOutput      string
+)
+
+var campaignAnalyzeTimingCmd = &cobra.Command{
+	Use:   "analyze-timing [campaign]",
+	Short: "separate likely valid from likely invalid usernames by response time",
+	Long: `many providers answer a guess against an existing username more slowly
+(or quickly) than one against a username that does not exist. the median time
+the provider took to answer each username's guesses is split into a slow and a
+fast cluster, and the usernames in the cluster that holds the known valid
+credentials (the slow one if there are none) are reported as likely valid.
+only guesses with a verdict (valid, valid_expired, or invalid) are used.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		campaignAnalyzeTiming(cmd, args)
+	},
+}
+
+func init() {
+	campaignAnalyzeTimingCmd.Flags().StringVarP(&campaignRef, "campaign", "c", "",
+		"the identifier or name of the campaign.")
+	campaignAnalyzeTimingCmd.Flags().StringVar(&flagTimingCluster, "valid-cluster", "auto",
+		"the cluster of likely valid usernames: slow, fast, or auto to pick the one holding the known valid credentials")
+	campaignAnalyzeTimingCmd.Flags().IntVar(&flagTimingMinAttempts, "min-attempts", 1,
+		"ignore usernames with fewer timed guesses than this")
+	campaignAnalyzeTimingCmd.Flags().StringVarP(&flagTimingOutput, "output-format", "o", "text",
+		"output format: text, or json with the latency of every guess")
+	campaignCmd.AddCommand(campaignAnalyzeTimingCmd)
+}
+
+// userTiming is the timing of the guesses against a single username.
+type userTiming struct {
+	Username    string          `json:"username"`
+	Median      time.Duration   `json:"median"`
+	Cluster     string          `json:"cluster"`
+	LikelyValid bool            `json:"likely_valid"`
+	KnownValid  bool            `json:"known_valid"`
+	Latencies   []time.Duration `json:"latencies"`
+}
+
+// timingAnalysis is the result of campaign analyze-timing. The durations are
+// in nanoseconds in JSON, like the latency of a result.
+type timingAnalysis struct {
+	CampaignID uint `json:"campaign_id"`
+
+	// Threshold is the median latency dividing the fast and slow clusters
+	Threshold time.Duration `json:"threshold"`
+
+	// Separation is the distance between the means of the clusters in
+	// pooled standard deviations (Cohen's d)
+	Separation float64 `json:"separation"`
+
+	// ValidCluster is the cluster of the likely valid usernames
+	ValidCluster string `json:"valid_cluster"`
+
+	Users []userTiming `json:"users"`
+}
+
+// fetchTimings returns the results of the campaign's guesses which have a
+// verdict and a latency.
+func fetchTimings(orchestrator string, campaignID uint) ([]db.Result, error) {
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ReturnedFields": []string{"username", "status", "latency"},
+		"Filter": map[string]interface{}{
+			"campaign_id": campaignID,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newRequest("POST", orchestrator+"/results", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []db.Result
+	err = json.NewDecoder(resp.Body).Decode(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	// rate limits, challenges, and errors are answered by something other
+	// than the provider's check of the username
+	timed := results[:0]
+	for _, r := range results {
+		switch r.Status {
+		case db.ResultStatusValid, db.ResultStatusValidExpired, db.ResultStatusInvalid:
+			if r.Latency > 0 {
+				timed = append(timed, r)
+			}
+		}
+	}
+	return timed, nil
+}
+
+// medianDuration returns the median of the durations, which are sorted in
+// place.
+func medianDuration(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	n := len(ds)
+	if n%2 == 1 {
+		return ds[n/2]
+	}
+	return (ds[n/2-1] + ds[n/2]) / 2
+}
+
+// meanVariance returns the mean and variance of the durations in
+// milliseconds.
+func meanVariance(ds []time.Duration) (float64, float64) {
+	var sum float64
+	for _, d := range ds {
+		sum += float64(d) / float64(time.Millisecond)
+	}
+	mean := sum / float64(len(ds))
+	var sq float64
+	for _, d := range ds {
+		x := float64(d)/float64(time.Millisecond) - mean
+		sq += x * x
+	}
+	return mean, sq / float64(len(ds))
+}
+
+// splitTimings returns the index splitting the sorted medians into the fast
+// and slow clusters with the least variance within them, which is the
+// optimal two-means clustering of one dimension, and the separation of the
+// clusters.
+func splitTimings(medians []time.Duration) (int, float64) {
+	best, bestSSE := 0, math.Inf(1)
+	for i := 1; i < len(medians); i++ {
+		if medians[i] == medians[i-1] {
+			continue
+		}
+		_, fastVar := meanVariance(medians[:i])
+		_, slowVar := meanVariance(medians[i:])
+		sse := fastVar*float64(i) + slowVar*float64(len(medians)-i)
+		if sse < bestSSE {
+			best, bestSSE = i, sse
+		}
+	}
+	if best == 0 {
+		return 0, 0
+	}
+
+	fastMean, fastVar := meanVariance(medians[:best])
+	slowMean, slowVar := meanVariance(medians[best:])
+	// jitter below a millisecond says nothing about the provider, so the
+	// spread is never taken as less than that
+	sd := math.Max(math.Sqrt((fastVar+slowVar)/2), 1)
+	return best, (slowMean - fastMean) / sd
+}
+
+// analyzeTiming clusters the usernames of the results by the median latency
+// of their guesses.
+func analyzeTiming(results []db.Result, minAttempts int, validCluster string) (*timingAnalysis, error) {
+	byUser := map[string]*userTiming{}
+	for _, r := range results {
+		u, ok := byUser[r.Username]
+		if !ok {
+			u = &userTiming{Username: r.Username}
+			byUser[r.Username] = u
+		}
+		u.Latencies = append(u.Latencies, r.Latency)
+		if r.Status == db.ResultStatusValid || r.Status == db.ResultStatusValidExpired {
+			u.KnownValid = true
+		}
+	}
+
+	var users []userTiming
+	for _, u := range byUser {
+		if len(u.Latencies) < minAttempts {
+			continue
+		}
+		// the latencies are kept in the order they were returned
+		sorted := append([]time.Duration(nil), u.Latencies...)
+		u.Median = medianDuration(sorted)
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Median != users[j].Median {
+			return users[i].Median < users[j].Median
+		}
+		return users[i].Username < users[j].Username
+	})
+
+	medians := make([]time.Duration, len(users))
+	for i, u := range users {
+		medians[i] = u.Median
+	}
+	split, separation := splitTimings(medians)
+	if split == 0 {
+		return nil, fmt.Errorf("the timings of %d usernames cannot be split into two clusters", len(users))
+	}
+
+	var slowValid, fastValid int
+	for i := range users {
+		users[i].Cluster = "fast"
+		if i >= split {
+			users[i].Cluster = "slow"
+		}
+		if users[i].KnownValid && i >= split {
+			slowValid++
+		} else if users[i].KnownValid {
+			fastValid++
+		}
+	}
+	if validCluster == "auto" {
+		validCluster = "slow"
+		if fastValid > slowValid {
+			validCluster = "fast"
+		}
+	}
+	for i := range users {
+		users[i].LikelyValid = users[i].Cluster == validCluster
+	}
+
+	return &timingAnalysis{
+		Threshold:    (medians[split-1] + medians[split]) / 2,
+		Separation:   separation,
+		ValidCluster: validCluster,
+		Users:        users,
+	}, nil
+}
+
+// campaignAnalyzeTiming reports the likely valid usernames of the provided
+// campaign from the latency of its guesses.
+func campaignAnalyzeTiming(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	switch flagTimingCluster {
+	case "auto", "slow", "fast":
+	default:
+		log.Fatalf("unknown cluster %q (auto, slow, or fast)", flagTimingCluster)
+	}
+	switch flagTimingOutput {
+	case "text", "json":
+	default:
+		log.Fatalf("unknown output format %q", flagTimingOutput)
+	}
+
+	if len(args) > 0 {
+		campaignRef = args[0]
+	}
+	if campaignRef == "" {
+		log.Fatal("a campaign is required, either as an argument or with --campaign")
+	}
+	campaignID := mustResolveCampaign(campaignRef)
+
+	results, err := fetchTimings(orchestrator, campaignID)
+	if err != nil {
+		log.Fatalf("error retrieving results: %s", err)
+	}
+	if len(results) == 0 {
+		log.Fatalf("campaign %d has no timed guesses, its workers may predate latency recording", campaignID)
+	}
+
+	analysis, err := analyzeTiming(results, flagTimingMinAttempts, flagTimingCluster)
+	if err != nil {
+		log.Fatalf("error analyzing timings: %s", err)
+	}
+	analysis.CampaignID = campaignID
+	if analysis.Separation < weakSeparation {
+		log.Warnf("the clusters are only %.1f standard deviations apart, the timings may not reveal valid usernames",
+			analysis.Separation)
+	}
+
+	if flagTimingOutput == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(analysis)
+		if err != nil {
+			log.Fatalf("error encoding analysis: %s", err)
+		}
+		return
+	}
+
+	var likely int
+	for _, u := range analysis.Users {
+		if u.LikelyValid {
+			likely++
+		}
+	}
+	fmt.Printf("%d of %d usernames are likely valid (the %s cluster, split at %s, separation %.1f).\n",
+		likely, len(analysis.Users), analysis.ValidCluster, analysis.Threshold.Round(time.Millisecond),
+		analysis.Separation)
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"username", "attempts", "median", "cluster", "likely valid", "known valid"})
+	for _, u := range analysis.Users {
+		t.AppendRow(table.Row{u.Username, len(u.Latencies), u.Median.Round(time.Millisecond), u.Cluster,
+			u.LikelyValid, u.KnownValid})
+	}
+	t.Render()
+}
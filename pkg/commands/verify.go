@@ -0,0 +1,99 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/praetorian-inc/trident/pkg/sign"
+)
+
+var (
+	// PEM file of the ed25519 public key to verify signatures with
+	flagVerifyKey string
+
+	// detached signature file, default: the export with a .sig suffix
+	flagVerifySignature string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <export>",
+	Short: "verify the signature of a results export",
+	Long: `checks the detached signature written by results --sign, showing the
+	signed campaigns and signing time if the export has not been altered`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		verifyExport(cmd, args)
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVarP(&flagVerifyKey, "key", "k", "",
+		"PEM file of the ed25519 public key matching the signing key")
+	err := verifyCmd.MarkFlagRequired("key")
+	if err != nil {
+		log.Fatalf("issue during argument parsing: %s", err)
+	}
+
+	verifyCmd.Flags().StringVarP(&flagVerifySignature, "signature", "s", "",
+		"the detached signature file (default: the export with a .sig suffix)")
+
+	rootCmd.AddCommand(verifyCmd)
+}
+
+// verifyExport checks the signature of the export and prints the signed
+// payload.
+func verifyExport(cmd *cobra.Command, args []string) {
+	export, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		log.Fatalf("error reading export: %s", err)
+	}
+
+	sigFile := flagVerifySignature
+	if sigFile == "" {
+		sigFile = args[0] + ".sig"
+	}
+	signature, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		log.Fatalf("error reading signature: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(flagVerifyKey)
+	if err != nil {
+		log.Fatalf("error reading public key: %s", err)
+	}
+	key, err := sign.ParsePublicKey(b)
+	if err != nil {
+		log.Fatalf("error parsing public key: %s", err)
+	}
+
+	payload, err := sign.Verify(key, export, signature)
+	if err != nil {
+		log.Fatalf("verification failed: %s", err)
+	}
+
+	campaigns := "not limited to particular campaigns"
+	if len(payload.CampaignIDs) > 0 {
+		campaigns = fmt.Sprint(payload.CampaignIDs)
+	}
+	fmt.Printf("Signature:  valid\n")
+	fmt.Printf("Campaigns:  %s\n", campaigns)
+	fmt.Printf("Signed at:  %s\n", payload.SignedAt)
+	fmt.Printf("SHA-256:    %s\n", payload.SHA256)
+}
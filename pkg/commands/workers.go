@@ -0,0 +1,139 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/table"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/praetorian-inc/trident/pkg/scheduler"
+)
+
+// a worker whose last heartbeat is older than this is marked stale
+var flagStaleAfter time.Duration
+
+var workersCmd = &cobra.Command{
+	Use:   "workers",
+	Short: "top-level command for the workers serving the orchestrator",
+	Long: `used by an operator to see which dispatchers are connected to the
+	orchestrator and whether they are healthy`,
+}
+
+var workersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the workers",
+	Long: `lists each dispatcher which sent a heartbeat in the last hour, with its
+region, egress address (if it reports one), the number of tasks it was working
+on, the number of times it backed off for a provider's Retry-After, and its last
+heartbeat. workers whose last heartbeat is older than
+--stale-after are marked stale.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		workersList(cmd, args)
+	},
+}
+
+func init() {
+	workersListCmd.Flags().DurationVar(&flagStaleAfter, "stale-after", scheduler.WorkerTimeout,
+		"mark workers whose last heartbeat is older than this as stale")
+
+	workersCmd.AddCommand(workersListCmd)
+	rootCmd.AddCommand(workersCmd)
+}
+
+// fetchWorkers returns the workers known to the orchestrator.
+func fetchWorkers(orchestrator string) ([]scheduler.WorkerStats, error) {
+	req, err := newRequest("GET", orchestrator+"/workers", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// add Cloudflare Access token to our request
+	err = authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	err = responseError(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []scheduler.WorkerStats
+	err = json.NewDecoder(resp.Body).Decode(&workers)
+	if err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+// renderWorkers prints a table of the workers, marking the ones whose last
+// heartbeat is older than staleAfter.
+func renderWorkers(workers []scheduler.WorkerStats, staleAfter time.Duration) {
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"worker", "region", "egress ip", "in flight", "backoffs", "breaker trips", "last heartbeat", "status"})
+	now := time.Now()
+	for _, w := range workers {
+		age := now.Sub(w.LastHeartbeat)
+		status := "live"
+		if age > staleAfter {
+			status = "stale"
+		} else if len(w.BreakersOpen) > 0 {
+			status = fmt.Sprintf("breaker open (%s)", strings.Join(w.BreakersOpen, ", "))
+		} else if w.BackoffUntil != nil && w.BackoffUntil.After(now) {
+			status = fmt.Sprintf("backing off (%s left)", w.BackoffUntil.Sub(now).Round(time.Second))
+		}
+		t.AppendRow(table.Row{
+			w.Name,
+			w.Region,
+			w.EgressIP,
+			w.InFlight,
+			w.Backoffs,
+			w.BreakerTrips,
+			fmt.Sprintf("%s (%s ago)", w.LastHeartbeat.Format(time.RFC3339), age.Round(time.Second)),
+			status,
+		})
+	}
+	t.Render()
+}
+
+// workersList prints the workers known to the orchestrator to the CLI.
+func workersList(cmd *cobra.Command, args []string) {
+	orchestrator := viper.GetString("orchestrator-url")
+
+	workers, err := fetchWorkers(orchestrator)
+	if err != nil {
+		log.Fatalf("error retrieving workers: %s", err)
+	}
+	if len(workers) == 0 {
+		log.Warn("no worker has sent a heartbeat in the last hour")
+		return
+	}
+	renderWorkers(workers, flagStaleAfter)
+}
@@ -0,0 +1,339 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Migration is a versioned change to the database schema. Migrations are
+// applied in order of version, each in its own transaction, and recorded in
+// the schema_migrations table.
+type Migration struct {
+	// Version is the schema version once the migration is applied
+	Version int
+
+	// Name describes the change
+	Name string
+
+	// Up applies the change
+	Up func(*gorm.DB) error
+
+	// Down reverts the change, nil if it cannot be reverted without losing
+	// data
+	Down func(*gorm.DB) error
+}
+
+// migrations is the history of the schema. The first migration creates the
+// tables from the current models, so every later migration must also apply
+// cleanly to a schema created from models which already include its change,
+// e.g. by using ADD COLUMN IF NOT EXISTS. Migrations are never edited once
+// released; a change is made by appending a new one.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create the campaign, result, and audit tables",
+		Up: func(tx *gorm.DB) error {
+			return tx.AutoMigrate(&Campaign{}, &Result{}, &AuditEntry{}).Error
+		},
+	},
+	{
+		Version: 2,
+		Name:    "make the audit log append-only",
+		Up:      execMigration(auditAppendOnly),
+		Down: execMigration(`
+DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries;
+DROP FUNCTION IF EXISTS audit_entries_append_only();
+`),
+	},
+	{
+		Version: 3,
+		Name:    "index unique campaign names",
+		Up:      execMigration(uniqueCampaignName),
+		Down:    execMigration(`DROP INDEX IF EXISTS idx_campaigns_name;`),
+	},
+	{
+		Version: 4,
+		Name:    "index pending notifications",
+		Up:      execMigration(pendingNotifications),
+		Down:    execMigration(`DROP INDEX IF EXISTS idx_results_notify_pending;`),
+	},
+	{
+		Version: 5,
+		Name:    "create the dispatched attempts table",
+		Up:      execMigration(dispatchedAttempts),
+		Down:    execMigration(`DROP TABLE IF EXISTS dispatched_attempts;`),
+	},
+	{
+		Version: 6,
+		Name:    "create the idempotency keys table",
+		Up:      execMigration(idempotencyKeys),
+		Down:    execMigration(`DROP TABLE IF EXISTS idempotency_keys;`),
+	},
+	{
+		Version: 7,
+		Name:    "add adaptive region selection to campaigns",
+		Up:      execMigration(adaptiveRegions),
+		Down: execMigration(`
+ALTER TABLE campaigns DROP COLUMN IF EXISTS region_weights;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS adaptive_regions;
+`),
+	},
+	{
+		Version: 8,
+		Name:    "add worker regions to campaigns and results",
+		Up:      execMigration(workerRegions),
+		Down: execMigration(`
+ALTER TABLE results DROP COLUMN IF EXISTS region;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS worker_regions;
+`),
+	},
+	{
+		Version: 9,
+		Name:    "add max concurrent to campaigns",
+		Up:      execMigration(maxConcurrent),
+		Down: execMigration(`
+ALTER TABLE campaigns DROP COLUMN IF EXISTS max_concurrent;
+`),
+	},
+	{
+		Version: 10,
+		Name:    "add hashed usernames to campaigns",
+		Up:      execMigration(hashUsernames),
+		Down: execMigration(`
+ALTER TABLE campaigns DROP COLUMN IF EXISTS username_salt;
+ALTER TABLE campaigns DROP COLUMN IF EXISTS hash_usernames;
+`),
+	},
+	{
+		Version: 11,
+		Name:    "add priority to campaigns",
+		Up:      execMigration(priority),
+		Down: execMigration(`
+ALTER TABLE campaigns DROP COLUMN IF EXISTS priority;
+`),
+	},
+	{
+		Version: 12,
+		Name:    "stop counting expired passwords as valid",
+		Up:      execMigration(expiredResults),
+		Down: execMigration(`
+UPDATE results SET valid = true WHERE status = 'valid_expired';
+`),
+	},
+}
+
+// SchemaVersion is the schema version this build requires.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// schemaMigrations records the applied migrations. Schemas created before
+// migrations were introduced have no record, and are brought up to date by
+// applying every migration, which is safe since the first versions only
+// create what is missing.
+const schemaMigrations = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version integer PRIMARY KEY,
+	name text NOT NULL,
+	applied_at timestamp with time zone NOT NULL DEFAULT now()
+);
+`
+
+// migrationLock is the key of the advisory lock held while migrating, so
+// orchestrators started together do not apply a migration twice.
+const migrationLock = 0x74726964656e74 // "trident"
+
+// execMigration returns a migration step running the statements.
+func execMigration(statements string) func(*gorm.DB) error {
+	return func(tx *gorm.DB) error {
+		return tx.Exec(statements).Error
+	}
+}
+
+// SchemaError reports a database schema at a version this build cannot run
+// against.
+type SchemaError struct {
+	Version  int
+	Required int
+}
+
+// Error allows SchemaError to implement the error interface
+func (e *SchemaError) Error() string {
+	if e.Version > e.Required {
+		return fmt.Sprintf("database schema version %d is newer than version %d required by this build, "+
+			"upgrade the orchestrator or migrate down", e.Version, e.Required)
+	}
+	return fmt.Sprintf("database schema version %d is older than version %d required by this build, "+
+		"run orchestrator migrate up", e.Version, e.Required)
+}
+
+// pending returns the migrations to apply to a schema at the version, in order.
+func pending(version int) []Migration {
+	var ms []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// reverting returns the migrations to revert to take a schema from the version
+// down to the target, newest first. It returns an error if one of them cannot
+// be reverted.
+func reverting(version, target int) ([]Migration, error) {
+	if target < 0 || target > version {
+		return nil, fmt.Errorf("cannot migrate down from version %d to %d", version, target)
+	}
+	var ms []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target || m.Version > version {
+			continue
+		}
+		if m.Down == nil {
+			return nil, fmt.Errorf("migration %d (%s) cannot be reverted", m.Version, m.Name)
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+// SchemaVersion returns the version of the database schema, 0 if no migration
+// has been applied.
+func (t *TridentDB) SchemaVersion() (int, error) {
+	err := t.db.Exec(schemaMigrations).Error
+	if err != nil {
+		return 0, err
+	}
+	return schemaVersion(t.db)
+}
+
+// schemaVersion reads the latest applied migration.
+func schemaVersion(tx *gorm.DB) (int, error) {
+	var row struct {
+		Version int
+	}
+	err := tx.Raw(`SELECT COALESCE(MAX(version), 0) AS version FROM schema_migrations`).Scan(&row).Error
+	return row.Version, err
+}
+
+// CheckSchema returns a SchemaError unless the database schema is at the
+// version this build requires.
+func (t *TridentDB) CheckSchema() error {
+	version, err := t.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version != SchemaVersion {
+		return &SchemaError{Version: version, Required: SchemaVersion}
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations and returns them. A schema newer
+// than this build is left alone and reported with a SchemaError.
+func (t *TridentDB) MigrateUp() ([]Migration, error) {
+	version, err := t.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, &SchemaError{Version: version, Required: SchemaVersion}
+	}
+
+	var applied []Migration
+	for _, m := range pending(version) {
+		err = t.migrate(m, true)
+		if err != nil {
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateDown reverts the migrations newer than the target version and
+// returns them, newest first. Nothing is reverted if one of them cannot be.
+func (t *TridentDB) MigrateDown(target int) ([]Migration, error) {
+	version, err := t.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if version > SchemaVersion {
+		return nil, &SchemaError{Version: version, Required: SchemaVersion}
+	}
+	ms, err := reverting(version, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var reverted []Migration
+	for _, m := range ms {
+		err = t.migrate(m, false)
+		if err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// migrate applies or reverts a single migration in a transaction holding the
+// migration lock. A migration already applied or reverted by another
+// orchestrator in the meantime is skipped.
+func (t *TridentDB) migrate(m Migration, up bool) error {
+	txn := t.db.Begin()
+	if txn.Error != nil {
+		return txn.Error
+	}
+
+	err := txn.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLock).Error
+	if err != nil {
+		txn.Rollback() // nolint:errcheck
+		return err
+	}
+	version, err := schemaVersion(txn)
+	if err != nil {
+		txn.Rollback() // nolint:errcheck
+		return err
+	}
+
+	switch {
+	case up && version >= m.Version, !up && version < m.Version:
+		return txn.Rollback().Error
+	case up:
+		log.Printf("applying migration %d: %s", m.Version, m.Name)
+		err = m.Up(txn)
+		if err == nil {
+			err = txn.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				m.Version, m.Name, time.Now()).Error
+		}
+	default:
+		log.Printf("reverting migration %d: %s", m.Version, m.Name)
+		err = m.Down(txn)
+		if err == nil {
+			err = txn.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version).Error
+		}
+	}
+	if err != nil {
+		txn.Rollback() // nolint:errcheck
+		return fmt.Errorf("migration %d (%s): %w", m.Version, m.Name, err)
+	}
+	return txn.Commit().Error
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestMigrations checks the migration history is numbered in order and that
+// SchemaVersion is its latest version.
+func TestMigrations(t *testing.T) {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d", i+1, m.Version)
+		}
+		if m.Name == "" || m.Up == nil {
+			t.Errorf("migration %d is incomplete", m.Version)
+		}
+	}
+	if SchemaVersion != len(migrations) {
+		t.Errorf("SchemaVersion is %d, expected %d", SchemaVersion, len(migrations))
+	}
+}
+
+func TestPending(t *testing.T) {
+	if ms := pending(0); len(ms) != len(migrations) || ms[0].Version != 1 {
+		t.Errorf("expected every migration for an empty schema, got %d", len(ms))
+	}
+	if ms := pending(SchemaVersion - 1); len(ms) != 1 || ms[0].Version != SchemaVersion {
+		t.Errorf("expected the latest migration, got %v", ms)
+	}
+	if ms := pending(SchemaVersion); len(ms) != 0 {
+		t.Errorf("expected no migrations for an up to date schema, got %d", len(ms))
+	}
+}
+
+func TestReverting(t *testing.T) {
+	ms, err := reverting(SchemaVersion, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) != SchemaVersion-2 || ms[0].Version != SchemaVersion || ms[len(ms)-1].Version != 3 {
+		t.Errorf("expected the migrations after 2 newest first, got %d", len(ms))
+	}
+
+	if ms, err = reverting(SchemaVersion, SchemaVersion); err != nil || len(ms) != 0 {
+		t.Errorf("expected nothing to revert, got %d, %v", len(ms), err)
+	}
+
+	// the tables cannot be dropped without losing data
+	if _, err = reverting(SchemaVersion, 0); err == nil || !strings.Contains(err.Error(), "cannot be reverted") {
+		t.Errorf("expected error reverting the first migration, got %v", err)
+	}
+	if _, err = reverting(2, 3); err == nil {
+		t.Error("expected error migrating down to a newer version")
+	}
+}
+
+func TestSchemaError(t *testing.T) {
+	older := (&SchemaError{Version: 1, Required: 2}).Error()
+	if !strings.Contains(older, "migrate up") {
+		t.Errorf("unexpected error for an older schema: %s", older)
+	}
+	newer := (&SchemaError{Version: 3, Required: 2}).Error()
+	if !strings.Contains(newer, "newer") {
+		t.Errorf("unexpected error for a newer schema: %s", newer)
+	}
+}
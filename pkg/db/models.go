@@ -15,7 +15,11 @@
 package db
 
 import (
+	"crypto/sha256"
+	"database/sql/driver"
 	"encoding/json"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/lib/pq"
@@ -42,31 +46,126 @@ const (
 	// CampaignStatusPaused is the value of the Status column if the campaign is Paused.
 	// Paused campaigns can be resumed, whereas cancelling is permanent
 	CampaignStatusPaused = "Paused"
+	// CampaignStatusDeadlineExceeded is the value of the Status column if the
+	// campaign reached its Deadline before running every task. Like a
+	// cancelled campaign it is terminal, whereas a campaign which ran all of
+	// its tasks remains Active
+	CampaignStatusDeadlineExceeded = "DeadlineExceeded"
+	// CampaignStatusCompleted is the value of the Status column if the campaign
+	// was stopped early because it reached its goal, e.g. StopAfterValid. The
+	// StopReason column records why. Completed campaigns cannot be resumed
+	CampaignStatusCompleted = "Completed"
+	// CampaignStatusScheduled is the value of the Status column if the
+	// campaign has not started yet because its NotBefore is in the future. It
+	// becomes Active once its first request is ready
+	CampaignStatusScheduled = "Scheduled"
 )
 
+// CampaignStatuses lists every CampaignStatus.
+var CampaignStatuses = []CampaignStatus{
+	CampaignStatusScheduled,
+	CampaignStatusActive,
+	CampaignStatusPaused,
+	CampaignStatusCancelled,
+	CampaignStatusDeadlineExceeded,
+	CampaignStatusCompleted,
+}
+
+// Terminal returns true if a campaign with this status will never make another
+// request.
+func (s CampaignStatus) Terminal() bool {
+	switch s {
+	case CampaignStatusCancelled, CampaignStatusDeadlineExceeded, CampaignStatusCompleted:
+		return true
+	}
+	return false
+}
+
 // Campaign stores the metadata associated with an entire password spraying campaign
 type Campaign struct {
 	// inherit the base model's fields
 	Model
 
+	// a memorable name, unique per orchestrator, which can be used in place
+	// of the ID
+	Name string `json:"name"`
+
 	// a campaign should not make requests before this time
 	NotBefore time.Time `json:"not_before"`
 
 	// a campaign should not make requests after this time
 	NotAfter time.Time `json:"not_after"`
 
+	// a campaign is stopped at this time even if tasks remain, regardless of
+	// its window
+	Deadline *time.Time `json:"deadline"`
+
 	// a campaign should make requests with this interval in between them
 	ScheduleInterval time.Duration `json:"schedule_interval"`
 
+	// each request is delayed by a random duration up to this value
+	Jitter time.Duration `json:"jitter"`
+
+	// a campaign makes at most this many requests in any hour, across all
+	// users, 0 for no limit
+	AttemptLimit int `json:"attempt_limit"`
+
+	// at most this many requests against the campaign's provider are in
+	// flight at once, shared with every other campaign against the provider,
+	// 0 for no limit
+	MaxConcurrent int `json:"max_concurrent"`
+
+	// the campaign's share of the orchestrator's dispatch turns relative to
+	// the other campaigns with ready tasks, 0 for the default priority of 1
+	Priority int `json:"priority"`
+
+	// requests are being deferred by the AttemptLimit until this time
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+
+	// the number of tasks which were not published because the campaign
+	// had already made the same attempt
+	DuplicatesSuppressed int `json:"duplicates_suppressed"`
+
+	// the number of attempts published so far and the last one of them,
+	// maintained by the orchestrator and moved by a seek
+	Cursor *Cursor `json:"cursor,omitempty" gorm:"type:jsonb"`
+
+	// the seed of the random number generator used for ordering and jitter,
+	// recorded so the schedule can be reproduced
+	Seed int64 `json:"seed"`
+
 	// current status of the campaign, used to pause/cancel/resume without deletion
 	Status CampaignStatus `json:"status"`
 
+	// the campaign is completed once this many credentials are valid, 0 to
+	// run every task
+	StopAfterValid int `json:"stop_after_valid"`
+
+	// why the campaign stopped or was paused before running every task, if
+	// it did
+	StopReason string `json:"stop_reason"`
+
+	// the campaign is paused once this fraction of its recent results were
+	// challenged by a WAF or captcha, 0 to never pause
+	AbortOnWAF float64 `json:"abort_on_waf"`
+
+	// whether the usernames are stored as their event.HashUsername keyed by
+	// UsernameSalt, both in the campaign and its results
+	HashUsernames bool `json:"hash_usernames"`
+
+	// the campaign's random salt of the hashed usernames
+	UsernameSalt string `json:"username_salt,omitempty"`
+
 	// the slice of usernames to guess in this campaign
 	Users pq.StringArray `json:"users" gorm:"type:varchar(255)[]"`
 
 	// passwords to try during this campaign
 	Passwords pq.StringArray `json:"passwords" gorm:"type:varchar(255)[]"`
 
+	// the passwords to try for each user, used instead of Passwords when
+	// every user has their own candidates (e.g. from breach data)
+	UserPasswords UserPasswords `json:"user_passwords,omitempty" gorm:"type:jsonb"`
+
 	// the authentication portal this campaign is targeting
 	Provider string `json:"provider"`
 
@@ -74,8 +173,449 @@ type Campaign struct {
 	// successful requests to the portal
 	ProviderMetadata json.RawMessage `json:"provider_metadata"`
 
+	// periods during which no requests may be made, attempts that would fall
+	// inside a blackout are deferred until it ends
+	Blackouts Blackouts `json:"blackouts" gorm:"type:jsonb"`
+
+	// recurring daily periods during which no requests may be made, handled
+	// like blackouts
+	QuietHours *QuietHours `json:"quiet_hours,omitempty" gorm:"type:jsonb"`
+
+	// the geo tag (e.g. a country code) of the target, used to prefer worker
+	// regions near the target's users
+	TargetGeo string `json:"target_geo"`
+
+	// whether the attempts are spread evenly across the worker regions
+	// rather than sent to a region picked at random for each attempt
+	RandomizeWorkers bool `json:"randomize_workers"`
+
+	// the worker regions the attempts may be sent from, each with its
+	// relative share of the attempts, or every region if empty
+	WorkerRegions WorkerRegions `json:"worker_regions,omitempty" gorm:"type:jsonb"`
+
+	// whether each attempt is sent to a region picked at random weighted by
+	// the region's recent record against the target, so traffic moves away
+	// from regions the target blocks
+	AdaptiveRegions bool `json:"adaptive_regions"`
+
+	// the campaign's recent record in each worker region, maintained by the
+	// orchestrator
+	RegionWeights RegionWeights `json:"region_weights,omitempty" gorm:"type:jsonb"`
+
+	// whether the provider's response to a valid credential is stored with
+	// the result
+	CaptureOnValid bool `json:"capture_on_valid"`
+
+	// the results are purged this long after the campaign's window ends, 0
+	// to keep them
+	Retain time.Duration `json:"retain"`
+
+	// whether the campaign itself is deleted with its results once Retain
+	// has passed
+	PurgeCampaign bool `json:"purge_campaign"`
+
+	// when the results of the campaign were purged
+	PurgedAt *time.Time `json:"purged_at,omitempty"`
+
 	// the results of the campaign
 	Results []Result `json:"results"`
+
+	// the number of results of each status, filled in by describe
+	Stats map[ResultStatus]int `json:"stats,omitempty" gorm:"-"`
+
+	// the number of failed results of each error category, filled in by
+	// describe
+	Errors map[string]int `json:"errors,omitempty" gorm:"-"`
+}
+
+// PurgeAt returns when the results of the campaign are purged, and false if
+// they are kept. The retention starts when the campaign's window ends, or at
+// its deadline if that is earlier.
+func (c *Campaign) PurgeAt() (time.Time, bool) {
+	if c.Retain <= 0 {
+		return time.Time{}, false
+	}
+	end := c.NotAfter
+	if c.Deadline != nil && c.Deadline.Before(end) {
+		end = *c.Deadline
+	}
+	return end.Add(c.Retain), true
+}
+
+// Blackout is a period during which a campaign must not make requests.
+type Blackout struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Overlaps returns true if the blackout overlaps the interval [start, end].
+func (b Blackout) Overlaps(start, end time.Time) bool {
+	return start.Before(b.End) && !end.Before(b.Start)
+}
+
+// Blackouts is a list of blackout periods stored as a JSON column.
+type Blackouts []Blackout
+
+// Defer returns the earliest time at or after t such that no request made
+// within [t, t+jitter] falls inside a blackout.
+func (bs Blackouts) Defer(t time.Time, jitter time.Duration) time.Time {
+	for deferred := true; deferred; {
+		deferred = false
+		for _, b := range bs {
+			if b.Overlaps(t, t.Add(jitter)) {
+				t = b.End
+				deferred = true
+			}
+		}
+	}
+	return t
+}
+
+// Value implements the driver.Valuer interface.
+func (b Blackouts) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+	return json.Marshal(b)
+}
+
+// Scan implements the sql.Scanner interface.
+func (b *Blackouts) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*b = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, b)
+	case string:
+		return json.Unmarshal([]byte(v), b)
+	}
+	return fmt.Errorf("unsupported type for blackouts: %T", src)
+}
+
+// QuietHours are recurring daily periods, in a time zone, during which a
+// campaign must not make requests. It is stored as a JSON column.
+type QuietHours struct {
+	// Location is the IANA time zone of the ranges, UTC if empty
+	Location string `json:"location,omitempty"`
+
+	// Ranges are the daily periods
+	Ranges []QuietRange `json:"ranges"`
+}
+
+// QuietRange is a daily period between two times of day in the 15:04 form. A
+// range whose end is before its start spans midnight.
+type QuietRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// quietLayout is the form of the times of day in a QuietRange
+const quietLayout = "15:04"
+
+// String returns the range in the start-end form.
+func (r QuietRange) String() string {
+	return r.Start + "-" + r.End
+}
+
+// span returns the time of day the range starts at and its length.
+func (r QuietRange) span() (time.Duration, time.Duration, error) {
+	s, err := time.Parse(quietLayout, r.Start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid quiet hours start %q", r.Start)
+	}
+	e, err := time.Parse(quietLayout, r.End)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid quiet hours end %q", r.End)
+	}
+	length := e.Sub(s)
+	if length == 0 {
+		return 0, 0, fmt.Errorf("quiet hours %s are empty", r)
+	}
+	if length < 0 {
+		length += 24 * time.Hour
+	}
+	return time.Duration(s.Hour())*time.Hour + time.Duration(s.Minute())*time.Minute, length, nil
+}
+
+// locations caches the time zones of the quiet hours, which are otherwise
+// read from disk for every deferral
+var locations sync.Map
+
+// location returns the time zone of the quiet hours.
+func (q *QuietHours) location() (*time.Location, error) {
+	if q.Location == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(q.Location); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(q.Location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quiet hours location %q: %w", q.Location, err)
+	}
+	locations.Store(q.Location, loc)
+	return loc, nil
+}
+
+// Validate checks the location and the ranges of the quiet hours. It returns
+// the shortest period of a day outside the quiet hours, which a campaign's
+// jitter must be shorter than.
+func (q *QuietHours) Validate() (time.Duration, error) {
+	if _, err := q.location(); err != nil {
+		return 0, err
+	}
+	if len(q.Ranges) == 0 {
+		return 0, fmt.Errorf("quiet hours have no ranges")
+	}
+
+	// the ranges are minute aligned, so mark each quiet minute of the day
+	var quiet [24 * 60]bool
+	for _, r := range q.Ranges {
+		start, length, err := r.span()
+		if err != nil {
+			return 0, err
+		}
+		for m := start / time.Minute; m < (start+length)/time.Minute; m++ {
+			quiet[m%(24*60)] = true
+		}
+	}
+
+	// walk the day twice, from the first quiet minute, so a gap spanning
+	// midnight is measured in one piece
+	first := -1
+	for m, q := range quiet {
+		if q {
+			first = m
+			break
+		}
+	}
+	gap, shortest := 0, len(quiet)
+	for i := 1; i <= len(quiet); i++ {
+		if quiet[(first+i)%len(quiet)] {
+			if gap > 0 && gap < shortest {
+				shortest = gap
+			}
+			gap = 0
+			continue
+		}
+		gap++
+	}
+	if shortest == len(quiet) {
+		return 0, fmt.Errorf("quiet hours cover the whole day")
+	}
+	return time.Duration(shortest) * time.Minute, nil
+}
+
+// Defer returns the earliest time at or after t such that no request made
+// within [t, t+jitter] falls inside the quiet hours. The jitter must be
+// shorter than the gap returned by Validate.
+func (q *QuietHours) Defer(t time.Time, jitter time.Duration) time.Time {
+	if q == nil {
+		return t
+	}
+	loc, err := q.location()
+	if err != nil {
+		return t
+	}
+
+	for deferred := true; deferred; {
+		deferred = false
+		for _, r := range q.Ranges {
+			start, length, err := r.span()
+			if err != nil {
+				continue
+			}
+			// the range of the day before may span midnight, and the jitter
+			// may reach into the next day
+			y, m, d := t.In(loc).Date()
+			for _, day := range []int{d - 1, d, d + 1} {
+				s := time.Date(y, m, day, int(start/time.Hour), int(start%time.Hour/time.Minute), 0, 0, loc)
+				b := Blackout{Start: s, End: s.Add(length)}
+				if b.Overlaps(t, t.Add(jitter)) {
+					t = b.End
+					deferred = true
+				}
+			}
+		}
+	}
+	return t
+}
+
+// Value implements the driver.Valuer interface.
+func (q QuietHours) Value() (driver.Value, error) {
+	return json.Marshal(q)
+}
+
+// Scan implements the sql.Scanner interface.
+func (q *QuietHours) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, q)
+	case string:
+		return json.Unmarshal([]byte(v), q)
+	}
+	return fmt.Errorf("unsupported type for quiet hours: %T", src)
+}
+
+// deferRequest returns the earliest time at or after t such that no request
+// made within [t, t+jitter] falls inside a blackout or the quiet hours.
+func deferRequest(t time.Time, jitter time.Duration, blackouts Blackouts, quiet *QuietHours) time.Time {
+	for {
+		next := quiet.Defer(blackouts.Defer(t, jitter), jitter)
+		if next.Equal(t) {
+			return t
+		}
+		t = next
+	}
+}
+
+// Defer returns the earliest time at or after t such that no request made
+// within [t, t+jitter] falls inside one of the campaign's blackouts or its
+// quiet hours.
+func (c *Campaign) Defer(t time.Time, jitter time.Duration) time.Time {
+	return deferRequest(t, jitter, c.Blackouts, c.QuietHours)
+}
+
+// Defer returns the earliest time at or after at when the task may be made,
+// outside the campaign's blackouts and quiet hours.
+func (t *Task) Defer(at time.Time) time.Time {
+	return deferRequest(at, 0, t.Blackouts, t.QuietHours)
+}
+
+// Cursor is a campaign's position in its schedule. Offset counts the attempts
+// published so far, so the next attempt is the one at Offset in the schedule
+// shown by a preview. It is stored as a JSON column.
+type Cursor struct {
+	Offset    int       `json:"offset"`
+	Username  string    `json:"username,omitempty"`
+	Password  string    `json:"password,omitempty"`
+	Region    string    `json:"region,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Value implements the driver.Valuer interface.
+func (c Cursor) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *Cursor) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return fmt.Errorf("unsupported type for cursor: %T", src)
+}
+
+// UserPasswords maps each username to the passwords to try for that user. It
+// is stored as a JSON column.
+type UserPasswords map[string][]string
+
+// PasswordsFor returns the passwords to try for the user: the user's own passwords if
+// the campaign has per-user passwords, or else the campaign's passwords.
+func (c *Campaign) PasswordsFor(user string) []string {
+	if c.UserPasswords != nil {
+		return c.UserPasswords[user]
+	}
+	return c.Passwords
+}
+
+// Value implements the driver.Valuer interface.
+func (p UserPasswords) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *UserPasswords) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*p = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	}
+	return fmt.Errorf("unsupported type for user passwords: %T", src)
+}
+
+// WorkerRegions maps each worker region a campaign's attempts may be sent from
+// to its weight, the region's share of the attempts relative to the others. It
+// is stored as a JSON column.
+type WorkerRegions map[string]float64
+
+// Weight returns the weight of the region, 0 if attempts may not be sent from
+// it. Every region has weight 1 if none are listed.
+func (w WorkerRegions) Weight(region string) float64 {
+	if len(w) == 0 {
+		return 1
+	}
+	return w[region]
+}
+
+// Value implements the driver.Valuer interface.
+func (w WorkerRegions) Value() (driver.Value, error) {
+	if w == nil {
+		return nil, nil
+	}
+	return json.Marshal(w)
+}
+
+// Scan implements the sql.Scanner interface.
+func (w *WorkerRegions) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*w = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, w)
+	case string:
+		return json.Unmarshal([]byte(v), w)
+	}
+	return fmt.Errorf("unsupported type for worker regions: %T", src)
+}
+
+// RegionStats is a campaign's recent record in a worker region. Succeeded
+// counts the results which reached the provider and Blocked those rate limited
+// or challenged before it. Both decay as newer results arrive, so the Weight
+// computed from them follows the region's recent history.
+type RegionStats struct {
+	Succeeded float64   `json:"succeeded"`
+	Blocked   float64   `json:"blocked"`
+	Weight    float64   `json:"weight"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RegionWeights maps each worker region to a campaign's RegionStats in it. It
+// is stored as a JSON column.
+type RegionWeights map[string]RegionStats
+
+// Value implements the driver.Valuer interface.
+func (w RegionWeights) Value() (driver.Value, error) {
+	if w == nil {
+		return nil, nil
+	}
+	return json.Marshal(w)
+}
+
+// Scan implements the sql.Scanner interface.
+func (w *RegionWeights) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*w = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, w)
+	case string:
+		return json.Unmarshal([]byte(v), w)
+	}
+	return fmt.Errorf("unsupported type for region weights: %T", src)
 }
 
 // Result carries metadata about an individual result from the password spraying
@@ -90,16 +630,25 @@ type Result struct {
 	// IP is the originating IP of the credential guess
 	IP string `json:"ip"`
 
+	// Region is the worker region the credential guess was made from, if
+	// regions are configured
+	Region string `json:"region,omitempty"`
+
 	// Timestamp is the time that we made the request
 	Timestamp time.Time `json:"timestamp"`
 
+	// Latency is how long the provider took to answer the guess, which can
+	// reveal whether the username exists
+	Latency time.Duration `json:"latency,omitempty"`
+
 	// Username is the username at the identity provider
 	Username string `json:"username"`
 
 	// Password is the password to guess against the identity provider
 	Password string `json:"password"`
 
-	// Valid indicates the provided credential was valid
+	// Valid indicates the provided credential was valid and usable. A correct
+	// but expired password is not Valid, see ResultStatusValidExpired
 	Valid bool `json:"valid"`
 
 	// Locked will be true iff the account is known to be locked
@@ -108,11 +657,130 @@ type Result struct {
 	// MFA will be true iff the account requires MFA to log in
 	MFA bool `json:"mfa"`
 
+	// Expired will be true iff the password is correct but has expired
+	Expired bool `json:"expired"`
+
 	// RateLimited indicates the provider has detected a large number of requests
 	RateLimited bool `json:"rate_limited"`
 
+	// RetryAfter is how long a rate limited provider asked to wait before the
+	// next attempt, zero if it did not say
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+
+	// WAF names the WAF or captcha which challenged the request, if any
+	WAF string `json:"waf,omitempty"`
+
+	// ErrorCategory is the category of the error which prevented the guess
+	// from producing a verdict (e.g. dns or timeout), if any
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Error is the sanitized error message of a failed guess
+	Error string `json:"error,omitempty"`
+
 	// Additional metadata from the auth provider (e.g. information about MFA)
 	Metadata json.RawMessage `json:"metadata"`
+
+	// Capture is the provider's response to a valid credential (status,
+	// headers, and a size-capped body with the password redacted), only
+	// stored for campaigns created with --capture-on-valid
+	Capture json.RawMessage `json:"capture"`
+
+	// Status is the outcome of the guess, derived from the flags above
+	Status ResultStatus `json:"status"`
+
+	// NotifyPending is set on a valid result until its notification has been
+	// delivered, so undelivered notifications survive a restart
+	NotifyPending bool `json:"-"`
+
+	// NotifiedAt is the time the notification of the result was delivered
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+}
+
+// The ResultStatus enum summarizes the outcome of a single credential guess.
+type ResultStatus string
+
+const (
+	// ResultStatusValid is the Status of a correct, usable credential
+	ResultStatusValid ResultStatus = "valid"
+	// ResultStatusValidExpired is the Status of a correct credential whose
+	// password has expired or must be changed. It cannot be used to log in
+	// directly, so its result is not Valid, but it still reveals the user's
+	// password patterns
+	ResultStatusValidExpired ResultStatus = "valid_expired"
+	// ResultStatusInvalid is the Status of an incorrect credential
+	ResultStatusInvalid ResultStatus = "invalid"
+	// ResultStatusLocked is the Status of a guess against a locked account
+	ResultStatusLocked ResultStatus = "locked"
+	// ResultStatusRateLimited is the Status of a guess rejected by the
+	// provider's rate limiting
+	ResultStatusRateLimited ResultStatus = "rate_limited"
+	// ResultStatusChallenged is the Status of a guess blocked or challenged
+	// by a WAF or captcha before it reached the provider
+	ResultStatusChallenged ResultStatus = "challenged"
+	// ResultStatusError is the Status of a guess which failed without a
+	// verdict, e.g. because the provider could not be reached
+	ResultStatusError ResultStatus = "error"
+)
+
+// ResultStatuses lists every ResultStatus in reporting order.
+var ResultStatuses = []ResultStatus{
+	ResultStatusValid,
+	ResultStatusValidExpired,
+	ResultStatusInvalid,
+	ResultStatusLocked,
+	ResultStatusRateLimited,
+	ResultStatusChallenged,
+	ResultStatusError,
+}
+
+// Classify returns the ResultStatus of the result's flags.
+func (r *Result) Classify() ResultStatus {
+	switch {
+	case r.ErrorCategory != "":
+		return ResultStatusError
+	case r.WAF != "":
+		return ResultStatusChallenged
+	case r.RateLimited:
+		return ResultStatusRateLimited
+	case r.Locked:
+		return ResultStatusLocked
+	case r.Expired:
+		return ResultStatusValidExpired
+	case r.Valid:
+		return ResultStatusValid
+	default:
+		return ResultStatusInvalid
+	}
+}
+
+// AuditEntry is a single row of the append-only audit log, recording that a
+// guess was made without recording the password. Entries are only written if
+// the orchestrator has audit logging enabled.
+type AuditEntry struct {
+	ID uint `json:"id" gorm:"primary_key"`
+
+	// Timestamp is the time that we made the request
+	Timestamp time.Time `json:"timestamp"`
+
+	// CampaignID is the campaign the guess belongs to
+	CampaignID uint `json:"campaign_id"`
+
+	// Username is the username at the identity provider, or its hex encoded
+	// HMAC-SHA256 keyed with the audit secret if UsernameHashed is set
+	Username string `json:"username"`
+
+	// UsernameHashed indicates Username was hashed before it was recorded
+	UsernameHashed bool `json:"username_hashed"`
+
+	// Provider is the name of the identity provider
+	Provider string `json:"provider"`
+
+	// Region is the worker region which made the request, if regions are
+	// configured
+	Region string `json:"region"`
+
+	// Result is the outcome of the guess
+	Result ResultStatus `json:"result"`
 }
 
 // Task carries metadata about a single task in the password spraying campaign
@@ -126,6 +794,28 @@ type Task struct {
 	// NotAfter will prevent execution after this time
 	NotAfter time.Time `json:"not_after"`
 
+	// Deadline is the campaign's hard stop, after which the task is drained
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// AttemptLimit is the campaign's maximum number of requests per hour
+	AttemptLimit int `json:"attempt_limit,omitempty"`
+
+	// MaxConcurrent is the most requests against the provider in flight at
+	// once, across every campaign against it
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+
+	// Priority is the campaign's weight when the producer shares its turns
+	// among the campaigns
+	Priority int `json:"priority,omitempty"`
+
+	// Blackouts are the campaign's periods during which no requests may be
+	// made, checked again when the task is published
+	Blackouts Blackouts `json:"blackouts,omitempty"`
+
+	// QuietHours are the campaign's recurring daily periods during which no
+	// requests may be made, checked along with the Blackouts
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+
 	// Username is the username at the identity provider
 	Username string `json:"username"`
 
@@ -137,6 +827,40 @@ type Task struct {
 
 	// ProviderMetadata is any required configuration data for the provider
 	ProviderMetadata json.RawMessage `json:"metadata"`
+
+	// TargetGeo is the geo tag of the target used to select a worker region
+	TargetGeo string `json:"target_geo"`
+
+	// RandomizeWorkers rotates the campaign's tasks through the worker regions
+	RandomizeWorkers bool `json:"randomize_workers,omitempty"`
+
+	// AdaptiveRegions weights the region selection by the campaign's recent
+	// record in each region
+	AdaptiveRegions bool `json:"adaptive_regions,omitempty"`
+
+	// WorkerRegions are the regions the task may be sent from with their
+	// weights, or any region if empty
+	WorkerRegions WorkerRegions `json:"worker_regions,omitempty"`
+
+	// CaptureOnValid asks the worker to return the provider's response to a
+	// valid credential
+	CaptureOnValid bool `json:"capture_on_valid,omitempty"`
+
+	// UsernameSalt is the campaign's salt if it hashes usernames, with which
+	// the worker's result is returned
+	UsernameSalt string `json:"username_salt,omitempty"`
+}
+
+// Digest identifies the attempt the task makes, its username, password, and
+// provider, without revealing the password. A campaign never dispatches two
+// tasks with the same digest.
+func (t *Task) Digest() []byte {
+	h := sha256.New()
+	for _, field := range []string{t.Username, t.Password, t.Provider} {
+		h.Write([]byte(field)) // nolint:errcheck
+		h.Write([]byte{0})     // nolint:errcheck
+	}
+	return h.Sum(nil)
 }
 
 // MarshalBinary task marshalling
@@ -0,0 +1,37 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	var tests = []struct {
+		result Result
+		status ResultStatus
+	}{
+		{Result{Valid: true}, ResultStatusValid},
+		{Result{Valid: true, Expired: true}, ResultStatusValidExpired},
+		// an expired result stays expired once it is no longer Valid
+		{Result{Expired: true}, ResultStatusValidExpired},
+		{Result{}, ResultStatusInvalid},
+		{Result{Valid: true, Locked: true}, ResultStatusLocked},
+		{Result{Expired: true, RateLimited: true}, ResultStatusRateLimited},
+	}
+	for _, test := range tests {
+		if status := test.result.Classify(); status != test.status {
+			t.Errorf("%+v was classified %s, expected %s", test.result, status, test.status)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// DefaultRetries is the number of times an idempotent operation is
+	// retried after a transient error unless Options sets another
+	DefaultRetries = 3
+
+	// DefaultRetryDelay is the delay before the first retry unless Options
+	// sets another. It doubles with every retry.
+	DefaultRetryDelay = 200 * time.Millisecond
+)
+
+// Options configures the connection pool of a TridentDB, how it retries
+// transient errors, and whether it migrates its schema.
+type Options struct {
+	// MaxOpenConns is the most connections open at once, 0 for no limit
+	MaxOpenConns int
+
+	// MaxIdleConns is the most idle connections kept, 2 if 0
+	MaxIdleConns int
+
+	// ConnMaxLifetime is how long a connection is reused before it is
+	// closed, 0 to reuse it forever. Setting it moves connections off a
+	// failed over primary.
+	ConnMaxLifetime time.Duration
+
+	// Retries is the number of times an idempotent operation is retried
+	// after a transient error, DefaultRetries if 0 and none if negative
+	Retries int
+
+	// RetryDelay is the delay before the first retry, DefaultRetryDelay if
+	// 0. It doubles with every retry.
+	RetryDelay time.Duration
+
+	// AutoMigrate applies pending schema migrations when New connects
+	AutoMigrate bool
+}
+
+// transient returns true if the error may go away by itself, such as a
+// dropped connection or a server restarting or failing over, so the
+// operation is worth retrying.
+func transient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08": // connection_exception
+			return true
+		case "40": // transaction_rollback, e.g. serialization failures
+			return true
+		case "53": // insufficient_resources, e.g. too_many_connections
+			return true
+		case "57": // operator_intervention, e.g. admin_shutdown
+			// query_canceled is the caller's doing
+			return pqErr.Code != "57014"
+		}
+	}
+	return false
+}
+
+// retry runs an idempotent operation, retrying it with an exponential backoff
+// while it fails with a transient error. Operations which are not idempotent,
+// such as inserts and increments, must not be retried, since the first attempt
+// may have been applied before its connection was lost.
+func (t *TridentDB) retry(op string, fn func() error) error {
+	delay := t.opts.RetryDelay
+	err := fn()
+	for i := 0; i < t.opts.Retries && transient(err); i++ {
+		log.Printf("transient error in %s, retrying in %s: %s", op, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	return err
+}
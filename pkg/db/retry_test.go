@@ -0,0 +1,82 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestTransient(t *testing.T) {
+	var testcases = []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errors.New("syntax error"), false},
+		{io.EOF, true},
+		{fmt.Errorf("query: %w", io.ErrUnexpectedEOF), true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "53300"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "57014"}, false},
+		{&pq.Error{Code: "23505"}, false},
+	}
+	for _, test := range testcases {
+		if transient(test.err) != test.transient {
+			t.Errorf("transient(%v) was %t", test.err, !test.transient)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tdb := &TridentDB{opts: Options{Retries: 2, RetryDelay: time.Millisecond}}
+
+	calls := 0
+	err := tdb.retry("test", func() error {
+		calls++
+		if calls < 3 {
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retry returned %v after %d calls, expected success after 3", err, calls)
+	}
+
+	calls = 0
+	err = tdb.retry("test", func() error {
+		calls++
+		return io.EOF
+	})
+	if err != io.EOF || calls != 3 {
+		t.Errorf("retry returned %v after %d calls, expected EOF after 3", err, calls)
+	}
+
+	calls = 0
+	err = tdb.retry("test", func() error {
+		calls++
+		return &pq.Error{Code: "23505"}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("retry returned %v after %d calls, expected no retries", err, calls)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	// DefaultBatchSize is the most results written in one batch unless
+	// BatchOptions sets another
+	DefaultBatchSize = 5000
+
+	// DefaultFlushInterval is the longest a result waits to be written
+	// unless BatchOptions sets another
+	DefaultFlushInterval = 3 * time.Second
+
+	// maxRetryDelay bounds the delay between attempts to write a batch while
+	// the database is unreachable
+	maxRetryDelay = 30 * time.Second
+)
+
+// BatchOptions configures a ResultWriter.
+type BatchOptions struct {
+	// Size is the most results written in one batch, DefaultBatchSize if 0
+	Size int
+
+	// Interval is the longest a result waits before its batch is written,
+	// DefaultFlushInterval if 0
+	Interval time.Duration
+
+	// Buffer is the most results held waiting to be written, Size if 0.
+	// Once it is full, senders block until a batch has been written.
+	Buffer int
+
+	// Written, if set, is called with the results of each batch once they
+	// are in the database, leaving out any which were dropped
+	Written func([]*Result)
+}
+
+// resultSink is where a ResultWriter writes its batches, a TridentDB outside
+// of tests.
+type resultSink interface {
+	CopyResults([]*Result) error
+	InsertResult(*Result) error
+	Ping() error
+}
+
+// ResultWriter batches results into the database, writing each batch with a
+// single COPY instead of an INSERT per result. A batch is written once it
+// holds Size results or its first result has waited Interval, whichever comes
+// first.
+//
+// A batch which cannot be written is retried with a backoff while the
+// database is unreachable, holding up the following results, so none are
+// lost to a restart or a failover. If the database is up but rejects the
+// batch, its results are inserted one at a time and only the ones rejected
+// again are dropped and logged.
+type ResultWriter struct {
+	results chan *Result
+	done    chan struct{}
+	sink    resultSink
+	opts    BatchOptions
+}
+
+// NewResultWriter starts a ResultWriter writing to the database. It must be
+// closed to write the results still waiting.
+func (t *TridentDB) NewResultWriter(opts BatchOptions) *ResultWriter {
+	return newResultWriter(t, opts)
+}
+
+func newResultWriter(sink resultSink, opts BatchOptions) *ResultWriter {
+	if opts.Size <= 0 {
+		opts.Size = DefaultBatchSize
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultFlushInterval
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = opts.Size
+	}
+
+	w := &ResultWriter{
+		results: make(chan *Result, opts.Buffer),
+		done:    make(chan struct{}),
+		sink:    sink,
+		opts:    opts,
+	}
+	go w.run()
+	return w
+}
+
+// Queue returns the channel results are sent on to be written. Nothing may be
+// sent once Close is called.
+func (w *ResultWriter) Queue() chan<- *Result {
+	return w.results
+}
+
+// Close writes the results still waiting and returns once they are written.
+func (w *ResultWriter) Close() {
+	close(w.results)
+	<-w.done
+}
+
+// run collects the results into batches and writes them until the writer is
+// closed and drained.
+func (w *ResultWriter) run() {
+	defer close(w.done)
+
+	batch := make([]*Result, 0, w.opts.Size)
+	for {
+		// block until the first result of a batch
+		r, ok := <-w.results
+		if !ok {
+			return
+		}
+		batch = append(batch, r)
+
+		timer := time.NewTimer(w.opts.Interval)
+	collect:
+		for len(batch) < w.opts.Size {
+			select {
+			case r, ok = <-w.results:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, r)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		w.write(batch)
+		batch = batch[:0]
+		if !ok {
+			return
+		}
+	}
+}
+
+// write writes a batch, retrying while the database is unreachable.
+func (w *ResultWriter) write(batch []*Result) {
+	delay := time.Second
+	for {
+		err := w.sink.CopyResults(batch)
+		if err == nil {
+			w.written(batch)
+			return
+		}
+		log.Printf("error writing a batch of %d results: %s", len(batch), err)
+
+		if w.sink.Ping() == nil {
+			// the database is up, so some result was at fault
+			w.writeEach(batch)
+			return
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// writeEach inserts the results of a rejected batch one at a time.
+func (w *ResultWriter) writeEach(batch []*Result) {
+	written := make([]*Result, 0, len(batch))
+	for _, r := range batch {
+		err := w.sink.InsertResult(r)
+		if err != nil {
+			log.Printf("error writing result for campaign %d: %s", r.CampaignID, err)
+			continue
+		}
+		written = append(written, r)
+	}
+	if dropped := len(batch) - len(written); dropped > 0 {
+		log.Printf("dropped %d of a batch of %d results", dropped, len(batch))
+	}
+	w.written(written)
+}
+
+// written passes the results written to the Written callback.
+func (w *ResultWriter) written(results []*Result) {
+	if w.opts.Written != nil && len(results) > 0 {
+		w.opts.Written(results)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink records the batches written to it. Every call takes latency, like
+// a round trip to the database.
+type fakeSink struct {
+	mu       sync.Mutex
+	batches  [][]*Result
+	inserted []*Result
+	latency  time.Duration
+
+	// results for this campaign are rejected
+	reject uint
+}
+
+func (s *fakeSink) CopyResults(results []*Result) error {
+	time.Sleep(s.latency)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range results {
+		if s.reject != 0 && r.CampaignID == s.reject {
+			return errors.New("invalid input syntax")
+		}
+	}
+	s.batches = append(s.batches, append([]*Result(nil), results...))
+	return nil
+}
+
+func (s *fakeSink) InsertResult(r *Result) error {
+	time.Sleep(s.latency)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reject != 0 && r.CampaignID == s.reject {
+		return errors.New("invalid input syntax")
+	}
+	s.inserted = append(s.inserted, r)
+	return nil
+}
+
+func (s *fakeSink) Ping() error {
+	return nil
+}
+
+func TestResultWriter(t *testing.T) {
+	sink := &fakeSink{}
+	w := newResultWriter(sink, BatchOptions{Size: 3, Interval: time.Hour})
+	for i := 0; i < 7; i++ {
+		w.Queue() <- &Result{CampaignID: 1}
+	}
+
+	// closing writes the partial batch, however long its interval
+	w.Close()
+	var sizes []int
+	for _, b := range sink.batches {
+		sizes = append(sizes, len(b))
+	}
+	if fmt.Sprint(sizes) != "[3 3 1]" {
+		t.Errorf("batches were %v, expected [3 3 1]", sizes)
+	}
+}
+
+func TestResultWriterInterval(t *testing.T) {
+	sink := &fakeSink{}
+	w := newResultWriter(sink, BatchOptions{Size: 100, Interval: 10 * time.Millisecond})
+	defer w.Close()
+
+	w.Queue() <- &Result{CampaignID: 1}
+	time.Sleep(100 * time.Millisecond)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.batches) != 1 {
+		t.Errorf("%d batches were written after the interval", len(sink.batches))
+	}
+}
+
+func TestResultWriterRejected(t *testing.T) {
+	sink := &fakeSink{reject: 2}
+	var written []*Result
+	w := newResultWriter(sink, BatchOptions{Size: 3, Interval: time.Hour, Written: func(results []*Result) {
+		written = append(written, results...)
+	}})
+	w.Queue() <- &Result{CampaignID: 1}
+	w.Queue() <- &Result{CampaignID: 2}
+	w.Queue() <- &Result{CampaignID: 1}
+	w.Close()
+
+	// the rejected batch is written one result at a time, dropping only the
+	// result at fault
+	if len(sink.batches) != 0 || len(sink.inserted) != 2 {
+		t.Errorf("%d batches and %d results were written", len(sink.batches), len(sink.inserted))
+	}
+	if len(written) != 2 || written[0].CampaignID != 1 || written[1].CampaignID != 1 {
+		t.Errorf("written callback was passed %d results", len(written))
+	}
+}
+
+// BenchmarkResultWriter compares writing each result on its own, as a plain
+// INSERT per result would, against batches. Each write takes 1ms, a
+// typical round trip to a database in the same region.
+func BenchmarkResultWriter(b *testing.B) {
+	for _, size := range []int{1, 100, DefaultBatchSize} {
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			sink := &fakeSink{latency: time.Millisecond}
+			w := newResultWriter(sink, BatchOptions{Size: size, Interval: time.Second})
+			r := &Result{CampaignID: 1}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				w.Queue() <- r
+			}
+			w.Close()
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatch
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Backoff holds the tasks of a provider for as long as the provider asked, in
+// the Retry-After header of a rate limited response, rather than continuing to
+// send it attempts. The delay is capped at Max, so a hostile or misconfigured
+// Retry-After cannot stall a campaign indefinitely.
+type Backoff struct {
+	max time.Duration
+
+	mu     sync.Mutex
+	until  map[string]time.Time
+	events int
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+// NewBackoff creates a Backoff which holds tasks for at most max. A max of 0
+// disables it, and Retry-After is ignored.
+func NewBackoff(max time.Duration) *Backoff {
+	return &Backoff{
+		max:   max,
+		until: make(map[string]time.Time),
+		now:   time.Now,
+	}
+}
+
+// Wait returns how long tasks for the provider must still be held, or 0 if
+// they may be submitted now.
+func (b *Backoff) Wait(provider string) time.Duration {
+	if b == nil || b.max <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wait := b.until[provider].Sub(b.now())
+	if wait <= 0 {
+		delete(b.until, provider)
+		return 0
+	}
+	return wait
+}
+
+// Pause holds the tasks for the provider for the delay it asked for, capped at
+// the maximum, and records the backoff. A delay which ends before the current
+// one is ignored.
+func (b *Backoff) Pause(provider string, delay time.Duration) {
+	if b == nil || b.max <= 0 || delay <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	capped := delay
+	if capped > b.max {
+		capped = b.max
+	}
+	until := b.now().Add(capped)
+	if !until.After(b.until[provider]) {
+		return
+	}
+	b.until[provider] = until
+	b.events++
+	if capped < delay {
+		log.Printf("provider %s asked to retry after %s, holding its tasks for %s (capped) until %s",
+			provider, delay, capped, until.Format(time.RFC3339))
+		return
+	}
+	log.Printf("provider %s asked to retry after %s, holding its tasks until %s",
+		provider, delay, until.Format(time.RFC3339))
+}
+
+// Status returns the time the last backoff in effect ends, the zero time if
+// none is, and the number of backoffs recorded since the dispatcher started.
+func (b *Backoff) Status() (time.Time, int) {
+	if b == nil {
+		return time.Time{}, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var last time.Time
+	now := b.now()
+	for _, until := range b.until {
+		if until.After(now) && until.After(last) {
+			last = until
+		}
+	}
+	return last, b.events
+}
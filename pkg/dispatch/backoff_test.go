@@ -0,0 +1,70 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBackoff(10 * time.Minute)
+	b.now = func() time.Time { return now }
+
+	if wait := b.Wait("okta"); wait != 0 {
+		t.Fatalf("tasks were held for %s before any backoff", wait)
+	}
+
+	b.Pause("okta", 2*time.Minute)
+	if wait := b.Wait("okta"); wait != 2*time.Minute {
+		t.Errorf("tasks were held for %s, expected 2m", wait)
+	}
+	if wait := b.Wait("o365"); wait != 0 {
+		t.Errorf("tasks for another provider were held for %s", wait)
+	}
+
+	// a shorter delay does not cut the backoff short
+	b.Pause("okta", time.Minute)
+	if wait := b.Wait("okta"); wait != 2*time.Minute {
+		t.Errorf("tasks were held for %s after a shorter delay", wait)
+	}
+
+	// a huge delay is capped
+	b.Pause("okta", 24*time.Hour)
+	if wait := b.Wait("okta"); wait != 10*time.Minute {
+		t.Errorf("tasks were held for %s, expected the 10m cap", wait)
+	}
+	until, events := b.Status()
+	if !until.Equal(now.Add(10*time.Minute)) || events != 2 {
+		t.Errorf("status was %s, %d", until, events)
+	}
+
+	now = now.Add(10 * time.Minute)
+	if wait := b.Wait("okta"); wait != 0 {
+		t.Errorf("tasks were held for %s after the backoff ended", wait)
+	}
+	if until, _ := b.Status(); !until.IsZero() {
+		t.Errorf("backoff in effect until %s after it ended", until)
+	}
+}
+
+func TestBackoffDisabled(t *testing.T) {
+	b := NewBackoff(0)
+	b.Pause("okta", time.Minute)
+	if wait := b.Wait("okta"); wait != 0 {
+		t.Errorf("disabled backoff held tasks for %s", wait)
+	}
+}
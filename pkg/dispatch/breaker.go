@@ -0,0 +1,187 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatch
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a provider's circuit breaker.
+type BreakerState string
+
+const (
+	// BreakerClosed submits every task
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen holds every task until the cooldown has passed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen submits a single probe task, whose outcome closes or
+	// reopens the breaker
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// probeWait is how often tasks held behind a probe check whether it finished.
+var probeWait = time.Second
+
+// Breaker is a circuit breaker per provider. It trips after Threshold
+// consecutive worker errors for a provider, so a dead endpoint does not
+// consume the campaign's window with errors: tasks for the provider are held
+// for the Cooldown, then a single probe decides whether to resume.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+	trips    int
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type circuit struct {
+	state    BreakerState
+	errors   int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a Breaker. A threshold of 0 disables it.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		circuits:  make(map[string]*circuit),
+		now:       time.Now,
+	}
+}
+
+func (b *Breaker) circuit(provider string) *circuit {
+	c, ok := b.circuits[provider]
+	if !ok {
+		c = &circuit{state: BreakerClosed}
+		b.circuits[provider] = c
+	}
+	return c
+}
+
+// transition moves the circuit to a new state and records the transition.
+func (b *Breaker) transition(provider string, c *circuit, state BreakerState) {
+	log.Printf("circuit breaker for provider %s: %s -> %s (%d consecutive errors)",
+		provider, c.state, state, c.errors)
+	c.state = state
+	if state == BreakerOpen {
+		c.openedAt = b.now()
+		b.trips++
+	}
+}
+
+// Allow returns true if a task for the provider may be submitted now, or else
+// how long to wait before asking again. Once the cooldown has passed the
+// first caller is allowed through as the probe.
+func (b *Breaker) Allow(provider string) (bool, time.Duration) {
+	if b == nil || b.threshold <= 0 {
+		return true, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider)
+	switch c.state {
+	case BreakerOpen:
+		if wait := c.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return false, wait
+		}
+		b.transition(provider, c, BreakerHalfOpen)
+		c.probing = true
+		return true, 0
+	case BreakerHalfOpen:
+		if c.probing {
+			return false, probeWait
+		}
+		c.probing = true
+		return true, 0
+	}
+	return true, 0
+}
+
+// Record records the outcome of a submitted task.
+func (b *Breaker) Record(provider string, err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.circuit(provider)
+	c.probing = false
+	if err == nil {
+		if c.state != BreakerClosed {
+			b.transition(provider, c, BreakerClosed)
+		}
+		c.errors = 0
+		return
+	}
+
+	c.errors++
+	switch {
+	case c.state == BreakerHalfOpen:
+		b.transition(provider, c, BreakerOpen)
+	case c.state == BreakerClosed && c.errors >= b.threshold:
+		b.transition(provider, c, BreakerOpen)
+	}
+}
+
+// Release gives up a task allowed by Allow without submitting it, so another
+// task can be sent as the probe.
+func (b *Breaker) Release(provider string) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.circuit(provider).probing = false
+}
+
+// Status returns the providers whose breaker is not closed, sorted, and the
+// number of times a breaker opened since the dispatcher started.
+func (b *Breaker) Status() ([]string, int) {
+	if b == nil {
+		return nil, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var open []string
+	for provider, c := range b.circuits {
+		if c.state != BreakerClosed {
+			open = append(open, provider)
+		}
+	}
+	sort.Strings(open)
+	return open, b.trips
+}
+
+// State returns the current state of the provider's breaker.
+func (b *Breaker) State(provider string) BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.circuit(provider).state
+}
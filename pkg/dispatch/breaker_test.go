@@ -0,0 +1,95 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatch
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := NewBreaker(3, 5*time.Minute)
+	b.now = func() time.Time { return now }
+	errWorker := errors.New("connection refused")
+
+	// a success resets the consecutive error count
+	b.Record("okta", errWorker)
+	b.Record("okta", errWorker)
+	b.Record("okta", nil)
+	b.Record("okta", errWorker)
+	b.Record("okta", errWorker)
+	if state := b.State("okta"); state != BreakerClosed {
+		t.Fatalf("breaker was %s before the threshold", state)
+	}
+
+	b.Record("okta", errWorker)
+	if state := b.State("okta"); state != BreakerOpen {
+		t.Fatalf("breaker was %s after the threshold", state)
+	}
+	if ok, wait := b.Allow("okta"); ok || wait != 5*time.Minute {
+		t.Errorf("open breaker allowed %t, wait %s", ok, wait)
+	}
+	if ok, _ := b.Allow("o365"); !ok {
+		t.Errorf("breaker for another provider was not closed")
+	}
+	if open, trips := b.Status(); len(open) != 1 || open[0] != "okta" || trips != 1 {
+		t.Errorf("unexpected status %v, %d trips", open, trips)
+	}
+
+	// after the cooldown a single probe is allowed through
+	now = now.Add(5 * time.Minute)
+	if ok, _ := b.Allow("okta"); !ok {
+		t.Fatalf("probe was not allowed after the cooldown")
+	}
+	if ok, _ := b.Allow("okta"); ok {
+		t.Errorf("second task was allowed during the probe")
+	}
+	b.Record("okta", errWorker)
+	if state := b.State("okta"); state != BreakerOpen {
+		t.Fatalf("breaker was %s after a failed probe", state)
+	}
+
+	// a released probe lets the next task probe instead
+	now = now.Add(5 * time.Minute)
+	if ok, _ := b.Allow("okta"); !ok {
+		t.Fatalf("probe was not allowed after the cooldown")
+	}
+	b.Release("okta")
+	if ok, _ := b.Allow("okta"); !ok {
+		t.Fatalf("probe was not allowed after a release")
+	}
+	b.Record("okta", nil)
+	if state := b.State("okta"); state != BreakerClosed {
+		t.Fatalf("breaker was %s after a successful probe", state)
+	}
+	if ok, _ := b.Allow("okta"); !ok {
+		t.Errorf("closed breaker did not allow task")
+	}
+	if open, trips := b.Status(); len(open) != 0 || trips != 2 {
+		t.Errorf("unexpected status %v, %d trips", open, trips)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := NewBreaker(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		b.Record("okta", errors.New("connection refused"))
+	}
+	if ok, _ := b.Allow("okta"); !ok {
+		t.Errorf("disabled breaker did not allow task")
+	}
+}
@@ -33,6 +33,18 @@ type WorkerClient interface {
 	Submit(event.AuthRequest) (*event.AuthResponse, error)
 }
 
+// WorkerError is returned by Submit when the worker reports a failed task.
+// Category is set if the attempt itself failed, e.g. the provider could not
+// be reached, in which case the failure is recorded as an error result.
+type WorkerError struct {
+	Category string
+	Msg      string
+}
+
+func (e *WorkerError) Error() string {
+	return e.Msg
+}
+
 // Driver is an interface which wraps the creation of a WorkerClient.
 type Driver interface {
 	New(opts map[string]string) (WorkerClient, error)
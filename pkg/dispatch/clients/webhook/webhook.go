@@ -17,7 +17,6 @@ package webhook
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 
@@ -92,7 +91,7 @@ func (w *Client) Submit(r event.AuthRequest) (*event.AuthResponse, error) {
 		if err != nil {
 			return nil, err
 		}
-		return nil, errors.New(res.ErrorMsg)
+		return nil, &dispatch.WorkerError{Category: res.Category, Msg: res.ErrorMsg}
 	}
 
 	var res event.AuthResponse
@@ -39,7 +39,12 @@ package dispatch
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/pubsub"
@@ -47,14 +52,33 @@ import (
 	"github.com/praetorian-inc/trident/pkg/event"
 )
 
+// HeartbeatInterval is how often a dispatcher publishes a heartbeat, so the
+// orchestrator can count the dispatchers serving its queue.
+var HeartbeatInterval = 30 * time.Second
+
+// HeldRedelivery is the longest a task held for its provider waits before it
+// is returned to Pub/Sub for redelivery. Held tasks are never kept until the
+// hold ends, since they would all be submitted at once, with no regard for
+// the campaign's interval between guesses against a user.
+var HeldRedelivery = 10 * time.Second
+
 // Dispatcher creates a data pipeline which accepts tasks, sends them to a
 // worker, and publishes the result. This pipeline can be visualized as:
 //  PubSub Subscription --> WorkerClient --> PubSub Topic
 type Dispatcher struct {
+	// inFlight is the number of tasks received but not yet finished. It is
+	// first so it is 64-bit aligned for atomic access.
+	inFlight int64
+
 	wc WorkerClient
 
-	sub     *pubsub.Subscription
-	resultc *pubsub.Topic
+	sub      *pubsub.Subscription
+	resultc  *pubsub.Topic
+	name     string
+	region   string
+	egressIP string
+	breaker  *Breaker
+	backoff  *Backoff
 }
 
 // Options is used to configure a Dispatcher
@@ -70,6 +94,32 @@ type Options struct {
 	// ResultTopicID is the Pub/Sub topic ID used by the dispatcher to publish
 	// results..
 	ResultTopicID string
+
+	// Name identifies the dispatcher in the orchestrator's worker stats. It
+	// defaults to the hostname.
+	Name string
+
+	// Region is the worker region served by this dispatcher. It is attached to
+	// each published result so the scheduler can track the region's health.
+	Region string
+
+	// EgressIP is the address the worker's attempts leave from, reported in
+	// the heartbeat if set
+	EgressIP string
+
+	// BreakerThreshold is the number of consecutive worker errors for a
+	// provider after which its tasks are held for BreakerCooldown. A value of
+	// 0 disables the circuit breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long tasks are held once the breaker trips,
+	// before a single probe task is sent to decide whether to resume.
+	BreakerCooldown time.Duration
+
+	// MaxRetryAfter caps how long a provider's tasks are held when it asks
+	// for a delay in the Retry-After header of a rate limited response. A
+	// value of 0 ignores Retry-After.
+	MaxRetryAfter time.Duration
 }
 
 // NewDispatcher creates a dispatcher based on the provided options and worker.
@@ -79,23 +129,112 @@ func NewDispatcher(ctx context.Context, opts Options, wc WorkerClient) (*Dispatc
 		return nil, err
 	}
 
+	name := opts.Name
+	if name == "" {
+		name, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	sub := client.Subscription(opts.SubscriptionID)
 	sub.ReceiveSettings.Synchronous = true
 	sub.ReceiveSettings.MaxOutstandingMessages = 10
 
 	return &Dispatcher{
-		wc:      wc,
-		sub:     sub,
-		resultc: client.Topic(opts.ResultTopicID),
+		wc:       wc,
+		sub:      sub,
+		resultc:  client.Topic(opts.ResultTopicID),
+		name:     name,
+		region:   opts.Region,
+		egressIP: opts.EgressIP,
+		breaker:  NewBreaker(opts.BreakerThreshold, opts.BreakerCooldown),
+		backoff:  NewBackoff(opts.MaxRetryAfter),
 	}, nil
 }
 
+// heartbeatAttributes returns the attributes of a heartbeat message, which
+// describe the dispatcher and the number of tasks it is working on.
+func (d *Dispatcher) heartbeatAttributes() map[string]string {
+	attrs := map[string]string{
+		"heartbeat": d.name,
+		"in_flight": strconv.FormatInt(atomic.LoadInt64(&d.inFlight), 10),
+	}
+	if d.region != "" {
+		attrs["region"] = d.region
+	}
+	if d.egressIP != "" {
+		attrs["egress_ip"] = d.egressIP
+	}
+	if open, trips := d.breaker.Status(); trips > 0 {
+		attrs["breaker_trips"] = strconv.Itoa(trips)
+		if len(open) > 0 {
+			attrs["breaker_open"] = strings.Join(open, ",")
+		}
+	}
+	if until, events := d.backoff.Status(); events > 0 {
+		attrs["backoffs"] = strconv.Itoa(events)
+		if !until.IsZero() {
+			attrs["backoff_until"] = until.UTC().Format(time.RFC3339)
+		}
+	}
+	return attrs
+}
+
+// heartbeat publishes a heartbeat to the result topic every
+// HeartbeatInterval until the context is done.
+func (d *Dispatcher) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		msg := &pubsub.Message{Attributes: d.heartbeatAttributes()}
+		_, err := d.resultc.Publish(ctx, msg).Get(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("error publishing heartbeat: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// redeliver waits before a held task is returned to Pub/Sub, for as long as
+// its provider is held but at most HeldRedelivery, so it is not redelivered in
+// a tight loop.
+func (d *Dispatcher) redeliver(ctx context.Context, wait time.Duration) {
+	if wait > HeldRedelivery {
+		wait = HeldRedelivery
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(wait):
+	}
+}
+
 // Listen listens for task messages on the Pub/Sub subscription. Tasks are sent
-// to the worker and results are then published to the Pub/Sub topic.
+// to the worker and results are then published to the Pub/Sub topic. A
+// heartbeat is published alongside the results while the dispatcher listens.
 func (d *Dispatcher) Listen(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go d.heartbeat(ctx)
+
 	return d.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		// always ACK messages to avoid infinite loop handling a bad message
-		defer msg.Ack()
+		// always ACK messages to avoid infinite loop handling a bad message,
+		// except held tasks, which are returned for redelivery
+		held := false
+		defer func() {
+			if held {
+				msg.Nack()
+				return
+			}
+			msg.Ack()
+		}()
+
+		atomic.AddInt64(&d.inFlight, 1)
+		defer atomic.AddInt64(&d.inFlight, -1)
 
 		var req event.AuthRequest
 		err := json.Unmarshal(msg.Data, &req)
@@ -104,20 +243,60 @@ func (d *Dispatcher) Listen(ctx context.Context) error {
 			return
 		}
 
+		// while the provider asked us to back off or its breaker is open,
+		// the task goes back to Pub/Sub
+		if wait := d.backoff.Wait(req.Provider); wait > 0 {
+			held = true
+			d.redeliver(ctx, wait)
+			return
+		}
+		if ok, wait := d.breaker.Allow(req.Provider); !ok {
+			held = true
+			d.redeliver(ctx, wait)
+			return
+		}
+
 		ts := time.Now()
-		if ts.After(req.NotAfter) {
+		if ts.After(req.NotAfter) || (req.Deadline != nil && ts.After(*req.Deadline)) {
+			// a probe that is never sent must not leave the breaker half-open
+			d.breaker.Release(req.Provider)
 			return
 		}
 
 		resp, err := d.wc.Submit(req)
+		d.breaker.Record(req.Provider, err)
+		if err == nil && resp.RateLimited {
+			d.backoff.Pause(req.Provider, resp.RetryAfter)
+		}
 		if err != nil {
 			log.Printf("error from worker: %s", err)
-			return
+			// failed attempts are recorded as error results, failures
+			// before the attempt was made are not
+			var werr *WorkerError
+			if !errors.As(err, &werr) || werr.Category == "" {
+				return
+			}
+			resp = &event.AuthResponse{
+				CampaignID:    req.CampaignID,
+				Username:      req.Username,
+				Password:      req.Password,
+				Timestamp:     ts,
+				ErrorCategory: werr.Category,
+				Error:         werr.Msg,
+			}
+		}
+
+		if req.UsernameSalt != "" {
+			resp.HashUsername(req.UsernameSalt)
 		}
 
 		b, _ := json.Marshal(resp)
-		d.resultc.Publish(ctx, &pubsub.Message{
+		result := &pubsub.Message{
 			Data: b,
-		})
+		}
+		if d.region != "" {
+			result.Attributes = map[string]string{"region": d.region}
+		}
+		d.resultc.Publish(ctx, result)
 	})
 }
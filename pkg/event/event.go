@@ -15,6 +15,10 @@
 package event
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
 	"time"
 )
 
@@ -29,6 +33,9 @@ type AuthRequest struct {
 	// NotAfter will prevent execution after this time
 	NotAfter time.Time `json:"not_after"`
 
+	// Deadline is the campaign's hard stop, after which the task is dropped
+	Deadline *time.Time `json:"deadline,omitempty"`
+
 	// Username is the username at the identity provider
 	Username string `json:"username"`
 
@@ -40,6 +47,25 @@ type AuthRequest struct {
 
 	// ProviderMetadata is any required configuration data for the provider
 	ProviderMetadata map[string]string `json:"metadata"`
+
+	// CaptureOnValid asks the worker to return the provider's response to a
+	// valid credential
+	CaptureOnValid bool `json:"capture_on_valid,omitempty"`
+
+	// UsernameSalt is the campaign's salt if it hashes usernames, in which
+	// case the response is returned with the hashed username, see
+	// AuthResponse.HashUsername
+	UsernameSalt string `json:"username_salt,omitempty"`
+}
+
+// HashUsername returns the hex HMAC-SHA256 of the username keyed by a
+// campaign's salt. The results of a campaign which hashes usernames carry it
+// in place of the username, so the plaintext only exists in the operator's
+// user list.
+func HashUsername(salt, username string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(username)) // nolint:errcheck
+	return hex.EncodeToString(mac.Sum(nil))
 }
 
 // AuthResponse represents the response to an authentication attempt.
@@ -53,6 +79,9 @@ type AuthResponse struct {
 	// Timestamp is the time that we made the request
 	Timestamp time.Time `json:"timestamp"`
 
+	// Latency is how long the provider took to answer the attempt
+	Latency time.Duration `json:"latency,omitempty"`
+
 	// Username is the username at the identity provider
 	Username string `json:"username"`
 
@@ -68,11 +97,49 @@ type AuthResponse struct {
 	// MFA will be true iff the account is known to require MFA to log in
 	MFA bool `json:"mfa"`
 
+	// Expired will be true iff the password is correct but has expired
+	Expired bool `json:"expired"`
+
 	// RateLimited indicates the provider has detected a large number of requests
 	RateLimited bool `json:"rate_limited"`
 
+	// RetryAfter is how long a rate limited provider asked the client to
+	// wait, from its Retry-After header, zero if it did not say
+	RetryAfter time.Duration `json:"retry_after,omitempty"`
+
+	// WAF names the WAF or captcha which challenged the request instead of
+	// the provider answering it, empty if none did
+	WAF string `json:"waf,omitempty"`
+
+	// ErrorCategory is the category of the error which prevented the attempt
+	// from producing a verdict (e.g. dns or timeout), empty if none did
+	ErrorCategory string `json:"error_category,omitempty"`
+
+	// Error is the sanitized error message, with the password redacted
+	Error string `json:"error,omitempty"`
+
 	// Additional metadata from the auth provider (e.g. information about MFA)
 	Metadata map[string]interface{} `json:"metadata"`
+
+	// Capture is the provider's response to a valid credential, only
+	// returned if the request asked for it
+	Capture *Capture `json:"capture,omitempty"`
+}
+
+// Capture is a size-capped copy of a provider's HTTP response, with the
+// attempted password redacted.
+type Capture struct {
+	// Status is the HTTP status code
+	Status int `json:"status"`
+
+	// Headers are the response headers, including any session cookies
+	Headers map[string][]string `json:"headers,omitempty"`
+
+	// Body is the beginning of the response body
+	Body string `json:"body,omitempty"`
+
+	// Truncated is true if the body was cut off
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // ErrorResponse represents a failure in task processing. This response should
@@ -80,4 +147,18 @@ type AuthResponse struct {
 type ErrorResponse struct {
 	// ErrorMsg is the result of error.Error()
 	ErrorMsg string `json:"error"`
+
+	// Category is the error category if the task failed while making the
+	// attempt, as opposed to before it could be made
+	Category string `json:"category,omitempty"`
+}
+
+// HashUsername replaces the username of the response with its hash keyed by
+// salt, including where the error message mentions it.
+func (r *AuthResponse) HashUsername(salt string) {
+	hashed := HashUsername(salt, r.Username)
+	if r.Username != "" {
+		r.Error = strings.ReplaceAll(r.Error, r.Username, hashed)
+	}
+	r.Username = hashed
 }
@@ -64,6 +64,23 @@ func init() {
 //
 // The authenticate strategy to use. This can be one of the following:
 // usernamemixed (default) or ntlm (bypasses external lockout).
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted. Certificate verification is skipped
+// unless insecure_skip_verify is false.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted. They only apply to the
+// usernamemixed strategy: NTLM authenticates the connection, so the ntlm
+// strategy uses a new connection for every attempt.
+//
+// The timeout and submit_timeout options described by nozzle.ParseTimeouts
+// are also accepted. Both strategies send the credentials with their only
+// request, so there is no discovery step.
 func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 	domain, ok := opts["domain"]
 	if !ok {
@@ -75,13 +92,76 @@ func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 		strategy = "usernamemixed"
 	}
 
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("adfs", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Nozzle{
 		Domain:    domain,
 		Strategy:  strategy,
 		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+		Timeouts:  timeouts,
 	}, nil
 }
 
+// Describe returns the configuration options of the adfs nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "domain", Description: "the host name of the adfs server, e.g. adfs.example.org", Required: true},
+		{Name: "strategy", Description: "usernamemixed (default) or ntlm"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepSubmit))
+}
+
+// trustEndpoints are the WS-Trust endpoints which accept a password in the
+// request itself, bypassing the MFA adapters of the sign-in page.
+var trustEndpoints = []nozzle.HTTPEndpoint{
+	{Name: "usernamemixed-2005", Path: "/adfs/services/trust/2005/usernamemixed", Legacy: true},
+	{Name: "usernamemixed-13", Path: "/adfs/services/trust/13/usernamemixed", Legacy: true},
+	{Name: "windowstransport-2005", Path: "/adfs/services/trust/2005/windowstransport", Legacy: true},
+	{Name: "windowstransport-13", Path: "/adfs/services/trust/13/windowstransport", Legacy: true},
+	{Name: "ls", Path: "/adfs/ls/"},
+}
+
+// Recon fulfils the nozzle.Recon interface and probes the adfs host, by default
+// the configured domain, for the WS-Trust endpoints used by the usernamemixed
+// and ntlm strategies. Disabled endpoints answer 404 or 503. The TLS options
+// described by New are accepted.
+func (Driver) Recon(host string, opts map[string]string) ([]nozzle.Surface, error) {
+	if host == "" {
+		host = opts["domain"]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("adfs recon requires a host or the 'domain' config parameter")
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return nozzle.ProbeHTTP(nozzle.ReconClient(tlsConfig), "https://"+host, FrozenUserAgent, trustEndpoints), nil
+}
+
 // Nozzle implements the nozzle.Nozzle interface for adfs.
 type Nozzle struct {
 	// Domain is the adfs subdomain
@@ -92,6 +172,18 @@ type Nozzle struct {
 
 	// UserAgent will override the Go-http-client user-agent in requests
 	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
 }
 
 var (
@@ -162,27 +254,33 @@ func (n *Nozzle) ntlmStrategy(username, password string) (*event.AuthResponse, e
 	client := &http.Client{
 		Transport: ntlmssp.Negotiator{
 			RoundTripper: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true, // nolint:gosec
-				},
+				TLSClientConfig: n.TLSConfig,
 			},
 		},
 	}
 
 	req, _ := http.NewRequest("GET", url, strings.NewReader(data))
+	req, cancel := n.Timeouts.Request(req, nozzle.StepSubmit)
+	defer cancel()
 	req.SetBasicAuth(username, password)
 	req.Header.Set("Content-Type", "application/soap+xml")
 	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
 	if resp.StatusCode == 503 {
 		return nil, fmt.Errorf("ntlm not enabled externally")
 	}
 
+	capture := nozzle.Capture(resp)
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -195,6 +293,7 @@ func (n *Nozzle) ntlmStrategy(username, password string) (*event.AuthResponse, e
 		Metadata: map[string]interface{}{
 			"xml": string(body),
 		},
+		Capture: capture,
 	}, nil
 }
 
@@ -203,23 +302,27 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 	data := fmt.Sprintf(usernameMixedRequest,
 		n.Domain, escape(username), escape(password), n.Domain)
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // nolint:gosec
-			},
-		},
-	}
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+	client := &http.Client{Transport: transport}
 
 	req, _ := http.NewRequest("GET", url, strings.NewReader(data))
+	req, cancel := n.Timeouts.Request(req, nozzle.StepSubmit)
+	defer cancel()
 	req.Header.Set("Content-Type", "application/soap+xml")
 	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
+	capture := nozzle.Capture(resp)
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -233,6 +336,7 @@ func (n *Nozzle) usernameMixedStrategy(username, password string) (*event.AuthRe
 			"status": resp.StatusCode,
 			"xml":    string(body),
 		},
+		Capture: capture,
 	}, nil
 }
 
@@ -16,7 +16,11 @@ package adfs
 
 import (
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,3 +49,76 @@ func TestNozzle(t *testing.T) {
 		t.Fatalf("unable to open nozzle: %s", err)
 	}
 }
+
+// TestGolden replays the saved responses in testdata through the
+// usernamemixed strategy. The ntlm strategy authenticates the connection
+// itself and cannot be replayed.
+func TestGolden(t *testing.T) {
+	noz, err := nozzle.Open("adfs", map[string]string{
+		"domain": "adfs.example.org",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	var testcases = []struct {
+		file  string
+		valid bool
+	}{
+		{file: "rstr.http", valid: true},
+		{file: "fault.http"},
+	}
+	for _, test := range testcases {
+		r, err := nozzle.ReadReplayer(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice@example.org", "Password1")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.file, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked || res.MFA {
+			t.Errorf("%s: got %+v", test.file, res)
+		}
+		if len(r.Requests) != 1 || r.Requests[0] != "GET https://adfs.example.org/adfs/services/trust/2005/usernamemixed" {
+			t.Errorf("%s: unexpected requests: %v", test.file, r.Requests)
+		}
+	}
+}
+
+func TestRecon(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "windowstransport") {
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(400)
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	surfaces, err := nozzle.Probe("adfs", "", map[string]string{"domain": host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range surfaces {
+		if !strings.HasPrefix(s.Endpoint, srv.URL+"/adfs/") {
+			t.Errorf("%s: unexpected endpoint %s", s.Name, s.Endpoint)
+		}
+		switch {
+		case strings.HasPrefix(s.Name, "usernamemixed"):
+			if !s.Exposed || !s.Legacy {
+				t.Errorf("%s: expected an exposed legacy endpoint: %+v", s.Name, s)
+			}
+		case strings.HasPrefix(s.Name, "windowstransport"):
+			if s.Exposed {
+				t.Errorf("%s: expected a disabled endpoint: %+v", s.Name, s)
+			}
+		}
+	}
+
+	if _, err := nozzle.Probe("adfs", "", nil); err == nil {
+		t.Error("expected error probing without a host")
+	}
+}
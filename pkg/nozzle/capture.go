@@ -0,0 +1,86 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+// MaxCaptureSize is the number of bytes of a response body kept in a capture.
+const MaxCaptureSize = 16 << 10
+
+// redacted replaces secrets in captured responses.
+const redacted = "[redacted]"
+
+// Capture copies the status, headers, and the first MaxCaptureSize bytes of
+// the body of a provider's response to a valid credential. The part of the
+// body that was read is put back, so the caller can still read the whole
+// response. The worker only returns the capture if the campaign asked for it,
+// after redacting the password with RedactCapture.
+func Capture(resp *http.Response) *event.Capture {
+	c := &event.Capture{
+		Status:  resp.StatusCode,
+		Headers: make(map[string][]string, len(resp.Header)),
+	}
+	for k, v := range resp.Header {
+		c.Headers[k] = append([]string(nil), v...)
+	}
+
+	if resp.Body == nil {
+		return c
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxCaptureSize+1))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+	if err != nil {
+		return c
+	}
+	if len(b) > MaxCaptureSize {
+		b = b[:MaxCaptureSize]
+		c.Truncated = true
+	}
+	c.Body = string(b)
+	return c
+}
+
+// RedactCapture replaces each of the secrets, e.g. the attempt's password,
+// in the captured headers and body, including their URL and HTML encoded
+// forms.
+func RedactCapture(c *event.Capture, secrets ...string) {
+	var variants []string
+	for _, s := range secrets {
+		if s != "" {
+			variants = append(variants, s, url.QueryEscape(s), html.EscapeString(s))
+		}
+	}
+	for _, s := range variants {
+		for k, values := range c.Headers {
+			for i, v := range values {
+				c.Headers[k][i] = strings.ReplaceAll(v, s, redacted)
+			}
+		}
+		c.Body = strings.ReplaceAll(c.Body, s, redacted)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	body := `{"sessionToken":"abc","echo":"p@ss w0rd","form":"p%40ss+w0rd"}` + strings.Repeat(" ", MaxCaptureSize)
+	resp := &http.Response{
+		StatusCode: 200,
+		Header: http.Header{
+			"Set-Cookie": {"sid=abc; Secure"},
+			"Location":   {"https://example.org/?pw=p%40ss+w0rd"},
+		},
+		Body: ioutil.NopCloser(strings.NewReader(body)),
+	}
+
+	c := Capture(resp)
+	RedactCapture(c, "p@ss w0rd", "")
+
+	if c.Status != 200 || !c.Truncated || len(c.Body) != MaxCaptureSize {
+		t.Errorf("unexpected capture: status %d, truncated %t, %d bytes", c.Status, c.Truncated, len(c.Body))
+	}
+	if !strings.HasPrefix(c.Body, `{"sessionToken":"abc","echo":"[redacted]","form":"[redacted]"}`) {
+		t.Errorf("password not redacted from body: %s", c.Body[:80])
+	}
+	if c.Headers["Set-Cookie"][0] != "sid=abc; Secure" || c.Headers["Location"][0] != "https://example.org/?pw=[redacted]" {
+		t.Errorf("unexpected headers: %v", c.Headers)
+	}
+	if resp.Header.Get("Location") != "https://example.org/?pw=p%40ss+w0rd" {
+		t.Errorf("redaction modified the response headers")
+	}
+
+	// the body can still be read in full after the capture
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil || string(b) != body {
+		t.Errorf("body was not restored: %d bytes, %v", len(b), err)
+	}
+}
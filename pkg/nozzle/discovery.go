@@ -0,0 +1,106 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDiscoveryTTL is how long discovery results are cached unless the
+// discovery_ttl option is set.
+const DefaultDiscoveryTTL = time.Hour
+
+// DiscoveryOptions describes the option read by DiscoveryTTL.
+var DiscoveryOptions = []Option{
+	{Name: "discovery_ttl", Description: "how long discovery results are cached, 1h by default"},
+}
+
+// Discovery caches the results of provider discovery requests, such as an
+// OpenID Connect well-known configuration. Nozzles are opened for every
+// attempt, so the cache is shared by all nozzles on the worker and keyed by
+// the discovery URL.
+var Discovery = NewDiscoveryCache()
+
+// DiscoveryCache is a concurrency safe cache of discovery results with a TTL.
+// Concurrent lookups of a missing key wait for a single fetch. Errors are not
+// cached.
+type DiscoveryCache struct {
+	mu      sync.Mutex
+	entries map[string]*discoveryEntry
+
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type discoveryEntry struct {
+	ready   chan struct{}
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// NewDiscoveryCache creates an empty DiscoveryCache.
+func NewDiscoveryCache() *DiscoveryCache {
+	return &DiscoveryCache{
+		entries: make(map[string]*discoveryEntry),
+		now:     time.Now,
+	}
+}
+
+// Get returns the cached value for key, calling fetch when the value is
+// missing, has failed, or is older than ttl.
+func (c *DiscoveryCache) Get(key string, ttl time.Duration, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		select {
+		case <-e.ready:
+			if e.err == nil && c.now().Before(e.expires) {
+				c.mu.Unlock()
+				return e.value, nil
+			}
+		default:
+			// another caller is fetching the value
+			c.mu.Unlock()
+			<-e.ready
+			return e.value, e.err
+		}
+	}
+	e = &discoveryEntry{ready: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	e.value, e.err = fetch()
+	e.expires = c.now().Add(ttl)
+	close(e.ready)
+	return e.value, e.err
+}
+
+// DiscoveryTTL reads the discovery_ttl option, a duration such as "30m" after
+// which cached discovery results are fetched again. It defaults to
+// DefaultDiscoveryTTL.
+func DiscoveryTTL(opts map[string]string) (time.Duration, error) {
+	v, ok := opts["discovery_ttl"]
+	if !ok {
+		return DefaultDiscoveryTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("invalid discovery_ttl %q", v)
+	}
+	return ttl, nil
+}
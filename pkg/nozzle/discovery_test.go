@@ -0,0 +1,91 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewDiscoveryCache()
+	c.now = func() time.Time { return now }
+
+	var fetches int32
+	fetch := func() (interface{}, error) {
+		atomic.AddInt32(&fetches, 1)
+		time.Sleep(10 * time.Millisecond)
+		return "https://example.org/token", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get("okta", time.Hour, fetch)
+			if err != nil || v != "https://example.org/token" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if fetches != 1 {
+		t.Errorf("concurrent lookups made %d fetches", fetches)
+	}
+
+	now = now.Add(59 * time.Minute)
+	c.Get("okta", time.Hour, fetch) // nolint:errcheck
+	if fetches != 1 {
+		t.Errorf("lookup before the ttl made %d fetches", fetches)
+	}
+	now = now.Add(time.Minute)
+	c.Get("okta", time.Hour, fetch) // nolint:errcheck
+	if fetches != 2 {
+		t.Errorf("lookup after the ttl made %d fetches", fetches)
+	}
+
+	// errors are returned but not cached
+	_, err := c.Get("o365", time.Hour, func() (interface{}, error) {
+		return nil, errors.New("connection refused")
+	})
+	if err == nil {
+		t.Errorf("expected fetch error")
+	}
+	v, err := c.Get("o365", time.Hour, fetch)
+	if err != nil || v != "https://example.org/token" {
+		t.Errorf("failed fetch was cached: %v, %v", v, err)
+	}
+}
+
+func TestDiscoveryTTL(t *testing.T) {
+	ttl, err := DiscoveryTTL(map[string]string{})
+	if err != nil || ttl != DefaultDiscoveryTTL {
+		t.Errorf("default ttl was %s, %v", ttl, err)
+	}
+	ttl, err = DiscoveryTTL(map[string]string{"discovery_ttl": "30m"})
+	if err != nil || ttl != 30*time.Minute {
+		t.Errorf("ttl was %s, %v", ttl, err)
+	}
+	for _, v := range []string{"soon", "-1m", "0s"} {
+		if _, err = DiscoveryTTL(map[string]string{"discovery_ttl": v}); err == nil {
+			t.Errorf("expected error for discovery_ttl %q", v)
+		}
+	}
+}
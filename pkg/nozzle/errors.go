@@ -0,0 +1,100 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// Error categories of failed attempts, reported with the sanitized error so
+// operators can tell why a campaign is not producing results.
+const (
+	// ErrorDNS is the category of failed name resolution
+	ErrorDNS = "dns"
+	// ErrorTLS is the category of failed TLS handshakes and certificates
+	ErrorTLS = "tls"
+	// ErrorTimeout is the category of attempts which timed out
+	ErrorTimeout = "timeout"
+	// ErrorConnectionRefused is the category of refused connections
+	ErrorConnectionRefused = "connection_refused"
+	// ErrorUnexpectedResponse is the category of responses the nozzle could
+	// not interpret, and of any other failure
+	ErrorUnexpectedResponse = "unexpected_response"
+	// ErrorConfig is the category of nozzles which could not be opened
+	ErrorConfig = "config"
+	// ErrorAuthDisabled is the category of servers which refuse password
+	// authentication altogether, whatever the credential
+	ErrorAuthDisabled = "auth_disabled"
+)
+
+// ErrAuthDisabled is returned by Login if the server does not accept password
+// authentication at all, so the attempt says nothing about the credential.
+var ErrAuthDisabled = errors.New("password authentication is disabled on the server")
+
+// maxErrorLength bounds the length of a sanitized error message.
+const maxErrorLength = 512
+
+// ErrorCategory returns the category of an error returned by Login.
+func ErrorCategory(err error) string {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, ErrAuthDisabled):
+		return ErrorAuthDisabled
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &recordErr), errors.As(err, &unknownAuthority),
+		errors.As(err, &hostname), errors.As(err, &invalid),
+		strings.HasPrefix(err.Error(), "tls: "), strings.Contains(err.Error(), " tls: "):
+		return ErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorConnectionRefused
+	}
+	return ErrorUnexpectedResponse
+}
+
+// SanitizeError returns the error message with each of the secrets, e.g. the
+// attempt's password, redacted and its length bounded, so it can be stored
+// with the attempt. A long message is cut at a rune boundary, so it stays
+// valid UTF-8.
+func SanitizeError(err error, secrets ...string) string {
+	msg := err.Error()
+	for _, s := range secrets {
+		if s != "" {
+			msg = strings.ReplaceAll(msg, s, redacted)
+		}
+	}
+	if len(msg) > maxErrorLength {
+		cut := maxErrorLength
+		for cut > 0 && !utf8.RuneStart(msg[cut]) {
+			cut--
+		}
+		msg = msg[:cut] + "..."
+	}
+	return msg
+}
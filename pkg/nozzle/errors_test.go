@@ -0,0 +1,75 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestErrorCategory(t *testing.T) {
+	var testcases = []struct {
+		desc     string
+		err      error
+		category string
+	}{
+		{"dns", &url.Error{Op: "Post", URL: "https://example.okta.com", Err: &net.OpError{
+			Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.okta.com", IsNotFound: true}}},
+			ErrorDNS},
+		{"certificate", &url.Error{Op: "Post", URL: "https://adfs.example.org", Err: x509.UnknownAuthorityError{}},
+			ErrorTLS},
+		{"handshake", fmt.Errorf("remote error: tls: handshake failure"), ErrorTLS},
+		{"timeout", &url.Error{Op: "Post", URL: "https://example.org", Err: context.DeadlineExceeded},
+			ErrorTimeout},
+		{"refused", &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}},
+			ErrorConnectionRefused},
+		{"unhandled status", errors.New("unhandled status code from okta provider: 500"), ErrorUnexpectedResponse},
+		{"auth disabled", fmt.Errorf("%w: 535 5.7.139 basic authentication is disabled", ErrAuthDisabled),
+			ErrorAuthDisabled},
+	}
+	for _, test := range testcases {
+		if category := ErrorCategory(test.err); category != test.category {
+			t.Errorf("%s: category was %s, expected %s", test.desc, category, test.category)
+		}
+	}
+}
+
+func TestSanitizeError(t *testing.T) {
+	err := fmt.Errorf("ldap bind failed for alice: invalid credentials Summer2020!")
+	msg := SanitizeError(err, "Summer2020!", "")
+	if strings.Contains(msg, "Summer2020!") || !strings.Contains(msg, "[redacted]") {
+		t.Errorf("password was not redacted: %s", msg)
+	}
+
+	msg = SanitizeError(errors.New(strings.Repeat("x", 1000)))
+	if len(msg) != maxErrorLength+3 {
+		t.Errorf("message was not truncated: %d", len(msg))
+	}
+
+	// a multi-byte character across the limit is left out whole
+	msg = SanitizeError(errors.New("x" + strings.Repeat("€", maxErrorLength)))
+	if !utf8.ValidString(msg) || len(msg) > maxErrorLength+3 || !strings.HasSuffix(msg, "€...") {
+		t.Errorf("message was not truncated at a rune boundary: %q", msg)
+	}
+}
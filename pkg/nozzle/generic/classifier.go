@@ -0,0 +1,179 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// DefaultClassifierTimeout bounds a classifier call unless
+	// classifier_timeout is set
+	DefaultClassifierTimeout = 5 * time.Second
+
+	// MaxClassifierTimeout is the longest classifier_timeout allowed, since
+	// every attempt waits for the classifier
+	MaxClassifierTimeout = 30 * time.Second
+
+	// classifierResponseLimit bounds how much of a verdict is read
+	classifierResponseLimit = 64 << 10
+)
+
+// ClassifierRequest is the body POSTed to the classifier for each attempt. The
+// password is redacted from the URL and the response, and never sent.
+type ClassifierRequest struct {
+	// Username is the username of the attempt
+	Username string `json:"username"`
+
+	// Method and URL are those of the login request
+	Method string `json:"method"`
+	URL    string `json:"url"`
+
+	// Response is the status, headers, and the beginning of the body of the
+	// provider's response
+	Response *event.Capture `json:"response"`
+}
+
+// ClassifierVerdict is the classifier's answer. Status is one of valid,
+// valid_expired, invalid, locked, or rate_limited. MFA marks a valid
+// credential whose account requires MFA, and Reason is stored in the
+// result's metadata.
+type ClassifierVerdict struct {
+	Status string `json:"status"`
+	MFA    bool   `json:"mfa,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Classifier is an external service which classifies the provider's responses
+// in place of the Matcher.
+type Classifier struct {
+	// URL is the http or https URL the responses are POSTed to
+	URL string
+
+	// Token is sent as a bearer token, if set
+	Token string
+
+	// Client makes the calls, its timeout bounds each of them
+	Client *http.Client
+}
+
+// Classify asks the classifier for the verdict on the response to the
+// attempt. The call is bounded by the client's timeout, and any failure,
+// including an unknown status, is returned as an error.
+func (c *Classifier) Classify(ctx context.Context, req *http.Request, username, password string,
+	capture *event.Capture) (*event.AuthResponse, error) {
+	// the capture is copied, since it is also returned with a valid result
+	response := &event.Capture{
+		Status:    capture.Status,
+		Headers:   make(map[string][]string, len(capture.Headers)),
+		Body:      capture.Body,
+		Truncated: capture.Truncated,
+	}
+	for k, v := range capture.Headers {
+		response.Headers[k] = append([]string(nil), v...)
+	}
+	nozzle.RedactCapture(response, password)
+
+	body, err := json.Marshal(ClassifierRequest{
+		Username: username,
+		Method:   req.Method,
+		URL:      redactURL(req.URL.String(), password),
+		Response: response,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.Client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("error calling classifier: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("classifier returned %d", resp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, classifierResponseLimit))
+	if err != nil {
+		return nil, fmt.Errorf("error reading classifier verdict: %w", err)
+	}
+	var verdict ClassifierVerdict
+	err = json.Unmarshal(b, &verdict)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing classifier verdict: %w", err)
+	}
+
+	res := &event.AuthResponse{
+		MFA: verdict.MFA,
+		Metadata: map[string]interface{}{
+			"status":        capture.Status,
+			"classified_by": "classifier",
+		},
+	}
+	if verdict.Reason != "" {
+		res.Metadata["reason"] = verdict.Reason
+	}
+	switch verdict.Status {
+	case "valid":
+		res.Valid = true
+	case "valid_expired":
+		res.Valid = true
+		res.Expired = true
+	case "invalid":
+	case "locked":
+		res.Locked = true
+	case "rate_limited":
+		res.RateLimited = true
+	default:
+		return nil, fmt.Errorf("classifier returned unknown status %q", verdict.Status)
+	}
+	if !res.Valid {
+		res.MFA = false
+	}
+	return res, nil
+}
+
+// redactURL replaces the password in the URL, in its raw and escaped forms.
+func redactURL(u, password string) string {
+	if password == "" {
+		return u
+	}
+	for _, s := range []string{password, url.QueryEscape(password), url.PathEscape(password)} {
+		u = strings.ReplaceAll(u, s, "[redacted]")
+	}
+	return u
+}
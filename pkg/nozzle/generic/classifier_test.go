@@ -0,0 +1,111 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassifier(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the form is echoed back, as some error pages do
+		fmt.Fprintf(w, "<p>%s</p>", r.PostFormValue("password"))
+		if r.PostFormValue("password") == "S3cret&" {
+			fmt.Fprint(w, `<script>var state = {"step": "otp"};</script>`)
+		}
+	}))
+	defer target.Close()
+
+	classifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer t0ken" || strings.Contains(string(b), "S3cret") ||
+			strings.Contains(string(b), "Passw0rd") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var req ClassifierRequest
+		if err := json.Unmarshal(b, &req); err != nil || req.Response == nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch {
+		case req.Username == "slow@example.org":
+			time.Sleep(500 * time.Millisecond)
+		case req.Username == "odd@example.org":
+			fmt.Fprint(w, `{"status": "maybe"}`)
+		case strings.Contains(req.Response.Body, `"step": "otp"`):
+			fmt.Fprint(w, `{"status": "valid", "mfa": true, "reason": "otp_prompt"}`)
+		default:
+			fmt.Fprint(w, `{"status": "invalid"}`)
+		}
+	}))
+	defer classifier.Close()
+
+	open := func(opts map[string]string) *Nozzle {
+		opts["url"] = target.URL + "/login?p={{urlencode .Password}}"
+		opts["body"] = "username={{urlencode .Username}}&password={{urlencode .Password}}"
+		opts["classifier_url"] = classifier.URL
+		opts["classifier_token"] = "t0ken"
+		opts["classifier_timeout"] = "100ms"
+		n, err := Driver{}.New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.(*Nozzle).TLSConfig = &tls.Config{InsecureSkipVerify: true} // nolint:gosec
+		return n.(*Nozzle)
+	}
+
+	n := open(map[string]string{})
+	res, err := n.Login("alice@example.org", "S3cret&")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid || !res.MFA || res.Metadata["reason"] != "otp_prompt" {
+		t.Errorf("expected valid credential with MFA, got %+v", res)
+	}
+
+	res, err = n.Login("alice@example.org", "Passw0rd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Valid {
+		t.Errorf("expected invalid credential, got %+v", res)
+	}
+
+	// without patterns, a failed or unknown verdict is an error
+	for _, username := range []string{"slow@example.org", "odd@example.org"} {
+		if _, err = n.Login(username, "Passw0rd"); err == nil {
+			t.Errorf("%s: expected error", username)
+		}
+	}
+
+	// with patterns, they classify the response instead
+	n = open(map[string]string{"valid_match": `"step": "otp"`})
+	res, err = n.Login("slow@example.org", "S3cret&")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid || res.Metadata["classifier_error"] == nil {
+		t.Errorf("expected valid credential from the fallback, got %+v", res)
+	}
+}
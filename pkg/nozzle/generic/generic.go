@@ -0,0 +1,723 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package generic implements a nozzle for HTTP login forms and APIs which have
+// no dedicated provider. The request is described by templates in the
+// provider config and the outcome by status codes and patterns matched
+// against the response.
+package generic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// TemplateHeaderPrefix is the prefix of config options which add a
+	// templated header to every request, e.g. "template_header.Authorization"
+	TemplateHeaderPrefix = "template_header."
+
+	// VarPrefix is the prefix of config options which capture a variable
+	// from the prelogin response, e.g. "var.csrf"
+	VarPrefix = "var."
+
+	// defaultContentType is sent with a body unless content_type is set
+	defaultContentType = "application/x-www-form-urlencoded"
+
+	// bodyLimit bounds how much of a response is read
+	bodyLimit = 1 << 20
+)
+
+// frameHeaders are managed by the HTTP client or identify the client, so they
+// may not be templated.
+var frameHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"From":              true,
+	"Host":              true,
+	"Transfer-Encoding": true,
+	"User-Agent":        true,
+	"Via":               true,
+}
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("generic-http", Driver{})
+}
+
+// New is used to create a generic HTTP nozzle and accepts the following
+// configuration options. The url, body, template_header, prelogin_url, and
+// prelogin_body options are templates, see nozzle.Template for the syntax and
+// functions.
+//
+// url
+//
+// The http or https URL the login request is sent to. Required.
+//
+// method
+//
+// The request method, POST by default.
+//
+// body
+//
+// The request body, e.g. "username={{urlencode .Username}}&password={{urlencode .Password}}".
+//
+// content_type
+//
+// The Content-Type of the body, application/x-www-form-urlencoded by default.
+//
+// template_header.<Name>
+//
+// Adds the header <Name> with the rendered value to every request, e.g.
+// template_header.Authorization: "Basic {{b64 .Username ":" .Password}}".
+//
+// prelogin_url, prelogin_method, prelogin_body
+//
+// A request sent before each login request, GET by default, such as for the
+// login form holding a CSRF token. Its redirects are followed, and the cookies
+// it sets are sent with the login request. The attempt is rate limited if it
+// returns status 429, and an error if it returns another status of 400 or
+// above.
+//
+// var.<name>
+//
+// A regular expression with a capture group matched against the prelogin
+// response, written as its headers (one "Name: value" line each) followed by
+// its body. The first group of the first match is available to the login
+// request's templates as {{.Vars.<name>}}, e.g.
+// var.csrf: 'name="csrf_token" value="([^"]+)"'. It requires prelogin_url,
+// and an attempt whose prelogin response does not match is an error.
+//
+// valid_status, valid_match
+//
+// A comma separated list of status codes, and a regular expression matched
+// against the Location header and the body, which mark a valid credential.
+//
+// invalid_match
+//
+// A regular expression marking an invalid credential. If no valid_status or
+// valid_match is set, every response it does not match is valid.
+//
+// locked_match
+//
+// A regular expression marking a locked account.
+//
+// classifier_url, classifier_token, classifier_timeout
+//
+// An http or https URL of a service which classifies the responses instead,
+// for flows the patterns cannot describe. Each response is POSTed to it as a
+// ClassifierRequest, without the password, along with the classifier_token as
+// a bearer token if set, and it answers with a ClassifierVerdict. Each call
+// takes at most classifier_timeout, 5s by default and 30s at most. If the
+// call fails, the response is classified by the patterns if any are set, and
+// the attempt is an error otherwise.
+//
+// At least one of valid_status, valid_match, invalid_match, and
+// classifier_url is required.
+// Redirects are never followed, so a redirect to the application after a
+// successful login can be matched by valid_status or valid_match. Responses
+// with status 429 are rate limited.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	rawURL := strings.TrimSpace(opts["url"])
+	if !strings.HasPrefix(rawURL, "https://") && !strings.HasPrefix(rawURL, "http://") {
+		return nil, fmt.Errorf("generic-http nozzle requires an http or https 'url' config parameter")
+	}
+
+	// the variables are declared before the templates referencing them are
+	// parsed
+	var err error
+	vars := make(map[string]*regexp.Regexp)
+	var varNames []string
+	for k, v := range opts {
+		if !strings.HasPrefix(k, VarPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(k, VarPrefix)
+		if !varName.MatchString(name) {
+			return nil, fmt.Errorf("generic-http nozzle has invalid variable name %q", name)
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("generic-http nozzle has invalid %s: %w", k, err)
+		}
+		if re.NumSubexp() == 0 {
+			return nil, fmt.Errorf("generic-http nozzle %s has no capture group", k)
+		}
+		vars[name] = re
+		varNames = append(varNames, name)
+	}
+
+	var prelogin *Prelogin
+	if v := strings.TrimSpace(opts["prelogin_url"]); v != "" {
+		if !strings.HasPrefix(v, "https://") && !strings.HasPrefix(v, "http://") {
+			return nil, fmt.Errorf("generic-http nozzle requires an http or https 'prelogin_url'")
+		}
+		prelogin = &Prelogin{
+			Method: strings.ToUpper(opts["prelogin_method"]),
+			Vars:   vars,
+		}
+		if prelogin.Method == "" {
+			prelogin.Method = http.MethodGet
+		}
+		prelogin.URL, err = nozzle.ParseTemplate("prelogin_url", v)
+		if err != nil {
+			return nil, err
+		}
+		if b := opts["prelogin_body"]; b != "" {
+			prelogin.Body, err = nozzle.ParseTemplate("prelogin_body", b)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if len(vars) > 0 {
+		return nil, fmt.Errorf("generic-http nozzle requires 'prelogin_url' to capture variables")
+	}
+
+	url, err := nozzle.ParseTemplate("url", rawURL, varNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(opts["method"])
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	var body *nozzle.Template
+	if v := opts["body"]; v != "" {
+		body, err = nozzle.ParseTemplate("body", v, varNames...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	contentType := opts["content_type"]
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	templateHeaders := make(map[string]*nozzle.Template)
+	for k, v := range opts {
+		if !strings.HasPrefix(k, TemplateHeaderPrefix) {
+			continue
+		}
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(strings.TrimPrefix(k, TemplateHeaderPrefix)))
+		if name == "" || strings.ContainsAny(name, " \t:\r\n") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		if frameHeaders[name] || strings.HasPrefix(name, "Proxy-") {
+			return nil, fmt.Errorf("header %s cannot be templated", name)
+		}
+		templateHeaders[name], err = nozzle.ParseTemplate(k, v, varNames...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var validStatus []int
+	for _, s := range strings.Split(opts["valid_status"], ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("generic-http nozzle has invalid valid_status %q", s)
+		}
+		validStatus = append(validStatus, code)
+	}
+
+	patterns := make(map[string]*regexp.Regexp)
+	for _, k := range []string{"valid_match", "invalid_match", "locked_match"} {
+		if v := opts[k]; v != "" {
+			patterns[k], err = regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("generic-http nozzle has invalid %s: %w", k, err)
+			}
+		}
+	}
+	var classifier *Classifier
+	if v := strings.TrimSpace(opts["classifier_url"]); v != "" {
+		if !strings.HasPrefix(v, "https://") && !strings.HasPrefix(v, "http://") {
+			return nil, fmt.Errorf("generic-http nozzle requires an http or https 'classifier_url'")
+		}
+		timeout := DefaultClassifierTimeout
+		if t := opts["classifier_timeout"]; t != "" {
+			timeout, err = time.ParseDuration(t)
+			if err != nil || timeout <= 0 || timeout > MaxClassifierTimeout {
+				return nil, fmt.Errorf("generic-http nozzle requires a classifier_timeout between 0 and %s",
+					MaxClassifierTimeout)
+			}
+		}
+		classifier = &Classifier{
+			URL:    v,
+			Token:  opts["classifier_token"],
+			Client: &http.Client{Timeout: timeout},
+		}
+	}
+
+	if len(validStatus) == 0 && patterns["valid_match"] == nil && patterns["invalid_match"] == nil &&
+		classifier == nil {
+		return nil, fmt.Errorf("generic-http nozzle requires one of 'valid_status', 'valid_match', " +
+			"'invalid_match', or 'classifier_url'")
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("generic-http", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		Prelogin:        prelogin,
+		URL:             url,
+		Method:          method,
+		Body:            body,
+		ContentType:     contentType,
+		TemplateHeaders: templateHeaders,
+		Matcher: Matcher{
+			ValidStatus: validStatus,
+			Valid:       patterns["valid_match"],
+			Invalid:     patterns["invalid_match"],
+			Locked:      patterns["locked_match"],
+		},
+		Classifier: classifier,
+		UserAgent:  FrozenUserAgent,
+		Headers:    headers,
+		TLSConfig:  tlsConfig,
+		Transport:  transport,
+	}, nil
+}
+
+// Describe returns the configuration options of the generic HTTP nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "url", Description: "the URL template the login request is sent to", Required: true},
+		{Name: "method", Description: "the request method, POST by default"},
+		{Name: "body", Description: "the request body template"},
+		{Name: "content_type", Description: "the Content-Type of the body, application/x-www-form-urlencoded by default"},
+		{Name: "template_header.<Name>", Description: "adds the header <Name> with the rendered template to every request"},
+		{Name: "prelogin_url", Description: "the URL template of a request sent before each login, e.g. for a CSRF token"},
+		{Name: "prelogin_method", Description: "the prelogin request method, GET by default"},
+		{Name: "prelogin_body", Description: "the prelogin request body template"},
+		{Name: "var.<name>", Description: "a regular expression capturing {{.Vars.<name>}} from the prelogin response"},
+		{Name: "valid_status", Description: "comma separated status codes marking a valid credential"},
+		{Name: "valid_match", Description: "a regular expression marking a valid credential"},
+		{Name: "invalid_match", Description: "a regular expression marking an invalid credential"},
+		{Name: "locked_match", Description: "a regular expression marking a locked account"},
+		{Name: "classifier_url", Description: "a service which classifies the responses instead of the patterns"},
+		{Name: "classifier_token", Description: "a bearer token sent to the classifier"},
+		{Name: "classifier_timeout", Description: "the longest a classifier call may take, 5s by default"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions)
+}
+
+// varName matches the names of captured variables, which are referenced as
+// {{.Vars.<name>}}.
+var varName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Prelogin is a request sent before each login request, whose response the
+// variables of the login request are captured from.
+type Prelogin struct {
+	// URL is the template of the prelogin URL
+	URL *nozzle.Template
+
+	// Method is the request method
+	Method string
+
+	// Body is the template of the request body, or nil to send no body
+	Body *nozzle.Template
+
+	// Vars capture each variable from the first group of their first match
+	Vars map[string]*regexp.Regexp
+}
+
+// Capture returns the variables captured from the response, whose body was
+// read into body. A variable with no match is an error.
+func (p *Prelogin) Capture(resp *http.Response, body []byte) (map[string]string, error) {
+	var b bytes.Buffer
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, v := range resp.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\n", name, v)
+		}
+	}
+	b.WriteString("\n")
+	b.Write(body)
+
+	vars := make(map[string]string, len(p.Vars))
+	for name, re := range p.Vars {
+		m := re.FindSubmatch(b.Bytes())
+		if m == nil {
+			return nil, fmt.Errorf("generic-http prelogin response has no match for %s%s", VarPrefix, name)
+		}
+		vars[name] = string(m[1])
+	}
+	return vars, nil
+}
+
+// Nozzle implements the nozzle.Nozzle interface for a configured HTTP login.
+type Nozzle struct {
+	// Prelogin is sent before each login request, or nil
+	Prelogin *Prelogin
+
+	// URL is the template of the login URL
+	URL *nozzle.Template
+
+	// Method is the request method
+	Method string
+
+	// Body is the template of the request body, or nil to send no body
+	Body *nozzle.Template
+
+	// ContentType is sent along with the body
+	ContentType string
+
+	// TemplateHeaders are rendered and added to each request
+	TemplateHeaders map[string]*nozzle.Template
+
+	// Matcher classifies the responses
+	Matcher Matcher
+
+	// Classifier classifies the responses in place of the Matcher, or nil
+	Classifier *Classifier
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+}
+
+// Login fulfils the nozzle.Nozzle interface. The prelogin request is sent and
+// its variables captured, if configured, then the templates are rendered for
+// the attempt, the request is sent, and the response is classified by the
+// Matcher.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	data := nozzle.TemplateData{Username: username, Password: password}
+
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if n.Prelogin != nil {
+		// the login request carries the session the prelogin request
+		// started, which a CSRF token is usually bound to
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+
+		vars, res, err := n.prelogin(ctx, &http.Client{Transport: transport, Jar: jar}, data)
+		if err != nil || res != nil {
+			return res, err
+		}
+		data.Vars = vars
+	}
+
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := n.newRequest(n.Method, n.URL, n.Body, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// render the templated headers in a stable order, as the functions
+	// they call may be time dependent
+	names := make([]string, 0, len(n.TemplateHeaders))
+	for name := range n.TemplateHeaders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v, err := n.TemplateHeaders[name].Execute(data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
+	capture := nozzle.Capture(resp)
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := n.classify(ctx, req, username, password, capture, resp, respBody)
+	if err != nil {
+		return nil, err
+	}
+	if res.RateLimited {
+		res.RetryAfter = nozzle.RetryAfter(resp.Header, time.Now())
+	}
+	if res.Valid {
+		res.Capture = capture
+	}
+	return res, nil
+}
+
+// classify classifies the response with the Classifier if there is one,
+// falling back to the Matcher if the classifier fails and any patterns are
+// configured.
+func (n *Nozzle) classify(ctx context.Context, req *http.Request, username, password string,
+	capture *event.Capture, resp *http.Response, body []byte) (*event.AuthResponse, error) {
+	if n.Classifier == nil {
+		return n.Matcher.Classify(resp.StatusCode, resp.Header.Get("Location"), body)
+	}
+
+	res, err := n.Classifier.Classify(ctx, req, username, password, capture)
+	if err == nil {
+		return res, nil
+	}
+	if !n.Matcher.Configured() {
+		return nil, err
+	}
+	res, merr := n.Matcher.Classify(resp.StatusCode, resp.Header.Get("Location"), body)
+	if merr != nil {
+		return nil, fmt.Errorf("%s, and %w", err, merr)
+	}
+	if res.Metadata == nil {
+		res.Metadata = make(map[string]interface{})
+	}
+	res.Metadata["classifier_error"] = err.Error()
+	return res, nil
+}
+
+// newRequest renders the URL and body templates into a request carrying the
+// user agent and the configured headers.
+func (n *Nozzle) newRequest(method string, url, body *nozzle.Template,
+	data nozzle.TemplateData) (*http.Request, error) {
+	u, err := url.Execute(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := body.Execute(data)
+		if err != nil {
+			return nil, err
+		}
+		r = strings.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", n.UserAgent)
+	if r != nil {
+		req.Header.Set("Content-Type", n.ContentType)
+	}
+	n.Headers.Apply(req, data.Username, data.Password)
+	return req, nil
+}
+
+// prelogin sends the prelogin request and returns the variables captured from
+// its response. A response which rate limits or challenges the attempt is
+// returned instead.
+func (n *Nozzle) prelogin(ctx context.Context, client *http.Client,
+	data nozzle.TemplateData) (map[string]string, *event.AuthResponse, error) {
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := n.newRequest(n.Prelogin.Method, n.Prelogin.URL, n.Prelogin.Body, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return nil, res, nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+		}, nil
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, fmt.Errorf("generic-http prelogin request returned %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, nil, err
+	}
+	vars, err := n.Prelogin.Capture(resp, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vars, nil, nil
+}
+
+// Matcher classifies the response to a login request by its status code,
+// Location header, and body.
+type Matcher struct {
+	// ValidStatus are the status codes of a valid credential
+	ValidStatus []int
+
+	// Valid matches the response to a valid credential
+	Valid *regexp.Regexp
+
+	// Invalid matches the response to an invalid credential
+	Invalid *regexp.Regexp
+
+	// Locked matches the response for a locked account
+	Locked *regexp.Regexp
+}
+
+// Configured reports whether the valid status codes or either the valid or
+// invalid pattern is set, which a response can be classified by alone.
+func (m *Matcher) Configured() bool {
+	return len(m.ValidStatus) > 0 || m.Valid != nil || m.Invalid != nil
+}
+
+// Classify maps a response onto an AuthResponse. Locked accounts are checked
+// first, then invalid and valid credentials. A response matching none of the
+// configured valid criteria is an error if an invalid pattern is also
+// configured, since it is neither of the outcomes the config describes.
+func (m *Matcher) Classify(status int, location string, body []byte) (*event.AuthResponse, error) {
+	if status == http.StatusTooManyRequests {
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	metadata := map[string]interface{}{
+		"status": status,
+	}
+	match := func(re *regexp.Regexp) bool {
+		return re != nil && (re.MatchString(location) || re.Match(body))
+	}
+
+	if match(m.Locked) {
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	}
+	if match(m.Invalid) {
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+
+	valid := match(m.Valid)
+	for _, code := range m.ValidStatus {
+		valid = valid || code == status
+	}
+
+	switch {
+	case valid, len(m.ValidStatus) == 0 && m.Valid == nil:
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case m.Invalid == nil:
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized response from generic-http provider: %d", status)
+}
@@ -0,0 +1,252 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package generic
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("generic-http", map[string]string{
+		"url":                           "https://portal.example.org/login?ts={{unix}}",
+		"body":                          "user={{urlencode .Username}}&pass={{urlencode .Password}}",
+		"template_header.Authorization": `Basic {{b64 .Username ":" .Password}}`,
+		"valid_status":                  "302, 303",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	_, err = nozzle.Open("generic-http", map[string]string{
+		"url":            "https://portal.example.org/login",
+		"classifier_url": "https://classifier.example.org/classify",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle with only a classifier: %s", err)
+	}
+
+	for _, opts := range []map[string]string{
+		{"valid_status": "200"},
+		{"url": "ftp://portal.example.org/", "valid_status": "200"},
+		{"url": "https://portal.example.org/"},
+		{"url": "https://portal.example.org/", "valid_status": "2xx"},
+		{"url": "https://portal.example.org/", "invalid_match": "("},
+		{"url": "https://portal.example.org/{{.Email}}", "valid_status": "200"},
+		{"url": "https://portal.example.org/", "valid_status": "200", "template_header.Host": "{{.Username}}"},
+		{"url": "https://portal.example.org/", "valid_status": "200", "body": "csrf={{.Vars.csrf}}"},
+		{"url": "https://portal.example.org/", "valid_status": "200", "var.csrf": `value="([^"]+)"`},
+		{"url": "https://portal.example.org/", "valid_status": "200", "prelogin_url": "https://portal.example.org/",
+			"var.csrf": `value="[^"]+"`},
+		{"url": "https://portal.example.org/", "valid_status": "200", "prelogin_url": "https://portal.example.org/",
+			"var.csrf": `value="([^"]+)"`, "body": "csrf={{.Vars.token}}"},
+		{"url": "https://portal.example.org/", "valid_status": "200", "prelogin_url": "https://portal.example.org/",
+			"var.csrf-token": `value="([^"]+)"`},
+		{"url": "https://portal.example.org/", "classifier_url": "ftp://classifier.example.org/"},
+		{"url": "https://portal.example.org/", "classifier_url": "https://classifier.example.org/",
+			"classifier_timeout": "1m"},
+	} {
+		_, err = nozzle.Open("generic-http", opts)
+		if err == nil {
+			t.Errorf("expected error opening nozzle with %v", opts)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	both := Matcher{
+		ValidStatus: []int{302},
+		Invalid:     regexp.MustCompile(`Invalid username or password`),
+		Locked:      regexp.MustCompile(`account is locked`),
+	}
+	invalidOnly := Matcher{
+		Invalid: regexp.MustCompile(`Invalid username or password`),
+	}
+	validOnly := Matcher{
+		Valid: regexp.MustCompile(`^/dashboard`),
+	}
+
+	var testcases = []struct {
+		name        string
+		matcher     Matcher
+		status      int
+		location    string
+		body        string
+		valid       bool
+		locked      bool
+		ratelimited bool
+		wantErr     bool
+	}{
+		{"valid status", both, 302, "/home", "", true, false, false, false},
+		{"invalid", both, 200, "", "<p>Invalid username or password</p>", false, false, false, false},
+		{"locked", both, 200, "", "<p>Your account is locked</p>", false, true, false, false},
+		{"unrecognized", both, 500, "", "error", false, false, false, true},
+		{"too many requests", both, 429, "", "", false, false, true, false},
+		{"no failure message", invalidOnly, 200, "", "<p>Welcome</p>", true, false, false, false},
+		{"failure message", invalidOnly, 200, "", "Invalid username or password", false, false, false, false},
+		{"valid location", validOnly, 302, "/dashboard", "", true, false, false, false},
+		{"other location", validOnly, 302, "/login?error=1", "", false, false, false, false},
+	}
+	for _, test := range testcases {
+		res, err := test.matcher.Classify(test.status, test.location, []byte(test.body))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.name, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked != test.locked || res.RateLimited != test.ratelimited {
+			t.Errorf("%s: got %+v", test.name, res)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Request-ID") == "" || r.URL.Query().Get("client") != "trident" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice@example.org:S3cret&"))
+		if r.Header.Get("Authorization") == auth && r.PostFormValue("remember") == "alice@example.org" {
+			http.Redirect(w, r, "/dashboard", http.StatusFound)
+			return
+		}
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	n, err := Driver{}.New(map[string]string{
+		"url":                           ts.URL + "/login?client=trident",
+		"body":                          "remember={{urlencode .Username}}",
+		"template_header.Authorization": `Basic {{b64 .Username ":" .Password}}`,
+		"template_header.X-Request-ID":  "{{uuid}}",
+		"valid_match":                   "^/dashboard",
+		"invalid_match":                 "Invalid username or password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.(*Nozzle).TLSConfig = &tls.Config{InsecureSkipVerify: true} // nolint:gosec
+
+	res, err := n.Login("alice@example.org", "S3cret&")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid {
+		t.Errorf("expected valid credential, got %+v", res)
+	}
+
+	res, err = n.Login("alice@example.org", "Password1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Valid {
+		t.Errorf("expected invalid credential, got %+v", res)
+	}
+}
+
+// TestLoginCSRF models a login form whose CSRF token is bound to the session
+// cookie set when the form is fetched.
+func TestLoginCSRF(t *testing.T) {
+	var sessions int
+	tokens := make(map[string]string)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/" && r.Method == http.MethodGet:
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case r.URL.Path == "/login" && r.Method == http.MethodGet:
+			sessions++
+			session := fmt.Sprintf("s%d", sessions)
+			tokens[session] = fmt.Sprintf("tok+%d&", sessions)
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: session})
+			w.Header().Set("X-Request-Token", "header-"+session)
+			fmt.Fprintf(w, `<form><input type="hidden" name="csrf_token" value="tok+%d&amp;"></form>`, sessions)
+		case r.URL.Path == "/login" && r.Method == http.MethodPost:
+			cookie, err := r.Cookie("session")
+			if err != nil || r.PostFormValue("csrf_token") != tokens[cookie.Value] ||
+				r.URL.Query().Get("rt") != "header-"+cookie.Value {
+				http.Error(w, "CSRF token mismatch", http.StatusForbidden)
+				return
+			}
+			if r.PostFormValue("username") == "alice@example.org" && r.PostFormValue("password") == "S3cret&" {
+				http.Redirect(w, r, "/dashboard", http.StatusFound)
+				return
+			}
+			fmt.Fprint(w, "Invalid username or password")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	opts := map[string]string{
+		"prelogin_url": ts.URL + "/",
+		"var.csrf":     `name="csrf_token" value="([^"]+)"`,
+		"var.rt":       `X-Request-Token: (\S+)`,
+		"url":          ts.URL + "/login?rt={{urlencode .Vars.rt}}",
+		"body": "csrf_token={{urlencode (htmlunescape .Vars.csrf)}}" +
+			"&username={{urlencode .Username}}&password={{urlencode .Password}}",
+		"valid_match":   "^/dashboard",
+		"invalid_match": "Invalid username or password",
+	}
+	n, err := Driver{}.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.(*Nozzle).TLSConfig = &tls.Config{InsecureSkipVerify: true} // nolint:gosec
+
+	res, err := n.Login("alice@example.org", "S3cret&")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid {
+		t.Errorf("expected valid credential, got %+v", res)
+	}
+
+	// every attempt starts a new session with its own token
+	res, err = n.Login("alice@example.org", "Password1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Valid {
+		t.Errorf("expected invalid credential, got %+v", res)
+	}
+	if sessions != 2 {
+		t.Errorf("expected 2 sessions, got %d", sessions)
+	}
+
+	// a form without the token is an error rather than a guess without it
+	opts["var.csrf"] = `name="authenticity_token" value="([^"]+)"`
+	n, err = Driver{}.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.(*Nozzle).TLSConfig = &tls.Config{InsecureSkipVerify: true} // nolint:gosec
+	if _, err = n.Login("alice@example.org", "S3cret&"); err == nil {
+		t.Error("expected an error without a match for the token")
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gitlab implements a nozzle for the web sign-in form of self-hosted
+// GitLab instances.
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// signInPath is the path of the sign-in form
+	signInPath = "/users/sign_in"
+
+	// bodyLimit bounds how much of a page is read
+	bodyLimit = 1 << 20
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// tagRegex matches the form, input, and meta tags of a page
+	tagRegex = regexp.MustCompile(`(?is)<(form|input|meta)\b([^>]*)>`)
+
+	// attrRegex matches a quoted attribute of a tag
+	attrRegex = regexp.MustCompile(`(?s)([a-zA-Z_:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+
+	// tokenRegex matches the characters of a Rails authenticity token, which
+	// is base64 encoded
+	tokenRegex = regexp.MustCompile(`^[A-Za-z0-9+/=_-]{1,256}$`)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("gitlab", Driver{})
+}
+
+// New is used to create a GitLab nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The host name (and optional port) of the GitLab instance. If users sign in
+// at https://gitlab.example.org/users/sign_in, the value of host is
+// "gitlab.example.org".
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout, discovery_timeout, and submit_timeout options described by
+// nozzle.ParseTimeouts are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	host, ok := opts["host"]
+	if !ok {
+		return nil, fmt.Errorf("gitlab nozzle requires 'host' config parameter")
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || host == "" || u.Host != host || u.User != nil {
+		return nil, fmt.Errorf("gitlab nozzle 'host' must be a host name without a scheme or path: %s", host)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("gitlab", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepDiscovery, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		Host:      host,
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+		Timeouts:  timeouts,
+	}, nil
+}
+
+// Describe returns the configuration options of the GitLab nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the GitLab instance, e.g. gitlab.example.org", Required: true},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepDiscovery, nozzle.StepSubmit))
+}
+
+// Nozzle implements the nozzle.Nozzle interface for GitLab.
+type Nozzle struct {
+	// Host is the host name of the GitLab instance
+	Host string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
+}
+
+// Login fulfils the nozzle.Nozzle interface and signs in to GitLab with the
+// supplied credentials. The sign-in form is fetched first for its session
+// cookie and authenticity (CSRF) token, and the credentials are then posted
+// with both. Valid, invalid, two-factor, expired, and locked out responses
+// are recognized; any other response is an error.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// each attempt receives a fresh cookie jar so a session is never shared
+	// between credential guesses
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+	client := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	signIn := "https://" + n.Host + signInPath
+	resp, body, res, err := n.do(client, nozzle.StepDiscovery, "GET", signIn, nil, username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status fetching gitlab sign-in form: %d", resp.StatusCode)
+	}
+	token, err := authenticityToken(body)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"authenticity_token": {token},
+		"user[login]":        {username},
+		"user[password]":     {password},
+		"user[remember_me]":  {"0"},
+	}
+	resp, body, res, err = n.do(client, nozzle.StepSubmit, "POST", signIn, strings.NewReader(form.Encode()),
+		username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	metadata := map[string]interface{}{
+		"status": resp.StatusCode,
+	}
+	switch resp.StatusCode {
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata:    metadata,
+		}, nil
+	case 422:
+		return nil, fmt.Errorf("gitlab rejected the authenticity token")
+	case 200:
+		// a correct password of an account with two-factor authentication
+		// renders the second factor form in place of the sign-in form
+		if twoFactor(body) {
+			return &event.AuthResponse{
+				Valid:    true,
+				MFA:      true,
+				Metadata: metadata,
+				Capture:  nozzle.Capture(resp),
+			}, nil
+		}
+		return classifyPage(body, metadata)
+	case 301, 302, 303:
+	default:
+		return nil, fmt.Errorf("unexpected status from gitlab sign-in: %d", resp.StatusCode)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	metadata["location"] = location.Path
+	switch {
+	case strings.HasSuffix(location.Path, signInPath):
+		if location.Host != n.Host {
+			return nil, fmt.Errorf("gitlab redirected to the sign-in form of another host: %s", location.Host)
+		}
+		// failed sign-ins redirect back to the form, which shows the reason
+		// in its flash message
+		_, body, res, err = n.do(client, nozzle.StepSubmit, "GET", location.String(), nil, username, password)
+		if err != nil || res != nil {
+			return res, err
+		}
+		return classifyPage(body, metadata)
+	case strings.HasSuffix(location.Path, "/profile/password/new"),
+		strings.HasSuffix(location.Path, "/profile/password/edit"):
+		metadata["reason"] = "password_expired"
+		return &event.AuthResponse{
+			Valid:    true,
+			Expired:  true,
+			Metadata: metadata,
+			Capture:  nozzle.Capture(resp),
+		}, nil
+	case strings.HasSuffix(location.Path, "/profile/two_factor_auth"):
+		// two-factor authentication is enforced but not yet set up
+		metadata["reason"] = "two_factor_setup"
+	}
+
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+		Capture:  nozzle.Capture(resp),
+	}, nil
+}
+
+// do sends a request of the named step with the configured headers and
+// timeout, and returns the response with its body, which has been read and
+// closed. If the response is a WAF or captcha challenge, the AuthResponse
+// reporting it is returned instead of the body.
+func (n *Nozzle) do(client *http.Client, step, method, url string, data io.Reader,
+	username, password string) (*http.Response, string, *event.AuthResponse, error) {
+	req, err := http.NewRequest(method, url, data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
+	req, cancel := n.Timeouts.Request(req, step)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return resp, "", res, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// the body is kept so a valid response can be captured
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return resp, string(b), nil, nil
+}
+
+// attributes returns the quoted attributes of a tag, with lowercase names and
+// HTML entities in the values decoded.
+func attributes(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range attrRegex.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3])
+	}
+	return attrs
+}
+
+// authenticityToken extracts the CSRF token from the sign-in page. The
+// authenticity_token field of the sign-in form is preferred over the
+// csrf-token meta tag, since the page may contain other forms. The token is
+// only returned if it looks like a Rails token, so markup that was matched by
+// mistake is never posted back.
+func authenticityToken(page string) (string, error) {
+	var field, meta string
+	inSignIn := false
+	for _, m := range tagRegex.FindAllStringSubmatch(page, -1) {
+		attrs := attributes(m[2])
+		switch strings.ToLower(m[1]) {
+		case "form":
+			action := attrs["action"]
+			if i := strings.IndexAny(action, "?#"); i >= 0 {
+				action = action[:i]
+			}
+			inSignIn = strings.HasSuffix(action, signInPath)
+		case "input":
+			if attrs["name"] == "authenticity_token" && (inSignIn || field == "") {
+				field = attrs["value"]
+				if inSignIn {
+					return validToken(field)
+				}
+			}
+		case "meta":
+			if attrs["name"] == "csrf-token" {
+				meta = attrs["content"]
+			}
+		}
+	}
+
+	switch {
+	case field != "":
+		return validToken(field)
+	case meta != "":
+		return validToken(meta)
+	}
+	return "", fmt.Errorf("gitlab sign-in form does not contain an authenticity token")
+}
+
+// validToken returns the token if it has the format of a Rails authenticity
+// token.
+func validToken(token string) (string, error) {
+	if !tokenRegex.MatchString(token) {
+		return "", fmt.Errorf("gitlab sign-in form contains a malformed authenticity token")
+	}
+	return token, nil
+}
+
+// twoFactor returns true if the page is the second factor form GitLab shows
+// after a correct password.
+func twoFactor(page string) bool {
+	return strings.Contains(page, "user[otp_attempt]") ||
+		strings.Contains(page, "js-login-2fa-device") ||
+		strings.Contains(page, "js-2fa-form")
+}
+
+// classifyPage classifies a sign-in form re-rendered after a failed sign-in by
+// its flash message.
+func classifyPage(page string, metadata map[string]interface{}) (*event.AuthResponse, error) {
+	lower := strings.ToLower(page)
+	switch {
+	case strings.Contains(lower, "your account is locked"):
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(lower, "invalid login or password"):
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(lower, "there was an error with the recaptcha"):
+		return &event.AuthResponse{
+			WAF:      "recaptcha",
+			Metadata: metadata,
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized response from gitlab sign-in")
+}
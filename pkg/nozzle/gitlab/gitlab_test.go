@@ -0,0 +1,226 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gitlab
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("gitlab", map[string]string{
+		"host": "gitlab.example.org:8443",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	for _, host := range []string{"", "https://gitlab.example.org", "gitlab.example.org/users", "evil.org#.example.org"} {
+		_, err = nozzle.Open("gitlab", map[string]string{
+			"host": host,
+		})
+		if err == nil {
+			t.Errorf("expected error opening nozzle with host %q", host)
+		}
+	}
+}
+
+func TestAuthenticityToken(t *testing.T) {
+	var testcases = []struct {
+		page    string
+		token   string
+		wantErr bool
+	}{
+		{`<meta name="csrf-token" content="meta+token==">`, "meta+token==", false},
+		{`<meta name="csrf-token" content="meta"><form action="/users/sign_in?redirect=1" method="post">` +
+			`<input type="hidden" name="authenticity_token" value="form&#43;token==" /></form>`, "form+token==", false},
+		{`<form action="/search"><input name="authenticity_token" value="search"></form>` +
+			`<form class="new_user" action="/users/sign_in"><input value='signin' name='authenticity_token'></form>`, "signin", false},
+		{`<input name="authenticity_token" value="&quot;&gt;&lt;script&gt;">`, "", true},
+		{`<html>no token</html>`, "", true},
+	}
+	for _, test := range testcases {
+		token, err := authenticityToken(test.page)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got token %q", test.page, token)
+			}
+			continue
+		}
+		if err != nil || token != test.token {
+			t.Errorf("%s: got %q, %v, expected %q", test.page, token, err, test.token)
+		}
+	}
+}
+
+// signInPage renders a sign-in form with the flash message.
+func signInPage(flash string) string {
+	return fmt.Sprintf(`<html><head><meta name="csrf-token" content="stale"></head><body>
+<div class="flash-alert">%s</div>
+<form class="new_user" action="/users/sign_in" accept-charset="UTF-8" method="post">
+<input type="hidden" name="authenticity_token" value="abc&#43;def==" autocomplete="off" />
+<input name="user[login]"><input name="user[password]">
+</form></body></html>`, flash)
+}
+
+// testServer simulates the GitLab sign-in flow. The password selects the
+// outcome of the sign-in.
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != signInPath {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			flash := ""
+			if c, err := r.Cookie("flash"); err == nil {
+				flash = c.Value
+			}
+			http.SetCookie(w, &http.Cookie{Name: "_gitlab_session", Value: "session"})
+			fmt.Fprint(w, signInPage(flash))
+			return
+		}
+
+		if c, err := r.Cookie("_gitlab_session"); err != nil || c.Value != "session" ||
+			r.PostFormValue("authenticity_token") != "abc+def==" {
+			w.WriteHeader(422)
+			return
+		}
+		if r.PostFormValue("user[login]") != "alice" {
+			t.Errorf("unexpected login: %s", r.PostFormValue("user[login]"))
+		}
+
+		switch r.PostFormValue("user[password]") {
+		case "valid":
+			http.SetCookie(w, &http.Cookie{Name: "_gitlab_session", Value: "signed-in"})
+			http.Redirect(w, r, "/", 302)
+		case "mfa":
+			fmt.Fprint(w, `<form action="/users/sign_in"><input name="user[otp_attempt]"></form>`)
+		case "expired":
+			http.Redirect(w, r, "/-/profile/password/new", 302)
+		case "locked":
+			http.SetCookie(w, &http.Cookie{Name: "flash", Value: "Your account is locked."})
+			http.Redirect(w, r, signInPath, 302)
+		case "captcha":
+			fmt.Fprint(w, signInPage("There was an error with the reCAPTCHA. Please solve the reCAPTCHA again."))
+		case "throttled":
+			w.WriteHeader(429)
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "flash", Value: "Invalid login or password."})
+			http.Redirect(w, r, signInPath, 302)
+		}
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	noz, err := nozzle.Open("gitlab", map[string]string{
+		"host":                 strings.TrimPrefix(srv.URL, "https://"),
+		"insecure_skip_verify": "true",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	var testcases = []struct {
+		password    string
+		valid       bool
+		mfa         bool
+		expired     bool
+		locked      bool
+		ratelimited bool
+		waf         string
+	}{
+		{password: "valid", valid: true},
+		{password: "mfa", valid: true, mfa: true},
+		{password: "expired", valid: true, expired: true},
+		{password: "locked", locked: true},
+		{password: "captcha", waf: "recaptcha"},
+		{password: "throttled", ratelimited: true},
+		{password: "wrong"},
+	}
+	for _, test := range testcases {
+		res, err := noz.Login("alice", test.password)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.password, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Expired != test.expired ||
+			res.Locked != test.locked || res.RateLimited != test.ratelimited || res.WAF != test.waf {
+			t.Errorf("%s: got %+v", test.password, res)
+		}
+		if test.valid && res.Capture == nil {
+			t.Errorf("%s: expected the response to be captured", test.password)
+		}
+		if test.password == "valid" && res.Capture != nil && len(res.Capture.Headers["Set-Cookie"]) == 0 {
+			t.Errorf("%s: expected the session cookie to be captured: %+v", test.password, res.Capture)
+		}
+	}
+}
+
+// TestGolden replays the saved responses in testdata through the nozzle: the
+// sign-in form, the response to the credentials, and the form the sign-in
+// redirects back to when it fails.
+func TestGolden(t *testing.T) {
+	noz, err := nozzle.Open("gitlab", map[string]string{
+		"host": "gitlab.example.org",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	var testcases = []struct {
+		files   []string
+		valid   bool
+		mfa     bool
+		expired bool
+		locked  bool
+	}{
+		{files: []string{"sign_in.http", "signed_in.http"}, valid: true},
+		{files: []string{"sign_in.http", "two_factor.http"}, valid: true, mfa: true},
+		{files: []string{"sign_in.http", "password_expired.http"}, valid: true, expired: true},
+		{files: []string{"sign_in.http", "sign_in_failed.http", "sign_in_invalid.http"}},
+		{files: []string{"sign_in.http", "sign_in_failed.http", "sign_in_locked.http"}, locked: true},
+	}
+	for _, test := range testcases {
+		paths := make([]string, len(test.files))
+		for i, file := range test.files {
+			paths[i] = filepath.Join("testdata", file)
+		}
+		r, err := nozzle.ReadReplayer(paths...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice", "Password1")
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", test.files, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Expired != test.expired || res.Locked != test.locked {
+			t.Errorf("%v: got %+v", test.files, res)
+		}
+		if len(r.Requests) != len(test.files) {
+			t.Errorf("%v: unexpected requests: %v", test.files, r.Requests)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	// HeaderPrefix is the prefix of config options which add a static header
+	// to every request of an HTTP nozzle, e.g. "header.X-Client-Version"
+	HeaderPrefix = "header."
+
+	// DefaultForwardedHeader is the header carrying the address rotated from
+	// the xff_pool option, unless xff_header is set
+	DefaultForwardedHeader = "X-Forwarded-For"
+)
+
+// HeaderOptions describes the options read by ParseHeaders.
+var HeaderOptions = []Option{
+	{Name: HeaderPrefix + "<Name>", Description: "adds the header <Name> with the option's value to every request"},
+	{Name: "xff_pool", Description: "comma separated public IP addresses, one of which is sent in X-Forwarded-For with each attempt"},
+	{Name: "xff_header", Description: "the header carrying the xff_pool address, X-Forwarded-For by default"},
+}
+
+// reservedHeaders are set by the nozzles or the HTTP client and may not be
+// overridden from the config.
+var reservedHeaders = map[string]bool{
+	"Authorization":     true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Type":      true,
+	"Cookie":            true,
+	"Host":              true,
+	"Transfer-Encoding": true,
+	"User-Agent":        true,
+}
+
+// identifyingHeaders describe the client itself and would tell the target who
+// is making the requests, so they may not be set from the config either.
+var identifyingHeaders = map[string]bool{
+	"From":    true,
+	"Referer": true,
+	"Via":     true,
+}
+
+// nonPublicNetworks are address ranges which must not appear in the xff_pool.
+// They are useless for spreading the apparent source address and could reveal
+// the operator's own network.
+var nonPublicNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/3",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// Headers holds the extra headers an HTTP nozzle adds to each request. A nil
+// *Headers adds nothing.
+type Headers struct {
+	// Static are added to every request
+	Static http.Header
+
+	// Pool holds the addresses rotated into the ForwardedHeader
+	Pool []string
+
+	// ForwardedHeader is the header carrying an address from the Pool
+	ForwardedHeader string
+}
+
+// ParseHeaders reads the header options shared by the HTTP nozzles:
+//
+// header.<Name>
+//
+// Adds the header <Name> with the option's value to every request.
+//
+// xff_pool
+//
+// A comma separated list of public IP addresses. Each attempt sends one of
+// them in the X-Forwarded-For header, which some providers use in place of the
+// client's address for rate limiting. The address is chosen from the
+// credential, so an attempt always carries the same address.
+//
+// xff_header
+//
+// The header carrying the xff_pool address, e.g. X-Real-IP. Defaults to
+// X-Forwarded-For.
+//
+// Headers which the nozzle sets itself or which identify the client (e.g.
+// Via) are rejected, as are private and loopback addresses in the pool.
+// ParseHeaders returns nil if no header options are set.
+func ParseHeaders(opts map[string]string) (*Headers, error) {
+	h := &Headers{
+		Static:          make(http.Header),
+		ForwardedHeader: DefaultForwardedHeader,
+	}
+
+	for k, v := range opts {
+		if !strings.HasPrefix(k, HeaderPrefix) {
+			continue
+		}
+		name, err := headerName(strings.TrimPrefix(k, HeaderPrefix))
+		if err != nil {
+			return nil, err
+		}
+		h.Static.Set(name, v)
+	}
+
+	if v, ok := opts["xff_header"]; ok {
+		name, err := headerName(v)
+		if err != nil {
+			return nil, err
+		}
+		h.ForwardedHeader = name
+	}
+
+	for _, addr := range strings.Split(opts["xff_pool"], ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("xff_pool entry %q is not an IP address", addr)
+		}
+		for _, n := range nonPublicNetworks {
+			if n.Contains(ip) {
+				return nil, fmt.Errorf("xff_pool entry %s is not a public address", addr)
+			}
+		}
+		h.Pool = append(h.Pool, ip.String())
+	}
+
+	if len(h.Pool) > 0 && h.Static.Get(h.ForwardedHeader) != "" {
+		return nil, fmt.Errorf("header %s is set both statically and from the xff_pool", h.ForwardedHeader)
+	}
+	if len(h.Static) == 0 && len(h.Pool) == 0 {
+		return nil, nil
+	}
+	return h, nil
+}
+
+// headerName canonicalizes a configured header name and rejects headers which
+// may not be overridden.
+func headerName(name string) (string, error) {
+	name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+	if name == "" || strings.ContainsAny(name, " \t:\r\n") {
+		return "", fmt.Errorf("invalid header name %q", name)
+	}
+	if reservedHeaders[name] || strings.HasPrefix(name, "Proxy-") {
+		return "", fmt.Errorf("header %s is set by the nozzle and cannot be configured", name)
+	}
+	if identifyingHeaders[name] {
+		return "", fmt.Errorf("header %s would identify the client and cannot be configured", name)
+	}
+	return name, nil
+}
+
+// Apply adds the headers to the request of an attempt. The pool address is
+// chosen by hashing the credential, so the rotation is reproducible across
+// workers and reruns of the same campaign.
+func (h *Headers) Apply(req *http.Request, username, password string) {
+	if h == nil {
+		return
+	}
+	for k, v := range h.Static {
+		req.Header[k] = v
+	}
+	if len(h.Pool) > 0 {
+		req.Header.Set(h.ForwardedHeader, h.Pool[poolIndex(username, password, len(h.Pool))])
+	}
+}
+
+// poolIndex deterministically maps a credential to an index into a pool.
+func poolIndex(username, password string, n int) int {
+	f := fnv.New32a()
+	f.Write([]byte(username)) // nolint:errcheck,gosec
+	f.Write([]byte{0})        // nolint:errcheck,gosec
+	f.Write([]byte(password)) // nolint:errcheck,gosec
+	return int(f.Sum32() % uint32(n))
+}
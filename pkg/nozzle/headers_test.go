@@ -0,0 +1,94 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders(map[string]string{"subdomain": "example"})
+	if err != nil || h != nil {
+		t.Fatalf("expected no headers, got %+v, %v", h, err)
+	}
+
+	h, err = ParseHeaders(map[string]string{
+		"header.x-client-version": "1.2",
+		"xff_pool":                "203.0.113.7, 198.51.100.1,2001:db8::1",
+		"xff_header":              "x-real-ip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Static.Get("X-Client-Version") != "1.2" || h.ForwardedHeader != "X-Real-Ip" || len(h.Pool) != 3 {
+		t.Errorf("unexpected headers: %+v", h)
+	}
+
+	for _, opts := range []map[string]string{
+		{"header.Authorization": "Bearer x"},
+		{"header.User-Agent": "trident"},
+		{"header.Via": "1.1 operator-laptop"},
+		{"header.Proxy-Authorization": "x"},
+		{"xff_pool": "10.0.0.1"},
+		{"xff_pool": "127.0.0.1"},
+		{"xff_pool": "fe80::1"},
+		{"xff_pool": "not-an-ip"},
+		{"xff_pool": "203.0.113.7", "header.X-Forwarded-For": "203.0.113.8"},
+	} {
+		_, err = ParseHeaders(opts)
+		if err == nil {
+			t.Errorf("expected error parsing %v", opts)
+		}
+	}
+}
+
+func TestHeadersApply(t *testing.T) {
+	h, err := ParseHeaders(map[string]string{
+		"header.X-Client-Version": "1.2",
+		"xff_pool":                "203.0.113.1,203.0.113.2,203.0.113.3,203.0.113.4",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, user := range []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi"} {
+		req, _ := http.NewRequest("GET", "https://example.org", nil)
+		h.Apply(req, user, "Password1")
+		xff := req.Header.Get(DefaultForwardedHeader)
+		seen[xff] = true
+
+		// the same attempt always carries the same address
+		again, _ := http.NewRequest("GET", "https://example.org", nil)
+		h.Apply(again, user, "Password1")
+		if again.Header.Get(DefaultForwardedHeader) != xff {
+			t.Errorf("rotation for %s is not deterministic", user)
+		}
+		if req.Header.Get("X-Client-Version") != "1.2" {
+			t.Errorf("static header missing for %s", user)
+		}
+	}
+	if len(seen) < 2 {
+		t.Errorf("attempts were not spread across the pool: %v", seen)
+	}
+
+	var none *Headers
+	req, _ := http.NewRequest("GET", "https://example.org", nil)
+	none.Apply(req, "alice", "Password1")
+	if len(req.Header) != 0 {
+		t.Errorf("nil headers added %v", req.Header)
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jenkins implements a nozzle for self-hosted Jenkins instances,
+// through either the login form or HTTP basic authentication of the API.
+package jenkins
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// StrategyForm posts the credentials to the login form
+	StrategyForm = "form"
+
+	// StrategyBasic sends the credentials to the API with basic
+	// authentication
+	StrategyBasic = "basic"
+
+	// loginPath is the path of the login form
+	loginPath = "/login"
+
+	// loginErrorPath is the page a failed login redirects to
+	loginErrorPath = "/loginError"
+
+	// whoAmIPath is the API endpoint describing the authenticated user
+	whoAmIPath = "/whoAmI/api/json"
+
+	// bodyLimit bounds how much of a page is read
+	bodyLimit = 1 << 20
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// actionRegex matches the security check the login form posts to, which
+	// is j_acegi_security_check before Jenkins 2.x
+	actionRegex = regexp.MustCompile(`(?i)action\s*=\s*["']?(?:[^"'\s>]*/)?(j_(?:spring|acegi)_security_check)\b`)
+
+	// crumbAttrRegex matches the crumb the page head carries for scripts, as
+	// the data-crumb-header and data-crumb-value attributes
+	crumbAttrRegex = regexp.MustCompile(`(?is)data-crumb-header\s*=\s*"([^"]*)"[^>]*?data-crumb-value\s*=\s*"([^"]*)"`)
+
+	// crumbInitRegex matches the crumb set by crumb.init() in older pages
+	crumbInitRegex = regexp.MustCompile(`crumb\.init\(\s*["']([^"']*)["']\s*,\s*["']([^"']*)["']\s*\)`)
+
+	// crumbRegex matches the characters of a crumb header name and value
+	crumbRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("jenkins", Driver{})
+}
+
+// New is used to create a Jenkins nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The host name (and optional port) of the Jenkins instance, e.g.
+// "jenkins.example.org:8080".
+//
+// path
+//
+// The path Jenkins is served under, e.g. "/jenkins". Empty by default.
+//
+// scheme
+//
+// https (default) or http, since internal instances are often served
+// without TLS.
+//
+// strategy
+//
+// form (default) posts the credentials to the login form, like a browser.
+// basic sends them to the /whoAmI API with basic authentication instead,
+// which some instances disable for passwords.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout, discovery_timeout, and submit_timeout options described by
+// nozzle.ParseTimeouts are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	host, ok := opts["host"]
+	if !ok {
+		return nil, fmt.Errorf("jenkins nozzle requires 'host' config parameter")
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || host == "" || u.Host != host || u.User != nil {
+		return nil, fmt.Errorf("jenkins nozzle 'host' must be a host name without a scheme or path: %s", host)
+	}
+
+	path := strings.TrimSuffix(opts["path"], "/")
+	if path != "" && (!strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#")) {
+		return nil, fmt.Errorf("jenkins nozzle 'path' must be an absolute path: %s", path)
+	}
+
+	scheme, ok := opts["scheme"]
+	if !ok {
+		scheme = "https"
+	}
+	if scheme != "https" && scheme != "http" {
+		return nil, fmt.Errorf("jenkins nozzle 'scheme' must be https or http: %s", scheme)
+	}
+
+	strategy, ok := opts["strategy"]
+	if !ok {
+		strategy = StrategyForm
+	}
+	if strategy != StrategyForm && strategy != StrategyBasic {
+		return nil, fmt.Errorf("jenkins nozzle 'strategy' must be %s or %s: %s", StrategyForm, StrategyBasic, strategy)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("jenkins", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepDiscovery, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		BaseURL:   scheme + "://" + host + path,
+		Strategy:  strategy,
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+		Timeouts:  timeouts,
+	}, nil
+}
+
+// Describe returns the configuration options of the Jenkins nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the Jenkins instance, e.g. jenkins.example.org:8080", Required: true},
+		{Name: "path", Description: "the path Jenkins is served under, e.g. /jenkins"},
+		{Name: "scheme", Description: "https (default) or http"},
+		{Name: "strategy", Description: "form (default) or basic"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepDiscovery, nozzle.StepSubmit))
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Jenkins.
+type Nozzle struct {
+	// BaseURL is the scheme, host, and path of the Jenkins instance
+	BaseURL string
+
+	// Strategy is the way the credentials are sent, form or basic
+	Strategy string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
+}
+
+// crumb is a CSRF token along with the header it is sent in.
+type crumb struct {
+	Header string
+	Value  string
+}
+
+// whoAmI is the part of the /whoAmI API response used by the nozzle.
+type whoAmI struct {
+	Name          string `json:"name"`
+	Anonymous     bool   `json:"anonymous"`
+	Authenticated bool   `json:"authenticated"`
+}
+
+// Login fulfils the nozzle.Nozzle interface and authenticates to Jenkins with
+// the configured strategy.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// each attempt receives a fresh cookie jar so a session is never shared
+	// between credential guesses
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+	client := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if n.Strategy == StrategyBasic {
+		return n.basic(client, username, password)
+	}
+	return n.form(client, username, password)
+}
+
+// basic sends the credentials to the /whoAmI API with basic authentication.
+// The API answers a wrong password with 401, and reports whether the request
+// was authenticated otherwise.
+func (n *Nozzle) basic(client *http.Client, username, password string) (*event.AuthResponse, error) {
+	resp, body, res, err := n.do(client, nozzle.StepSubmit, "GET", n.BaseURL+whoAmIPath, nil, nil, func(req *http.Request) {
+		req.SetBasicAuth(username, password)
+	}, username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	metadata := map[string]interface{}{
+		"status": resp.StatusCode,
+	}
+	switch resp.StatusCode {
+	case 401:
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata:    metadata,
+		}, nil
+	case 403:
+		// the credential is valid but the user lacks the Overall/Read
+		// permission
+		metadata["reason"] = "forbidden"
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+			Capture:  nozzle.Capture(resp),
+		}, nil
+	case 200:
+	default:
+		return nil, fmt.Errorf("unexpected status from jenkins whoAmI: %d", resp.StatusCode)
+	}
+
+	var who whoAmI
+	err = json.Unmarshal([]byte(body), &who)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected response from jenkins whoAmI: %w", err)
+	}
+	if !who.Authenticated || who.Anonymous {
+		// the instance ignored the credentials, e.g. because basic
+		// authentication with a password is disabled
+		return nil, fmt.Errorf("jenkins did not authenticate the basic credentials as %s", username)
+	}
+	metadata["name"] = who.Name
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+		Capture:  nozzle.Capture(resp),
+	}, nil
+}
+
+// form posts the credentials to the login form. The form is fetched first for
+// the session cookie, the security check it posts to, and the crumb if the
+// instance requires one. A successful login redirects anywhere except the
+// login error page.
+func (n *Nozzle) form(client *http.Client, username, password string) (*event.AuthResponse, error) {
+	resp, body, res, err := n.do(client, nozzle.StepDiscovery, "GET", n.BaseURL+loginPath, nil, nil, nil,
+		username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status fetching jenkins login form: %d", resp.StatusCode)
+	}
+	action, err := securityCheck(body)
+	if err != nil {
+		return nil, err
+	}
+	c, err := pageCrumb(body)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"j_username": {username},
+		"j_password": {password},
+		"from":       {""},
+		"Submit":     {"Sign in"},
+	}
+	if c != nil {
+		// the crumb is accepted from either the form or the header
+		form.Set(c.Header, c.Value)
+	}
+	resp, body, res, err = n.do(client, nozzle.StepSubmit, "POST", n.BaseURL+"/"+action,
+		strings.NewReader(form.Encode()), c, nil, username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	metadata := map[string]interface{}{
+		"status": resp.StatusCode,
+	}
+	switch resp.StatusCode {
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata:    metadata,
+		}, nil
+	case 403:
+		if strings.Contains(strings.ToLower(body), "crumb") {
+			return nil, fmt.Errorf("jenkins rejected the crumb of the login form")
+		}
+		return nil, fmt.Errorf("jenkins refused the login form")
+	case 301, 302, 303:
+	default:
+		return nil, fmt.Errorf("unexpected status from jenkins login: %d", resp.StatusCode)
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		return nil, err
+	}
+	metadata["location"] = location.Path
+	if strings.HasSuffix(location.Path, loginErrorPath) || strings.HasSuffix(location.Path, loginPath) {
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+		Capture:  nozzle.Capture(resp),
+	}, nil
+}
+
+// do sends a request of the named step with its timeout, the configured
+// headers, the crumb if set, and any changes made by prepare, and returns the
+// response with its body, which has been read and closed. If the response is a
+// WAF or captcha challenge, the AuthResponse reporting it is returned instead
+// of the body.
+func (n *Nozzle) do(client *http.Client, step, method, url string, data io.Reader, c *crumb,
+	prepare func(*http.Request), username, password string) (*http.Response, string, *event.AuthResponse, error) {
+	req, err := http.NewRequest(method, url, data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("User-Agent", n.UserAgent)
+	if c != nil {
+		req.Header.Set(c.Header, c.Value)
+	}
+	if prepare != nil {
+		prepare(req)
+	}
+	n.Headers.Apply(req, username, password)
+	req, cancel := n.Timeouts.Request(req, step)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return resp, "", res, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// the body is kept so a valid response can be captured
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return resp, string(b), nil, nil
+}
+
+// securityCheck returns the security check the login form posts to. A login
+// page without one means the instance has no security realm to sign in to.
+func securityCheck(page string) (string, error) {
+	m := actionRegex.FindStringSubmatch(page)
+	if m == nil {
+		return "", fmt.Errorf("jenkins login page does not contain a login form")
+	}
+	return m[1], nil
+}
+
+// pageCrumb returns the crumb carried by the login page, or nil if the
+// instance does not require one. Jenkins binds the crumb to the session, so
+// it is only valid with the cookie set by the same page. A crumb is only
+// returned if it looks like one, so markup that was matched by mistake is
+// never sent back.
+func pageCrumb(page string) (*crumb, error) {
+	m := crumbAttrRegex.FindStringSubmatch(page)
+	if m == nil {
+		m = crumbInitRegex.FindStringSubmatch(page)
+	}
+	if m == nil {
+		return nil, nil
+	}
+	c := &crumb{Header: html.UnescapeString(m[1]), Value: html.UnescapeString(m[2])}
+	if c.Header == "" && c.Value == "" {
+		// pages of instances without a crumb issuer carry empty attributes
+		return nil, nil
+	}
+	if !crumbRegex.MatchString(c.Header) || !crumbRegex.MatchString(c.Value) {
+		return nil, fmt.Errorf("jenkins login page contains a malformed crumb")
+	}
+	return c, nil
+}
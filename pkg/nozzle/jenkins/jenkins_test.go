@@ -0,0 +1,260 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jenkins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("jenkins", map[string]string{
+		"host":     "jenkins.example.org:8080",
+		"path":     "/jenkins/",
+		"scheme":   "http",
+		"strategy": "basic",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	for _, opts := range []map[string]string{
+		{},
+		{"host": "https://jenkins.example.org"},
+		{"host": "jenkins.example.org/jenkins"},
+		{"host": "jenkins.example.org", "path": "jenkins"},
+		{"host": "jenkins.example.org", "scheme": "ftp"},
+		{"host": "jenkins.example.org", "strategy": "token"},
+	} {
+		_, err = nozzle.Open("jenkins", opts)
+		if err == nil {
+			t.Errorf("expected error opening nozzle with %v", opts)
+		}
+	}
+}
+
+func TestPageCrumb(t *testing.T) {
+	var testcases = []struct {
+		page    string
+		crumb   *crumb
+		wantErr bool
+	}{
+		{`<head data-rooturl="" data-crumb-header="Jenkins-Crumb" data-crumb-value="0a1b2c">`,
+			&crumb{"Jenkins-Crumb", "0a1b2c"}, false},
+		{`<script>crumb.init("Jenkins-Crumb", "3d4e5f");</script>`, &crumb{"Jenkins-Crumb", "3d4e5f"}, false},
+		{`<head data-crumb-header="" data-crumb-value="">`, nil, false},
+		{`<html>no crumb</html>`, nil, false},
+		{`<head data-crumb-header="Jenkins-Crumb" data-crumb-value="&quot;&gt;&lt;script&gt;">`, nil, true},
+	}
+	for _, test := range testcases {
+		c, err := pageCrumb(test.page)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got crumb %v", test.page, c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.page, err)
+			continue
+		}
+		if (c == nil) != (test.crumb == nil) || (c != nil && *c != *test.crumb) {
+			t.Errorf("%s: got %v, expected %v", test.page, c, test.crumb)
+		}
+	}
+}
+
+func TestSecurityCheck(t *testing.T) {
+	var testcases = []struct {
+		page    string
+		action  string
+		wantErr bool
+	}{
+		{`<form method="post" name="login" action="j_spring_security_check">`, "j_spring_security_check", false},
+		{`<form name="login" action="/jenkins/j_acegi_security_check" method="post">`, "j_acegi_security_check", false},
+		{`<form action="/search">`, "", true},
+	}
+	for _, test := range testcases {
+		action, err := securityCheck(test.page)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %q", test.page, action)
+			}
+			continue
+		}
+		if err != nil || action != test.action {
+			t.Errorf("%s: got %q, %v, expected %q", test.page, action, err, test.action)
+		}
+	}
+}
+
+// loginPage renders a Jenkins login page with the crumb of the session.
+const loginPage = `<html><head data-rooturl="/jenkins" data-crumb-header="Jenkins-Crumb" data-crumb-value="c0ffee">
+</head><body><form method="post" name="login" action="j_spring_security_check">
+<input name="j_username"><input name="j_password" type="password">
+</form></body></html>`
+
+// testServer simulates a Jenkins instance served under /jenkins. The password
+// selects the outcome of the login.
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/jenkins" + loginPath:
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session"})
+			fmt.Fprint(w, loginPage)
+		case "/jenkins/j_spring_security_check":
+			if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != "session" ||
+				r.Header.Get("Jenkins-Crumb") != "c0ffee" {
+				w.WriteHeader(403)
+				fmt.Fprint(w, "No valid crumb was included in the request")
+				return
+			}
+			if r.PostFormValue("j_username") != "alice" {
+				t.Errorf("unexpected login: %s", r.PostFormValue("j_username"))
+			}
+			switch r.PostFormValue("j_password") {
+			case "valid":
+				http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "signed-in"})
+				http.Redirect(w, r, "/jenkins/", 302)
+			case "throttled":
+				w.WriteHeader(429)
+			default:
+				http.Redirect(w, r, "/jenkins"+loginErrorPath, 302)
+			}
+		case "/jenkins" + whoAmIPath:
+			username, password, _ := r.BasicAuth()
+			who := whoAmI{Name: "anonymous", Anonymous: true}
+			switch password {
+			case "valid":
+				who = whoAmI{Name: username, Authenticated: true}
+			case "forbidden":
+				w.WriteHeader(403)
+				return
+			case "throttled":
+				w.WriteHeader(429)
+				return
+			case "ignored":
+			default:
+				w.WriteHeader(401)
+				return
+			}
+			json.NewEncoder(w).Encode(who) // nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	var testcases = []struct {
+		strategy    string
+		password    string
+		valid       bool
+		ratelimited bool
+		wantErr     bool
+	}{
+		{strategy: StrategyForm, password: "valid", valid: true},
+		{strategy: StrategyForm, password: "throttled", ratelimited: true},
+		{strategy: StrategyForm, password: "wrong"},
+		{strategy: StrategyBasic, password: "valid", valid: true},
+		{strategy: StrategyBasic, password: "forbidden", valid: true},
+		{strategy: StrategyBasic, password: "throttled", ratelimited: true},
+		{strategy: StrategyBasic, password: "ignored", wantErr: true},
+		{strategy: StrategyBasic, password: "wrong"},
+	}
+	for _, test := range testcases {
+		noz, err := nozzle.Open("jenkins", map[string]string{
+			"host":                 strings.TrimPrefix(srv.URL, "https://"),
+			"path":                 "/jenkins",
+			"strategy":             test.strategy,
+			"insecure_skip_verify": "true",
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		res, err := noz.Login("alice", test.password)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %s: expected error, got %+v", test.strategy, test.password, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %s", test.strategy, test.password, err)
+			continue
+		}
+		if res.Valid != test.valid || res.RateLimited != test.ratelimited {
+			t.Errorf("%s %s: got %+v", test.strategy, test.password, res)
+		}
+		if test.valid && res.Capture == nil {
+			t.Errorf("%s %s: expected the response to be captured", test.strategy, test.password)
+		}
+	}
+}
+
+// TestGolden replays the saved responses in testdata through both
+// strategies.
+func TestGolden(t *testing.T) {
+	var testcases = []struct {
+		strategy string
+		files    []string
+		valid    bool
+	}{
+		{StrategyForm, []string{"login.http", "logged_in.http"}, true},
+		{StrategyForm, []string{"login.http", "login_error.http"}, false},
+		{StrategyBasic, []string{"who_am_i.http"}, true},
+		{StrategyBasic, []string{"invalid_basic.http"}, false},
+	}
+	for _, test := range testcases {
+		noz, err := nozzle.Open("jenkins", map[string]string{
+			"host":     "jenkins.example.org",
+			"strategy": test.strategy,
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		paths := make([]string, len(test.files))
+		for i, file := range test.files {
+			paths[i] = filepath.Join("testdata", file)
+		}
+		r, err := nozzle.ReadReplayer(paths...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice", "Password1")
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", test.files, err)
+			continue
+		}
+		if res.Valid != test.valid {
+			t.Errorf("%v: got %+v", test.files, res)
+		}
+		if len(r.Requests) != len(test.files) {
+			t.Errorf("%v: unexpected requests: %v", test.files, r.Requests)
+		}
+	}
+}
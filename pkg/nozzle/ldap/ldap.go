@@ -0,0 +1,313 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// DialTimeout bounds both the TCP connection and the bind request for a
+	// single credential guess
+	DialTimeout = 15 * time.Second
+)
+
+// Active Directory reports the reason for a failed bind as a hexadecimal
+// sub-code in the diagnostic message, e.g.
+// "80090308: LdapErr: DSID-0C09042F, comment: AcceptSecurityContext error,
+// data 775, v4563". The codes other than 525 and 52e are only returned when
+// the supplied password was correct.
+const (
+	adUserNotFound      = "525"
+	adLogonHours        = "530"
+	adWorkstation       = "531"
+	adPasswordExpired   = "532"
+	adAccountDisabled   = "533"
+	adAccountExpired    = "701"
+	adMustResetPassword = "773"
+	adAccountLocked     = "775"
+)
+
+var adDataCode = regexp.MustCompile(`data ([0-9a-fA-F]+)`)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("ldap", Driver{})
+}
+
+// New is used to create an LDAP nozzle and accepts the following configuration
+// options:
+//
+// server
+//
+// The URL of the directory server, for example ldaps://dc01.example.org or
+// ldap://dc01.example.org:389.
+//
+// base_dn
+//
+// The base DN of the directory (e.g. "DC=example,DC=org"). It is substituted
+// for {base_dn} in the bind DN template.
+//
+// bind_dn
+//
+// A template used to build the bind DN from the username, where {username}
+// and {base_dn} are replaced. Defaults to "{username}", which allows Active
+// Directory user principal names (alice@example.org) or down-level logon
+// names (EXAMPLE\alice) to be bound directly. For other directories use a
+// template such as "uid={username},ou=people,{base_dn}".
+//
+// starttls
+//
+// If "true", upgrade an ldap:// connection with StartTLS before binding.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	server, ok := opts["server"]
+	if !ok {
+		return nil, fmt.Errorf("ldap nozzle requires 'server' config parameter")
+	}
+
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		return nil, fmt.Errorf("ldap nozzle server must use ldap or ldaps instead of %s", u.Scheme)
+	}
+
+	template, ok := opts["bind_dn"]
+	if !ok {
+		template = "{username}"
+	}
+	if !strings.Contains(template, "{username}") {
+		return nil, fmt.Errorf("ldap nozzle bind_dn must contain {username}")
+	}
+
+	baseDN := opts["base_dn"]
+	if strings.Contains(template, "{base_dn}") && baseDN == "" {
+		return nil, fmt.Errorf("ldap nozzle requires 'base_dn' config parameter for bind_dn %s", template)
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+	// StartTLS needs the server name to verify the certificate
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	return &Nozzle{
+		Server:    u.String(),
+		BaseDN:    baseDN,
+		BindDN:    template,
+		StartTLS:  opts["starttls"] == "true",
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// Describe returns the configuration options of the LDAP nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "server", Description: "the URL of the directory server, e.g. ldaps://dc01.example.org", Required: true},
+		{Name: "base_dn", Description: "the base DN substituted for {base_dn} in bind_dn"},
+		{Name: "bind_dn", Description: "the bind DN template, {username} by default"},
+		{Name: "starttls", Description: "whether an ldap:// connection is upgraded with StartTLS"},
+	}, nozzle.TLSOptions)
+}
+
+// Nozzle implements the nozzle.Nozzle interface for LDAP simple binds.
+type Nozzle struct {
+	// Server is the ldap:// or ldaps:// URL of the directory server
+	Server string
+
+	// BaseDN is substituted for {base_dn} in the bind DN template
+	BaseDN string
+
+	// BindDN is the bind DN template containing {username}
+	BindDN string
+
+	// StartTLS upgrades plaintext connections before binding
+	StartTLS bool
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+}
+
+// bindDN expands the bind DN template for the given username. The username is
+// escaped when it is embedded in a distinguished name.
+func (n *Nozzle) bindDN(username string) string {
+	if n.BindDN != "{username}" {
+		username = escapeDN(username)
+	}
+	r := strings.NewReplacer("{username}", username, "{base_dn}", n.BaseDN)
+	return r.Replace(n.BindDN)
+}
+
+// escapeDN escapes the special characters of an RDN value (RFC 4514 section
+// 2.4). The value is walked rune by rune, so the first and last characters are
+// found whatever their encoded length.
+func escapeDN(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, c := range runes {
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+			continue
+		case strings.ContainsRune(`,+"\<>;=`, c):
+			b.WriteRune('\\')
+		case (c == ' ' || c == '#') && i == 0:
+			b.WriteRune('\\')
+		case c == ' ' && i == len(runes)-1:
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs a simple bind with
+// the supplied credentials.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := n.TLSConfig
+
+	conn, err := ldap.DialURL(n.Server,
+		ldap.DialWithDialer(&net.Dialer{Timeout: DialTimeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(DialTimeout)
+
+	if n.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// an empty password would result in an unauthenticated bind, which
+	// succeeds on most servers; go-ldap refuses to send it and errors here
+	err = conn.Bind(n.bindDN(username), password)
+	return classify(err)
+}
+
+// classify maps the result of a bind onto an AuthResponse.
+func classify(err error) (*event.AuthResponse, error) {
+	if err == nil {
+		return &event.AuthResponse{
+			Valid: true,
+		}, nil
+	}
+
+	lerr, ok := err.(*ldap.Error)
+	if !ok {
+		return nil, err
+	}
+
+	switch lerr.ResultCode {
+	case ldap.LDAPResultInvalidCredentials:
+		return classifyAD(lerr)
+	case ldap.LDAPResultBusy, ldap.LDAPResultUnavailable:
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unhandled result from ldap provider: %s", lerr)
+}
+
+// classifyAD inspects the Active Directory sub-code of an invalidCredentials
+// result. Directories that do not return a sub-code are treated as a plain bad
+// password.
+func classifyAD(lerr *ldap.Error) (*event.AuthResponse, error) {
+	var code string
+	if lerr.Err != nil {
+		if m := adDataCode.FindStringSubmatch(lerr.Err.Error()); m != nil {
+			code = strings.ToLower(m[1])
+		}
+	}
+
+	metadata := map[string]interface{}{
+		"result_code": lerr.ResultCode,
+	}
+	if code != "" {
+		metadata["ad_code"] = code
+	}
+
+	switch code {
+	case adAccountLocked:
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case adPasswordExpired, adMustResetPassword:
+		metadata["reason"] = "password_expired"
+		return &event.AuthResponse{
+			Valid:    true,
+			Expired:  true,
+			Metadata: metadata,
+		}, nil
+	case adAccountDisabled:
+		metadata["reason"] = "account_disabled"
+	case adAccountExpired:
+		metadata["reason"] = "account_expired"
+	case adLogonHours, adWorkstation:
+		metadata["reason"] = "logon_restricted"
+	default:
+		// 52e, 525, or a directory that does not report a sub-code
+		if code == adUserNotFound {
+			metadata["reason"] = "user_not_found"
+		}
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+
+	// the remaining sub-codes are only returned for a correct password
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+	}, nil
+}
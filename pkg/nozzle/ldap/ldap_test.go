@@ -0,0 +1,127 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("ldap", map[string]string{
+		"server":  "ldaps://dc01.example.org",
+		"base_dn": "DC=example,DC=org",
+		"bind_dn": "CN={username},CN=Users,{base_dn}",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	_, err = nozzle.Open("ldap", map[string]string{
+		"base_dn": "DC=example,DC=org",
+	})
+	if err == nil {
+		t.Fatalf("expected error opening nozzle without server")
+	}
+
+	_, err = nozzle.Open("ldap", map[string]string{
+		"server": "https://dc01.example.org",
+	})
+	if err == nil {
+		t.Fatalf("expected error opening nozzle with non-ldap server")
+	}
+
+	_, err = nozzle.Open("ldap", map[string]string{
+		"server":  "ldap://dc01.example.org",
+		"bind_dn": "CN={username},CN=Users,{base_dn}",
+	})
+	if err == nil {
+		t.Fatalf("expected error opening nozzle without base_dn")
+	}
+}
+
+func TestBindDN(t *testing.T) {
+	var testcases = []struct {
+		template string
+		input    string
+		expected string
+	}{
+		{"{username}", "alice@example.org", "alice@example.org"},
+		{"{username}", `EXAMPLE\alice`, `EXAMPLE\alice`},
+		{"CN={username},CN=Users,{base_dn}", "Smith, Alice", `CN=Smith\, Alice,CN=Users,DC=example,DC=org`},
+		{"uid={username},ou=people,{base_dn}", "alice", "uid=alice,ou=people,DC=example,DC=org"},
+		{"CN={username}", " #Zoë ", `CN=\ #Zoë\ `},
+		{"CN={username}", "Zoë ", `CN=Zoë\ `},
+		{"CN={username}", "#Zoë", `CN=\#Zoë`},
+		{"CN={username}", "é#", "CN=é#"},
+		{"CN={username}", "a\x00b", `CN=a\00b`},
+	}
+	for _, test := range testcases {
+		n := &Nozzle{BindDN: test.template, BaseDN: "DC=example,DC=org"}
+		if got := n.bindDN(test.input); got != test.expected {
+			t.Errorf("bindDN(%s) was %s, expected %s", test.input, got, test.expected)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	diagnostic := "80090308: LdapErr: DSID-0C09042F, comment: AcceptSecurityContext error, data %s, v4563"
+	bind := func(code uint16, msg string) error {
+		return ldap.NewError(code, fmt.Errorf("%s", msg))
+	}
+
+	var testcases = []struct {
+		err         error
+		valid       bool
+		locked      bool
+		ratelimited bool
+		wantErr     bool
+	}{
+		{nil, true, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "52e")), false, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "525")), false, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "775")), false, true, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "532")), true, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "773")), true, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, fmt.Sprintf(diagnostic, "533")), true, false, false, false},
+		{bind(ldap.LDAPResultInvalidCredentials, "Invalid Credentials"), false, false, false, false},
+		{bind(ldap.LDAPResultBusy, "busy"), false, false, true, false},
+		{bind(ldap.LDAPResultUnwillingToPerform, "unwilling"), false, false, false, true},
+		{fmt.Errorf("connection reset"), false, false, false, true},
+	}
+	for i, test := range testcases {
+		resp, err := classify(test.err)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("test %d: expected error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: unexpected error: %s", i, err)
+			continue
+		}
+		if resp.Valid != test.valid || resp.Locked != test.locked || resp.RateLimited != test.ratelimited {
+			t.Errorf("test %d: got %+v", i, resp)
+		}
+		if expired := resp.Metadata["reason"] == "password_expired"; resp.Expired != expired {
+			t.Errorf("test %d: expired was %t, expected %t", i, resp.Expired, expired)
+		}
+	}
+}
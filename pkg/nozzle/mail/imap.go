@@ -0,0 +1,352 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+// IMAPDriver implements the nozzle.Driver interface.
+type IMAPDriver struct{}
+
+func init() {
+	nozzle.Register("imap", IMAPDriver{})
+}
+
+var imapDefaultPorts = map[string]string{
+	SecurityTLS:      "993",
+	SecuritySTARTTLS: "143",
+	SecurityNone:     "143",
+}
+
+// New is used to create an IMAP nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The hostname of the mail server, e.g. "outlook.office365.com".
+//
+// port
+//
+// The IMAP port. Defaults to 143 (or 993 when security is "tls").
+//
+// security
+//
+// One of starttls (default), tls, or none.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+func (IMAPDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	o, err := parseOptions("imap", opts, imapDefaultPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IMAPNozzle{
+		options: *o,
+	}, nil
+}
+
+// Describe returns the configuration options of the IMAP nozzle.
+func (IMAPDriver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the mail server", Required: true},
+		{Name: "port", Description: "the IMAP port, 143 or 993 with tls by default"},
+		{Name: "security", Description: "starttls (default), tls, or none"},
+	}, nozzle.TLSOptions)
+}
+
+// IMAPNozzle implements the nozzle.Nozzle interface for IMAP.
+type IMAPNozzle struct {
+	options
+}
+
+// imapConn is a minimal tagged-command IMAP client. Only the handful of
+// commands needed to authenticate are supported.
+type imapConn struct {
+	conn net.Conn
+	text *textproto.Conn
+	seq  int
+}
+
+func newIMAPConn(conn net.Conn) *imapConn {
+	return &imapConn{
+		conn: conn,
+		text: textproto.NewConn(conn),
+	}
+}
+
+func (c *imapConn) Close() error {
+	return c.text.Close()
+}
+
+// greeting reads the untagged server greeting.
+func (c *imapConn) greeting() error {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return fmt.Errorf("unexpected imap greeting: %s", line)
+	}
+	return nil
+}
+
+// command sends a tagged command and reads until the tagged completion
+// response. Untagged responses are returned alongside the completion status
+// (OK, NO, or BAD) and its text. If cont is non-nil, it is called for each
+// continuation request ("+ ...") and its result is sent to the server.
+func (c *imapConn) command(cont func(string) string, format string, args ...interface{}) (untagged []string, status, text string, err error) {
+	c.seq++
+	tag := fmt.Sprintf("a%d", c.seq)
+	err = c.text.PrintfLine("%s "+format, append([]interface{}{tag}, args...)...)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	for {
+		var line string
+		line, err = c.text.ReadLine()
+		if err != nil {
+			return nil, "", "", err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+"):
+			if cont == nil {
+				return nil, "", "", fmt.Errorf("unexpected imap continuation: %s", line)
+			}
+			err = c.text.PrintfLine("%s", cont(strings.TrimSpace(strings.TrimPrefix(line, "+"))))
+			if err != nil {
+				return nil, "", "", err
+			}
+		case strings.HasPrefix(line, "* "):
+			untagged = append(untagged, strings.TrimPrefix(line, "* "))
+		case strings.HasPrefix(line, tag+" "):
+			parts := strings.SplitN(strings.TrimPrefix(line, tag+" "), " ", 2)
+			status = strings.ToUpper(parts[0])
+			if len(parts) > 1 {
+				text = parts[1]
+			}
+			return untagged, status, text, nil
+		}
+	}
+}
+
+// capabilities issues a CAPABILITY command and returns the advertised
+// capabilities in upper case.
+func (c *imapConn) capabilities() (map[string]bool, error) {
+	untagged, status, text, err := c.command(nil, "CAPABILITY")
+	if err != nil {
+		return nil, err
+	}
+	if status != "OK" {
+		return nil, fmt.Errorf("imap CAPABILITY failed: %s %s", status, text)
+	}
+
+	caps := make(map[string]bool)
+	for _, line := range untagged {
+		fields := strings.Fields(strings.ToUpper(line))
+		if len(fields) == 0 || fields[0] != "CAPABILITY" {
+			continue
+		}
+		for _, c := range fields[1:] {
+			caps[c] = true
+		}
+	}
+	return caps, nil
+}
+
+// quote returns s as an IMAP quoted string (RFC 3501 section 4.3), or false
+// if s holds a control or non-ASCII character, which a quoted string cannot
+// carry. A CR or LF would otherwise end the command early.
+func quote(s string) (string, bool) {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return "", false
+		}
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`, true
+}
+
+// login issues a LOGIN command. The username and password are sent as quoted
+// strings, or as synchronizing literals (RFC 3501 section 4.3) if either
+// cannot be quoted, each sent once the server asks for it.
+func (c *imapConn) login(username, password string) (status, text string, err error) {
+	if strings.ContainsRune(username, 0) || strings.ContainsRune(password, 0) {
+		return "", "", fmt.Errorf("imap credentials cannot contain a NUL byte")
+	}
+
+	user, userOK := quote(username)
+	pass, passOK := quote(password)
+	if userOK && passOK {
+		_, status, text, err = c.command(nil, "LOGIN %s %s", user, pass)
+		return status, text, err
+	}
+
+	literals := []string{fmt.Sprintf("%s {%d}", username, len(password)), password}
+	_, status, text, err = c.command(func(string) string {
+		if len(literals) == 0 {
+			return ""
+		}
+		next := literals[0]
+		literals = literals[1:]
+		return next
+	}, "LOGIN {%d}", len(username))
+	return status, text, err
+}
+
+// classifyIMAP maps a tagged LOGIN/AUTHENTICATE completion onto an
+// AuthResponse. Response codes are defined in RFC 5530.
+func classifyIMAP(status, text string) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"status":  status,
+		"message": text,
+	}
+
+	upper := strings.ToUpper(text)
+	switch {
+	case status == "OK":
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case status == "BAD":
+		return nil, fmt.Errorf("imap server rejected command: %s", text)
+	case strings.Contains(upper, "[UNAVAILABLE]"):
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case strings.Contains(upper, "[PRIVACYREQUIRED]"):
+		return nil, fmt.Errorf("imap server requires encryption for auth: %s", text)
+	case containsAny(text, disabledPhrases):
+		return nil, fmt.Errorf("%w: %s", nozzle.ErrAuthDisabled, text)
+	case containsAny(text, lockedPhrases), strings.Contains(upper, "[CONTACTADMIN]"):
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	}
+
+	// NO, including [AUTHENTICATIONFAILED] and [AUTHORIZATIONFAILED]
+	return &event.AuthResponse{
+		Metadata: metadata,
+	}, nil
+}
+
+// openIMAP connects to the mail server, negotiating STARTTLS if configured,
+// and returns the connection with the capabilities the server advertises
+// before login.
+func (o *options) openIMAP() (*imapConn, map[string]bool, error) {
+	conn, err := o.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := newIMAPConn(conn)
+	err = c.greeting()
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, err
+	}
+
+	caps, err := c.capabilities()
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, err
+	}
+	if o.Security != SecuritySTARTTLS {
+		return c, caps, nil
+	}
+
+	if !caps["STARTTLS"] {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, fmt.Errorf("imap server does not support STARTTLS")
+	}
+	_, status, text, err := c.command(nil, "STARTTLS")
+	if err == nil && status != "OK" {
+		err = fmt.Errorf("imap STARTTLS failed: %s %s", status, text)
+	}
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, err
+	}
+
+	tlsConn := tls.Client(conn, o.tlsConfig())
+	err = tlsConn.Handshake()
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, err
+	}
+	c = newIMAPConn(tlsConn)
+
+	// capabilities must be discarded after STARTTLS (RFC 3501 6.2.1)
+	caps, err = c.capabilities()
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, nil, err
+	}
+	return c, caps, nil
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an IMAP login
+// against the configured mail server. LOGIN is preferred; AUTHENTICATE PLAIN
+// is used when the server advertises LOGINDISABLED.
+func (n *IMAPNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, caps, err := n.openIMAP()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close() // nolint:errcheck
+
+	var status, text string
+	switch {
+	case !caps["LOGINDISABLED"]:
+		status, text, err = c.login(username, password)
+	case caps["AUTH=PLAIN"]:
+		ir := base64.StdEncoding.EncodeToString([]byte("\x00" + username + "\x00" + password))
+		_, status, text, err = c.command(func(string) string { return ir }, "AUTHENTICATE PLAIN")
+	default:
+		return nil, fmt.Errorf("%w: imap server advertises LOGINDISABLED without AUTH=PLAIN",
+			nozzle.ErrAuthDisabled)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "OK" {
+		c.command(nil, "LOGOUT") // nolint:errcheck,gosec
+	}
+	return classifyIMAP(status, text)
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	for _, name := range []string{"smtp", "imap"} {
+		_, err := nozzle.Open(name, map[string]string{
+			"host": "mail.example.com",
+		})
+		if err != nil {
+			t.Fatalf("unable to open %s nozzle: %s", name, err)
+		}
+
+		_, err = nozzle.Open(name, map[string]string{
+			"host":     "mail.example.com",
+			"security": "tls",
+		})
+		if err != nil {
+			t.Fatalf("unable to open %s nozzle: %s", name, err)
+		}
+
+		_, err = nozzle.Open(name, map[string]string{
+			"host":     "mail.example.com",
+			"security": "ssl3",
+		})
+		if err == nil {
+			t.Fatalf("expected error opening %s nozzle with unknown security", name)
+		}
+	}
+}
+
+type testcase struct {
+	desc        string
+	code        int
+	status      string
+	msg         string
+	valid       bool
+	mfa         bool
+	locked      bool
+	ratelimited bool
+}
+
+func TestClassifySMTP(t *testing.T) {
+	var testcases = []testcase{
+		{desc: "success", code: 235, msg: "2.7.0 Authentication successful", valid: true},
+		{desc: "invalid", code: 535, msg: "5.7.8 Authentication credentials invalid"},
+		{desc: "app password", code: 534, msg: "5.7.9 Application-specific password required", valid: true, mfa: true},
+		{desc: "locked", code: 535, msg: "5.7.139 Authentication unsuccessful, the user account is locked", locked: true},
+		{desc: "throttled", code: 454, msg: "4.7.0 Too many login attempts, please try again later", ratelimited: true},
+	}
+
+	for _, test := range testcases {
+		res, err := classifySMTP(test.code, test.msg)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		check(t, test, res.Valid, res.MFA, res.Locked, res.RateLimited)
+	}
+
+	_, err := classifySMTP(538, "5.7.11 Encryption required")
+	if err == nil {
+		t.Errorf("expected error for encryption required reply")
+	}
+
+	// a server refusing password auth says nothing about the credential
+	_, err = classifySMTP(535, "5.7.139 Authentication unsuccessful, SmtpClientAuthentication is disabled for the Tenant")
+	if !errors.Is(err, nozzle.ErrAuthDisabled) {
+		t.Errorf("expected auth disabled error, got %v", err)
+	}
+}
+
+func TestClassifyIMAP(t *testing.T) {
+	var testcases = []testcase{
+		{desc: "success", status: "OK", msg: "LOGIN completed", valid: true},
+		{desc: "invalid", status: "NO", msg: "[AUTHENTICATIONFAILED] Authentication failed."},
+		{desc: "locked", status: "NO", msg: "[CONTACTADMIN] Account locked", locked: true},
+		{desc: "unavailable", status: "NO", msg: "[UNAVAILABLE] Try again later", ratelimited: true},
+	}
+
+	for _, test := range testcases {
+		res, err := classifyIMAP(test.status, test.msg)
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		check(t, test, res.Valid, res.MFA, res.Locked, res.RateLimited)
+	}
+
+	_, err := classifyIMAP("NO", "[ALERT] Basic authentication is disabled")
+	if !errors.Is(err, nozzle.ErrAuthDisabled) {
+		t.Errorf("expected auth disabled error, got %v", err)
+	}
+}
+
+func check(t *testing.T, test testcase, valid, mfa, locked, ratelimited bool) {
+	if valid != test.valid {
+		t.Errorf("[%s] valid was %t, expected %t", test.desc, valid, test.valid)
+	}
+	if mfa != test.mfa {
+		t.Errorf("[%s] mfa was %t, expected %t", test.desc, mfa, test.mfa)
+	}
+	if locked != test.locked {
+		t.Errorf("[%s] locked was %t, expected %t", test.desc, locked, test.locked)
+	}
+	if ratelimited != test.ratelimited {
+		t.Errorf("[%s] rate_limited was %t, expected %t", test.desc, ratelimited, test.ratelimited)
+	}
+}
+
+func TestReconSurfaces(t *testing.T) {
+	var s nozzle.Surface
+	imapSurface(&s, map[string]bool{"IMAP4REV1": true, "AUTH=XOAUTH2": true, "LOGINDISABLED": true})
+	if !s.Exposed || s.Legacy || len(s.Auth) != 1 || s.Auth[0] != "XOAUTH2" {
+		t.Errorf("imap with only XOAUTH2: got %+v", s)
+	}
+
+	s = nozzle.Surface{}
+	imapSurface(&s, map[string]bool{"IMAP4REV1": true, "AUTH=PLAIN": true})
+	if !s.Legacy || len(s.Auth) != 2 {
+		t.Errorf("imap with LOGIN and PLAIN: got %+v", s)
+	}
+
+	s = nozzle.Surface{}
+	smtpSurface(&s, "LOGIN XOAUTH2")
+	if !s.Exposed || !s.Legacy || len(s.Auth) != 2 {
+		t.Errorf("smtp with LOGIN: got %+v", s)
+	}
+
+	s = nozzle.Surface{}
+	smtpSurface(&s, "")
+	if !s.Exposed || s.Legacy || len(s.Auth) != 0 {
+		t.Errorf("smtp without AUTH: got %+v", s)
+	}
+}
+
+// imapLoginServer reads one LOGIN command from the client, following its
+// literals, and returns the username and password it received.
+func imapLoginServer(conn net.Conn) (string, string, error) {
+	defer conn.Close() // nolint:errcheck
+	r := bufio.NewReader(conn)
+
+	var args []string
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	tag := strings.SplitN(line, " ", 2)[0]
+	for strings.HasSuffix(line, "}") {
+		i := strings.LastIndex(line, "{")
+		n, err := strconv.Atoi(line[i+1 : len(line)-1])
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Fprintf(conn, "+ Ready\r\n") // nolint:errcheck
+		literal := make([]byte, n)
+		if _, err = io.ReadFull(r, literal); err != nil {
+			return "", "", err
+		}
+		args = append(args, string(literal))
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+	}
+	if len(args) == 0 {
+		args = strings.SplitN(line, " ", 4)[2:]
+	}
+	fmt.Fprintf(conn, "%s OK LOGIN completed\r\n", tag) // nolint:errcheck
+	return args[0], args[1], nil
+}
+
+func TestIMAPLogin(t *testing.T) {
+	var tests = []struct {
+		username, password string
+		quoted             bool
+	}{
+		{"alice@example.org", "Summer2020!", true},
+		{"alice@example.org", "a1\r\nb2 LOGOUT", false},
+		{"alice@example.org", "Pässword\t1", false},
+	}
+	for _, test := range tests {
+		client, server := net.Pipe()
+		type login struct{ username, password string }
+		received := make(chan login, 1)
+		go func() {
+			u, p, err := imapLoginServer(server)
+			if err != nil {
+				t.Error(err)
+			}
+			received <- login{u, p}
+		}()
+
+		status, _, err := newIMAPConn(client).login(test.username, test.password)
+		if err != nil || status != "OK" {
+			t.Errorf("%q: unexpected status %q (%v)", test.password, status, err)
+		}
+		got := <-received
+		if test.quoted {
+			got.username, got.password = strings.Trim(got.username, `"`), strings.Trim(got.password, `"`)
+		}
+		if got.username != test.username || got.password != test.password {
+			t.Errorf("%q: server received %q and %q", test.password, got.username, got.password)
+		}
+	}
+
+	if _, _, err := newIMAPConn(nil).login("alice", "pass\x00word"); err == nil {
+		t.Error("expected an error for a NUL byte")
+	}
+}
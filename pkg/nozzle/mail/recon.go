@@ -0,0 +1,132 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+// passwordMechanisms are the SASL mechanisms which send the password itself,
+// as opposed to a token such as XOAUTH2.
+var passwordMechanisms = map[string]bool{
+	"PLAIN": true,
+	"LOGIN": true,
+}
+
+// reconOptions parses the options of a mail driver with the host to probe,
+// which defaults to the configured host.
+func reconOptions(name, host string, opts map[string]string, defaultPorts map[string]string) (*options, error) {
+	merged := make(map[string]string, len(opts)+1)
+	for k, v := range opts {
+		merged[k] = v
+	}
+	if host != "" {
+		merged["host"] = host
+	}
+	return parseOptions(name, merged, defaultPorts)
+}
+
+// Recon fulfils the nozzle.Recon interface and reports whether the IMAP server
+// offers password logins. The connection is made with the configured port and
+// security mode, and no credentials are sent.
+func (IMAPDriver) Recon(host string, opts map[string]string) ([]nozzle.Surface, error) {
+	o, err := reconOptions("imap", host, opts, imapDefaultPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := nozzle.Surface{
+		Name:     "imap",
+		Endpoint: net.JoinHostPort(o.Host, o.Port),
+	}
+	c, caps, err := o.openIMAP()
+	if err != nil {
+		s.Detail = err.Error()
+		return []nozzle.Surface{s}, nil
+	}
+	c.command(nil, "LOGOUT") // nolint:errcheck,gosec
+	c.Close()                // nolint:errcheck,gosec
+
+	imapSurface(&s, caps)
+	return []nozzle.Surface{s}, nil
+}
+
+// imapSurface fills in the surface from the capabilities of an IMAP server.
+// The LOGIN command takes a password unless LOGINDISABLED is advertised.
+func imapSurface(s *nozzle.Surface, caps map[string]bool) {
+	s.Exposed = true
+	if !caps["LOGINDISABLED"] {
+		s.Auth = append(s.Auth, "LOGIN-COMMAND")
+		s.Legacy = true
+	}
+	var mechanisms []string
+	for c := range caps {
+		if strings.HasPrefix(c, "AUTH=") {
+			mechanisms = append(mechanisms, strings.TrimPrefix(c, "AUTH="))
+		}
+	}
+	sort.Strings(mechanisms)
+	for _, m := range mechanisms {
+		s.Auth = append(s.Auth, m)
+		if passwordMechanisms[m] {
+			s.Legacy = true
+		}
+	}
+}
+
+// Recon fulfils the nozzle.Recon interface and reports whether the SMTP
+// submission server offers password authentication. The connection is made
+// with the configured port and security mode, and no credentials are sent.
+func (SMTPDriver) Recon(host string, opts map[string]string) ([]nozzle.Surface, error) {
+	o, err := reconOptions("smtp", host, opts, smtpDefaultPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := nozzle.Surface{
+		Name:     "smtp",
+		Endpoint: net.JoinHostPort(o.Host, o.Port),
+	}
+	c, err := o.openSMTP()
+	if err != nil {
+		s.Detail = err.Error()
+		return []nozzle.Surface{s}, nil
+	}
+	_, mechanisms := c.Extension("AUTH")
+	c.Quit() // nolint:errcheck,gosec
+
+	smtpSurface(&s, mechanisms)
+	return []nozzle.Surface{s}, nil
+}
+
+// smtpSurface fills in the surface from the mechanisms of the AUTH extension
+// of an SMTP server, which are empty if it does not offer authentication.
+func smtpSurface(s *nozzle.Surface, mechanisms string) {
+	s.Exposed = true
+	if mechanisms == "" {
+		s.Detail = "AUTH not offered"
+		return
+	}
+	for _, m := range strings.Fields(strings.ToUpper(mechanisms)) {
+		s.Auth = append(s.Auth, m)
+		if passwordMechanisms[m] {
+			s.Legacy = true
+		}
+	}
+}
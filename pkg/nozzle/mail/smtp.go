@@ -0,0 +1,259 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+// SMTPDriver implements the nozzle.Driver interface.
+type SMTPDriver struct{}
+
+func init() {
+	nozzle.Register("smtp", SMTPDriver{})
+}
+
+var smtpDefaultPorts = map[string]string{
+	SecurityTLS:      "465",
+	SecuritySTARTTLS: "587",
+	SecurityNone:     "587",
+}
+
+// New is used to create an SMTP nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The hostname of the mail server, e.g. "smtp.office365.com".
+//
+// port
+//
+// The submission port. Defaults to 587 (or 465 when security is "tls").
+//
+// security
+//
+// One of starttls (default), tls, or none. STARTTLS is required by most
+// servers before they advertise the AUTH extension.
+//
+// mechanism
+//
+// The SASL mechanism to use: plain (default) or login.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+func (SMTPDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	o, err := parseOptions("smtp", opts, smtpDefaultPorts)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanism, ok := opts["mechanism"]
+	if !ok {
+		mechanism = "plain"
+	}
+	mechanism = strings.ToLower(mechanism)
+	if mechanism != "plain" && mechanism != "login" {
+		return nil, fmt.Errorf("smtp nozzle has unknown mechanism %q", mechanism)
+	}
+
+	return &SMTPNozzle{
+		options:   *o,
+		Mechanism: mechanism,
+	}, nil
+}
+
+// Describe returns the configuration options of the SMTP nozzle.
+func (SMTPDriver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the mail server", Required: true},
+		{Name: "port", Description: "the submission port, 587 or 465 with tls by default"},
+		{Name: "security", Description: "starttls (default), tls, or none"},
+		{Name: "mechanism", Description: "the SASL mechanism, plain (default) or login"},
+	}, nozzle.TLSOptions)
+}
+
+// SMTPNozzle implements the nozzle.Nozzle interface for SMTP submission.
+type SMTPNozzle struct {
+	options
+
+	// Mechanism is the SASL mechanism (plain or login)
+	Mechanism string
+}
+
+// plainAuth implements the PLAIN mechanism (RFC 4616). Unlike smtp.PlainAuth,
+// it does not refuse to send credentials over an unencrypted connection; the
+// operator opts into that by selecting the "none" security mode.
+type plainAuth struct {
+	username, password string
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "PLAIN", []byte("\x00" + a.username + "\x00" + a.password), nil
+}
+
+func (a *plainAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		return nil, errors.New("unexpected server challenge")
+	}
+	return nil, nil
+}
+
+// loginAuth implements the non-standard but widely deployed LOGIN mechanism.
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.Contains(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.Contains(prompt, "password"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+// classifySMTP maps an SMTP AUTH reply onto an AuthResponse. Reply codes are
+// defined in RFC 4954 section 6; the enhanced status codes in the message text
+// carry the more specific reason where servers provide one.
+func classifySMTP(code int, msg string) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"code":    code,
+		"message": msg,
+	}
+
+	switch {
+	case code == 235:
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case containsAny(msg, disabledPhrases):
+		return nil, fmt.Errorf("%w: %d %s", nozzle.ErrAuthDisabled, code, msg)
+	case containsAny(msg, lockedPhrases):
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case code == 534:
+		// 5.7.9: authentication mechanism is too weak, typically returned
+		// by providers that require an app password once the real password
+		// has been accepted
+		return &event.AuthResponse{
+			Valid:    true,
+			MFA:      true,
+			Metadata: metadata,
+		}, nil
+	case code == 535:
+		return &event.AuthResponse{
+			Metadata: metadata,
+		}, nil
+	case code == 421 || code == 454:
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case code == 538:
+		return nil, fmt.Errorf("smtp server requires encryption for auth: %d %s", code, msg)
+	}
+
+	return nil, fmt.Errorf("unhandled reply from smtp provider: %d %s", code, msg)
+}
+
+// openSMTP connects to the mail server and negotiates STARTTLS if configured.
+func (o *options) openSMTP() (*smtp.Client, error) {
+	conn, err := o.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := smtp.NewClient(conn, o.Host)
+	if err != nil {
+		conn.Close() // nolint:errcheck,gosec
+		return nil, err
+	}
+
+	if o.Security == SecuritySTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close() // nolint:errcheck,gosec
+			return nil, fmt.Errorf("smtp server does not support STARTTLS")
+		}
+		err = c.StartTLS(o.tlsConfig())
+		if err != nil {
+			c.Close() // nolint:errcheck,gosec
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an SMTP AUTH
+// exchange against the configured mail server.
+func (n *SMTPNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := n.openSMTP()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close() // nolint:errcheck
+
+	ok, mechanisms := c.Extension("AUTH")
+	if !ok {
+		return nil, fmt.Errorf("%w: smtp server does not advertise AUTH", nozzle.ErrAuthDisabled)
+	}
+	if !strings.Contains(strings.ToUpper(mechanisms), strings.ToUpper(n.Mechanism)) {
+		return nil, fmt.Errorf("smtp server does not support AUTH %s (supports %s)",
+			strings.ToUpper(n.Mechanism), mechanisms)
+	}
+
+	var a smtp.Auth = &plainAuth{username: username, password: password}
+	if n.Mechanism == "login" {
+		a = &loginAuth{username: username, password: password}
+	}
+
+	err = c.Auth(a)
+	if err == nil {
+		c.Quit() // nolint:errcheck,gosec
+		return classifySMTP(235, "")
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return classifySMTP(protoErr.Code, protoErr.Msg)
+	}
+	return nil, err
+}
@@ -21,8 +21,18 @@
 //      "github.com/praetorian-inc/trident/pkg/nozzle"
 //
 //      _ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/generic"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/gitlab"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/jenkins"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/ldap"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/mail"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/ntlm"
 //      _ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
 //      _ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/salesforce"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/vmware"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/windows"
+//      _ "github.com/praetorian-inc/trident/pkg/nozzle/wordpress"
 //  )
 //
 //  noz, err := nozzle.Open("okta", map[string]string{"subdomain":"example"})
@@ -32,12 +42,16 @@
 //  resp, err := noz.Login("username", "password")
 //  // ...
 //
+// Each driver describes the configuration options it accepts, which are listed
+// by Describe.
+//
 // See https://golang.org/doc/effective_go.html#blank_import for more
 // information on "blank imports".
 package nozzle
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/praetorian-inc/trident/pkg/event"
@@ -51,6 +65,16 @@ var (
 // Driver is the interface the wraps creation of a Nozzle.
 type Driver interface {
 	New(opts map[string]string) (Nozzle, error)
+
+	// Describe returns the configuration options accepted by New.
+	Describe() []Option
+}
+
+// Option describes a configuration option accepted by a nozzle.
+type Option struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
 }
 
 // Nozzle is the interface that wraps a basic Login() method to be implemented for
@@ -73,6 +97,41 @@ func Open(name string, opts map[string]string) (Nozzle, error) {
 	return n.New(opts)
 }
 
+// JoinOptions concatenates lists of options, e.g. a nozzle's own options and
+// the shared TLSOptions.
+func JoinOptions(lists ...[]Option) []Option {
+	var opts []Option
+	for _, l := range lists {
+		opts = append(opts, l...)
+	}
+	return opts
+}
+
+// Drivers returns the sorted names of the registered nozzle drivers.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Describe returns the configuration options accepted by the nozzle driver
+// with the provided name.
+func Describe(name string) ([]Option, error) {
+	driversMu.RLock()
+	n, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("nozzle: unknown driver %q (forgotten import?)", name)
+	}
+
+	return n.Describe(), nil
+}
+
 // Register makes a nozzle driver available at the provided name. If register is
 // called twice or if the driver is nil, if panics. Register() is typically
 // called in the nozzle implementation's init() function to allow for easy
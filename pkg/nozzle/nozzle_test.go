@@ -0,0 +1,99 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle_test
+
+import (
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/adfs"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/generic"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/gitlab"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/jenkins"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ldap"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/mail"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/ntlm"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/o365"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/okta"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/salesforce"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/vmware"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/windows"
+	_ "github.com/praetorian-inc/trident/pkg/nozzle/wordpress"
+)
+
+// TestDescribe checks that every driver documents its options and that a
+// driver without required options can be opened without config.
+func TestDescribe(t *testing.T) {
+	names := nozzle.Drivers()
+	if len(names) == 0 {
+		t.Fatal("no drivers registered")
+	}
+	for _, name := range names {
+		opts, err := nozzle.Describe(name)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(opts) == 0 {
+			t.Errorf("%s: no options described", name)
+		}
+
+		seen := make(map[string]bool)
+		required := false
+		for _, opt := range opts {
+			if opt.Name == "" || opt.Description == "" {
+				t.Errorf("%s: incomplete option %+v", name, opt)
+			}
+			if seen[opt.Name] {
+				t.Errorf("%s: option %s described twice", name, opt.Name)
+			}
+			seen[opt.Name] = true
+			required = required || opt.Required
+		}
+
+		_, err = nozzle.Open(name, map[string]string{})
+		if required && err == nil {
+			t.Errorf("%s: opened without its required options", name)
+		}
+		if !required && err != nil {
+			t.Errorf("%s: no required options but opening failed: %s", name, err)
+		}
+	}
+
+	if _, err := nozzle.Describe("nonexistent"); err == nil {
+		t.Error("expected error describing unknown driver")
+	}
+}
+
+// TestProbers checks which drivers can probe a target for endpoints.
+func TestProbers(t *testing.T) {
+	probers := nozzle.Probers()
+	expected := []string{"adfs", "imap", "o365", "smtp"}
+	if len(probers) != len(expected) {
+		t.Fatalf("probers were %v, expected %v", probers, expected)
+	}
+	for i := range expected {
+		if probers[i] != expected[i] {
+			t.Errorf("probers were %v, expected %v", probers, expected)
+		}
+	}
+
+	if _, err := nozzle.Probe("okta", "example.okta.com", nil); err == nil {
+		t.Error("expected error probing with a driver without recon")
+	}
+	if _, err := nozzle.Probe("nonexistent", "example.org", nil); err == nil {
+		t.Error("expected error probing with an unknown driver")
+	}
+}
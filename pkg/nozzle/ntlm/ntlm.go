@@ -0,0 +1,298 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ntlm
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/go-ntlmssp"
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+)
+
+var (
+	// errNoNTLM is returned when the server offers neither NTLM nor Negotiate
+	// authentication
+	errNoNTLM = errors.New("ntlm provider url does not offer ntlm or negotiate authentication")
+
+	// errKerberos is returned when the server offers Negotiate but does not
+	// accept NTLM within it, i.e. it requires Kerberos
+	errKerberos = errors.New("ntlm provider url requires kerberos, which is not supported")
+
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("ntlm", Driver{})
+	nozzle.Register("ntlm-http", Driver{})
+}
+
+// New is used to create an NTLM nozzle, registered as both "ntlm" and
+// "ntlm-http", and accepts the following configuration options:
+//
+// url
+//
+// The URL of an NTLM (or Negotiate) protected resource, for example
+// https://mail.example.org/EWS/Exchange.asmx or
+// https://mail.example.org/autodiscover/autodiscover.xml. Negotiate is only
+// supported with NTLM inside it: Kerberos needs a ticket from the domain's KDC,
+// and a server which requires it fails every attempt with an error.
+//
+// domain
+//
+// The NetBIOS domain name (e.g. "EXAMPLE") sent in the NTLM authenticate
+// message. If omitted, the username is used as-is, which allows usernames in
+// the DOMAIN\user or user@example.org forms.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	rawurl, ok := opts["url"]
+	if !ok {
+		return nil, fmt.Errorf("ntlm nozzle requires 'url' config parameter")
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("ntlm nozzle url must use http or https instead of %s", u.Scheme)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		URL:       u.String(),
+		Domain:    opts["domain"],
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+	}, nil
+}
+
+// Describe returns the configuration options of the NTLM nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "url", Description: "the URL of an NTLM protected resource", Required: true},
+		{Name: "domain", Description: "the NetBIOS domain name sent in the authenticate message"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions)
+}
+
+// Nozzle implements the nozzle.Nozzle interface for NTLM protected HTTP
+// endpoints.
+type Nozzle struct {
+	// URL is the NTLM protected resource
+	URL string
+
+	// Domain is the NetBIOS domain name used during NTLM negotiation
+	Domain string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+}
+
+// qualify prefixes the username with the configured domain unless the username
+// already carries a domain.
+func (n *Nozzle) qualify(username string) string {
+	if n.Domain == "" || strings.ContainsAny(username, `\@`) {
+		return username
+	}
+	return n.Domain + `\` + username
+}
+
+// handshakeTransport records every round trip of a single negotiation so the
+// final response can be attributed to the right step of the handshake.
+type handshakeTransport struct {
+	http.RoundTripper
+	host   string
+	rounds []net.Conn
+}
+
+// RoundTrip implements the http.RoundTripper interface. It refuses to send
+// basic credentials, which the negotiator falls back to when the server does
+// not offer NTLM or Negotiate, or any request to a host other than the
+// configured one, and records the connection used for each round trip.
+func (t *handshakeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.Header.Get("Authorization"), "Basic ") {
+		return nil, errNoNTLM
+	}
+	if req.URL.Host != t.host {
+		return nil, fmt.Errorf("ntlm provider refused to send credentials to %s", req.URL.Host)
+	}
+
+	i := len(t.rounds)
+	t.rounds = append(t.rounds, nil)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.rounds[i] = info.Conn
+		},
+	}
+	return t.RoundTripper.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an NTLM negotiation
+// against the configured URL. The negotiation takes three round trips: an
+// anonymous request, the negotiate message, and the authenticate message. NTLM
+// authenticates the connection rather than the request, so the authenticate
+// message must be sent on the connection which received the challenge; a final
+// 401 on that connection indicates the authenticate message was rejected. Any
+// other outcome of the handshake is an error so it is never mistaken for an
+// invalid credential.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// each attempt receives a fresh transport so an authenticated connection
+	// is never reused by a subsequent credential guess
+	transport := &http.Transport{
+		TLSClientConfig: n.TLSConfig,
+	}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequest("GET", n.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	handshake := &handshakeTransport{RoundTripper: transport, host: req.URL.Host}
+
+	// redirects are never followed, so the credentials are never sent to
+	// another host
+	client := &http.Client{
+		Transport: ntlmssp.Negotiator{
+			RoundTripper: handshake,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req.SetBasicAuth(n.qualify(username), password)
+	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
+	rounds := handshake.rounds
+	switch {
+	case resp.StatusCode == 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+		}, nil
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("unhandled status code from ntlm provider: %d", resp.StatusCode)
+	case len(rounds) == 1 && resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil, fmt.Errorf("ntlm provider url does not require authentication")
+	case len(rounds) == 1 && resp.StatusCode != 401:
+		return nil, fmt.Errorf("unhandled status code from ntlm provider before authentication: %d",
+			resp.StatusCode)
+	case len(rounds) == 1:
+		return nil, errNoNTLM
+	case len(rounds) == 2 && requiresKerberos(resp):
+		return nil, errKerberos
+	case len(rounds) == 2:
+		return nil, fmt.Errorf("ntlm provider did not send a challenge: %d", resp.StatusCode)
+	case rounds[1] == nil || rounds[1] != rounds[2]:
+		return nil, fmt.Errorf("ntlm provider closed the connection during the handshake")
+	case resp.StatusCode == 401:
+		return &event.AuthResponse{
+			Valid: false,
+			Metadata: map[string]interface{}{
+				"status": resp.StatusCode,
+			},
+		}, nil
+	}
+
+	// any other response means the server accepted the authenticate message
+	// (a 403 is an authorization failure for a valid account)
+	return &event.AuthResponse{
+		Valid: true,
+		Metadata: map[string]interface{}{
+			"status": resp.StatusCode,
+		},
+		Capture: nozzle.Capture(resp),
+	}, nil
+}
+
+// requiresKerberos reports whether the server answered the NTLM negotiate
+// message by offering only Negotiate again, without an NTLM challenge.
+func requiresKerberos(resp *http.Response) bool {
+	if resp.StatusCode != 401 {
+		return false
+	}
+	offered := resp.Header.Values("WWW-Authenticate")
+	if len(offered) == 0 {
+		return false
+	}
+	for _, v := range offered {
+		if !strings.EqualFold(strings.TrimSpace(v), "Negotiate") {
+			return false
+		}
+	}
+	return true
+}
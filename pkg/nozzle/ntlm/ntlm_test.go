@@ -0,0 +1,186 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ntlm
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("ntlm", map[string]string{
+		"url":    "https://mail.example.com/EWS/Exchange.asmx",
+		"domain": "EXAMPLE",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	_, err = nozzle.Open("ntlm-http", map[string]string{
+		"url": "https://intranet.example.com/",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle as ntlm-http: %s", err)
+	}
+
+	_, err = nozzle.Open("ntlm", map[string]string{
+		"domain": "EXAMPLE",
+	})
+	if err == nil {
+		t.Fatalf("expected error opening nozzle without url")
+	}
+
+	_, err = nozzle.Open("ntlm", map[string]string{
+		"url": "file:///etc/passwd",
+	})
+	if err == nil {
+		t.Fatalf("expected error opening nozzle with non-http url")
+	}
+}
+
+func TestQualify(t *testing.T) {
+	n := &Nozzle{Domain: "EXAMPLE"}
+	var testcases = []struct {
+		input    string
+		expected string
+	}{
+		{"alice", `EXAMPLE\alice`},
+		{`OTHER\alice`, `OTHER\alice`},
+		{"alice@example.org", "alice@example.org"},
+	}
+	for _, test := range testcases {
+		if got := n.qualify(test.input); got != test.expected {
+			t.Errorf("qualify(%s) was %s, expected %s", test.input, got, test.expected)
+		}
+	}
+}
+
+// challenge returns a minimal NTLM CHALLENGE message with an empty target
+// info list.
+func challenge() string {
+	b := make([]byte, 52)
+	copy(b, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(b[8:], 2)
+	binary.LittleEndian.PutUint32(b[20:], 0x00088201) // unicode, ntlm, ess
+	copy(b[24:], "\x01\x23\x45\x67\x89\xab\xcd\xef")
+	binary.LittleEndian.PutUint16(b[40:], 4)
+	binary.LittleEndian.PutUint16(b[42:], 4)
+	binary.LittleEndian.PutUint32(b[44:], 48)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// ntlmServer emulates the steps of an NTLM protected server, with each
+// behaviour toggled by the test case.
+type ntlmServer struct {
+	basic       bool
+	noChallenge bool
+	closeConn   bool
+	accept      bool
+	kerberos    bool
+
+	// probe is the status of the response to the anonymous request, 401
+	// if 0
+	probe int
+}
+
+func (s *ntlmServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	var msg []byte
+	if strings.HasPrefix(auth, "NTLM ") {
+		msg, _ = base64.StdEncoding.DecodeString(strings.TrimPrefix(auth, "NTLM "))
+	}
+
+	switch {
+	case strings.HasPrefix(auth, "Basic "):
+		panic("basic credentials must never be sent")
+	case auth == "" && s.probe != 0:
+		w.Header().Set("Location", "https://login.example.org/")
+		w.WriteHeader(s.probe)
+		return
+	case s.kerberos:
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+	case auth == "" && s.basic:
+		w.Header().Set("WWW-Authenticate", `Basic realm="example"`)
+	case auth == "":
+		w.Header().Set("WWW-Authenticate", "NTLM")
+	case s.noChallenge:
+		w.Header().Set("WWW-Authenticate", "NTLM")
+	case len(msg) < 12:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	case binary.LittleEndian.Uint32(msg[8:]) == 1:
+		if s.closeConn {
+			w.Header().Set("Connection", "close")
+		}
+		w.Header().Set("WWW-Authenticate", "NTLM "+challenge())
+	case s.accept:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+func TestLogin(t *testing.T) {
+	var testcases = []struct {
+		desc        string
+		server      ntlmServer
+		valid       bool
+		rateLimited bool
+		wantErr     bool
+	}{
+		{"valid", ntlmServer{accept: true}, true, false, false},
+		{"invalid", ntlmServer{}, false, false, false},
+		{"basic only", ntlmServer{basic: true}, false, false, true},
+		{"no challenge", ntlmServer{noChallenge: true}, false, false, true},
+		{"connection closed", ntlmServer{closeConn: true, accept: true}, false, false, true},
+		{"kerberos only", ntlmServer{kerberos: true}, false, false, true},
+		{"no authentication", ntlmServer{probe: http.StatusOK}, false, false, true},
+		{"redirected probe", ntlmServer{probe: http.StatusFound}, false, false, true},
+		{"rate limited probe", ntlmServer{probe: http.StatusTooManyRequests}, false, true, false},
+		{"unavailable probe", ntlmServer{probe: http.StatusServiceUnavailable}, false, false, true},
+	}
+	for _, test := range testcases {
+		server := test.server
+		ts := httptest.NewServer(&server)
+
+		noz, err := Driver{}.New(map[string]string{"url": ts.URL, "domain": "EXAMPLE"})
+		if err != nil {
+			t.Fatalf("unable to create nozzle: %s", err)
+		}
+		resp, err := noz.Login("alice", "Password1")
+		ts.Close()
+
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("[%s] expected error, got %+v", test.desc, resp)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%s] unexpected error: %s", test.desc, err)
+			continue
+		}
+		if resp.Valid != test.valid || resp.RateLimited != test.rateLimited {
+			t.Errorf("[%s] valid was %t and rate limited %t, expected %t and %t", test.desc,
+				resp.Valid, resp.RateLimited, test.valid, test.rateLimited)
+		}
+	}
+}
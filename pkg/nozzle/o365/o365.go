@@ -16,6 +16,7 @@ package o365
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -56,6 +57,26 @@ func init() {
 //
 // The domain to send oauth requests to. This defaults to login.microsoft.com and
 // is unlikely to require configuration.
+//
+// tenant
+//
+// The tenant name or ID, e.g. "example.onmicrosoft.com". When set, attempts are
+// sent to the tenant's token endpoint read from its OpenID Connect discovery
+// document instead of the common endpoint. The document is fetched once and
+// cached for discovery_ttl (default 1h).
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout, discovery_timeout, and submit_timeout options described by
+// nozzle.ParseTimeouts are also accepted.
 func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 	domain, ok := opts["domain"]
 	if !ok {
@@ -63,20 +84,113 @@ func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 		domain = "login.microsoft.com"
 	}
 
+	tenant := opts["tenant"]
+	if strings.ContainsAny(tenant, "/?#") {
+		return nil, fmt.Errorf("invalid o365 tenant %q", tenant)
+	}
+
+	ttl, err := nozzle.DiscoveryTTL(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("o365", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepDiscovery, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Nozzle{
-		Domain:    domain,
-		UserAgent: FrozenUserAgent,
+		Domain:       domain,
+		Tenant:       tenant,
+		DiscoveryTTL: ttl,
+		UserAgent:    FrozenUserAgent,
+		Headers:      headers,
+		TLSConfig:    tlsConfig,
+		Transport:    transport,
+		Timeouts:     timeouts,
 	}, nil
 }
 
+// Describe returns the configuration options of the o365 nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "domain", Description: "the domain oauth requests are sent to, login.microsoft.com by default"},
+		{Name: "tenant", Description: "the tenant whose discovered token endpoint is used, e.g. example.onmicrosoft.com"},
+	}, nozzle.DiscoveryOptions, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepDiscovery, nozzle.StepSubmit))
+}
+
+// legacyEndpoints are the Exchange endpoints which may accept basic or NTLM
+// authentication, bypassing the conditional access and MFA of the tenant.
+var legacyEndpoints = []nozzle.HTTPEndpoint{
+	{Name: "ews", Path: "/EWS/Exchange.asmx"},
+	{Name: "activesync", Path: "/Microsoft-Server-ActiveSync"},
+	{Name: "autodiscover", Path: "/autodiscover/autodiscover.xml"},
+	{Name: "mapi", Path: "/mapi/emsmdb/"},
+	{Name: "rpc", Path: "/rpc/rpcproxy.dll"},
+	{Name: "oab", Path: "/OAB/"},
+	{Name: "powershell", Path: "/powershell/"},
+}
+
+// Recon fulfils the nozzle.Recon interface and probes the Exchange host, by
+// default outlook.office365.com, for the legacy endpoints which accept a
+// password without modern authentication. An on-premises Exchange server
+// published alongside the tenant can be probed by its host name. The TLS
+// options described by New are accepted.
+func (Driver) Recon(host string, opts map[string]string) ([]nozzle.Surface, error) {
+	if host == "" {
+		host = "outlook.office365.com"
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return nozzle.ProbeHTTP(nozzle.ReconClient(tlsConfig), "https://"+host, FrozenUserAgent, legacyEndpoints), nil
+}
+
 // Nozzle implements the nozzle.Nozzle interface for o365.
 type Nozzle struct {
 	// Domain is the O365 domain
 	// "login.microsoft.com" for example
 	Domain string
 
+	// Tenant is the tenant whose token endpoint is discovered, if set
+	Tenant string
+
+	// DiscoveryTTL is how long the discovered token endpoint is cached
+	DiscoveryTTL time.Duration
+
 	// UserAgent will override the Go-http-client user-agent in requests
 	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
 }
 
 // struct for error response from o365
@@ -94,6 +208,7 @@ type o365Error struct {
 
 var (
 	oauth2TokenURL  = "https://%s/common/oauth2/token" // nolint:gosec
+	openIDConfigURL = "https://%s/%s/.well-known/openid-configuration"
 	oauth2TokenBody = "grant_type=password" +
 		"&resource=https://graph.windows.net" +
 		"&client_id=1b730954-1685-4b74-9bfd-dac224a7b894" +
@@ -103,30 +218,91 @@ var (
 		"&scope=openid"
 )
 
+// openIDConfig is the part of the OpenID Connect discovery document used by
+// the nozzle.
+type openIDConfig struct {
+	TokenEndpoint string `json:"token_endpoint"`
+}
+
+// tokenEndpoint returns the tenant's token endpoint from the cached OpenID
+// Connect discovery document.
+func (n *Nozzle) tokenEndpoint(client *http.Client) (string, error) {
+	url := fmt.Sprintf(openIDConfigURL, n.Domain, n.Tenant)
+	v, err := nozzle.Discovery.Get(url, n.DiscoveryTTL, func() (interface{}, error) {
+		req, _ := http.NewRequest("GET", url, nil)
+		req, cancel := n.Timeouts.Request(req, nozzle.StepDiscovery)
+		defer cancel()
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", n.UserAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close() // nolint:errcheck
+
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("unhandled status code from o365 openid configuration: %d", resp.StatusCode)
+		}
+		var config openIDConfig
+		err = json.NewDecoder(resp.Body).Decode(&config)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(config.TokenEndpoint, "https://") {
+			return nil, fmt.Errorf("invalid token endpoint in o365 openid configuration: %q", config.TokenEndpoint)
+		}
+		return config.TokenEndpoint, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return v.(string), nil
+}
+
 func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthResponse, error) {
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+	client := &http.Client{Transport: transport}
+
 	url := fmt.Sprintf(oauth2TokenURL, n.Domain)
+	if n.Tenant != "" {
+		var err error
+		url, err = n.tokenEndpoint(client)
+		if err != nil {
+			return nil, err
+		}
+	}
 	body := fmt.Sprintf(oauth2TokenBody, username, password)
 
 	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	req, cancel := n.Timeouts.Request(req, nozzle.StepSubmit)
+	defer cancel()
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
 	switch resp.StatusCode {
 	// Success: from docs, it seems that 200 always indicates a successful auth attempt
 	case 200:
 		return &event.AuthResponse{
-			Valid: true,
+			Valid:   true,
+			Capture: nozzle.Capture(resp),
 		}, nil
 	// a 400 does not necessarily indicate a failure, we need to check
 	// the response body to be sure
 	case 400, 401:
+		capture := nozzle.Capture(resp)
 		var res o365Error
 		err = json.NewDecoder(resp.Body).Decode(&res)
 		if err != nil {
@@ -136,6 +312,7 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 		valid := false
 		mfa := false
 		locked := false
+		expired := false
 		// extract AADST code supplied in error_description
 		re := regexp.MustCompile("(AADSTS.*?):")
 		matches := re.FindStringSubmatch(res.ErrorDescription)
@@ -177,6 +354,8 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 			locked = true
 		case "AADSTS50055":
 			// InvalidPasswordExpiredPassword - The password is expired.
+			valid = true
+			expired = true
 		case "AADSTS50053":
 			// IdsLocked - The account is locked because the user tried to sign in too many times
 			// with an incorrect user ID or password.
@@ -185,12 +364,14 @@ func (n *Nozzle) oauth2TokenLogin(username, password string) (*event.AuthRespons
 			// UserAccountNotFound - To sign into this application, the account must be added to the directory.
 		}
 		return &event.AuthResponse{
-			Valid:  valid,
-			Locked: locked,
-			MFA:    mfa,
+			Valid:   valid,
+			Locked:  locked,
+			MFA:     mfa,
+			Expired: expired,
 			Metadata: map[string]interface{}{
 				"o365Error": res,
 			},
+			Capture: capture,
 		}, nil
 	}
 
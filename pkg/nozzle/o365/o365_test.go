@@ -17,7 +17,11 @@ package o365
 import (
 	"fmt"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -156,3 +160,95 @@ func TestNozzle(t *testing.T) {
 		}*/
 	}
 }
+
+func TestTenantDiscovery(t *testing.T) {
+	var discoveries int
+	srv := httptest.NewTLSServer(http.NewServeMux())
+	defer srv.Close()
+	mux := srv.Config.Handler.(*http.ServeMux)
+	mux.HandleFunc("/example.onmicrosoft.com/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		discoveries++
+		fmt.Fprintf(w, `{"token_endpoint":"%s/00000000-0000-0000-0000-000000000000/oauth2/token"}`, srv.URL)
+	})
+	mux.HandleFunc("/00000000-0000-0000-0000-000000000000/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant","error_description":"AADSTS50126: Error validating credentials."}`)
+	})
+
+	noz, err := nozzle.Open("o365", map[string]string{
+		"domain":               strings.TrimPrefix(srv.URL, "https://"),
+		"tenant":               "example.onmicrosoft.com",
+		"insecure_skip_verify": "true",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := noz.Login("alice@example.onmicrosoft.com", "Invalid1!")
+		if err != nil {
+			t.Fatalf("error in login: %s", err)
+		}
+		if res.Valid {
+			t.Errorf("invalid login was valid")
+		}
+	}
+	if discoveries != 1 {
+		t.Errorf("openid configuration was fetched %d times", discoveries)
+	}
+
+	_, err = nozzle.Open("o365", map[string]string{"tenant": "example/common"})
+	if err == nil {
+		t.Errorf("expected error for invalid tenant")
+	}
+}
+
+// TestGolden replays the saved responses in testdata through the nozzle. The
+// tenant case fetches the discovery document before the token.
+func TestGolden(t *testing.T) {
+	var testcases = []struct {
+		files   []string
+		tenant  string
+		valid   bool
+		mfa     bool
+		locked  bool
+		expired bool
+	}{
+		{files: []string{"token.http"}, valid: true},
+		{files: []string{"mfa_required.http"}, valid: true, mfa: true},
+		{files: []string{"password_expired.http"}, valid: true, expired: true},
+		{files: []string{"locked.http"}, locked: true},
+		{files: []string{"invalid_password.http"}},
+		{files: []string{"user_not_found.http"}},
+		{files: []string{"openid_configuration.http", "token.http"}, tenant: "golden.onmicrosoft.com", valid: true},
+	}
+	for _, test := range testcases {
+		opts := map[string]string{"domain": "login.microsoftonline.com"}
+		if test.tenant != "" {
+			opts["tenant"] = test.tenant
+		}
+		noz, err := nozzle.Open("o365", opts)
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		paths := make([]string, len(test.files))
+		for i, file := range test.files {
+			paths[i] = filepath.Join("testdata", file)
+		}
+		r, err := nozzle.ReadReplayer(paths...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice@example.onmicrosoft.com", "Password1")
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", test.files, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked || res.Expired != test.expired {
+			t.Errorf("%v: got %+v", test.files, res)
+		}
+		if len(r.Requests) != len(test.files) {
+			t.Errorf("%v: unexpected requests: %v", test.files, r.Requests)
+		}
+	}
+}
@@ -17,6 +17,7 @@ package okta
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -56,18 +57,53 @@ func init() {
 //
 // The subdomain of the Okta organization. If a user logs in at
 // example.okta.com, the value of subdomain is "example".
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
 func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
 	subdomain, ok := opts["subdomain"]
 	if !ok {
 		return nil, fmt.Errorf("okta nozzle requires 'subdomain' config parameter")
 	}
 
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("okta", opts)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Nozzle{
 		Subdomain: subdomain,
 		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
 	}, nil
 }
 
+// Describe returns the configuration options of the Okta nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "subdomain", Description: "the Okta subdomain, \"example\" for example.okta.com", Required: true},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions)
+}
+
 // Nozzle implements the nozzle.Nozzle interface for Okta.
 type Nozzle struct {
 	// Subdomain is the Okta subdomain
@@ -75,6 +111,15 @@ type Nozzle struct {
 
 	// UserAgent will override the Go-http-client user-agent in requests
 	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
 }
 
 type oktaAuthResponse struct {
@@ -110,15 +155,25 @@ func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
+
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
 
-	resp, err := http.DefaultClient.Do(req)
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
 	switch resp.StatusCode {
 	case 200:
+		capture := nozzle.Capture(resp)
 		var res oktaAuthResponse
 		err = json.NewDecoder(resp.Body).Decode(&res)
 		if err != nil {
@@ -129,7 +184,9 @@ func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
 			Valid:    res.Status != "LOCKED_OUT",
 			MFA:      res.Status == "MFA_REQUIRED",
 			Locked:   res.Status == "LOCKED_OUT",
+			Expired:  res.Status == "PASSWORD_EXPIRED",
 			Metadata: res.Embedded,
+			Capture:  capture,
 		}, nil
 	case 401:
 		return &event.AuthResponse{
@@ -138,6 +195,7 @@ func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
 	case 429:
 		return &event.AuthResponse{
 			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
 		}, nil
 	}
 
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -107,3 +108,47 @@ func TestNozzle(t *testing.T) {
 		}
 	}
 }
+
+// TestGolden replays the saved responses in testdata through the nozzle.
+func TestGolden(t *testing.T) {
+	noz, err := nozzle.Open("okta", map[string]string{
+		"subdomain": "example",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	var testcases = []struct {
+		file        string
+		valid       bool
+		mfa         bool
+		locked      bool
+		expired     bool
+		ratelimited bool
+	}{
+		{file: "success.http", valid: true},
+		{file: "mfa_required.http", valid: true, mfa: true},
+		{file: "password_expired.http", valid: true, expired: true},
+		{file: "locked_out.http", locked: true},
+		{file: "authentication_failed.http"},
+		{file: "rate_limited.http", ratelimited: true},
+	}
+	for _, test := range testcases {
+		r, err := nozzle.ReadReplayer(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice@example.org", "Password1")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.file, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.Locked != test.locked ||
+			res.Expired != test.expired || res.RateLimited != test.ratelimited {
+			t.Errorf("%s: got %+v", test.file, res)
+		}
+		if len(r.Requests) != 1 || r.Requests[0] != "POST https://example.okta.com/api/v1/authn" {
+			t.Errorf("%s: unexpected requests: %v", test.file, r.Requests)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReconTimeout bounds each request sent while probing a target.
+var ReconTimeout = 15 * time.Second
+
+// Surface is an authentication endpoint found while probing a target.
+type Surface struct {
+	// Name identifies the endpoint, e.g. "ews" or "imap"
+	Name string `json:"name"`
+
+	// Endpoint is the URL or address probed
+	Endpoint string `json:"endpoint"`
+
+	// Exposed is true if the endpoint answered and is enabled
+	Exposed bool `json:"exposed"`
+
+	// Auth lists the authentication schemes or mechanisms offered
+	Auth []string `json:"auth,omitempty"`
+
+	// Legacy is true if the endpoint accepts a password on its own, such as
+	// with basic authentication, so MFA enforced by the identity provider
+	// may not apply to it
+	Legacy bool `json:"legacy"`
+
+	// Detail explains the verdict, e.g. the status code or the error
+	Detail string `json:"detail,omitempty"`
+}
+
+// Recon is implemented by drivers which can probe a target for alternative,
+// often legacy, authentication endpoints before a campaign targets it.
+type Recon interface {
+	// Recon probes the host and returns the endpoints it checked. Errors
+	// reaching a single endpoint are reported in its Surface.
+	Recon(host string, opts map[string]string) ([]Surface, error)
+}
+
+// Probe probes the host for the authentication endpoints known to the driver
+// with the provided name. It returns an error if the driver cannot probe.
+func Probe(name, host string, opts map[string]string) ([]Surface, error) {
+	driversMu.RLock()
+	n, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("nozzle: unknown driver %q (forgotten import?)", name)
+	}
+
+	r, ok := n.(Recon)
+	if !ok {
+		return nil, fmt.Errorf("nozzle: driver %q cannot probe for endpoints", name)
+	}
+	return r.Recon(host, opts)
+}
+
+// Probers returns the sorted names of the registered drivers which implement
+// Recon.
+func Probers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	var names []string
+	for name, n := range drivers {
+		if _, ok := n.(Recon); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReconClient returns the client used to probe HTTP endpoints. Redirects are
+// returned rather than followed, since a redirect to a sign-in page shows the
+// endpoint does not take a password itself.
+func ReconClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: ReconTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// HTTPEndpoint is an HTTP authentication endpoint checked by ProbeHTTP.
+type HTTPEndpoint struct {
+	// Name identifies the endpoint
+	Name string
+
+	// Path is appended to the base URL
+	Path string
+
+	// Legacy is true if the endpoint accepts a password on its own whenever
+	// it is enabled, whatever scheme it offers, as WS-Trust endpoints do
+	Legacy bool
+}
+
+// legacySchemes are the HTTP authentication schemes which accept a password
+// without a browser, and so without the MFA prompt of the identity provider.
+var legacySchemes = map[string]bool{
+	"basic":     true,
+	"ntlm":      true,
+	"negotiate": true,
+}
+
+// ProbeHTTP sends a GET request to each endpoint under the base URL and
+// reports which are enabled and the authentication schemes they offer. An
+// endpoint is disabled if it cannot be reached or answers 404, 410, or 503.
+func ProbeHTTP(client *http.Client, baseURL, userAgent string, endpoints []HTTPEndpoint) []Surface {
+	surfaces := make([]Surface, 0, len(endpoints))
+	for _, e := range endpoints {
+		s := Surface{
+			Name:     e.Name,
+			Endpoint: baseURL + e.Path,
+		}
+
+		req, err := http.NewRequest("GET", s.Endpoint, nil)
+		if err != nil {
+			s.Detail = err.Error()
+			surfaces = append(surfaces, s)
+			continue
+		}
+		req.Header.Set("User-Agent", userAgent)
+		resp, err := client.Do(req)
+		if err != nil {
+			s.Detail = err.Error()
+			surfaces = append(surfaces, s)
+			continue
+		}
+		resp.Body.Close() // nolint:errcheck,gosec
+
+		classifyProbe(&s, e, resp)
+		surfaces = append(surfaces, s)
+	}
+	return surfaces
+}
+
+// classifyProbe fills in the surface from the response to its probe.
+func classifyProbe(s *Surface, e HTTPEndpoint, resp *http.Response) {
+	s.Detail = fmt.Sprintf("status %d", resp.StatusCode)
+	switch resp.StatusCode {
+	case 404, 410, 503:
+		return
+	case 301, 302, 303, 307, 308:
+		// the endpoint sends clients to a sign-in page
+		s.Exposed = true
+		s.Detail += " to " + resp.Header.Get("Location")
+		return
+	}
+	s.Exposed = true
+
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		for _, challenge := range strings.Split(v, ",") {
+			fields := strings.Fields(challenge)
+			if len(fields) == 0 || strings.Contains(fields[0], "=") {
+				// a parameter of the previous challenge
+				continue
+			}
+			s.Auth = append(s.Auth, fields[0])
+			if legacySchemes[strings.ToLower(fields[0])] {
+				s.Legacy = true
+			}
+		}
+	}
+	if e.Legacy {
+		s.Legacy = true
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/basic":
+			w.Header().Add("WWW-Authenticate", `Bearer authorization_uri="https://login.example.org", Basic realm="x"`)
+			w.Header().Add("WWW-Authenticate", "NTLM")
+			w.WriteHeader(401)
+		case "/bearer":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="x", error="invalid_token"`)
+			w.WriteHeader(401)
+		case "/soap":
+			w.WriteHeader(400)
+		case "/redirect":
+			http.Redirect(w, r, "/signin", http.StatusFound)
+		case "/disabled":
+			w.WriteHeader(503)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	surfaces := ProbeHTTP(ReconClient(nil), srv.URL, "test", []HTTPEndpoint{
+		{Name: "basic", Path: "/basic"},
+		{Name: "bearer", Path: "/bearer"},
+		{Name: "soap", Path: "/soap", Legacy: true},
+		{Name: "redirect", Path: "/redirect", Legacy: true},
+		{Name: "disabled", Path: "/disabled", Legacy: true},
+		{Name: "missing", Path: "/missing"},
+	})
+
+	var testcases = []struct {
+		exposed bool
+		legacy  bool
+		auth    []string
+	}{
+		{true, true, []string{"Bearer", "Basic", "NTLM"}},
+		{true, false, []string{"Bearer"}},
+		{true, true, nil},
+		{true, false, nil},
+		{false, false, nil},
+		{false, false, nil},
+	}
+	if len(surfaces) != len(testcases) {
+		t.Fatalf("expected %d surfaces, got %d", len(testcases), len(surfaces))
+	}
+	for i, test := range testcases {
+		s := surfaces[i]
+		if s.Exposed != test.exposed || s.Legacy != test.legacy {
+			t.Errorf("%s: got %+v", s.Name, s)
+		}
+		if len(s.Auth) != len(test.auth) {
+			t.Errorf("%s: auth was %v, expected %v", s.Name, s.Auth, test.auth)
+			continue
+		}
+		for j := range test.auth {
+			if s.Auth[j] != test.auth[j] {
+				t.Errorf("%s: auth was %v, expected %v", s.Name, s.Auth, test.auth)
+			}
+		}
+	}
+
+	// an unreachable endpoint is reported rather than failing the probe
+	surfaces = ProbeHTTP(ReconClient(nil), "http://127.0.0.1:1", "test", []HTTPEndpoint{{Name: "down", Path: "/"}})
+	if len(surfaces) != 1 || surfaces[0].Exposed || surfaces[0].Detail == "" {
+		t.Errorf("unexpected surface for an unreachable endpoint: %+v", surfaces)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+var (
+	// replayMu serializes replays, since the replayer is shared by every
+	// transport
+	replayMu sync.Mutex
+
+	// replay answers the requests of every transport returned by
+	// RoundTripper while set, guarded by poolsMu
+	replay http.RoundTripper
+)
+
+// Replayer is an http.RoundTripper which answers each request with the next
+// of a list of saved responses instead of sending it.
+type Replayer struct {
+	mu        sync.Mutex
+	responses [][]byte
+
+	// Requests lists the method and URL of each request answered so far
+	Requests []string
+}
+
+// NewReplayer returns a Replayer answering with the saved responses, in
+// order. Each response is parsed by ParseResponse.
+func NewReplayer(responses ...[]byte) *Replayer {
+	return &Replayer{responses: responses}
+}
+
+// ReadReplayer returns a Replayer answering with the responses saved in the
+// files, in order.
+func ReadReplayer(paths ...string) (*Replayer, error) {
+	responses := make([][]byte, 0, len(paths))
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path) // nolint:gosec
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, b)
+	}
+	return NewReplayer(responses...), nil
+}
+
+// RoundTrip fulfils the http.RoundTripper interface.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(r.Requests)
+	if n >= len(r.responses) {
+		return nil, fmt.Errorf("no saved response left for %s %s", req.Method, req.URL)
+	}
+	r.Requests = append(r.Requests, req.Method+" "+req.URL.String())
+	resp, err := ParseResponse(r.responses[n], req)
+	if err != nil {
+		return nil, fmt.Errorf("saved response %d: %w", n+1, err)
+	}
+	return resp, nil
+}
+
+// ParseResponse parses a saved response to the request. The response is
+// either the raw HTTP response, as printed by curl -i or copied from an
+// intercepting proxy, or the capture of a valid result as JSON. Any
+// Content-Length or Transfer-Encoding header is dropped, so the body can be
+// edited freely, and lines may end in LF alone.
+func ParseResponse(b []byte, req *http.Request) (*http.Response, error) {
+	if trimmed := bytes.TrimSpace(b); bytes.HasPrefix(trimmed, []byte("{")) {
+		var c event.Capture
+		err := json.Unmarshal(trimmed, &c)
+		if err != nil || c.Status == 0 {
+			return nil, fmt.Errorf("not a capture: %v", err)
+		}
+		resp := &http.Response{
+			Status:     strconv.Itoa(c.Status) + " " + http.StatusText(c.Status),
+			StatusCode: c.Status,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header(c.Headers),
+			Request:    req,
+		}
+		if resp.Header == nil {
+			resp.Header = http.Header{}
+		}
+		setBody(resp, []byte(c.Body))
+		return resp, nil
+	}
+
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	head, body := b, []byte(nil)
+	if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		head, body = b[:i], b[i+2:]
+	}
+
+	var lines []string
+	for i, line := range strings.Split(string(head), "\n") {
+		if i == 0 && strings.HasPrefix(line, "HTTP/2 ") {
+			// curl prints the HTTP/2 version without a minor version
+			line = "HTTP/2.0 " + strings.TrimPrefix(line, "HTTP/2 ")
+		}
+		name := strings.ToLower(strings.SplitN(line, ":", 2)[0])
+		if i > 0 && (name == "content-length" || name == "transfer-encoding") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(strings.Join(lines, "\r\n")+"\r\n\r\n")), req)
+	if err != nil {
+		return nil, err
+	}
+	setBody(resp, body)
+	return resp, nil
+}
+
+// setBody replaces the body of the response.
+func setBody(resp *http.Response, body []byte) {
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+}
+
+// ReplayLogin attempts the login with the nozzle while every request sent
+// through a transport from RoundTripper is answered by the replayer instead
+// of the network, so a provider's classification of saved responses can be
+// checked offline. Nozzles which do not send their requests through
+// RoundTripper, such as ldap or smb, are not replayed and must not be passed
+// to it. Replays are serialized, and are never meant to run on a worker,
+// since its attempts would be answered too.
+func ReplayLogin(noz Nozzle, r *Replayer, username, password string) (*event.AuthResponse, error) {
+	replayMu.Lock()
+	defer replayMu.Unlock()
+
+	poolsMu.Lock()
+	replay = r
+	poolsMu.Unlock()
+	defer func() {
+		poolsMu.Lock()
+		replay = nil
+		poolsMu.Unlock()
+	}()
+
+	res, err := noz.Login(username, password)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.Requests) == 0 && err == nil {
+		return nil, fmt.Errorf("the login did not send a request to replay")
+	}
+	return res, err
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestParseResponse(t *testing.T) {
+	var testcases = []struct {
+		name     string
+		saved    string
+		status   int
+		location string
+		body     string
+	}{
+		{"crlf", "HTTP/1.1 401 Unauthorized\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{\"a\":1}",
+			401, "", `{"a":1}`},
+		{"lf", "HTTP/1.1 302 Found\nLocation: /loginError\nTransfer-Encoding: chunked\n\n", 302, "/loginError", ""},
+		{"curl http2", "HTTP/2 200\ncontent-type: text/html\n\n<html></html>\n", 200, "", "<html></html>\n"},
+		{"capture", `{"status":302,"headers":{"Location":["/dashboard"]},"body":"ok"}`, 302, "/dashboard", "ok"},
+	}
+	req, _ := http.NewRequest("POST", "https://example.org/login", nil)
+	for _, test := range testcases {
+		resp, err := ParseResponse([]byte(test.saved), req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil || string(b) != test.body {
+			t.Errorf("%s: body was %q, %v", test.name, b, err)
+		}
+		if resp.StatusCode != test.status || resp.Header.Get("Location") != test.location {
+			t.Errorf("%s: got %d %v", test.name, resp.StatusCode, resp.Header)
+		}
+	}
+
+	for _, saved := range []string{"", "not a response", `{"body":"no status"}`} {
+		if _, err := ParseResponse([]byte(saved), req); err == nil {
+			t.Errorf("expected error parsing %q", saved)
+		}
+	}
+}
+
+func TestReplayer(t *testing.T) {
+	r := NewReplayer([]byte("HTTP/1.1 200 OK\n\nfirst"))
+	client := &http.Client{Transport: r}
+	resp, err := client.Get("https://example.org/one")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close() // nolint:errcheck,gosec
+
+	_, err = client.Get("https://example.org/two")
+	if err == nil {
+		t.Errorf("expected error once the saved responses ran out")
+	}
+	if len(r.Requests) != 1 || r.Requests[0] != "GET https://example.org/one" {
+		t.Errorf("unexpected requests: %v", r.Requests)
+	}
+}
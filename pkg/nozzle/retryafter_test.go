@@ -0,0 +1,48 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 9, 10, 14, 0, 0, 0, time.UTC)
+	var testcases = []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"0", 0},
+		{"-30", 0},
+		{"99999999999999", maxRetryAfter},
+		{"Thu, 10 Sep 2020 14:05:00 GMT", 5 * time.Minute},
+		{"Thu, 10 Sep 2020 13:55:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, test := range testcases {
+		header := http.Header{}
+		if test.value != "" {
+			header.Set("Retry-After", test.value)
+		}
+		if d := RetryAfter(header, now); d != test.expected {
+			t.Errorf("RetryAfter(%q) was %s, expected %s", test.value, d, test.expected)
+		}
+	}
+}
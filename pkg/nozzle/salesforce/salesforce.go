@@ -0,0 +1,332 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package salesforce
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+	"github.com/praetorian-inc/trident/pkg/util"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// defaultHost is the production login host
+	defaultHost = "login.salesforce.com"
+
+	// defaultAPIVersion is the version of the partner SOAP API used to log in
+	defaultAPIVersion = "49.0"
+
+	// bodyLimit bounds how much of a response is read
+	bodyLimit = 1 << 20
+
+	// loginTemplate is the partner API login request
+	loginTemplate = `<?xml version="1.0" encoding="utf-8"?>
+<env:Envelope xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:env="http://schemas.xmlsoap.org/soap/envelope/">
+<env:Body><n1:login xmlns:n1="urn:partner.soap.sforce.com"><n1:username>%s</n1:username><n1:password>%s</n1:password></n1:login></env:Body>
+</env:Envelope>`
+)
+
+// SOAP fault codes of a failed login (the ExceptionCode enum of the partner
+// API).
+const (
+	faultInvalidLogin    = "INVALID_LOGIN"
+	faultSecurityToken   = "LOGIN_MUST_USE_SECURITY_TOKEN"
+	faultChallengeIssued = "LOGIN_CHALLENGE_ISSUED"
+	faultPasswordLockout = "PASSWORD_LOCKOUT"
+	faultLoginRate       = "LOGIN_RATE_EXCEEDED"
+	faultRequestLimit    = "REQUEST_LIMIT_EXCEEDED"
+	faultAPIDisabled     = "API_CURRENTLY_DISABLED"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("salesforce", Driver{})
+}
+
+// New is used to create a Salesforce nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The login host, "login.salesforce.com" (the default) for production orgs,
+// "test.salesforce.com" for sandboxes, or the org's My Domain such as
+// "example.my.salesforce.com". It must end with ".salesforce.com".
+//
+// api_version
+//
+// The version of the partner SOAP API, "49.0" by default.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	host := opts["host"]
+	if host == "" {
+		host = defaultHost
+	}
+	version := opts["api_version"]
+	if version == "" {
+		version = defaultAPIVersion
+	}
+
+	url := fmt.Sprintf("https://%s/services/Soap/u/%s", host, version)
+	err := util.ValidateURLSuffix(url, ".salesforce.com")
+	if err != nil {
+		return nil, fmt.Errorf("salesforce nozzle 'host' is invalid: %w", err)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("salesforce", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		URL:       url,
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+	}, nil
+}
+
+// Describe returns the configuration options of the Salesforce nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the login host, login.salesforce.com by default"},
+		{Name: "api_version", Description: "the version of the partner SOAP API, 49.0 by default"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions)
+}
+
+// Nozzle implements the nozzle.Nozzle interface for Salesforce.
+type Nozzle struct {
+	// URL is the partner SOAP API endpoint of the login host
+	URL string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+}
+
+// loginEnvelope is the response to a login request, either a loginResponse or
+// a fault.
+type loginEnvelope struct {
+	Body struct {
+		Fault *struct {
+			Code   string `xml:"faultcode"`
+			String string `xml:"faultstring"`
+		} `xml:"Fault"`
+		LoginResponse *struct {
+			Result struct {
+				PasswordExpired bool   `xml:"passwordExpired"`
+				UserID          string `xml:"userId"`
+				OrganizationID  string `xml:"userInfo>organizationId"`
+			} `xml:"result"`
+		} `xml:"loginResponse"`
+	} `xml:"Body"`
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs a partner SOAP API
+// login. Salesforce reports a wrong password and a locked account with the same
+// INVALID_LOGIN fault, so locked accounts are only reported as such when the
+// org returns PASSWORD_LOCKOUT.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := fmt.Sprintf(loginTemplate, escape(username), escape(password))
+	req, err := http.NewRequest("POST", n.URL, bytes.NewBufferString(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+	req.Header.Set("SOAPAction", "login")
+	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
+
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return res, nil
+	}
+
+	capture := nozzle.Capture(resp)
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := classify(resp.StatusCode, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.RateLimited {
+		res.RetryAfter = nozzle.RetryAfter(resp.Header, time.Now())
+	}
+	if res.Valid {
+		res.Capture = capture
+	}
+	return res, nil
+}
+
+// classify maps the status code and body of a login response onto an
+// AuthResponse.
+func classify(status int, body []byte) (*event.AuthResponse, error) {
+	if status == http.StatusTooManyRequests {
+		return &event.AuthResponse{
+			RateLimited: true,
+		}, nil
+	}
+
+	var env loginEnvelope
+	err := xml.Unmarshal(body, &env)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse salesforce response (%d): %w", status, err)
+	}
+
+	if lr := env.Body.LoginResponse; status == http.StatusOK && lr != nil {
+		return &event.AuthResponse{
+			Valid:   true,
+			Expired: lr.Result.PasswordExpired,
+			Metadata: map[string]interface{}{
+				"user_id":         lr.Result.UserID,
+				"organization_id": lr.Result.OrganizationID,
+			},
+		}, nil
+	}
+
+	if env.Body.Fault == nil {
+		return nil, fmt.Errorf("unhandled status code from salesforce provider: %d", status)
+	}
+
+	// fault codes are qualified with the sf: namespace prefix
+	code := env.Body.Fault.Code
+	if i := strings.LastIndex(code, ":"); i >= 0 {
+		code = code[i+1:]
+	}
+	metadata := map[string]interface{}{
+		"fault": code,
+	}
+
+	switch code {
+	case faultInvalidLogin:
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case faultSecurityToken:
+		// the password is correct, but the login comes from outside the
+		// org's trusted IP ranges and must append the user's security token
+		metadata["reason"] = "security_token_required"
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case faultChallengeIssued:
+		metadata["reason"] = "identity_verification"
+		return &event.AuthResponse{
+			Valid:    true,
+			MFA:      true,
+			Metadata: metadata,
+		}, nil
+	case faultAPIDisabled:
+		// reported once the credential has been verified, for users whose
+		// profile does not allow API access
+		metadata["reason"] = "api_disabled"
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case faultPasswordLockout:
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case faultLoginRate, faultRequestLimit:
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unhandled fault from salesforce provider: %s: %s", code, env.Body.Fault.String)
+}
+
+// escape returns s escaped for use as XML character data.
+func escape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package salesforce
+
+import (
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	for _, host := range []string{"", "test.salesforce.com", "example.my.salesforce.com"} {
+		_, err := nozzle.Open("salesforce", map[string]string{
+			"host": host,
+		})
+		if err != nil {
+			t.Errorf("unable to open nozzle with host %q: %s", host, err)
+		}
+	}
+
+	for _, host := range []string{"evil.org", "salesforce.com.evil.org", "evil.org#.salesforce.com"} {
+		_, err := nozzle.Open("salesforce", map[string]string{
+			"host": host,
+		})
+		if err == nil {
+			t.Errorf("expected error opening nozzle with host %q", host)
+		}
+	}
+}
+
+// fault renders a SOAP fault with the fault code.
+func fault(code string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns:sf="urn:fault.partner.soap.sforce.com">
+<soapenv:Body><soapenv:Fault><faultcode>sf:%s</faultcode>
+<faultstring>%s: Invalid username, password, security token; or user locked out.</faultstring>
+</soapenv:Fault></soapenv:Body></soapenv:Envelope>`, code, code)
+}
+
+// loginResponse renders a successful login response.
+func loginResponse(expired bool) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/" xmlns="urn:partner.soap.sforce.com">
+<soapenv:Body><loginResponse><result>
+<passwordExpired>%t</passwordExpired><sessionId>00Dxx0000001gPL!AR8AQ</sessionId>
+<userId>005xx000001Sv6eAAC</userId><userInfo><organizationId>00Dxx0000001gPLEAY</organizationId></userInfo>
+</result></loginResponse></soapenv:Body></soapenv:Envelope>`, expired)
+}
+
+func TestClassify(t *testing.T) {
+	var testcases = []struct {
+		name        string
+		status      int
+		body        string
+		valid       bool
+		expired     bool
+		mfa         bool
+		locked      bool
+		ratelimited bool
+		reason      string
+		wantErr     bool
+	}{
+		{"valid", 200, loginResponse(false), true, false, false, false, false, "", false},
+		{"expired", 200, loginResponse(true), true, true, false, false, false, "", false},
+		{"invalid", 500, fault(faultInvalidLogin), false, false, false, false, false, "", false},
+		{"security token", 500, fault(faultSecurityToken), true, false, false, false, false, "security_token_required", false},
+		{"challenge", 500, fault(faultChallengeIssued), true, false, true, false, false, "identity_verification", false},
+		{"api disabled", 500, fault(faultAPIDisabled), true, false, false, false, false, "api_disabled", false},
+		{"locked", 500, fault(faultPasswordLockout), false, false, false, true, false, "", false},
+		{"rate limited", 500, fault(faultLoginRate), false, false, false, false, true, "", false},
+		{"too many requests", 429, "", false, false, false, false, true, "", false},
+		{"unknown fault", 500, fault("UNSUPPORTED_CLIENT"), false, false, false, false, false, "", true},
+		{"not soap", 502, "<html>bad gateway</html>", false, false, false, false, false, "", true},
+	}
+	for _, test := range testcases {
+		res, err := classify(test.status, []byte(test.body))
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.name, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Expired != test.expired || res.MFA != test.mfa ||
+			res.Locked != test.locked || res.RateLimited != test.ratelimited {
+			t.Errorf("%s: got %+v", test.name, res)
+		}
+		if reason, _ := res.Metadata["reason"].(string); reason != test.reason {
+			t.Errorf("%s: reason was %q, expected %q", test.name, reason, test.reason)
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Username string `xml:"Body>login>username"`
+			Password string `xml:"Body>login>password"`
+		}
+		err := xml.NewDecoder(r.Body).Decode(&req)
+		if err != nil || r.Header.Get("SOAPAction") != "login" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if req.Username == "alice@example.org" && req.Password == "<Summer&2020>" {
+			fmt.Fprint(w, loginResponse(false))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, fault(faultInvalidLogin))
+	}))
+	defer ts.Close()
+
+	n := &Nozzle{
+		URL:       ts.URL + "/services/Soap/u/" + defaultAPIVersion,
+		UserAgent: FrozenUserAgent,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+	}
+
+	res, err := n.Login("alice@example.org", "<Summer&2020>")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Valid || res.Metadata["user_id"] != "005xx000001Sv6eAAC" {
+		t.Errorf("expected valid credential, got %+v", res)
+	}
+
+	res, err = n.Login("alice@example.org", "Password1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Valid {
+		t.Errorf("expected invalid credential, got %+v", res)
+	}
+}
+
+// TestGolden replays the saved responses in testdata through the nozzle.
+func TestGolden(t *testing.T) {
+	noz, err := nozzle.Open("salesforce", map[string]string{})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	var testcases = []struct {
+		file        string
+		valid       bool
+		locked      bool
+		ratelimited bool
+		reason      string
+	}{
+		{file: "login.http", valid: true},
+		{file: "security_token.http", valid: true, reason: "security_token_required"},
+		{file: "invalid_login.http"},
+		{file: "password_lockout.http", locked: true},
+		{file: "login_rate.http", ratelimited: true},
+	}
+	for _, test := range testcases {
+		r, err := nozzle.ReadReplayer(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice@example.org", "Password1")
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.file, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked != test.locked || res.RateLimited != test.ratelimited {
+			t.Errorf("%s: got %+v", test.file, res)
+		}
+		if reason, _ := res.Metadata["reason"].(string); reason != test.reason {
+			t.Errorf("%s: reason was %q, expected %q", test.file, reason, test.reason)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the context a Template is executed with for each attempt.
+type TemplateData struct {
+	// Username is the username of the attempt
+	Username string
+
+	// Password is the password of the attempt
+	Password string
+
+	// Vars are the variables captured for the attempt, such as a CSRF token
+	// taken from a login form fetched before the login request
+	Vars map[string]string
+}
+
+// Template is a request template from a nozzle's configuration, such as a
+// request body, header value, or URL. It uses the text/template syntax and is
+// executed once per attempt, e.g.
+//
+//  {"user": {{json .Username}}, "auth": "{{b64 .Username ":" .Password}}"}
+//
+// and a captured variable is referenced as {{.Vars.name}}, e.g.
+//
+//  csrf_token={{urlencode .Vars.csrf}}&username={{urlencode .Username}}
+//
+// The following functions are available in addition to the text/template
+// builtins:
+//
+//  b64 ARGS...      standard base64 of the concatenated arguments
+//  b64url ARGS...   unpadded URL-safe base64 of the concatenated arguments
+//  urlencode S      S escaped for a URL query or form value
+//  htmlunescape S   S with HTML entities such as &amp; decoded
+//  json S           S as a quoted JSON string
+//  now              the current time in RFC3339 format (UTC)
+//  unix             the current time in seconds since the Unix epoch
+//  unixms           the current time in milliseconds since the Unix epoch
+//  uuid             a random version 4 UUID
+//  nonce N          N random bytes, hex encoded
+//
+// Referencing a field that TemplateData does not have, or a variable that was
+// not declared, is an error when the template is parsed.
+type Template struct {
+	tmpl *template.Template
+}
+
+// templateFuncs are the functions available to a Template.
+var templateFuncs = template.FuncMap{
+	"b64": func(s ...string) string {
+		return base64.StdEncoding.EncodeToString([]byte(strings.Join(s, "")))
+	},
+	"b64url": func(s ...string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(s, "")))
+	},
+	"urlencode":    url.QueryEscape,
+	"htmlunescape": html.UnescapeString,
+	"json": func(s string) (string, error) {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(s)
+		return strings.TrimSuffix(b.String(), "\n"), err
+	},
+	"now": func() string {
+		return time.Now().UTC().Format(time.RFC3339)
+	},
+	"unix": func() int64 {
+		return time.Now().Unix()
+	},
+	"unixms": func() int64 {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	},
+	"uuid":  newUUID,
+	"nonce": nonce,
+}
+
+// ParseTemplate parses the template text of the named config option. Vars are
+// the names of the variables the template may reference.
+func ParseTemplate(name, text string, vars ...string) (*Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template in %s: %w", name, err)
+	}
+
+	// executing against zero values catches references to unknown fields
+	// and variables before the first attempt
+	data := TemplateData{Vars: make(map[string]string, len(vars))}
+	for _, v := range vars {
+		data.Vars[v] = ""
+	}
+	err = tmpl.Execute(new(bytes.Buffer), data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template in %s: %w", name, err)
+	}
+	return &Template{tmpl: tmpl}, nil
+}
+
+// Execute renders the template for an attempt.
+func (t *Template) Execute(data TemplateData) (string, error) {
+	var b bytes.Buffer
+	err := t.tmpl.Execute(&b, data)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// nonce returns n random bytes, hex encoded.
+func nonce(n int) (string, error) {
+	if n <= 0 || n > 1024 {
+		return "", fmt.Errorf("nonce length %d is out of range", n)
+	}
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestTemplate(t *testing.T) {
+	data := TemplateData{Username: "alice@example.org", Password: `Pa"ss w0rd&`}
+
+	var testcases = []struct {
+		text     string
+		expected string
+	}{
+		{"{{.Username}}", "alice@example.org"},
+		{`{{b64 .Username ":" .Password}}`, "YWxpY2VAZXhhbXBsZS5vcmc6UGEic3MgdzByZCY="},
+		{"{{b64url .Password}}", "UGEic3MgdzByZCY"},
+		{"user={{urlencode .Username}}&pass={{urlencode .Password}}", "user=alice%40example.org&pass=Pa%22ss+w0rd%26"},
+		{`{"password": {{json .Password}}}`, `{"password": "Pa\"ss w0rd&"}`},
+	}
+	for _, test := range testcases {
+		tmpl, err := ParseTemplate("body", test.text)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.text, err)
+			continue
+		}
+		out, err := tmpl.Execute(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.text, err)
+			continue
+		}
+		if out != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.text, out, test.expected)
+		}
+	}
+
+	var patterns = map[string]string{
+		"{{now}}":     `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$`,
+		"{{unix}}":    `^\d{10}$`,
+		"{{unixms}}":  `^\d{13}$`,
+		"{{uuid}}":    `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`,
+		"{{nonce 8}}": `^[0-9a-f]{16}$`,
+	}
+	for text, pattern := range patterns {
+		tmpl, err := ParseTemplate("body", text)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", text, err)
+			continue
+		}
+		out, err := tmpl.Execute(data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", text, err)
+			continue
+		}
+		if !regexp.MustCompile(pattern).MatchString(out) {
+			t.Errorf("%s: %q does not match %s", text, out, pattern)
+		}
+	}
+
+	for _, text := range []string{"{{.Email}}", "{{b64 .Username", "{{sha1 .Password}}", "{{nonce 0}}", "{{.Vars.csrf}}"} {
+		_, err := ParseTemplate("body", text)
+		if err == nil {
+			t.Errorf("%s: expected error", text)
+		}
+	}
+}
+
+func TestTemplateVars(t *testing.T) {
+	tmpl, err := ParseTemplate("body", "csrf={{urlencode (htmlunescape .Vars.csrf)}}&user={{.Username}}", "csrf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tmpl.Execute(TemplateData{Username: "alice", Vars: map[string]string{"csrf": "a+b&amp;c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "csrf=a%2Bb%26c&user=alice"; out != expected {
+		t.Errorf("got %q, expected %q", out, expected)
+	}
+
+	// a declared variable missing from the attempt is an error
+	_, err = tmpl.Execute(TemplateData{Username: "alice"})
+	if err == nil {
+		t.Error("expected error without the csrf variable")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// StepDiscovery is the step of a multi-step login which fetches what the
+	// credentials are submitted with, such as a login form, a redirect, or a
+	// discovery document
+	StepDiscovery = "discovery"
+
+	// StepSubmit is the step of a login which submits the credentials
+	StepSubmit = "submit"
+)
+
+// TimeoutOptions returns the options read by ParseTimeouts for the named steps.
+func TimeoutOptions(steps ...string) []Option {
+	opts := []Option{
+		{Name: "timeout", Description: "how long each request of an attempt may take, e.g. 10s, unlimited by default"},
+	}
+	for _, step := range steps {
+		opts = append(opts, Option{
+			Name:        step + "_timeout",
+			Description: fmt.Sprintf("how long the %s request may take, timeout by default", step),
+		})
+	}
+	return opts
+}
+
+// Timeouts bounds the requests of the steps of a login.
+type Timeouts struct {
+	// Default bounds the requests of steps without their own timeout, 0 for
+	// no limit
+	Default time.Duration
+
+	// Steps holds the timeouts set for single steps
+	Steps map[string]time.Duration
+}
+
+// ParseTimeouts reads the timeout options of a nozzle whose login takes the
+// named steps:
+//
+// timeout
+//
+// How long each request of an attempt may take, including reading its
+// response, as a duration such as "10s". Unlimited by default.
+//
+// <step>_timeout
+//
+// How long the requests of a single step may take, e.g. discovery_timeout or
+// submit_timeout. Defaults to timeout. Providers which fetch a login form or
+// discovery document before submitting the credentials can give the slow but
+// benign fetch more time while keeping the submission tight.
+func ParseTimeouts(opts map[string]string, steps ...string) (*Timeouts, error) {
+	t := &Timeouts{Steps: make(map[string]time.Duration)}
+
+	var err error
+	if v, ok := opts["timeout"]; ok {
+		t.Default, err = time.ParseDuration(v)
+		if err != nil || t.Default <= 0 {
+			return nil, fmt.Errorf("timeout must be a positive duration: %q", v)
+		}
+	}
+	for _, step := range steps {
+		name := step + "_timeout"
+		v, ok := opts[name]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s must be a positive duration: %q", name, v)
+		}
+		t.Steps[step] = d
+	}
+	return t, nil
+}
+
+// Step returns the timeout of the named step, 0 for no limit. A nil Timeouts
+// never limits a step.
+func (t *Timeouts) Step(step string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if d, ok := t.Steps[step]; ok {
+		return d
+	}
+	return t.Default
+}
+
+// Request returns the request bound by the timeout of the named step, and a
+// function releasing its timer which must be called once the response has been
+// read.
+func (t *Timeouts) Request(req *http.Request, step string) (*http.Request, context.CancelFunc) {
+	d := t.Step(step)
+	if d <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	return req.WithContext(ctx), cancel
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTimeouts(t *testing.T) {
+	tm, err := ParseTimeouts(map[string]string{}, StepDiscovery, StepSubmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm.Step(StepDiscovery) != 0 || tm.Step(StepSubmit) != 0 {
+		t.Errorf("expected no timeouts by default: %+v", tm)
+	}
+
+	tm, err = ParseTimeouts(map[string]string{
+		"timeout":           "5s",
+		"discovery_timeout": "30s",
+	}, StepDiscovery, StepSubmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := tm.Step(StepDiscovery); d != 30*time.Second {
+		t.Errorf("discovery timeout was %s", d)
+	}
+	if d := tm.Step(StepSubmit); d != 5*time.Second {
+		t.Errorf("expected submit to default to the timeout, was %s", d)
+	}
+
+	var nilTimeouts *Timeouts
+	if d := nilTimeouts.Step(StepSubmit); d != 0 {
+		t.Errorf("expected no timeout without options, was %s", d)
+	}
+
+	for _, opts := range []map[string]string{
+		{"timeout": "soon"},
+		{"timeout": "0s"},
+		{"submit_timeout": "-1s"},
+	} {
+		if _, err := ParseTimeouts(opts, StepDiscovery, StepSubmit); err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+}
+
+func TestTimeoutsRequest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	tm, err := ParseTimeouts(map[string]string{
+		"timeout":        "5s",
+		"submit_timeout": "20ms",
+	}, StepDiscovery, StepSubmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, step := range []string{StepDiscovery, StepSubmit} {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req, cancel := tm.Request(req, step)
+		resp, err := http.DefaultClient.Do(req)
+		cancel()
+		if step == StepDiscovery {
+			if err != nil {
+				t.Errorf("discovery: unexpected error: %s", err)
+				continue
+			}
+			resp.Body.Close() // nolint:errcheck,gosec
+			continue
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("submit: expected a timeout, got %v", err)
+		}
+		if category := ErrorCategory(err); category != ErrorTimeout {
+			t.Errorf("submit: error was categorized as %s", category)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tlsVersions maps the accepted min_tls_version and max_tls_version values.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// minSecureVersion is the oldest TLS version negotiated unless allow_weak_tls
+// is set.
+const minSecureVersion = tls.VersionTLS12
+
+// TLSOptions describes the options read by TLSConfig.
+var TLSOptions = []Option{
+	{Name: "min_tls_version", Description: "the oldest TLS version to negotiate, 1.2 by default"},
+	{Name: "max_tls_version", Description: "the newest TLS version to negotiate"},
+	{Name: "cipher_suites", Description: "comma separated cipher suite names, including insecure suites"},
+	{Name: "allow_weak_tls", Description: "must be true to negotiate a version older than 1.2 or an insecure cipher suite"},
+	{Name: "tls_server_name", Description: "the server name sent in SNI and used to verify the certificate"},
+	{Name: "insecure_skip_verify", Description: "whether to skip verification of the server certificate"},
+}
+
+// insecureWarning makes sure the warning about disabled certificate
+// verification is logged once per process rather than once per attempt.
+var insecureWarning sync.Once
+
+// TLSConfig reads the TLS options shared by the nozzles:
+//
+// min_tls_version, max_tls_version
+//
+// The oldest and newest TLS versions to negotiate: 1.0, 1.1, 1.2, or 1.3. The
+// default minimum is 1.2. Legacy targets may only offer older versions.
+//
+// cipher_suites
+//
+// A comma separated list of cipher suite names, e.g.
+// TLS_RSA_WITH_AES_128_CBC_SHA, including the insecure suites which are
+// otherwise disabled. TLS 1.3 suites are not configurable.
+//
+// allow_weak_tls
+//
+// Must be true to negotiate a version older than 1.2 or to use an insecure
+// cipher suite, so that weakening the connection is always explicit.
+//
+// tls_server_name
+//
+// The server name sent in the SNI extension and used to verify the
+// certificate, for targets reached through an address that does not match
+// their certificate.
+//
+// insecure_skip_verify
+//
+// Whether to skip verification of the server certificate, for self-signed
+// internal endpoints. The default is insecureDefault, which only the adfs
+// nozzle sets, since federation servers are commonly issued certificates by an
+// internal CA that workers do not trust.
+//
+// Disabled verification is logged as a warning, whether it was requested or
+// is the nozzle's default.
+func TLSConfig(opts map[string]string, insecureDefault bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         minSecureVersion,
+		InsecureSkipVerify: insecureDefault, // nolint:gosec
+	}
+
+	var allowWeak bool
+	if v, ok := opts["allow_weak_tls"]; ok {
+		var err error
+		allowWeak, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("allow_weak_tls must be true or false: %w", err)
+		}
+	}
+
+	if v, ok := opts["min_tls_version"]; ok {
+		version, ok := tlsVersions[strings.TrimSpace(v)]
+		if !ok {
+			return nil, fmt.Errorf("unknown min_tls_version %q", v)
+		}
+		if version < minSecureVersion && !allowWeak {
+			return nil, fmt.Errorf("min_tls_version %s is older than 1.2 and requires allow_weak_tls", v)
+		}
+		cfg.MinVersion = version
+	}
+	if v, ok := opts["max_tls_version"]; ok {
+		version, ok := tlsVersions[strings.TrimSpace(v)]
+		if !ok {
+			return nil, fmt.Errorf("unknown max_tls_version %q", v)
+		}
+		if version < minSecureVersion && !allowWeak {
+			return nil, fmt.Errorf("max_tls_version %s is older than 1.2 and requires allow_weak_tls", v)
+		}
+		cfg.MaxVersion = version
+	}
+	if cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return nil, fmt.Errorf("min_tls_version is newer than max_tls_version")
+	}
+
+	if v, ok := opts["cipher_suites"]; ok {
+		suites := make(map[string]*tls.CipherSuite)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s
+		}
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			suite, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+			if suite.Insecure && !allowWeak {
+				return nil, fmt.Errorf("cipher suite %s is insecure and requires allow_weak_tls", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, suite.ID)
+		}
+	}
+
+	if v := strings.TrimSpace(opts["tls_server_name"]); v != "" {
+		cfg.ServerName = v
+	}
+
+	if v, ok := opts["insecure_skip_verify"]; ok {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("insecure_skip_verify must be true or false: %w", err)
+		}
+		cfg.InsecureSkipVerify = insecure // nolint:gosec
+	}
+
+	if cfg.InsecureSkipVerify {
+		insecureWarning.Do(func() {
+			log.Warn("TLS certificate verification is disabled (set insecure_skip_verify to false to enable it), " +
+				"responses could come from anyone able to intercept the connection")
+		})
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	cfg, err := TLSConfig(map[string]string{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.InsecureSkipVerify || cfg.MinVersion != tls.VersionTLS12 || cfg.CipherSuites != nil {
+		t.Errorf("unexpected default config: %+v", cfg)
+	}
+
+	cfg, err = TLSConfig(map[string]string{
+		"min_tls_version":      "1.0",
+		"max_tls_version":      "1.2",
+		"cipher_suites":        "TLS_RSA_WITH_AES_128_CBC_SHA, TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+		"allow_weak_tls":       "true",
+		"tls_server_name":      "portal.example.org",
+		"insecure_skip_verify": "false",
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InsecureSkipVerify || cfg.MinVersion != tls.VersionTLS10 || cfg.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if cfg.ServerName != "portal.example.org" {
+		t.Errorf("tls_server_name was not applied: %q", cfg.ServerName)
+	}
+	if len(cfg.CipherSuites) != 2 || cfg.CipherSuites[1] != tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA {
+		t.Errorf("unexpected cipher suites: %v", cfg.CipherSuites)
+	}
+
+	cfg, err = TLSConfig(map[string]string{"insecure_skip_verify": "true"}, false)
+	if err != nil || !cfg.InsecureSkipVerify {
+		t.Errorf("insecure_skip_verify was not applied: %+v, %v", cfg, err)
+	}
+
+	for _, opts := range []map[string]string{
+		{"min_tls_version": "1"},
+		{"max_tls_version": "SSLv3"},
+		{"min_tls_version": "1.3", "max_tls_version": "1.2"},
+		{"cipher_suites": "TLS_RSA_WITH_RC5"},
+		{"insecure_skip_verify": "maybe"},
+		{"allow_weak_tls": "maybe"},
+		{"min_tls_version": "1.0"},
+		{"max_tls_version": "1.1", "allow_weak_tls": "false"},
+		{"cipher_suites": "TLS_RSA_WITH_RC4_128_SHA"},
+	} {
+		_, err = TLSConfig(opts, false)
+		if err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+}
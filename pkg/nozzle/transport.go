@@ -0,0 +1,218 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConns is the number of idle connections kept per host
+	// unless the max_idle_conns option is set
+	DefaultMaxIdleConns = 2
+
+	// DefaultIdleConnTimeout is how long an idle connection is kept unless
+	// the idle_conn_timeout option is set
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// maxPools bounds the number of connection pools kept by a worker
+	maxPools = 64
+)
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*http.Transport)
+)
+
+// Transport holds the connection options shared by the HTTP nozzles.
+type Transport struct {
+	// KeepAlive reuses connections across attempts
+	KeepAlive bool
+
+	// HTTP2 negotiates HTTP/2 with servers which support it
+	HTTP2 bool
+
+	// MaxIdleConns is the number of idle connections kept per host
+	MaxIdleConns int
+
+	// IdleConnTimeout is how long an idle connection is kept
+	IdleConnTimeout time.Duration
+
+	// key identifies the connection pool of the nozzle configuration
+	key string
+}
+
+// TransportOptions describes the options read by ParseTransport.
+var TransportOptions = []Option{
+	{Name: "keep_alive", Description: "whether connections are reused across attempts, true by default"},
+	{Name: "http2", Description: "whether HTTP/2 is negotiated with servers which support it, true by default"},
+	{Name: "max_idle_conns", Description: "the number of idle connections kept per host, 2 by default"},
+	{Name: "idle_conn_timeout", Description: "how long idle connections are kept, 90s by default"},
+}
+
+// ParseTransport reads the connection options shared by the HTTP nozzles:
+//
+// keep_alive
+//
+// Whether connections are reused across attempts, like a browser would,
+// instead of opening a new TCP and TLS connection for every attempt. Defaults
+// to true. Set it to false to force a new connection for every attempt.
+//
+// http2
+//
+// Whether HTTP/2 is negotiated with servers which support it. Defaults to
+// true.
+//
+// max_idle_conns, idle_conn_timeout
+//
+// The number of idle connections kept per host (default 2) and how long they
+// are kept (default 90s).
+//
+// Nozzles are opened for every attempt, so reused connections are kept in a
+// pool shared by the worker and keyed by the provider and its configuration.
+// Attempts against different targets or with different TLS options never
+// share a connection, and since every region runs its own workers, neither do
+// regions.
+func ParseTransport(provider string, opts map[string]string) (*Transport, error) {
+	t := &Transport{
+		KeepAlive:       true,
+		HTTP2:           true,
+		MaxIdleConns:    DefaultMaxIdleConns,
+		IdleConnTimeout: DefaultIdleConnTimeout,
+	}
+
+	var err error
+	if v, ok := opts["keep_alive"]; ok {
+		t.KeepAlive, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("keep_alive must be true or false: %w", err)
+		}
+	}
+	if v, ok := opts["http2"]; ok {
+		t.HTTP2, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("http2 must be true or false: %w", err)
+		}
+	}
+	if v, ok := opts["max_idle_conns"]; ok {
+		t.MaxIdleConns, err = strconv.Atoi(v)
+		if err != nil || t.MaxIdleConns < 1 {
+			return nil, fmt.Errorf("max_idle_conns must be a positive integer: %q", v)
+		}
+	}
+	if v, ok := opts["idle_conn_timeout"]; ok {
+		t.IdleConnTimeout, err = time.ParseDuration(v)
+		if err != nil || t.IdleConnTimeout <= 0 {
+			return nil, fmt.Errorf("idle_conn_timeout must be a positive duration: %q", v)
+		}
+	}
+
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(provider)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\x00%s=%s", k, opts[k])
+	}
+	t.key = b.String()
+
+	return t, nil
+}
+
+// RoundTripper returns the transport for an attempt and a function which must
+// be called once the attempt is done. With keep_alive, the transport comes
+// from the pool of the nozzle configuration; otherwise it is new and its
+// connections are closed by the returned function. A nil Transport never
+// reuses connections. During a ReplayLogin, the transport is answered by the
+// replayer instead.
+func (t *Transport) RoundTripper(tlsConfig *tls.Config) (*http.Transport, func()) {
+	if t == nil {
+		// nozzles created without ParseTransport never reuse connections
+		t = &Transport{
+			HTTP2:           true,
+			MaxIdleConns:    DefaultMaxIdleConns,
+			IdleConnTimeout: DefaultIdleConnTimeout,
+		}
+	}
+
+	poolsMu.Lock()
+	rt := replay
+	poolsMu.Unlock()
+	if rt != nil {
+		// a replayed attempt never reaches the network, and its transport
+		// must not be pooled with real ones. HTTP/2 is disabled, since it
+		// would register itself for https in place of the replayer.
+		transport := &http.Transport{
+			TLSNextProto: make(map[string]func(string, *tls.Conn) http.RoundTripper),
+		}
+		transport.RegisterProtocol("https", rt)
+		transport.RegisterProtocol("http", rt)
+		return transport, func() {}
+	}
+
+	if !t.KeepAlive {
+		transport := t.newTransport(tlsConfig)
+		return transport, transport.CloseIdleConnections
+	}
+
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+
+	transport, ok := pools[t.key]
+	if !ok {
+		if len(pools) >= maxPools {
+			for key, p := range pools {
+				p.CloseIdleConnections()
+				delete(pools, key)
+			}
+		}
+		transport = t.newTransport(tlsConfig)
+		pools[t.key] = transport
+	}
+	return transport, func() {}
+}
+
+// newTransport creates a transport with the configured options.
+func (t *Transport) newTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     t.HTTP2,
+		MaxIdleConnsPerHost:   t.MaxIdleConns,
+		IdleConnTimeout:       t.IdleConnTimeout,
+	}
+	if !t.HTTP2 {
+		// a non-nil map disables the automatic HTTP/2 upgrade
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return transport
+}
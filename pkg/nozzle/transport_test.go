@@ -0,0 +1,154 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseTransport(t *testing.T) {
+	tr, err := ParseTransport("okta", map[string]string{"subdomain": "example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.KeepAlive || !tr.HTTP2 || tr.MaxIdleConns != DefaultMaxIdleConns ||
+		tr.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("unexpected defaults: %+v", tr)
+	}
+
+	tr, err = ParseTransport("okta", map[string]string{
+		"keep_alive":        "false",
+		"http2":             "false",
+		"max_idle_conns":    "8",
+		"idle_conn_timeout": "30s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.KeepAlive || tr.HTTP2 || tr.MaxIdleConns != 8 || tr.IdleConnTimeout != 30*time.Second {
+		t.Errorf("unexpected options: %+v", tr)
+	}
+
+	for _, opts := range []map[string]string{
+		{"keep_alive": "sometimes"},
+		{"http2": "2"},
+		{"max_idle_conns": "0"},
+		{"idle_conn_timeout": "-1s"},
+	} {
+		if _, err := ParseTransport("okta", opts); err == nil {
+			t.Errorf("expected error for %v", opts)
+		}
+	}
+}
+
+func TestRoundTripper(t *testing.T) {
+	a, _ := ParseTransport("okta", map[string]string{"subdomain": "a"})
+	b, _ := ParseTransport("okta", map[string]string{"subdomain": "b"})
+	o, _ := ParseTransport("o365", map[string]string{"subdomain": "a"})
+
+	ta, release := a.RoundTripper(&tls.Config{})
+	release()
+	again, _ := a.RoundTripper(&tls.Config{})
+	if ta != again {
+		t.Errorf("expected the same configuration to share a pool")
+	}
+	if tb, _ := b.RoundTripper(&tls.Config{}); tb == ta {
+		t.Errorf("expected different targets to use different pools")
+	}
+	if to, _ := o.RoundTripper(&tls.Config{}); to == ta {
+		t.Errorf("expected different providers to use different pools")
+	}
+
+	fresh, _ := ParseTransport("okta", map[string]string{"subdomain": "a", "keep_alive": "false"})
+	t1, _ := fresh.RoundTripper(&tls.Config{})
+	t2, _ := fresh.RoundTripper(&tls.Config{})
+	if t1 == t2 {
+		t.Errorf("expected a new transport for every attempt")
+	}
+
+	var nilTransport *Transport
+	t3, release := nilTransport.RoundTripper(nil)
+	t4, _ := nilTransport.RoundTripper(nil)
+	release()
+	if t3 == t4 {
+		t.Errorf("expected a new transport for every attempt without options")
+	}
+}
+
+// http2Server starts a TLS server which negotiates HTTP/2.
+func http2Server() *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto) // nolint:errcheck,gosec
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	return srv
+}
+
+func TestHTTP2(t *testing.T) {
+	srv := http2Server()
+	defer srv.Close()
+
+	for _, enabled := range []string{"true", "false"} {
+		tr, err := ParseTransport("test", map[string]string{"url": srv.URL, "http2": enabled})
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport, release := tr.RoundTripper(&tls.Config{InsecureSkipVerify: true}) // nolint:gosec
+		resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close() // nolint:errcheck,gosec
+		release()
+
+		if expected := map[string]string{"true": "HTTP/2.0", "false": "HTTP/1.1"}[enabled]; string(body) != expected {
+			t.Errorf("http2=%s: server saw %s", enabled, body)
+		}
+	}
+}
+
+// BenchmarkTransport compares the latency of attempts which open a new
+// connection with attempts which reuse a pooled one.
+func BenchmarkTransport(b *testing.B) {
+	srv := http2Server()
+	defer srv.Close()
+
+	for _, keepAlive := range []string{"false", "true"} {
+		tr, err := ParseTransport("bench", map[string]string{"url": srv.URL, "keep_alive": keepAlive})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("keep_alive="+keepAlive, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				transport, release := tr.RoundTripper(&tls.Config{InsecureSkipVerify: true}) // nolint:gosec
+				resp, err := (&http.Client{Transport: transport}).Get(srv.URL)
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(ioutil.Discard, resp.Body) // nolint:errcheck,gosec
+				resp.Body.Close()                  // nolint:errcheck,gosec
+				release()
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmware
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// brokerPath is the XML API of the connection server used by the
+	// Horizon clients
+	brokerPath = "/broker/xml"
+
+	// brokerVersion is the version of the XML API sent with each request
+	brokerVersion = "15.0"
+
+	// the authentication screens of the XML API
+	screenWindowsPassword = "windows-password"
+	screenPasswordExpired = "windows-password-expired"
+)
+
+// HorizonDriver implements the nozzle.Driver interface.
+type HorizonDriver struct{}
+
+func init() {
+	nozzle.Register("vmware-horizon", HorizonDriver{})
+}
+
+// New is used to create a Horizon nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The host name (and optional port) of the connection server or Unified
+// Access Gateway, e.g. "horizon.example.org".
+//
+// domain
+//
+// The NetBIOS domain name (e.g. "EXAMPLE") used for usernames without a
+// domain. By default the first domain the connection server offers is used.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout, discovery_timeout, and submit_timeout options described by
+// nozzle.ParseTimeouts are also accepted.
+func (HorizonDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	e, err := parseEndpoint("vmware-horizon", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &HorizonNozzle{
+		endpoint: *e,
+		Domain:   opts["domain"],
+	}, nil
+}
+
+// Describe returns the configuration options of the Horizon nozzle.
+func (HorizonDriver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the connection server, e.g. horizon.example.org", Required: true},
+		{Name: "domain", Description: "the NetBIOS domain name used for usernames without a domain"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepDiscovery, nozzle.StepSubmit))
+}
+
+// HorizonNozzle implements the nozzle.Nozzle interface for the XML API of a
+// Horizon connection server.
+type HorizonNozzle struct {
+	endpoint
+
+	// Domain is the NetBIOS domain name used for unqualified usernames
+	Domain string
+}
+
+// brokerRequest is a request to the XML API.
+type brokerRequest struct {
+	XMLName          xml.Name      `xml:"broker"`
+	Version          string        `xml:"version,attr"`
+	GetConfiguration *struct{}     `xml:"get-configuration"`
+	Authenticate     *brokerScreen `xml:"do-submit-authentication>screen"`
+}
+
+// brokerResponse is the part of a response of the XML API used by the nozzle.
+type brokerResponse struct {
+	XMLName       xml.Name      `xml:"broker"`
+	Configuration *brokerResult `xml:"configuration"`
+	Submit        *brokerResult `xml:"submit-authentication"`
+}
+
+// brokerResult is the result of a request, along with the authentication
+// screen the client is asked to fill in next, if any.
+type brokerResult struct {
+	Result       string        `xml:"result"`
+	ErrorCode    string        `xml:"error-code"`
+	ErrorMessage string        `xml:"error-message"`
+	Screen       *brokerScreen `xml:"authentication>screen"`
+}
+
+// brokerScreen is an authentication screen and its parameters.
+type brokerScreen struct {
+	Name   string        `xml:"name"`
+	Params []brokerParam `xml:"params>param"`
+}
+
+// brokerParam is a parameter of an authentication screen.
+type brokerParam struct {
+	Name   string   `xml:"name"`
+	Values []string `xml:"values>value"`
+}
+
+// param returns the first value of the named parameter of the screen.
+func (s *brokerScreen) param(name string) string {
+	for _, p := range s.Params {
+		if p.Name == name && len(p.Values) > 0 {
+			return p.Values[0]
+		}
+	}
+	return ""
+}
+
+// Login fulfils the nozzle.Nozzle interface. The connection server is asked
+// for its configuration, which starts the session and names the first
+// authentication screen, and the credentials are submitted to the windows
+// password screen. The nozzle stops once the password is accepted and never
+// lists or launches a desktop.
+func (n *HorizonNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	client, release, err := n.client()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	_, config, res, err := n.broker(client, nozzle.StepDiscovery, brokerRequest{GetConfiguration: &struct{}{}},
+		username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	if config.Configuration == nil || config.Configuration.Result != "ok" || config.Configuration.Screen == nil {
+		return nil, fmt.Errorf("unexpected configuration from horizon connection server")
+	}
+	if name := config.Configuration.Screen.Name; name != screenWindowsPassword {
+		return nil, fmt.Errorf("horizon connection server asks for %s before the windows password", name)
+	}
+
+	domain, user := n.Domain, username
+	if i := strings.Index(username, `\`); i >= 0 {
+		domain, user = username[:i], username[i+1:]
+	}
+	if domain == "" {
+		domain = config.Configuration.Screen.param("domain")
+	}
+	screen := &brokerScreen{
+		Name: screenWindowsPassword,
+		Params: []brokerParam{
+			{Name: "username", Values: []string{user}},
+			{Name: "domain", Values: []string{domain}},
+			{Name: "password", Values: []string{password}},
+		},
+	}
+	resp, submitted, res, err := n.broker(client, nozzle.StepSubmit, brokerRequest{Authenticate: screen}, username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	if submitted.Submit == nil {
+		return nil, fmt.Errorf("unexpected response from horizon connection server")
+	}
+
+	res, err = classifyBroker(submitted.Submit)
+	if err != nil {
+		return nil, err
+	}
+	if res.Valid {
+		res.Capture = nozzle.Capture(resp)
+	}
+	return res, nil
+}
+
+// broker sends a request of the named step to the XML API and parses the
+// response.
+func (n *HorizonNozzle) broker(client *http.Client, step string, req brokerRequest,
+	username, password string) (*http.Response, *brokerResponse, *event.AuthResponse, error) {
+	req.Version = brokerVersion
+	data, err := xml.Marshal(req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	data = append([]byte(xml.Header), data...)
+
+	resp, body, res, err := n.do(client, step, "POST", n.BaseURL+brokerPath, "text/xml", data, username, password)
+	if err != nil || res != nil {
+		return nil, nil, res, err
+	}
+	if resp.StatusCode == 429 || resp.StatusCode == 503 {
+		return nil, nil, &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata: map[string]interface{}{
+				"status": resp.StatusCode,
+			},
+		}, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, nil, nil, fmt.Errorf("unexpected status from horizon connection server: %d", resp.StatusCode)
+	}
+
+	var parsed brokerResponse
+	err = xml.Unmarshal([]byte(body), &parsed)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unexpected response from horizon connection server: %w", err)
+	}
+	return resp, &parsed, nil, nil
+}
+
+// classifyBroker maps the result of submitting the windows password onto an
+// AuthResponse. An accepted password either completes the authentication or
+// leads to a later screen, such as a disclaimer or a required password
+// change. A rejected one is answered with the windows password screen again,
+// carrying the error shown to the user.
+func classifyBroker(r *brokerResult) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{}
+	message := r.ErrorMessage
+	if r.Screen != nil {
+		metadata["screen"] = r.Screen.Name
+		if m := r.Screen.param("error"); m != "" {
+			message = m
+		}
+	}
+	if message != "" {
+		metadata["message"] = message
+	}
+	if r.ErrorCode != "" {
+		metadata["error_code"] = r.ErrorCode
+	}
+	lower := strings.ToLower(message)
+
+	switch {
+	case strings.Contains(lower, "locked"):
+		metadata["reason"] = "locked_out"
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case r.Screen != nil && r.Screen.Name == screenPasswordExpired:
+		metadata["reason"] = "password_expired"
+		return &event.AuthResponse{
+			Valid:    true,
+			Expired:  true,
+			Metadata: metadata,
+		}, nil
+	case r.ErrorCode == "AUTHENTICATION_FAILED":
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case r.Result != "ok" && r.Result != "partial":
+		return nil, fmt.Errorf("horizon connection server returned %s: %s %s", r.Result, r.ErrorCode, message)
+	case r.Screen != nil && r.Screen.Name == screenWindowsPassword:
+		if strings.Contains(lower, "disabled") {
+			metadata["reason"] = "account_disabled"
+		}
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+	}, nil
+}
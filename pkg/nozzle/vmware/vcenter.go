@@ -0,0 +1,213 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmware
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// vcenterLoginPath is the vSphere client login, which redirects to the
+	// SAML request of the SSO server
+	vcenterLoginPath = "/ui/login"
+
+	// webssoPath is the path prefix of the SSO server's SAML endpoint
+	webssoPath = "/websso/SAML2/SSO/"
+)
+
+// VCenterDriver implements the nozzle.Driver interface.
+type VCenterDriver struct{}
+
+func init() {
+	nozzle.Register("vcenter", VCenterDriver{})
+}
+
+// New is used to create a vCenter nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The host name (and optional port) of the vCenter server, e.g.
+// "vcenter.example.org".
+//
+// domain
+//
+// The SSO domain appended to usernames without a domain, e.g.
+// "vsphere.local". By default such usernames are left to the default
+// identity source of the SSO server.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout, discovery_timeout, and submit_timeout options described by
+// nozzle.ParseTimeouts are also accepted.
+func (VCenterDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	e, err := parseEndpoint("vcenter", opts)
+	if err != nil {
+		return nil, err
+	}
+	return &VCenterNozzle{
+		endpoint: *e,
+		Domain:   opts["domain"],
+	}, nil
+}
+
+// Describe returns the configuration options of the vCenter nozzle.
+func (VCenterDriver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the vCenter server, e.g. vcenter.example.org", Required: true},
+		{Name: "domain", Description: "the SSO domain appended to usernames without a domain, e.g. vsphere.local"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepDiscovery, nozzle.StepSubmit))
+}
+
+// VCenterNozzle implements the nozzle.Nozzle interface for the vCenter single
+// sign-on login.
+type VCenterNozzle struct {
+	endpoint
+
+	// Domain is the SSO domain appended to usernames without a domain
+	Domain string
+}
+
+// Login fulfils the nozzle.Nozzle interface. The vSphere client login is
+// fetched for the SAML request it redirects to, and the credentials are
+// posted to the SSO server with it, the way the login page does. A valid
+// credential is answered with the SAML response for the vSphere client, which
+// the nozzle does not post back, so no session is created.
+func (n *VCenterNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	err := RateLimiter.Wait(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	client, release, err := n.client()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	resp, _, res, err := n.do(client, nozzle.StepDiscovery, "GET", n.BaseURL+vcenterLoginPath, "", nil,
+		username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	sso, err := ssoLocation(resp.StatusCode, resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Domain != "" && !strings.ContainsAny(username, `@\`) {
+		username += "@" + n.Domain
+	}
+	credential := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	form := url.Values{"CastleAuthorization": {"Basic " + credential}}
+	resp, body, res, err := n.do(client, nozzle.StepSubmit, "POST", sso, "application/x-www-form-urlencoded",
+		[]byte(form.Encode()), username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+
+	res, err = classifyWebSSO(resp.StatusCode, body)
+	if err != nil {
+		return nil, err
+	}
+	if res.RateLimited {
+		res.RetryAfter = nozzle.RetryAfter(resp.Header, time.Now())
+	}
+	if res.Valid {
+		res.Capture = nozzle.Capture(resp)
+	}
+	return res, nil
+}
+
+// ssoLocation returns the SAML request the vSphere client login redirected
+// to. The SSO server may be an external Platform Services Controller, so any
+// https host is accepted.
+func ssoLocation(status int, location string) (string, error) {
+	if status != 302 && status != 303 {
+		return "", fmt.Errorf("unexpected status from vcenter login: %d", status)
+	}
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "https" || !strings.Contains(u.Path, webssoPath) ||
+		u.Query().Get("SAMLRequest") == "" {
+		return "", fmt.Errorf("vcenter login did not redirect to an SSO server: %q", location)
+	}
+	return u.String(), nil
+}
+
+// classifyWebSSO maps the SSO server's answer to the posted credentials onto
+// an AuthResponse. A valid credential receives the form carrying the SAML
+// response, anything else a 401 with the message the login page shows. Only
+// an expired password is reported after the password was checked.
+func classifyWebSSO(status int, body string) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"status": status,
+	}
+	page := strings.ToLower(body)
+	switch {
+	case status == 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case status == 200 && strings.Contains(page, `name="samlresponse"`):
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+		}, nil
+	case status != 401:
+	case strings.Contains(page, "locked"):
+		metadata["reason"] = "locked_out"
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(page, "password expired") || strings.Contains(page, "password has expired"):
+		metadata["reason"] = "password_expired"
+		return &event.AuthResponse{
+			Valid:    true,
+			Expired:  true,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(page, "disabled"):
+		metadata["reason"] = "account_disabled"
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(page, "invalid credentials") || strings.Contains(page, "authentication failed"):
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected response from vcenter sso: %d", status)
+}
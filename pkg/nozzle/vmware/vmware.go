@@ -0,0 +1,172 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vmware implements nozzles for VMware management interfaces: the
+// single sign-on login of vCenter and the XML API of a Horizon connection
+// server. Both stop once the credential has been checked and never open a
+// session to a desktop or the vSphere client.
+package vmware
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// bodyLimit bounds how much of a response is read
+	bodyLimit = 1 << 20
+)
+
+// RateLimiter limits requests from the same worker to a maximum of 3/s
+var RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+// endpoint holds the connection options shared by the VMware nozzles.
+type endpoint struct {
+	// BaseURL is the scheme and host of the server
+	BaseURL string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
+}
+
+// parseEndpoint parses the host and the header, TLS, transport, and timeout
+// options of the named nozzle.
+func parseEndpoint(name string, opts map[string]string) (*endpoint, error) {
+	host, ok := opts["host"]
+	if !ok {
+		return nil, fmt.Errorf("%s nozzle requires 'host' config parameter", name)
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || host == "" || u.Host != host || u.User != nil {
+		return nil, fmt.Errorf("%s nozzle 'host' must be a host name without a scheme or path: %s", name, host)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepDiscovery, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint{
+		BaseURL:   "https://" + host,
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+		Timeouts:  timeouts,
+	}, nil
+}
+
+// client returns a client for a single attempt and the function releasing its
+// transport. Each attempt receives a fresh cookie jar so a session is never
+// shared between credential guesses, and redirects are returned rather than
+// followed.
+func (e *endpoint) client() (*http.Client, func(), error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport, release := e.Transport.RoundTripper(e.TLSConfig)
+	return &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, release, nil
+}
+
+// do sends a request of the named step with the configured headers and
+// timeout, and returns the response with its body, which has been read and
+// closed. If the response is a WAF or captcha challenge, the AuthResponse
+// reporting it is returned instead of the body.
+func (e *endpoint) do(client *http.Client, step, method, url, contentType string, data []byte,
+	username, password string) (*http.Response, string, *event.AuthResponse, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("User-Agent", e.UserAgent)
+	e.Headers.Apply(req, username, password)
+	req, cancel := e.Timeouts.Request(req, step)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return resp, "", res, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// the body is kept so a valid response can be captured
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return resp, string(b), nil, nil
+}
@@ -0,0 +1,268 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vmware
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	for _, name := range []string{"vcenter", "vmware-horizon"} {
+		_, err := nozzle.Open(name, map[string]string{
+			"host":   "vmware.example.org:8443",
+			"domain": "EXAMPLE",
+		})
+		if err != nil {
+			t.Fatalf("unable to open %s nozzle: %s", name, err)
+		}
+
+		for _, opts := range []map[string]string{
+			{},
+			{"host": "https://vmware.example.org"},
+			{"host": "vmware.example.org/ui"},
+		} {
+			_, err = nozzle.Open(name, opts)
+			if err == nil {
+				t.Errorf("expected error opening %s nozzle with %v", name, opts)
+			}
+		}
+	}
+}
+
+func TestSSOLocation(t *testing.T) {
+	var testcases = []struct {
+		status   int
+		location string
+		wantErr  bool
+	}{
+		{302, "https://vcenter.example.org/websso/SAML2/SSO/vsphere.local?SAMLRequest=abc", false},
+		{302, "https://psc.example.org/websso/SAML2/SSO/vsphere.local?SAMLRequest=abc&RelayState=x", false},
+		{200, "", true},
+		{302, "http://vcenter.example.org/websso/SAML2/SSO/vsphere.local?SAMLRequest=abc", true},
+		{302, "https://vcenter.example.org/websso/SAML2/SSO/vsphere.local", true},
+		{302, "https://vcenter.example.org/ui/", true},
+	}
+	for _, test := range testcases {
+		_, err := ssoLocation(test.status, test.location)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%d %s: unexpected error: %v", test.status, test.location, err)
+		}
+	}
+}
+
+// vcenterServer simulates a vCenter server with its SSO server. The password
+// selects the outcome of the login.
+func vcenterServer(t *testing.T) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case vcenterLoginPath:
+			http.Redirect(w, r, srv.URL+webssoPath+"vsphere.local?SAMLRequest=request", 302)
+		case webssoPath + "vsphere.local":
+			auth := strings.TrimPrefix(r.PostFormValue("CastleAuthorization"), "Basic ")
+			b, err := base64.StdEncoding.DecodeString(auth)
+			if err != nil {
+				t.Errorf("unexpected authorization: %s", auth)
+			}
+			credential := strings.SplitN(string(b), ":", 2)
+			if credential[0] != "alice@vsphere.local" {
+				t.Errorf("unexpected login: %s", credential[0])
+			}
+			switch credential[1] {
+			case "valid":
+				fmt.Fprint(w, `<form method="post"><input type="hidden" name="SAMLResponse" value="response"/></form>`)
+			case "locked":
+				w.WriteHeader(401)
+				fmt.Fprint(w, "User account is locked. Please contact your administrator.")
+			case "throttled":
+				w.WriteHeader(429)
+			default:
+				w.WriteHeader(401)
+				fmt.Fprint(w, "Invalid credentials")
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return srv
+}
+
+// horizonServer simulates a Horizon connection server for the EXAMPLE
+// domain. The password selects the outcome of the login.
+func horizonServer(t *testing.T) *httptest.Server {
+	screen := func(name, errorMessage string) *brokerScreen {
+		s := &brokerScreen{Name: name, Params: []brokerParam{{Name: "domain", Values: []string{"EXAMPLE"}}}}
+		if errorMessage != "" {
+			s.Params = append(s.Params, brokerParam{Name: "error", Values: []string{errorMessage}})
+		}
+		return s
+	}
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != brokerPath {
+			http.NotFound(w, r)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		var req brokerRequest
+		err := xml.Unmarshal(b, &req)
+		if err != nil {
+			t.Errorf("unexpected request: %s", b)
+		}
+
+		var resp brokerResponse
+		switch {
+		case req.GetConfiguration != nil:
+			http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session"})
+			resp.Configuration = &brokerResult{Result: "ok", Screen: screen(screenWindowsPassword, "")}
+		case req.Authenticate != nil:
+			if c, err := r.Cookie("JSESSIONID"); err != nil || c.Value != "session" {
+				resp.Submit = &brokerResult{Result: "error", ErrorCode: "NOT_AUTHENTICATED"}
+				break
+			}
+			if user, domain := req.Authenticate.param("username"), req.Authenticate.param("domain"); user != "alice" ||
+				domain != "EXAMPLE" {
+				t.Errorf("unexpected login: %s\\%s", domain, user)
+			}
+			switch req.Authenticate.param("password") {
+			case "valid":
+				resp.Submit = &brokerResult{Result: "ok"}
+			case "expired":
+				resp.Submit = &brokerResult{Result: "partial", Screen: screen(screenPasswordExpired, "")}
+			case "locked":
+				resp.Submit = &brokerResult{Result: "partial",
+					Screen: screen(screenWindowsPassword, "Your account has been locked.")}
+			case "throttled":
+				w.WriteHeader(503)
+				return
+			default:
+				resp.Submit = &brokerResult{Result: "partial",
+					Screen: screen(screenWindowsPassword, "Unknown username or bad password.")}
+			}
+		}
+		xml.NewEncoder(w).Encode(resp) // nolint:errcheck
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	vcenter := vcenterServer(t)
+	defer vcenter.Close()
+	horizon := horizonServer(t)
+	defer horizon.Close()
+
+	var testcases = []struct {
+		provider    string
+		password    string
+		valid       bool
+		locked      bool
+		expired     bool
+		ratelimited bool
+	}{
+		{provider: "vcenter", password: "valid", valid: true},
+		{provider: "vcenter", password: "locked", locked: true},
+		{provider: "vcenter", password: "throttled", ratelimited: true},
+		{provider: "vcenter", password: "wrong"},
+		{provider: "vmware-horizon", password: "valid", valid: true},
+		{provider: "vmware-horizon", password: "expired", valid: true, expired: true},
+		{provider: "vmware-horizon", password: "locked", locked: true},
+		{provider: "vmware-horizon", password: "throttled", ratelimited: true},
+		{provider: "vmware-horizon", password: "wrong"},
+	}
+	for _, test := range testcases {
+		srv, domain := vcenter, "vsphere.local"
+		if test.provider == "vmware-horizon" {
+			srv, domain = horizon, ""
+		}
+		noz, err := nozzle.Open(test.provider, map[string]string{
+			"host":                 strings.TrimPrefix(srv.URL, "https://"),
+			"domain":               domain,
+			"insecure_skip_verify": "true",
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		res, err := noz.Login("alice", test.password)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %s", test.provider, test.password, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked != test.locked || res.Expired != test.expired ||
+			res.RateLimited != test.ratelimited {
+			t.Errorf("%s %s: got %+v", test.provider, test.password, res)
+		}
+		if test.valid && res.Capture == nil {
+			t.Errorf("%s %s: expected the response to be captured", test.provider, test.password)
+		}
+	}
+}
+
+// TestGolden replays the saved responses in testdata through both nozzles.
+func TestGolden(t *testing.T) {
+	var testcases = []struct {
+		provider string
+		files    []string
+		valid    bool
+		locked   bool
+		expired  bool
+	}{
+		{provider: "vcenter", files: []string{"ui_login.http", "saml_response.http"}, valid: true},
+		{provider: "vcenter", files: []string{"ui_login.http", "sso_invalid.http"}},
+		{provider: "vcenter", files: []string{"ui_login.http", "sso_locked.http"}, locked: true},
+		{provider: "vcenter", files: []string{"ui_login.http", "sso_password_expired.http"}, valid: true, expired: true},
+		{provider: "vmware-horizon", files: []string{"configuration.http", "authenticated.http"}, valid: true},
+		{provider: "vmware-horizon", files: []string{"configuration.http", "bad_password.http"}},
+		{provider: "vmware-horizon", files: []string{"configuration.http", "account_locked.http"}, locked: true},
+		{provider: "vmware-horizon", files: []string{"configuration.http", "password_expired.http"},
+			valid: true, expired: true},
+	}
+	for _, test := range testcases {
+		noz, err := nozzle.Open(test.provider, map[string]string{
+			"host": "vcenter.example.org",
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		paths := make([]string, len(test.files))
+		for i, file := range test.files {
+			paths[i] = filepath.Join("testdata", file)
+		}
+		r, err := nozzle.ReadReplayer(paths...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice", "Password1")
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", test.files, err)
+			continue
+		}
+		if res.Valid != test.valid || res.Locked != test.locked || res.Expired != test.expired {
+			t.Errorf("%v: got %+v", test.files, res)
+		}
+		if len(r.Requests) != len(test.files) {
+			t.Errorf("%v: unexpected requests: %v", test.files, r.Requests)
+		}
+	}
+}
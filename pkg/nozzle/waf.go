@@ -0,0 +1,108 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+// wafBodyLimit is how much of an error response body is inspected for a WAF
+// or captcha signature.
+const wafBodyLimit = 64 << 10
+
+// wafBody is a body signature of a WAF block page or captcha challenge.
+type wafBody struct {
+	name   string
+	marker string
+}
+
+// wafBodies are checked in order, so vendor pages which embed a captcha are
+// attributed to the vendor.
+var wafBodies = []wafBody{
+	{"cloudflare", "cf-chl-"},
+	{"cloudflare", "challenge-platform"},
+	{"cloudflare", "Attention Required! | Cloudflare"},
+	{"imperva", "_Incapsula_Resource"},
+	{"imperva", "Incapsula incident ID"},
+	{"akamai", "errors.edgesuite.net"},
+	{"f5-asm", "The requested URL was rejected. Please consult with your administrator."},
+	{"aws-waf", "awswaf"},
+	{"recaptcha", "g-recaptcha"},
+	{"recaptcha", "www.google.com/recaptcha/"},
+	{"hcaptcha", "h-captcha"},
+	{"hcaptcha", "hcaptcha.com/1/api.js"},
+}
+
+// DetectWAF returns the name of the WAF or captcha that challenged or blocked
+// the request (e.g. "cloudflare" or "recaptcha"), or an empty string if the
+// response is a genuine answer from the provider. Headers are checked on every
+// response, the body only on error responses, since a login page may embed a
+// captcha without enforcing it. The inspected part of the body is put back,
+// so the caller can still read the whole response.
+func DetectWAF(resp *http.Response) string {
+	switch {
+	case resp.Header.Get("Cf-Mitigated") == "challenge":
+		return "cloudflare"
+	case resp.Header.Get("X-Amzn-Waf-Action") != "":
+		return "aws-waf"
+	case resp.Header.Get("X-Iinfo") != "" && resp.StatusCode >= 400:
+		return "imperva"
+	}
+
+	if resp.StatusCode < 400 || resp.Body == nil {
+		return ""
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, wafBodyLimit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+	if err != nil {
+		return ""
+	}
+
+	body := string(b)
+	for _, sig := range wafBodies {
+		if strings.Contains(body, sig.marker) {
+			return sig.name
+		}
+	}
+	if strings.EqualFold(resp.Header.Get("Server"), "AkamaiGHost") && resp.StatusCode == 403 {
+		return "akamai"
+	}
+	return ""
+}
+
+// Challenged returns the AuthResponse reporting a WAF or captcha challenge if
+// DetectWAF finds one in the response, or nil otherwise.
+func Challenged(resp *http.Response) *event.AuthResponse {
+	waf := DetectWAF(resp)
+	if waf == "" {
+		return nil
+	}
+	return &event.AuthResponse{
+		WAF: waf,
+		Metadata: map[string]interface{}{
+			"status": resp.StatusCode,
+		},
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nozzle
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDetectWAF(t *testing.T) {
+	var testcases = []struct {
+		desc   string
+		status int
+		header http.Header
+		body   string
+		waf    string
+	}{
+		{"okta invalid", 401, nil, `{"errorCode":"E0000004"}`, ""},
+		{"cloudflare header", 403, http.Header{"Cf-Mitigated": {"challenge"}}, "", "cloudflare"},
+		{"cloudflare page", 503, nil, `<script src="/cdn-cgi/challenge-platform/h/b/orchestrate/jsch/v1"></script>`, "cloudflare"},
+		{"aws waf captcha", 405, http.Header{"X-Amzn-Waf-Action": {"captcha"}}, "", "aws-waf"},
+		{"akamai", 403, http.Header{"Server": {"AkamaiGHost"}}, "<H1>Access Denied</H1>", "akamai"},
+		{"imperva", 403, nil, `<iframe src="/_Incapsula_Resource?CWUDNSAI=1"></iframe>`, "imperva"},
+		{"f5", 200, nil, "The requested URL was rejected. Please consult with your administrator.", ""},
+		{"f5 error", 403, nil, "The requested URL was rejected. Please consult with your administrator.", "f5-asm"},
+		{"recaptcha", 429, nil, `<div class="g-recaptcha" data-sitekey="x"></div>`, "recaptcha"},
+		{"login page with captcha", 200, nil, `<div class="g-recaptcha" data-sitekey="x"></div>`, ""},
+	}
+	for _, test := range testcases {
+		header := test.header
+		if header == nil {
+			header = http.Header{}
+		}
+		resp := &http.Response{
+			StatusCode: test.status,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(test.body)),
+		}
+		if waf := DetectWAF(resp); waf != test.waf {
+			t.Errorf("%s: detected %q, expected %q", test.desc, waf, test.waf)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil || string(b) != test.body {
+			t.Errorf("%s: body was not restored: %q", test.desc, b)
+		}
+	}
+}
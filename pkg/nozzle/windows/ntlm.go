@@ -0,0 +1,220 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package windows
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" // nolint:gosec
+	"crypto/rc4" // nolint:gosec
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Azure/go-ntlmssp"
+	"golang.org/x/crypto/md4" // nolint:staticcheck
+)
+
+// The NTLM messages themselves are built by go-ntlmssp, as in the ntlm nozzle.
+// It only implements authentication, so the session security CredSSP needs to
+// seal the server's public key (MS-NLMP 3.4) is derived here from the
+// messages it produced.
+
+// NTLM negotiate flags (MS-NLMP 2.2.2.5).
+const (
+	ntlmNegotiateSign                    = 0x00000010
+	ntlmNegotiateSeal                    = 0x00000020
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiateKeyExch                 = 0x40000000
+
+	// ntlmSessionFlags are requested on top of the go-ntlmssp defaults, since
+	// CredSSP seals its messages. Key exchange is not requested, as go-ntlmssp
+	// does not support it.
+	ntlmSessionFlags = ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateAlwaysSign
+)
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// ntlmNegotiate returns an NTLM NEGOTIATE message naming the domain, which
+// also requests signing and sealing.
+func ntlmNegotiate(domain string) ([]byte, error) {
+	msg, err := ntlmssp.NewNegotiateMessage(domain, "")
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(msg[12:], binary.LittleEndian.Uint32(msg[12:])|ntlmSessionFlags)
+	return msg, nil
+}
+
+// ntlmAuthenticate returns the NTLMv2 AUTHENTICATE message go-ntlmssp computes
+// for the challenge, together with the resulting session. As with go-ntlmssp,
+// the credentials are checked in the domain the server names in its challenge.
+func ntlmAuthenticate(challenge []byte, user, password string) ([]byte, *ntlmSession, error) {
+	target, flags, err := parseChallenge(challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	if flags&ntlmNegotiateExtendedSessionSecurity == 0 || flags&ntlmNegotiate128 == 0 {
+		return nil, nil, fmt.Errorf("ntlm server does not support extended session security")
+	}
+
+	msg, err := ntlmssp.ProcessChallenge(challenge, user, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof, err := ntProofStr(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// without key exchange, the exported session key is the session base key
+	session, err := newNTLMSession(flags, hmacMD5(ntowfv2(user, target, password), proof))
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, session, nil
+}
+
+// parseChallenge returns the raw (UTF-16) target name and the flags of an NTLM
+// CHALLENGE message.
+func parseChallenge(b []byte) ([]byte, uint32, error) {
+	if len(b) < 32 || !bytes.Equal(b[:8], ntlmSignature) || binary.LittleEndian.Uint32(b[8:]) != 2 {
+		return nil, 0, fmt.Errorf("invalid ntlm challenge message")
+	}
+	target, ok := varField(b, 12)
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid ntlm challenge target name")
+	}
+	return target, binary.LittleEndian.Uint32(b[20:]), nil
+}
+
+// ntProofStr returns the NTProofStr which starts the NTLMv2 response of an
+// AUTHENTICATE message.
+func ntProofStr(b []byte) ([]byte, error) {
+	response, ok := varField(b, 20)
+	if len(b) < 64 || !ok || len(response) < 16 {
+		return nil, fmt.Errorf("invalid ntlm authenticate message")
+	}
+	return response[:16], nil
+}
+
+// varField returns the payload of the length/offset field of an NTLM message
+// at the given offset (MS-NLMP 2.2.1).
+func varField(b []byte, at int) ([]byte, bool) {
+	if len(b) < at+8 {
+		return nil, false
+	}
+	length := int(binary.LittleEndian.Uint16(b[at:]))
+	offset := int(binary.LittleEndian.Uint32(b[at+4:]))
+	if offset+length > len(b) {
+		return nil, false
+	}
+	return b[offset : offset+length], true
+}
+
+// ntlmSession holds the negotiated client keys used to seal messages.
+type ntlmSession struct {
+	flags   uint32
+	signKey []byte
+	sealer  *rc4.Cipher
+	seq     uint32
+}
+
+// Seal encrypts and signs a message, returning the signature and the sealed
+// message (MS-NLMP 3.4.3 with extended session security).
+func (s *ntlmSession) Seal(msg []byte) ([]byte, []byte) {
+	sealed := make([]byte, len(msg))
+	s.sealer.XORKeyStream(sealed, msg)
+
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, s.seq)
+	checksum := hmacMD5(s.signKey, seq, msg)[:8]
+	if s.flags&ntlmNegotiateKeyExch != 0 {
+		s.sealer.XORKeyStream(checksum, checksum)
+	}
+	s.seq++
+
+	sig := make([]byte, 16)
+	binary.LittleEndian.PutUint32(sig, 1)
+	copy(sig[4:], checksum)
+	copy(sig[12:], seq)
+	return sig, sealed
+}
+
+// newNTLMSession derives the client signing and sealing keys from the
+// exported session key.
+func newNTLMSession(flags uint32, sessionKey []byte) (*ntlmSession, error) {
+	sealer, err := rc4.NewCipher(md5Sum(sessionKey, // nolint:gosec
+		[]byte("session key to client-to-server sealing key magic constant\x00")))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ntlmSession{
+		flags: flags,
+		signKey: md5Sum(sessionKey,
+			[]byte("session key to client-to-server signing key magic constant\x00")),
+		sealer: sealer,
+	}, nil
+}
+
+// ntowfv2 computes the NTLMv2 response key for the credentials, where target
+// is the UTF-16 encoded domain.
+func ntowfv2(user string, target []byte, password string) []byte {
+	h := md4.New()
+	h.Write(encodeUTF16(password)) // nolint:errcheck,gosec
+	return hmacMD5(h.Sum(nil), encodeUTF16(strings.ToUpper(user)), target)
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d) // nolint:errcheck,gosec
+	}
+	return mac.Sum(nil)
+}
+
+func md5Sum(data ...[]byte) []byte {
+	h := md5.New() // nolint:gosec
+	for _, d := range data {
+		h.Write(d) // nolint:errcheck,gosec
+	}
+	return h.Sum(nil)
+}
+
+func encodeUTF16(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(codes))
+	for i, c := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// splitUsername separates a DOMAIN\user username into its parts. Usernames
+// without a domain use the provided default, except user principal names
+// (user@example.org) which are sent with an empty domain.
+func splitUsername(username, domain string) (string, string) {
+	if i := strings.Index(username, `\`); i >= 0 {
+		return username[:i], username[i+1:]
+	}
+	if strings.Contains(username, "@") {
+		return "", username
+	}
+	return domain, username
+}
@@ -0,0 +1,366 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package windows
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// credsspVersion is the CredSSP version sent by the client. Version 3 is
+	// the first to report errorCode and the last before clientNonce.
+	credsspVersion = 3
+
+	// protocolSSL and protocolHybrid are the RDP_NEG_REQ security protocols
+	protocolSSL    = 0x00000001
+	protocolHybrid = 0x00000002
+
+	// RDP negotiation response types
+	typeRDPNegRsp     = 0x02
+	typeRDPNegFailure = 0x03
+)
+
+// x224ConnectionRequest is a TPKT wrapped X.224 Connection Request carrying
+// an RDP_NEG_REQ for TLS and CredSSP.
+var x224ConnectionRequest = []byte{
+	0x03, 0x00, 0x00, 0x13, // TPKT header, length 19
+	0x0e, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, // X.224 CR TPDU
+	0x01, 0x00, 0x08, 0x00, // RDP_NEG_REQ
+	0x03, 0x00, 0x00, 0x00, // PROTOCOL_SSL | PROTOCOL_HYBRID
+}
+
+// RDPDriver implements the nozzle.Driver interface.
+type RDPDriver struct{}
+
+func init() {
+	nozzle.Register("rdp", RDPDriver{})
+}
+
+// New is used to create an RDP nozzle and accepts the following configuration
+// options:
+//
+// host
+//
+// The RDP server(s) to authenticate against, comma separated. Each attempt is
+// sent to one host chosen at random. The port defaults to 3389.
+//
+// domain
+//
+// The NetBIOS domain name (e.g. "EXAMPLE") used for usernames without a
+// domain. It is sent in the NTLM negotiate message, while the credential is
+// checked in the domain the server names in its challenge, as with the ntlm
+// nozzle.
+//
+// The server must require network level authentication. The nozzle performs
+// the CredSSP NTLM exchange and disconnects as soon as the server accepts or
+// rejects the credential, before any credentials are delegated or a session
+// is created.
+func (RDPDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	hosts, err := parseHosts("rdp", opts, "3389")
+	if err != nil {
+		return nil, err
+	}
+
+	return &RDPNozzle{
+		Hosts:  hosts,
+		Domain: opts["domain"],
+	}, nil
+}
+
+// Describe returns the configuration options of the RDP nozzle.
+func (RDPDriver) Describe() []nozzle.Option {
+	return []nozzle.Option{
+		{Name: "host", Description: "comma separated RDP servers, port 3389 by default", Required: true},
+		{Name: "domain", Description: "the NetBIOS domain name used for usernames without a domain"},
+	}
+}
+
+// RDPNozzle implements the nozzle.Nozzle interface for RDP network level
+// authentication.
+type RDPNozzle struct {
+	// Hosts are the host:port addresses of the RDP servers
+	Hosts []string
+
+	// Domain is the NetBIOS domain name used for unqualified usernames
+	Domain string
+}
+
+// parseHosts reads the comma separated host config parameter.
+func parseHosts(name string, opts map[string]string, port string) ([]string, error) {
+	host, ok := opts["host"]
+	if !ok {
+		return nil, fmt.Errorf("%s nozzle requires 'host' config parameter", name)
+	}
+
+	var hosts []string
+	for _, h := range strings.Split(host, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" {
+			hosts = append(hosts, hostPort(h, port))
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s nozzle requires at least one host", name)
+	}
+	return hosts, nil
+}
+
+// pickHost returns a random host from hosts.
+func pickHost(hosts []string) string {
+	return hosts[rand.Intn(len(hosts))] // nolint:gosec
+}
+
+// tsRequest is the CredSSP TSRequest structure (MS-CSSP 2.2.1).
+type tsRequest struct {
+	Version    int         `asn1:"explicit,tag:0"`
+	NegoTokens []negoToken `asn1:"optional,explicit,tag:1"`
+	AuthInfo   []byte      `asn1:"optional,explicit,tag:2"`
+	PubKeyAuth []byte      `asn1:"optional,explicit,tag:3"`
+	ErrorCode  int64       `asn1:"optional,explicit,tag:4"`
+}
+
+// negoToken is a single entry of the TSRequest NegoData.
+type negoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs a CredSSP NTLM
+// exchange with the supplied credentials.
+func (n *RDPNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", pickHost(n.Hosts), DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint:errcheck
+	err = conn.SetDeadline(time.Now().Add(DialTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	err = negotiateNLA(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true, // nolint:gosec
+	})
+	err = tlsConn.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := subjectPublicKey(tlsConn.ConnectionState().PeerCertificates)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, user := splitUsername(username, n.Domain)
+	negotiate, err := ntlmNegotiate(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := credssp(tlsConn, &tsRequest{
+		Version:    credsspVersion,
+		NegoTokens: []negoToken{{Token: negotiate}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.NegoTokens) == 0 {
+		return nil, fmt.Errorf("rdp server did not send an ntlm challenge")
+	}
+	authenticate, session, err := ntlmAuthenticate(resp.NegoTokens[0].Token, user, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// the server only answers with its own pubKeyAuth if the authenticate
+	// message was accepted, otherwise it reports the logon NTSTATUS
+	sig, sealed := session.Seal(pubKey)
+	resp, err = credssp(tlsConn, &tsRequest{
+		Version:    credsspVersion,
+		NegoTokens: []negoToken{{Token: authenticate}},
+		PubKeyAuth: append(sig, sealed...),
+	})
+	if err != nil {
+		var status *credsspError
+		if errors.As(err, &status) {
+			return classifyStatus(status.Code)
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("rdp server closed the connection without an error code")
+		}
+		return nil, err
+	}
+	if len(resp.PubKeyAuth) == 0 {
+		return nil, fmt.Errorf("rdp server did not send pubKeyAuth")
+	}
+
+	return &event.AuthResponse{
+		Valid: true,
+	}, nil
+}
+
+// negotiateNLA sends the X.224 Connection Request and verifies that the server
+// selected CredSSP.
+func negotiateNLA(conn net.Conn) error {
+	_, err := conn.Write(x224ConnectionRequest)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if header[0] != 0x03 || length < 4 {
+		return fmt.Errorf("invalid tpkt header from rdp server")
+	}
+	body := make([]byte, length-4)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return err
+	}
+
+	// X.224 Connection Confirm followed by an RDP_NEG_RSP or RDP_NEG_FAILURE
+	if len(body) < 15 {
+		return fmt.Errorf("rdp server only supports standard rdp security")
+	}
+	neg := body[7:15]
+	value := binary.LittleEndian.Uint32(neg[4:])
+	switch {
+	case neg[0] == typeRDPNegFailure:
+		return fmt.Errorf("rdp negotiation failure: %d", value)
+	case neg[0] != typeRDPNegRsp:
+		return fmt.Errorf("invalid rdp negotiation response type: %d", neg[0])
+	case value != protocolHybrid:
+		return fmt.Errorf("rdp server does not require network level authentication")
+	}
+	return nil
+}
+
+// subjectPublicKey returns the SubjectPublicKey of the server certificate,
+// which the client must encrypt in its first pubKeyAuth.
+func subjectPublicKey(certs []*x509.Certificate) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("rdp server did not send a certificate")
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(certs[0].RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, err
+	}
+	return spki.PublicKey.Bytes, nil
+}
+
+// credsspError is returned when the server reports an errorCode.
+type credsspError struct {
+	Code uint32
+}
+
+func (e *credsspError) Error() string {
+	return fmt.Sprintf("credssp error code 0x%08X", e.Code)
+}
+
+// credssp writes a TSRequest and reads the server's TSRequest response.
+func credssp(rw io.ReadWriter, req *tsRequest) (*tsRequest, error) {
+	b, err := asn1.Marshal(*req)
+	if err != nil {
+		return nil, err
+	}
+	_, err = rw.Write(b)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = readDER(rw)
+	if err != nil {
+		return nil, err
+	}
+	var resp tsRequest
+	_, err = asn1.Unmarshal(b, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ErrorCode != 0 {
+		return nil, &credsspError{Code: uint32(resp.ErrorCode)}
+	}
+	return &resp, nil
+}
+
+// readDER reads a single DER encoded element from r.
+func readDER(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("invalid der length from rdp server")
+		}
+		extra := make([]byte, n)
+		_, err = io.ReadFull(r, extra)
+		if err != nil {
+			return nil, err
+		}
+		header = append(header, extra...)
+		length = 0
+		for _, b := range extra {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 1<<16 {
+		return nil, fmt.Errorf("der element from rdp server is too large")
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, body...), nil
+}
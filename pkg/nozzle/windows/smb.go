@@ -0,0 +1,136 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package windows
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/hirochachacha/go-smb2"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+// SMBDriver implements the nozzle.Driver interface.
+type SMBDriver struct{}
+
+func init() {
+	nozzle.Register("smb", SMBDriver{})
+}
+
+// New is used to create an SMB nozzle and accepts the following configuration
+// options:
+//
+// host
+//
+// The SMB server(s) to authenticate against, comma separated. Each attempt is
+// sent to one host chosen at random. The port defaults to 445.
+//
+// domain
+//
+// The NetBIOS domain name (e.g. "EXAMPLE") used for usernames without a
+// domain.
+//
+// The nozzle performs an SMB2 session setup and logs off immediately. No tree
+// is connected and no file is opened.
+func (SMBDriver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	hosts, err := parseHosts("smb", opts, "445")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SMBNozzle{
+		Hosts:  hosts,
+		Domain: opts["domain"],
+	}, nil
+}
+
+// Describe returns the configuration options of the SMB nozzle.
+func (SMBDriver) Describe() []nozzle.Option {
+	return []nozzle.Option{
+		{Name: "host", Description: "comma separated SMB servers, port 445 by default", Required: true},
+		{Name: "domain", Description: "the NetBIOS domain name used for usernames without a domain"},
+	}
+}
+
+// SMBNozzle implements the nozzle.Nozzle interface for SMB2 NTLM session
+// setup.
+type SMBNozzle struct {
+	// Hosts are the host:port addresses of the SMB servers
+	Hosts []string
+
+	// Domain is the NetBIOS domain name used for unqualified usernames
+	Domain string
+}
+
+// Login fulfils the nozzle.Nozzle interface and performs an SMB2 session setup
+// with the supplied credentials.
+func (n *SMBNozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DialTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", pickHost(n.Hosts))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint:errcheck
+
+	domain, user := splitUsername(username, n.Domain)
+	dialer := &smb2.Dialer{
+		// requiring signing makes the session setup fail if the server
+		// falls back to a guest or anonymous session
+		Negotiator: smb2.Negotiator{
+			RequireMessageSigning: true,
+		},
+		Initiator: &smb2.NTLMInitiator{
+			User:     user,
+			Password: password,
+			Domain:   domain,
+		},
+	}
+
+	session, err := dialer.DialContext(ctx, conn)
+	if err != nil {
+		var status *smb2.ResponseError
+		if errors.As(err, &status) {
+			return classifyStatus(status.Code)
+		}
+		var invalid *smb2.InvalidResponseError
+		if errors.As(err, &invalid) && strings.Contains(invalid.Message, "doesn't support signing") {
+			return &event.AuthResponse{
+				Valid: false,
+				Metadata: map[string]interface{}{
+					"reason": "guest_session",
+				},
+			}, nil
+		}
+		return nil, err
+	}
+	_ = session.Logoff()
+
+	return &event.AuthResponse{
+		Valid: true,
+	}, nil
+}
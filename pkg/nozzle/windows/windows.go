@@ -0,0 +1,122 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package windows implements nozzles for the Windows network logon protocols
+// found inside the perimeter: SMB (NTLM session setup) and RDP (network level
+// authentication). Both nozzles stop once the credential has been checked and
+// never open a share or an interactive session.
+package windows
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// DialTimeout bounds both the TCP connection and the whole protocol
+	// exchange for a single credential guess
+	DialTimeout = 15 * time.Second
+)
+
+// NTSTATUS values returned by a failed network logon (MS-ERREF 2.3.1).
+const (
+	statusNoSuchUser             = 0xC0000064
+	statusWrongPassword          = 0xC000006A
+	statusLogonFailure           = 0xC000006D
+	statusAccountRestriction     = 0xC000006E
+	statusInvalidLogonHours      = 0xC000006F
+	statusInvalidWorkstation     = 0xC0000070
+	statusPasswordExpired        = 0xC0000071
+	statusAccountDisabled        = 0xC0000072
+	statusInsufficientResources  = 0xC000009A
+	statusLogonTypeNotGranted    = 0xC000015B
+	statusAccountExpired         = 0xC0000193
+	statusPasswordMustChange     = 0xC0000224
+	statusAccountLockedOut       = 0xC0000234
+	statusRequestNotAccepted     = 0xC00000D0
+	statusTooManySessions        = 0xC00000CE
+	statusRemoteResourcesBlocked = 0xC0000205
+)
+
+// classifyStatus maps the NTSTATUS of a failed logon onto an AuthResponse.
+// The restriction, expiry, and disabled statuses are only returned after the
+// password has been verified, so they are reported as valid with a reason.
+func classifyStatus(code uint32) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"ntstatus": fmt.Sprintf("0x%08X", code),
+	}
+
+	switch code {
+	case statusLogonFailure, statusWrongPassword:
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case statusNoSuchUser:
+		metadata["reason"] = "user_not_found"
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case statusAccountLockedOut:
+		return &event.AuthResponse{
+			Locked:   true,
+			Metadata: metadata,
+		}, nil
+	case statusInsufficientResources, statusRequestNotAccepted, statusTooManySessions,
+		statusRemoteResourcesBlocked:
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case statusPasswordExpired, statusPasswordMustChange:
+		metadata["reason"] = "password_expired"
+		return &event.AuthResponse{
+			Valid:    true,
+			Expired:  true,
+			Metadata: metadata,
+		}, nil
+	case statusAccountDisabled:
+		metadata["reason"] = "account_disabled"
+	case statusAccountExpired:
+		metadata["reason"] = "account_expired"
+	case statusAccountRestriction, statusInvalidLogonHours, statusInvalidWorkstation,
+		statusLogonTypeNotGranted:
+		metadata["reason"] = "logon_restricted"
+	default:
+		return nil, fmt.Errorf("unhandled ntstatus from windows provider: 0x%08X", code)
+	}
+
+	return &event.AuthResponse{
+		Valid:    true,
+		Metadata: metadata,
+	}, nil
+}
+
+// hostPort returns the host with the default port appended unless the host
+// already includes a port.
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package windows
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	for _, name := range []string{"smb", "rdp"} {
+		_, err := nozzle.Open(name, map[string]string{
+			"host":   "dc01.example.org, dc02.example.org:1445",
+			"domain": "EXAMPLE",
+		})
+		if err != nil {
+			t.Fatalf("unable to open %s nozzle: %s", name, err)
+		}
+
+		_, err = nozzle.Open(name, map[string]string{
+			"domain": "EXAMPLE",
+		})
+		if err == nil {
+			t.Fatalf("expected error opening %s nozzle without host", name)
+		}
+	}
+
+	noz, err := RDPDriver{}.New(map[string]string{"host": "dc01.example.org, dc02.example.org:1445"})
+	if err != nil {
+		t.Fatalf("unable to create rdp nozzle: %s", err)
+	}
+	hosts := noz.(*RDPNozzle).Hosts
+	if len(hosts) != 2 || hosts[0] != "dc01.example.org:3389" || hosts[1] != "dc02.example.org:1445" {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+}
+
+func TestSplitUsername(t *testing.T) {
+	var testcases = []struct {
+		input  string
+		domain string
+		user   string
+	}{
+		{"alice", "EXAMPLE", "alice"},
+		{`OTHER\alice`, "OTHER", "alice"},
+		{"alice@example.org", "", "alice@example.org"},
+	}
+	for _, test := range testcases {
+		domain, user := splitUsername(test.input, "EXAMPLE")
+		if domain != test.domain || user != test.user {
+			t.Errorf("splitUsername(%s) was %s, %s", test.input, domain, user)
+		}
+	}
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestNTLMv2 checks the session key derivation and sealing against the test
+// vectors in MS-NLMP 4.2.4.
+func TestNTLMv2(t *testing.T) {
+	target := encodeUTF16("Domain")
+	ntowf := ntowfv2("User", target, "Password")
+	if expected := unhex(t, "0c868a403bfd7a93a3001ef22ef02e3f"); !bytes.Equal(ntowf, expected) {
+		t.Errorf("ntowfv2 was %x, expected %x", ntowf, expected)
+	}
+
+	// a challenge naming the target, and an authenticate message holding
+	// only the NTLMv2 response
+	challenge := make([]byte, 48)
+	copy(challenge, ntlmSignature)
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint16(challenge[12:], uint16(len(target)))
+	binary.LittleEndian.PutUint32(challenge[16:], 48)
+	challenge = append(challenge, target...)
+	name, _, err := parseChallenge(challenge)
+	if err != nil || !bytes.Equal(name, target) {
+		t.Fatalf("unexpected target name %x (%v)", name, err)
+	}
+
+	response := unhex(t, "68cd0ab851e51c96aabc927bebef6a1c0101000000000000")
+	authenticate := make([]byte, 64)
+	binary.LittleEndian.PutUint16(authenticate[20:], uint16(len(response)))
+	binary.LittleEndian.PutUint32(authenticate[24:], 64)
+	authenticate = append(authenticate, response...)
+	proof, err := ntProofStr(authenticate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ntProofStr(authenticate[:70]); err == nil {
+		t.Error("expected an error for a truncated authenticate message")
+	}
+
+	sessionKey := hmacMD5(ntowf, proof)
+	if expected := unhex(t, "8de40ccadbc14a82f15cb0ad0de95ca3"); !bytes.Equal(sessionKey, expected) {
+		t.Errorf("session base key was %x, expected %x", sessionKey, expected)
+	}
+
+	session, err := newNTLMSession(ntlmNegotiateKeyExch, bytes.Repeat([]byte{0x55}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, sealed := session.Seal(encodeUTF16("Plaintext"))
+	if expected := unhex(t, "54e50165bf1936dc996020c1811b0f06fb5f"); !bytes.Equal(sealed, expected) {
+		t.Errorf("sealed message was %x, expected %x", sealed, expected)
+	}
+	if expected := unhex(t, "010000007fb38ec5c55d497600000000"); !bytes.Equal(sig, expected) {
+		t.Errorf("signature was %x, expected %x", sig, expected)
+	}
+}
+
+func TestNTLMNegotiate(t *testing.T) {
+	msg, err := ntlmNegotiate("EXAMPLE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := binary.LittleEndian.Uint32(msg[12:])
+	if flags&ntlmSessionFlags != ntlmSessionFlags || flags&ntlmNegotiateKeyExch != 0 {
+		t.Errorf("unexpected negotiate flags 0x%08x", flags)
+	}
+}
+
+func TestClassifyStatus(t *testing.T) {
+	var testcases = []struct {
+		code        uint32
+		valid       bool
+		locked      bool
+		ratelimited bool
+		wantErr     bool
+	}{
+		{statusLogonFailure, false, false, false, false},
+		{statusNoSuchUser, false, false, false, false},
+		{statusAccountLockedOut, false, true, false, false},
+		{statusPasswordExpired, true, false, false, false},
+		{statusPasswordMustChange, true, false, false, false},
+		{statusAccountDisabled, true, false, false, false},
+		{statusLogonTypeNotGranted, true, false, false, false},
+		{statusInsufficientResources, false, false, true, false},
+		{0xC0000022, false, false, false, true},
+	}
+	for _, test := range testcases {
+		resp, err := classifyStatus(test.code)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("0x%08X: expected error", test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("0x%08X: unexpected error: %s", test.code, err)
+			continue
+		}
+		if resp.Valid != test.valid || resp.Locked != test.locked || resp.RateLimited != test.ratelimited {
+			t.Errorf("0x%08X: got %+v", test.code, resp)
+		}
+		expired := test.code == statusPasswordExpired || test.code == statusPasswordMustChange
+		if resp.Expired != expired {
+			t.Errorf("0x%08X: expired was %t, expected %t", test.code, resp.Expired, expired)
+		}
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wordpress implements a nozzle for WordPress sites, through either
+// the wp-login.php form or the wp.getUsersBlogs method of xmlrpc.php.
+package wordpress
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+const (
+	// FrozenUserAgent is a static user agent that we use for all requests. This
+	// value is based on the UA client hint work within browsers.
+	// Additional details: https://bugs.chromium.org/p/chromium/issues/detail?id=955620
+	FrozenUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)" +
+		"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/75.0.3764.0 Safari/537.36"
+
+	// ModeLogin posts the credentials to the wp-login.php form
+	ModeLogin = "login"
+
+	// ModeXMLRPC calls wp.getUsersBlogs through xmlrpc.php with the
+	// credentials
+	ModeXMLRPC = "xmlrpc"
+
+	// loginPath is the path of the login form
+	loginPath = "/wp-login.php"
+
+	// xmlrpcPath is the path of the XML-RPC endpoint
+	xmlrpcPath = "/xmlrpc.php"
+
+	// loggedInCookie is the prefix of the cookie set by a successful login
+	loggedInCookie = "wordpress_logged_in_"
+
+	// testCookie is the cookie the login form requires to prove the browser
+	// accepts cookies, set by the form page with this value
+	testCookie      = "wordpress_test_cookie"
+	testCookieValue = "WP Cookie check"
+
+	// faultIncorrect is the XML-RPC fault code of a wrong username or
+	// password, and faultDisabled that of a site with XML-RPC disabled
+	faultIncorrect = 403
+	faultDisabled  = 405
+
+	// bodyLimit bounds how much of a response is read
+	bodyLimit = 1 << 20
+)
+
+var (
+	// RateLimiter limits requests from the same worker to a maximum of 3/s
+	RateLimiter = rate.NewLimiter(rate.Every(300*time.Millisecond), 1)
+
+	// loginErrorRegex matches the error the login form shows after a failed
+	// login
+	loginErrorRegex = regexp.MustCompile(`(?is)<div[^>]+id\s*=\s*["']login_error["'][^>]*>(.*?)</div>`)
+
+	// twoFactorRegex matches the second factor forms of the common two-factor
+	// plugins, shown only once the password was accepted
+	twoFactorRegex = regexp.MustCompile(`(?i)name\s*=\s*["'](?:wp-auth-id|wfls-token|googleotp|two_factor_code)["']`)
+
+	// tagRegex matches the markup stripped from a login error
+	tagRegex = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Driver implements the nozzle.Driver interface.
+type Driver struct{}
+
+func init() {
+	nozzle.Register("wordpress", Driver{})
+}
+
+// New is used to create a WordPress nozzle and accepts the following
+// configuration options:
+//
+// host
+//
+// The host name (and optional port) of the WordPress site, e.g.
+// "blog.example.org".
+//
+// path
+//
+// The path WordPress is installed under, e.g. "/blog". Empty by default.
+//
+// scheme
+//
+// https (default) or http.
+//
+// mode
+//
+// login (default) posts the credentials to wp-login.php, like a browser.
+// xmlrpc calls wp.getUsersBlogs through xmlrpc.php instead, which security
+// plugins limiting the login form often leave out. Each attempt sends one
+// credential, system.multicall is never used to batch guesses, so the
+// campaign's interval holds for every guess.
+//
+// The header.<Name>, xff_pool, and xff_header options described by
+// nozzle.ParseHeaders are also accepted.
+//
+// The min_tls_version, max_tls_version, cipher_suites, allow_weak_tls,
+// tls_server_name, and insecure_skip_verify options described by
+// nozzle.TLSConfig are also accepted.
+//
+// The keep_alive, http2, max_idle_conns, and idle_conn_timeout options
+// described by nozzle.ParseTransport are also accepted.
+//
+// The timeout and submit_timeout options described by nozzle.ParseTimeouts
+// are also accepted.
+func (Driver) New(opts map[string]string) (nozzle.Nozzle, error) {
+	host, ok := opts["host"]
+	if !ok {
+		return nil, fmt.Errorf("wordpress nozzle requires 'host' config parameter")
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || host == "" || u.Host != host || u.User != nil {
+		return nil, fmt.Errorf("wordpress nozzle 'host' must be a host name without a scheme or path: %s", host)
+	}
+
+	path := strings.TrimSuffix(opts["path"], "/")
+	if path != "" && (!strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#")) {
+		return nil, fmt.Errorf("wordpress nozzle 'path' must be an absolute path: %s", path)
+	}
+
+	scheme, ok := opts["scheme"]
+	if !ok {
+		scheme = "https"
+	}
+	if scheme != "https" && scheme != "http" {
+		return nil, fmt.Errorf("wordpress nozzle 'scheme' must be https or http: %s", scheme)
+	}
+
+	mode, ok := opts["mode"]
+	if !ok {
+		mode = ModeLogin
+	}
+	if mode != ModeLogin && mode != ModeXMLRPC {
+		return nil, fmt.Errorf("wordpress nozzle 'mode' must be %s or %s: %s", ModeLogin, ModeXMLRPC, mode)
+	}
+
+	headers, err := nozzle.ParseHeaders(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := nozzle.TLSConfig(opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := nozzle.ParseTransport("wordpress", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := nozzle.ParseTimeouts(opts, nozzle.StepSubmit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Nozzle{
+		BaseURL:   scheme + "://" + host + path,
+		Mode:      mode,
+		UserAgent: FrozenUserAgent,
+		Headers:   headers,
+		TLSConfig: tlsConfig,
+		Transport: transport,
+		Timeouts:  timeouts,
+	}, nil
+}
+
+// Describe returns the configuration options of the WordPress nozzle.
+func (Driver) Describe() []nozzle.Option {
+	return nozzle.JoinOptions([]nozzle.Option{
+		{Name: "host", Description: "the host name of the WordPress site, e.g. blog.example.org", Required: true},
+		{Name: "path", Description: "the path WordPress is installed under, e.g. /blog"},
+		{Name: "scheme", Description: "https (default) or http"},
+		{Name: "mode", Description: "login (default) for wp-login.php or xmlrpc for xmlrpc.php"},
+	}, nozzle.HeaderOptions, nozzle.TLSOptions, nozzle.TransportOptions,
+		nozzle.TimeoutOptions(nozzle.StepSubmit))
+}
+
+// Nozzle implements the nozzle.Nozzle interface for WordPress.
+type Nozzle struct {
+	// BaseURL is the scheme, host, and path of the WordPress site
+	BaseURL string
+
+	// Mode is the endpoint the credentials are sent to, login or xmlrpc
+	Mode string
+
+	// UserAgent will override the Go-http-client user-agent in requests
+	UserAgent string
+
+	// Headers are the configured extra headers added to each request
+	Headers *nozzle.Headers
+
+	// TLSConfig is the configured TLS client configuration
+	TLSConfig *tls.Config
+
+	// Transport holds the configured connection options
+	Transport *nozzle.Transport
+
+	// Timeouts bounds the requests of each step of a login
+	Timeouts *nozzle.Timeouts
+}
+
+// Login fulfils the nozzle.Nozzle interface and authenticates to WordPress
+// with the configured mode.
+func (n *Nozzle) Login(username, password string) (*event.AuthResponse, error) {
+	ctx := context.Background()
+	err := RateLimiter.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// each attempt receives a fresh cookie jar so a session is never shared
+	// between credential guesses
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	transport, release := n.Transport.RoundTripper(n.TLSConfig)
+	defer release()
+	client := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if n.Mode == ModeXMLRPC {
+		return n.xmlrpc(client, username, password)
+	}
+	return n.login(client, username, password)
+}
+
+// login posts the credentials to wp-login.php along with the test cookie the
+// form page would have set. A successful login redirects with the logged in
+// cookie. A failed one shows the login form again with an error, which tells
+// a wrong password from an unknown user.
+func (n *Nozzle) login(client *http.Client, username, password string) (*event.AuthResponse, error) {
+	u, err := url.Parse(n.BaseURL + loginPath)
+	if err != nil {
+		return nil, err
+	}
+	client.Jar.SetCookies(u, []*http.Cookie{{Name: testCookie, Value: testCookieValue}})
+
+	form := url.Values{
+		"log":         {username},
+		"pwd":         {password},
+		"wp-submit":   {"Log In"},
+		"redirect_to": {n.BaseURL + "/wp-admin/"},
+		"testcookie":  {"1"},
+	}
+	resp, body, res, err := n.do(client, u.String(), "application/x-www-form-urlencoded",
+		strings.NewReader(form.Encode()), username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	return classifyLogin(resp, body)
+}
+
+// classifyLogin interprets the response to a login form post.
+func classifyLogin(resp *http.Response, body string) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"status": resp.StatusCode,
+	}
+	switch resp.StatusCode {
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata:    metadata,
+		}, nil
+	case 301, 302, 303:
+		for _, c := range resp.Cookies() {
+			if strings.HasPrefix(c.Name, loggedInCookie) {
+				if location, err := resp.Location(); err == nil {
+					metadata["location"] = location.Path
+				}
+				return &event.AuthResponse{
+					Valid:    true,
+					Metadata: metadata,
+					Capture:  nozzle.Capture(resp),
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("wordpress login redirected without signing in")
+	case 200:
+	default:
+		return nil, fmt.Errorf("unexpected status from wordpress login: %d", resp.StatusCode)
+	}
+
+	// a correct password of an account with two-factor authentication
+	// renders the second factor form in place of the login form
+	if twoFactorRegex.MatchString(body) {
+		return &event.AuthResponse{
+			Valid:    true,
+			MFA:      true,
+			Metadata: metadata,
+			Capture:  nozzle.Capture(resp),
+		}, nil
+	}
+
+	m := loginErrorRegex.FindStringSubmatch(body)
+	if m == nil {
+		return nil, fmt.Errorf("wordpress login page does not show a login error")
+	}
+	msg := strings.ToLower(strings.Join(strings.Fields(html.UnescapeString(tagRegex.ReplaceAllString(m[1], " "))), " "))
+	switch {
+	case strings.Contains(msg, "too many") || strings.Contains(msg, "locked out") ||
+		strings.Contains(msg, "try again in"):
+		// lockout plugins block the source address rather than the account
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case strings.Contains(msg, "is incorrect"):
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(msg, "unknown username") || strings.Contains(msg, "unknown email") ||
+		strings.Contains(msg, "is not registered"):
+		metadata["reason"] = "user_not_found"
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case strings.Contains(msg, "cookies"):
+		return nil, fmt.Errorf("wordpress rejected the login test cookie")
+	}
+	return nil, fmt.Errorf("unexpected wordpress login error: %s", msg)
+}
+
+// getUsersBlogs is the XML-RPC call made with each credential.
+const getUsersBlogs = `<?xml version="1.0"?>
+<methodCall><methodName>wp.getUsersBlogs</methodName><params>` +
+	`<param><value><string>%s</string></value></param>` +
+	`<param><value><string>%s</string></value></param>` +
+	`</params></methodCall>`
+
+// xmlrpc calls wp.getUsersBlogs with the credentials. A valid credential
+// returns the user's blogs, and any other a fault.
+func (n *Nozzle) xmlrpc(client *http.Client, username, password string) (*event.AuthResponse, error) {
+	var user, pass bytes.Buffer
+	if err := xml.EscapeText(&user, []byte(username)); err != nil {
+		return nil, err
+	}
+	if err := xml.EscapeText(&pass, []byte(password)); err != nil {
+		return nil, err
+	}
+	call := fmt.Sprintf(getUsersBlogs, user.String(), pass.String())
+
+	resp, body, res, err := n.do(client, n.BaseURL+xmlrpcPath, "text/xml", strings.NewReader(call),
+		username, password)
+	if err != nil || res != nil {
+		return res, err
+	}
+	return classifyXMLRPC(resp, body)
+}
+
+// methodResponse is the part of an XML-RPC response used by the nozzle. A
+// successful wp.getUsersBlogs returns an array with a struct for each blog.
+type methodResponse struct {
+	Blogs []xmlrpcStruct `xml:"params>param>value>array>data>value>struct"`
+	Fault *xmlrpcStruct  `xml:"fault>value>struct"`
+}
+
+// xmlrpcStruct is an XML-RPC struct of scalar members.
+type xmlrpcStruct struct {
+	Members []struct {
+		Name  string `xml:"name"`
+		Value struct {
+			Int     string `xml:"int"`
+			I4      string `xml:"i4"`
+			Boolean string `xml:"boolean"`
+			String  string `xml:"string"`
+			Text    string `xml:",chardata"`
+		} `xml:"value"`
+	} `xml:"member"`
+}
+
+// member returns the value of the named member as a string, or "".
+func (s *xmlrpcStruct) member(name string) string {
+	for _, m := range s.Members {
+		if m.Name != name {
+			continue
+		}
+		for _, v := range []string{m.Value.Int, m.Value.I4, m.Value.Boolean, m.Value.String} {
+			if v != "" {
+				return v
+			}
+		}
+		return strings.TrimSpace(m.Value.Text)
+	}
+	return ""
+}
+
+// classifyXMLRPC interprets the response to wp.getUsersBlogs. WordPress
+// answers a wrong password and an unknown user alike with fault 403, and a
+// site with XML-RPC disabled with fault 405.
+func classifyXMLRPC(resp *http.Response, body string) (*event.AuthResponse, error) {
+	metadata := map[string]interface{}{
+		"status": resp.StatusCode,
+	}
+	switch resp.StatusCode {
+	case 429:
+		return &event.AuthResponse{
+			RateLimited: true,
+			RetryAfter:  nozzle.RetryAfter(resp.Header, time.Now()),
+			Metadata:    metadata,
+		}, nil
+	case 200:
+	default:
+		return nil, fmt.Errorf("unexpected status from wordpress xmlrpc: %d", resp.StatusCode)
+	}
+
+	var mr methodResponse
+	err := xml.Unmarshal([]byte(body), &mr)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected response from wordpress xmlrpc: %w", err)
+	}
+
+	if mr.Fault == nil {
+		metadata["blogs"] = len(mr.Blogs)
+		if len(mr.Blogs) > 0 {
+			admin := mr.Blogs[0].member("isAdmin")
+			metadata["admin"] = admin == "1" || admin == "true"
+		}
+		return &event.AuthResponse{
+			Valid:    true,
+			Metadata: metadata,
+			Capture:  nozzle.Capture(resp),
+		}, nil
+	}
+
+	code := mr.Fault.member("faultCode")
+	msg := mr.Fault.member("faultString")
+	metadata["fault_code"] = code
+	switch {
+	case code == fmt.Sprint(faultIncorrect) && strings.Contains(strings.ToLower(msg), "too many"):
+		return &event.AuthResponse{
+			RateLimited: true,
+			Metadata:    metadata,
+		}, nil
+	case code == fmt.Sprint(faultIncorrect):
+		return &event.AuthResponse{
+			Valid:    false,
+			Metadata: metadata,
+		}, nil
+	case code == fmt.Sprint(faultDisabled):
+		return nil, fmt.Errorf("wordpress xmlrpc is disabled: %s", msg)
+	}
+	return nil, fmt.Errorf("unexpected fault from wordpress xmlrpc: %s %s", code, msg)
+}
+
+// do posts the body with its content type, the submit timeout, and the
+// configured headers, and returns the response with its body, which has been
+// read and closed. If the response is a WAF or captcha challenge, the
+// AuthResponse reporting it is returned instead of the body.
+func (n *Nozzle) do(client *http.Client, url, contentType string, data io.Reader,
+	username, password string) (*http.Response, string, *event.AuthResponse, error) {
+	req, err := http.NewRequest("POST", url, data)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", n.UserAgent)
+	n.Headers.Apply(req, username, password)
+	req, cancel := n.Timeouts.Request(req, nozzle.StepSubmit)
+	defer cancel()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if res := nozzle.Challenged(resp); res != nil {
+		return resp, "", res, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, bodyLimit))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	// the body is kept so a valid response can be captured
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return resp, string(b), nil, nil
+}
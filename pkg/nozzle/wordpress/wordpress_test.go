@@ -0,0 +1,236 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wordpress
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func TestNozzle(t *testing.T) {
+	_, err := nozzle.Open("wordpress", map[string]string{
+		"host":   "blog.example.org",
+		"path":   "/blog/",
+		"scheme": "http",
+		"mode":   "xmlrpc",
+	})
+	if err != nil {
+		t.Fatalf("unable to open nozzle: %s", err)
+	}
+
+	for _, opts := range []map[string]string{
+		{},
+		{"host": "https://blog.example.org"},
+		{"host": "blog.example.org/blog"},
+		{"host": "blog.example.org", "path": "blog"},
+		{"host": "blog.example.org", "scheme": "ftp"},
+		{"host": "blog.example.org", "mode": "rest"},
+	} {
+		_, err = nozzle.Open("wordpress", opts)
+		if err == nil {
+			t.Errorf("expected error opening nozzle with %v", opts)
+		}
+	}
+}
+
+// loginError renders the login page with the error shown after a failed
+// login.
+func loginError(msg string) string {
+	return `<html><body class="login"><div id="login"><div id="login_error" class="notice notice-error"><p>` +
+		msg + `</p></div><form name="loginform" id="loginform" method="post"></form></div></body></html>`
+}
+
+// fault renders an XML-RPC fault response.
+func fault(code int, msg string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><methodResponse><fault><value><struct>`+
+		`<member><name>faultCode</name><value><int>%d</int></value></member>`+
+		`<member><name>faultString</name><value><string>%s</string></value></member>`+
+		`</struct></value></fault></methodResponse>`, code, msg)
+}
+
+// testServer simulates a WordPress site installed under /blog. The password
+// selects the outcome of the login.
+func testServer(t *testing.T) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/blog" + loginPath:
+			if c, err := r.Cookie(testCookie); err != nil || c.Value != testCookieValue {
+				fmt.Fprint(w, loginError("<strong>Error:</strong> Cookies are blocked or not supported."))
+				return
+			}
+			if r.PostFormValue("log") != "alice" {
+				fmt.Fprint(w, loginError("<strong>Error:</strong> The username <strong>"+
+					r.PostFormValue("log")+"</strong> is not registered on this site."))
+				return
+			}
+			switch r.PostFormValue("pwd") {
+			case "valid":
+				http.SetCookie(w, &http.Cookie{Name: loggedInCookie + "hash", Value: "alice"})
+				http.Redirect(w, r, "/blog/wp-admin/", 302)
+			case "two-factor":
+				fmt.Fprint(w, `<form name="validate_2fa_form" id="loginform" method="post">`+
+					`<input type="hidden" name="wp-auth-id" value="1"></form>`)
+			case "throttled":
+				fmt.Fprint(w, loginError("<strong>Error:</strong> Too many failed login attempts. "+
+					"Please try again in 20 minutes."))
+			default:
+				fmt.Fprint(w, loginError("<strong>Error:</strong> The password you entered for the username "+
+					"<strong>alice</strong> is incorrect."))
+			}
+		case "/blog" + xmlrpcPath:
+			var call struct {
+				Method string   `xml:"methodName"`
+				Params []string `xml:"params>param>value>string"`
+			}
+			if err := xml.NewDecoder(r.Body).Decode(&call); err != nil || call.Method != "wp.getUsersBlogs" ||
+				len(call.Params) != 2 {
+				t.Errorf("unexpected call: %+v, %v", call, err)
+				w.WriteHeader(400)
+				return
+			}
+			switch call.Params[1] {
+			case "valid<&>":
+				fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><array><data>`+
+					`<value><struct><member><name>isAdmin</name><value><boolean>0</boolean></value></member>`+
+					`</struct></value></data></array></value></param></params></methodResponse>`)
+			case "disabled":
+				fmt.Fprint(w, fault(faultDisabled, "XML-RPC services are disabled on this site."))
+			case "throttled":
+				w.WriteHeader(429)
+			default:
+				fmt.Fprint(w, fault(faultIncorrect, "Incorrect username or password."))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLogin(t *testing.T) {
+	srv := testServer(t)
+	defer srv.Close()
+
+	var testcases = []struct {
+		mode        string
+		username    string
+		password    string
+		valid       bool
+		mfa         bool
+		ratelimited bool
+		reason      string
+		wantErr     bool
+	}{
+		{mode: ModeLogin, password: "valid", valid: true},
+		{mode: ModeLogin, password: "two-factor", valid: true, mfa: true},
+		{mode: ModeLogin, password: "throttled", ratelimited: true},
+		{mode: ModeLogin, password: "wrong"},
+		{mode: ModeLogin, username: "bob", password: "wrong", reason: "user_not_found"},
+		{mode: ModeXMLRPC, password: "valid<&>", valid: true},
+		{mode: ModeXMLRPC, password: "throttled", ratelimited: true},
+		{mode: ModeXMLRPC, password: "disabled", wantErr: true},
+		{mode: ModeXMLRPC, password: "wrong"},
+	}
+	for _, test := range testcases {
+		noz, err := nozzle.Open("wordpress", map[string]string{
+			"host":                 strings.TrimPrefix(srv.URL, "https://"),
+			"path":                 "/blog",
+			"mode":                 test.mode,
+			"insecure_skip_verify": "true",
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		username := test.username
+		if username == "" {
+			username = "alice"
+		}
+		res, err := noz.Login(username, test.password)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %s: expected error, got %+v", test.mode, test.password, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %s", test.mode, test.password, err)
+			continue
+		}
+		if res.Valid != test.valid || res.MFA != test.mfa || res.RateLimited != test.ratelimited {
+			t.Errorf("%s %s: got %+v", test.mode, test.password, res)
+		}
+		if reason, _ := res.Metadata["reason"].(string); reason != test.reason {
+			t.Errorf("%s %s: reason was %q, expected %q", test.mode, test.password, reason, test.reason)
+		}
+		if test.valid && res.Capture == nil {
+			t.Errorf("%s %s: expected the response to be captured", test.mode, test.password)
+		}
+	}
+}
+
+// TestGolden replays the saved responses in testdata through both modes.
+func TestGolden(t *testing.T) {
+	var testcases = []struct {
+		mode    string
+		file    string
+		valid   bool
+		wantErr bool
+	}{
+		{ModeLogin, "logged_in.http", true, false},
+		{ModeLogin, "login_incorrect.http", false, false},
+		{ModeLogin, "login_unknown.http", false, false},
+		{ModeXMLRPC, "xmlrpc_blogs.http", true, false},
+		{ModeXMLRPC, "xmlrpc_incorrect.http", false, false},
+		{ModeXMLRPC, "xmlrpc_disabled.http", false, true},
+	}
+	for _, test := range testcases {
+		noz, err := nozzle.Open("wordpress", map[string]string{
+			"host": "blog.example.org",
+			"mode": test.mode,
+		})
+		if err != nil {
+			t.Fatalf("unable to open nozzle: %s", err)
+		}
+
+		r, err := nozzle.ReadReplayer(filepath.Join("testdata", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := nozzle.ReplayLogin(noz, r, "alice", "Password1")
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", test.file, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.file, err)
+			continue
+		}
+		if res.Valid != test.valid {
+			t.Errorf("%s: got %+v", test.file, res)
+		}
+		if test.mode == ModeXMLRPC && test.valid && res.Metadata["admin"] != true {
+			t.Errorf("%s: expected an administrator, got %+v", test.file, res.Metadata)
+		}
+	}
+}
@@ -15,10 +15,12 @@
 package parse
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"strings"
 
@@ -96,3 +98,50 @@ func DecodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) err
 
 	return nil
 }
+
+// ValidateJSONBody reads the request body, refusing a body larger than limit
+// bytes if limit is positive, and checks it with the provided validate
+// function, e.g. a schema.Validate* function. The body is restored so it can
+// be decoded afterwards with DecodeJSONBody.
+func ValidateJSONBody(w http.ResponseWriter, r *http.Request, limit int64, validate func([]byte) error) error {
+	body, err := ReadBody(w, r, limit)
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if len(body) == 0 {
+		msg := "Request body must not be empty"
+		return &MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+	}
+
+	err = validate(body)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		if errors.As(err, &syntaxError) {
+			msg := fmt.Sprintf("Request body contains badly-formed JSON (at position %d)", syntaxError.Offset)
+			return &MalformedRequest{Status: http.StatusBadRequest, Msg: msg}
+		}
+		return &MalformedRequest{Status: http.StatusBadRequest, Msg: err.Error()}
+	}
+
+	return nil
+}
+
+// ReadBody reads the whole request body, refusing a body larger than limit
+// bytes with a 413 MalformedRequest if limit is positive.
+func ReadBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	reader := r.Body
+	if limit > 0 {
+		reader = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		if err.Error() == "http: request body too large" {
+			msg := fmt.Sprintf("Request body must not be larger than %d bytes", limit)
+			return nil, &MalformedRequest{Status: http.StatusRequestEntityTooLarge, Msg: msg}
+		}
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pwned looks up how often passwords appear in breach corpora through
+// a k-anonymity range API such as the one of Have I Been Pwned. Only the first
+// five hex characters of each password's SHA-1 digest are sent, and the
+// matching suffix is looked up locally in the returned range.
+package pwned
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" // nolint:gosec
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultURL is the range API of Have I Been Pwned
+	DefaultURL = "https://api.pwnedpasswords.com/range/"
+
+	// prefixLen is the number of hex characters of the digest sent
+	prefixLen = 5
+
+	// rangeLimit bounds the size of a single range response
+	rangeLimit = 4 << 20
+)
+
+// Client looks up password exposure counts, caching each range it fetches.
+type Client struct {
+	// URL is the range API, to which the hash prefix is appended
+	URL string
+
+	// HTTPClient is used for the range requests
+	HTTPClient *http.Client
+
+	// CacheDir, if set, holds fetched ranges across runs
+	CacheDir string
+
+	// CacheTTL is how long a range in CacheDir is used before it is fetched
+	// again
+	CacheTTL time.Duration
+
+	ranges map[string][]byte
+}
+
+// NewClient returns a Client for the range API at url, caching ranges in
+// cacheDir for a week. An empty cacheDir only caches ranges in memory.
+func NewClient(url, cacheDir string) *Client {
+	return &Client{
+		URL:        url,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		CacheDir:   cacheDir,
+		CacheTTL:   7 * 24 * time.Hour,
+	}
+}
+
+// Count returns the number of times the password appears in the breach
+// corpus, 0 if it does not appear.
+func (c *Client) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password)) // nolint:gosec
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:prefixLen], digest[prefixLen:]
+
+	r, err := c.fetch(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	// each line of a range is SUFFIX:COUNT
+	scanner := bufio.NewScanner(bytes.NewReader(r))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		sep := strings.IndexByte(line, ':')
+		if sep < 0 || !strings.EqualFold(line[:sep], suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(line[sep+1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid count in range %s: %w", prefix, err)
+		}
+		return count, nil
+	}
+	return 0, scanner.Err()
+}
+
+// fetch returns the range of the prefix from the memory cache, the cache
+// directory, or the range API, in that order.
+func (c *Client) fetch(prefix string) ([]byte, error) {
+	if r, ok := c.ranges[prefix]; ok {
+		return r, nil
+	}
+	if c.ranges == nil {
+		c.ranges = make(map[string][]byte)
+	}
+
+	var path string
+	if c.CacheDir != "" {
+		path = filepath.Join(c.CacheDir, prefix)
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < c.CacheTTL {
+			r, err := ioutil.ReadFile(path)
+			if err == nil {
+				c.ranges[prefix] = r
+				return r, nil
+			}
+		}
+	}
+
+	req, err := http.NewRequest("GET", c.URL+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// padding hides the number of suffixes in the range from an observer
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("range api returned %d", resp.StatusCode)
+	}
+	r, err := ioutil.ReadAll(io.LimitReader(resp.Body, rangeLimit))
+	if err != nil {
+		return nil, err
+	}
+	c.ranges[prefix] = r
+
+	// the cache is best effort, a range which cannot be stored is fetched
+	// again next time
+	if path != "" && os.MkdirAll(c.CacheDir, 0700) == nil {
+		_ = ioutil.WriteFile(path, r, 0600)
+	}
+	return r, nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pwned
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// sha1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+func TestCount(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/range/5BAA6" {
+			fmt.Fprint(w, "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n")
+			return
+		}
+		fmt.Fprint(w, "003D68EB55068C33ACE09247EE4C639306B:3\r\n"+
+			"1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\r\n"+
+			"2DC183F740EE76F27B78EB39C8AD972A757:0\r\n")
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "pwned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint:errcheck
+
+	c := NewClient(ts.URL+"/range/", dir)
+	for _, test := range []struct {
+		password string
+		count    int
+	}{
+		{"password", 3861493},
+		{"password", 3861493},
+		{"correct horse battery staple", 0},
+	} {
+		count, err := c.Count(test.password)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if count != test.count {
+			t.Errorf("%s: count was %d, expected %d", test.password, count, test.count)
+		}
+	}
+
+	// only the prefix is sent, and each range is fetched once
+	if len(requests) != 2 {
+		t.Errorf("expected 2 requests, got %v", requests)
+	}
+	for _, path := range requests {
+		if len(path) != len("/range/")+prefixLen {
+			t.Errorf("request path %s holds more than the prefix", path)
+		}
+	}
+
+	// a new client reads the range from the cache directory
+	count, err := NewClient(ts.URL+"/range/", dir).Count("password")
+	if err != nil || count != 3861493 {
+		t.Errorf("cached count was %d, %v", count, err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("expected the cached range to be used, got %v", requests)
+	}
+}
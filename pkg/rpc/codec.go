@@ -0,0 +1,48 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the content subtype of the JSON codec, sent by the client as
+// application/grpc+json.
+const CodecName = "json"
+
+// Codec is a gRPC codec which marshals messages as JSON, so the RPCs carry
+// the same types as the REST API rather than generated protobufs.
+type Codec struct{}
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Marshal fulfils the encoding.Codec interface.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal fulfils the encoding.Codec interface.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// Name fulfils the encoding.Codec interface.
+func (Codec) Name() string {
+	return CodecName
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpc implements the optional gRPC transport between the client and
+// the orchestrator. It carries the RPCs which benefit from streaming, such as
+// following a campaign's results as they arrive, while everything else stays
+// on the REST API. Messages are the REST API's types marshalled as JSON by
+// Codec, so the two transports cannot drift apart.
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/praetorian-inc/trident/pkg/auth"
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const (
+	// ServiceName is the name of the orchestrator's gRPC service
+	ServiceName = "trident.Orchestrator"
+
+	// DefaultPollInterval is how often a followed stream checks for new
+	// results
+	DefaultPollInterval = 2 * time.Second
+
+	// resultsBatch bounds the results read from the database at once
+	resultsBatch = 500
+)
+
+// ResultsRequest selects the results sent by StreamResults: those matching
+// the query, as in the REST API's /results, with an ID greater than AfterID.
+// With Follow, the stream stays open and sends new results as they are
+// inserted, until the client cancels it.
+type ResultsRequest struct {
+	db.Query
+	AfterID uint `json:"after_id,omitempty"`
+	Follow  bool `json:"follow,omitempty"`
+}
+
+// ResultStore is the part of the database used by the Server.
+type ResultStore interface {
+	ResultsAfter(query db.Query, afterID uint, limit int) ([]db.Result, error)
+}
+
+// resultsServer is the handler type of the service.
+type resultsServer interface {
+	StreamResults(*ResultsRequest, grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*resultsServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamResults",
+			Handler:       streamResultsHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+// streamResultsHandler decodes the request of a StreamResults call.
+func streamResultsHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(ResultsRequest)
+	err := stream.RecvMsg(req)
+	if err != nil {
+		return err
+	}
+	return srv.(resultsServer).StreamResults(req, stream)
+}
+
+// Server implements the orchestrator's gRPC service.
+type Server struct {
+	DB ResultStore
+
+	// PollInterval is how often a followed stream checks for new results,
+	// DefaultPollInterval if zero
+	PollInterval time.Duration
+}
+
+// Register registers the service with the gRPC server.
+func (s *Server) Register(g *grpc.Server) {
+	g.RegisterService(&serviceDesc, s)
+}
+
+// StreamResults sends the requested results in ID order, reading them from
+// the database in batches. The ID is always returned, since a followed
+// stream resumes after the last result sent.
+func (s *Server) StreamResults(req *ResultsRequest, stream grpc.ServerStream) error {
+	query := req.Query
+	if len(query.ReturnedFields) > 0 && !contains(query.ReturnedFields, "*") && !contains(query.ReturnedFields, "id") {
+		query.ReturnedFields = append([]string{"id"}, query.ReturnedFields...)
+	}
+	poll := s.PollInterval
+	if poll == 0 {
+		poll = DefaultPollInterval
+	}
+
+	after := req.AfterID
+	for {
+		results, err := s.DB.ResultsAfter(query, after, resultsBatch)
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			return status.Error(codes.Internal, "error querying results")
+		}
+		for i := range results {
+			err = stream.SendMsg(&results[i])
+			if err != nil {
+				return err
+			}
+			after = results[i].ID
+		}
+		if len(results) == resultsBatch {
+			continue
+		}
+		if !req.Follow {
+			return nil
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// contains reports whether the list holds s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Client calls the orchestrator's gRPC service.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Dial connects to the orchestrator's gRPC service at target, a host:port.
+// Calls are encoded with Codec.
+func Dial(ctx context.Context, target string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append(opts, grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)))
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ResultStream receives the results of a StreamResults call.
+type ResultStream struct {
+	stream grpc.ClientStream
+}
+
+// StreamResults requests a stream of results. The stream ends when the
+// context is cancelled, or, without Follow, once every result was sent.
+func (c *Client) StreamResults(ctx context.Context, req *ResultsRequest) (*ResultStream, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/StreamResults")
+	if err != nil {
+		return nil, err
+	}
+	err = stream.SendMsg(req)
+	if err != nil {
+		return nil, err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+	return &ResultStream{stream: stream}, nil
+}
+
+// Recv returns the next result, or io.EOF once the stream has ended.
+func (s *ResultStream) Recv() (*db.Result, error) {
+	var res db.Result
+	err := s.stream.RecvMsg(&res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// authCredentials sends the headers an auth.Authenticator adds to a request
+// as the metadata of each call.
+type authCredentials struct {
+	authenticator auth.Authenticator
+	secure        bool
+}
+
+// AuthCredentials returns call credentials carrying the headers added by the
+// authenticator, e.g. the Cloudflare Access token. They are only sent over
+// TLS unless insecure is set.
+func AuthCredentials(a auth.Authenticator, insecure bool) credentials.PerRPCCredentials {
+	return &authCredentials{authenticator: a, secure: !insecure}
+}
+
+// GetRequestMetadata fulfils the credentials.PerRPCCredentials interface.
+func (c *authCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://orchestrator/", nil)
+	if err != nil {
+		return nil, err
+	}
+	err = c.authenticator.Auth(req)
+	if err != nil {
+		return nil, err
+	}
+	md := make(map[string]string, len(req.Header))
+	for k := range req.Header {
+		md[strings.ToLower(k)] = req.Header.Get(k)
+	}
+	return md, nil
+}
+
+// RequireTransportSecurity fulfils the credentials.PerRPCCredentials
+// interface.
+func (c *authCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// mockStore holds results in ID order.
+type mockStore struct {
+	mu      sync.Mutex
+	results []db.Result
+	queries []db.Query
+}
+
+func (m *mockStore) add(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < n; i++ {
+		m.results = append(m.results, db.Result{Model: db.Model{ID: uint(len(m.results) + 1)}, Username: "alice"})
+	}
+}
+
+func (m *mockStore) ResultsAfter(query db.Query, afterID uint, limit int) ([]db.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries = append(m.queries, query)
+	var results []db.Result
+	for _, r := range m.results {
+		if r.ID > afterID && len(results) < limit {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// dial serves the store over an in-memory connection and returns a client.
+func dial(t *testing.T, store ResultStore) (*Client, func()) {
+	lis := bufconn.Listen(1 << 20)
+	g := grpc.NewServer()
+	(&Server{DB: store, PollInterval: 10 * time.Millisecond}).Register(g)
+	go g.Serve(lis) // nolint:errcheck
+
+	c, err := Dial(context.Background(), "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.Close() // nolint:errcheck
+		g.Stop()
+	}
+}
+
+func TestStreamResults(t *testing.T) {
+	store := &mockStore{}
+	store.add(resultsBatch + 10)
+	c, stop := dial(t, store)
+	defer stop()
+
+	stream, err := c.StreamResults(context.Background(), &ResultsRequest{
+		Query:   db.Query{ReturnedFields: []string{"username"}, Filter: map[string]interface{}{"valid": true}},
+		AfterID: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, res.ID)
+	}
+	if len(ids) != resultsBatch+5 || ids[0] != 6 || ids[len(ids)-1] != resultsBatch+10 {
+		t.Errorf("unexpected results: %d from %v", len(ids), ids[:1])
+	}
+
+	// the ID is added to the returned fields, since the stream pages by it
+	q := store.queries[0]
+	if len(q.ReturnedFields) != 2 || q.ReturnedFields[0] != "id" || q.Filter["valid"] != true {
+		t.Errorf("unexpected query: %+v", q)
+	}
+}
+
+func TestStreamResultsFollow(t *testing.T) {
+	store := &mockStore{}
+	store.add(2)
+	c, stop := dial(t, store)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.StreamResults(ctx, &ResultsRequest{Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ID != uint(i) {
+			t.Errorf("result %d had ID %d", i, res.ID)
+		}
+		if i == 2 {
+			// results inserted after the stream started are sent too
+			store.add(2)
+		}
+	}
+
+	cancel()
+	_, err = stream.Recv()
+	if err == nil || err == io.EOF {
+		t.Errorf("expected the cancelled stream to fail, got %v", err)
+	}
+}
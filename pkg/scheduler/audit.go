@@ -0,0 +1,138 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// AuditMode configures the audit log written by the scheduler.
+type AuditMode string
+
+const (
+	// AuditOff disables the audit log
+	AuditOff AuditMode = ""
+	// AuditOn records every guess with its username
+	AuditOn AuditMode = "on"
+	// AuditHashed records every guess with the HMAC-SHA256 of its username,
+	// keyed with the audit secret
+	AuditHashed AuditMode = "hashed"
+)
+
+// Validate returns an error if the mode is not one of the known modes.
+func (m AuditMode) Validate() error {
+	switch m {
+	case AuditOff, AuditOn, AuditHashed:
+		return nil
+	}
+	return fmt.Errorf("unknown audit mode %q, expected %q or %q", string(m), AuditOn, AuditHashed)
+}
+
+// Decode sets the mode from its configuration value, rejecting unknown modes
+// when the configuration is read. It implements envconfig.Decoder.
+func (m *AuditMode) Decode(value string) error {
+	mode := AuditMode(value)
+	err := mode.Validate()
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// auditor writes an audit entry for each result. It caches the provider of
+// each campaign since results do not carry it.
+type auditor struct {
+	mode AuditMode
+	key  []byte
+	db   *db.TridentDB
+
+	mu        sync.Mutex
+	providers map[uint]string
+}
+
+// newAuditor creates an auditor, returning nil if the mode is AuditOff. The
+// usernames are hashed with the secret in AuditHashed mode.
+func newAuditor(mode AuditMode, secret string, database *db.TridentDB) *auditor {
+	if mode == AuditOff {
+		return nil
+	}
+	var key []byte
+	if mode == AuditHashed {
+		key = []byte(secret)
+	}
+	return &auditor{
+		mode:      mode,
+		key:       key,
+		db:        database,
+		providers: make(map[uint]string),
+	}
+}
+
+// provider returns the provider of the campaign.
+func (a *auditor) provider(campaignID uint) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if p, ok := a.providers[campaignID]; ok {
+		return p, nil
+	}
+	p, err := a.db.GetCampaignProvider(campaignID)
+	if err != nil {
+		return "", err
+	}
+	a.providers[campaignID] = p
+	return p, nil
+}
+
+// Record appends the result to the audit log. A nil auditor records nothing.
+func (a *auditor) Record(res *db.Result, region string) error {
+	if a == nil {
+		return nil
+	}
+	provider, err := a.provider(res.CampaignID)
+	if err != nil {
+		return err
+	}
+	return a.db.InsertAuditEntry(auditEntry(res, provider, region, a.key))
+}
+
+// auditEntry converts a result into an audit entry. The password is never
+// copied. With a key, the username is replaced by its HMAC-SHA256, so it
+// cannot be recovered by hashing a list of likely usernames without the key.
+func auditEntry(res *db.Result, provider, region string, key []byte) *db.AuditEntry {
+	username := res.Username
+	hash := key != nil
+	if hash {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(username)) // nolint:errcheck,gosec
+		username = hex.EncodeToString(mac.Sum(nil))
+	}
+	return &db.AuditEntry{
+		Timestamp:      res.Timestamp,
+		CampaignID:     res.CampaignID,
+		Username:       username,
+		UsernameHashed: hash,
+		Provider:       provider,
+		Region:         region,
+		Result:         res.Status,
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestAuditEntry(t *testing.T) {
+	res := &db.Result{
+		CampaignID: 7,
+		Timestamp:  time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC),
+		Username:   "alice@example.org",
+		Password:   "Password0",
+		Valid:      true,
+		Status:     db.ResultStatusValid,
+	}
+
+	entry := auditEntry(res, "okta", "europe-west3", nil)
+	if entry.Username != res.Username || entry.UsernameHashed {
+		t.Errorf("unexpected username %q (hashed %t)", entry.Username, entry.UsernameHashed)
+	}
+	if entry.CampaignID != 7 || entry.Provider != "okta" || entry.Region != "europe-west3" ||
+		entry.Result != db.ResultStatusValid || !entry.Timestamp.Equal(res.Timestamp) {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+
+	entry = auditEntry(res, "okta", "", []byte("s3cret"))
+	expected := "5885f723eeb6e07a2dde3fda670c853e3cc22c8b4795ce07af3fd1508854178c"
+	if !entry.UsernameHashed || entry.Username != expected {
+		t.Errorf("username was hashed to %q, expected %q", entry.Username, expected)
+	}
+
+	// the unsalted digest of the username is not used
+	entry = auditEntry(res, "okta", "", []byte("other"))
+	if entry.Username == expected ||
+		entry.Username == "7a64adf28737ea90719cbdf0b1a87a5effff3753b79c91d717f4f4153ead0498" {
+		t.Errorf("username was not keyed: %q", entry.Username)
+	}
+}
+
+func TestAuditModeDecode(t *testing.T) {
+	for _, value := range []string{"", "on", "hashed"} {
+		var mode AuditMode
+		if err := mode.Decode(value); err != nil || mode != AuditMode(value) {
+			t.Errorf("%q: unexpected mode %q (%v)", value, mode, err)
+		}
+	}
+	for _, value := range []string{"yes", "true", "Hashed", "sha256"} {
+		var mode AuditMode
+		if err := mode.Decode(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// inflightExpiry is how long an attempt holds its slot if no result arrives
+// for it, e.g. because its worker died.
+const inflightExpiry = 2 * time.Minute
+
+// concurrencyLimiter tracks the attempts in flight against each target, which
+// have been published but whose result has not arrived yet. Campaigns with the
+// same provider and provider metadata share a target, so together they stay
+// under the MaxConcurrent of their tasks.
+type concurrencyLimiter struct {
+	mu      sync.Mutex
+	targets map[string]map[string]time.Time
+	// attempts maps each attempt in flight to its target
+	attempts map[string]string
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{
+		targets:  make(map[string]map[string]time.Time),
+		attempts: make(map[string]string),
+	}
+}
+
+// targetKey identifies the target of a task by its provider and a hash of its
+// provider metadata.
+func targetKey(task *db.Task) string {
+	sum := sha256.Sum256(task.ProviderMetadata)
+	return task.Provider + ":" + hex.EncodeToString(sum[:8])
+}
+
+// attemptKey identifies an attempt of a campaign by its username and password.
+// Results carry both, so the attempt can be found again once its result
+// arrives.
+func attemptKey(campaignID uint, username, password string) string {
+	digest := (&db.Task{Username: username, Password: password}).Digest()
+	return fmt.Sprintf("%d:%x", campaignID, digest)
+}
+
+// Acquire records the attempt as in flight against the target and returns
+// true, unless limit attempts are already in flight against it.
+func (l *concurrencyLimiter) Acquire(target, attempt string, limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight, ok := l.targets[target]
+	if !ok {
+		inflight = make(map[string]time.Time)
+		l.targets[target] = inflight
+	}
+	for a, expires := range inflight {
+		if now.After(expires) {
+			delete(inflight, a)
+			delete(l.attempts, a)
+		}
+	}
+	if len(inflight) >= limit {
+		return false
+	}
+	inflight[attempt] = now.Add(inflightExpiry)
+	l.attempts[attempt] = target
+	return true
+}
+
+// Release frees the slot of the attempt, if it holds one.
+func (l *concurrencyLimiter) Release(attempt string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	target, ok := l.attempts[attempt]
+	if !ok {
+		return
+	}
+	delete(l.attempts, attempt)
+	delete(l.targets[target], attempt)
+	if len(l.targets[target]) == 0 {
+		delete(l.targets, target)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter()
+	now := time.Now()
+
+	// two campaigns against the same target share its slots
+	okta := &db.Task{Provider: "okta", ProviderMetadata: json.RawMessage(`{"subdomain":"example"}`)}
+	other := &db.Task{Provider: "okta", ProviderMetadata: json.RawMessage(`{"subdomain":"other"}`)}
+	if targetKey(okta) == targetKey(other) {
+		t.Fatal("different tenants have the same target")
+	}
+
+	a := attemptKey(1, "alice", "Password1")
+	b := attemptKey(2, "bob", "Password1")
+	c := attemptKey(2, "carol", "Password1")
+	if !l.Acquire(targetKey(okta), a, 2, now) || !l.Acquire(targetKey(okta), b, 2, now) {
+		t.Fatal("attempts under the limit were held")
+	}
+	if l.Acquire(targetKey(okta), c, 2, now) {
+		t.Error("attempt over the limit was acquired")
+	}
+	if !l.Acquire(targetKey(other), c, 2, now) {
+		t.Error("attempt against another target was held")
+	}
+	l.Release(c)
+
+	// a result frees the slot of its attempt
+	l.Release(attemptKey(1, "alice", "Password1"))
+	if !l.Acquire(targetKey(okta), c, 2, now) {
+		t.Error("attempt was held after a result freed a slot")
+	}
+
+	// attempts whose result never arrives free their slot eventually
+	d := attemptKey(3, "dave", "Password1")
+	if l.Acquire(targetKey(okta), d, 2, now) {
+		t.Error("attempt over the limit was acquired")
+	}
+	if !l.Acquire(targetKey(okta), d, 2, now.Add(inflightExpiry+time.Second)) {
+		t.Error("expired attempts still hold their slots")
+	}
+
+	// releasing an unknown attempt is harmless
+	l.Release(attemptKey(4, "eve", "Password1"))
+}
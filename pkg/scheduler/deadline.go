@@ -0,0 +1,56 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+var (
+	// DeadlineInterval is how often the campaigns are checked for a deadline
+	// which has passed
+	DeadlineInterval = 30 * time.Second
+)
+
+// EnforceDeadlines stops every campaign whose deadline has passed, checking
+// every DeadlineInterval. The producer only sees a deadline when it pops one
+// of the campaign's tasks, which may not be due for a long time. It never
+// returns.
+func (s *PubSubScheduler) EnforceDeadlines() {
+	for {
+		s.sweepDeadlines(time.Now())
+		time.Sleep(DeadlineInterval)
+	}
+}
+
+// sweepDeadlines stops the campaigns whose deadline has passed at now and
+// drains their remaining tasks.
+func (s *PubSubScheduler) sweepDeadlines(now time.Time) {
+	campaigns, err := s.db.OverdueCampaigns(now)
+	if err != nil {
+		log.Printf("error querying campaigns past their deadline: %s", err)
+		return
+	}
+
+	for _, c := range campaigns {
+		err = s.stop(c.ID, db.CampaignStatusDeadlineExceeded, "deadline reached")
+		if err != nil {
+			log.Printf("error stopping campaign id=%d: %s", c.ID, err)
+		}
+	}
+}
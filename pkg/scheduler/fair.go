@@ -0,0 +1,116 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultPriority is the dispatch weight of a campaign without a priority.
+const DefaultPriority = 1
+
+// fairShare shares the producer's dispatch turns among the campaigns with
+// queued tasks, so a large campaign cannot hold back the others. Each pass
+// over the queues gives every campaign as many turns as its priority, so its
+// share of the tasks dispatched is its priority over the sum of priorities of
+// the campaigns with ready tasks.
+type fairShare struct {
+	mu         sync.Mutex
+	priorities map[uint]int
+}
+
+func newFairShare() *fairShare {
+	return &fairShare{priorities: make(map[uint]int)}
+}
+
+// SetPriority records the priority of a campaign, learned from its tasks.
+func (f *fairShare) SetPriority(campaignID uint, priority int) {
+	if priority < DefaultPriority {
+		priority = DefaultPriority
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.priorities[campaignID] = priority
+}
+
+// Turns returns the order of turns of one pass over the campaigns, each
+// appearing as many times as its priority. The turns are interleaved by smooth
+// weighted round-robin, so a high priority campaign does not take all of its
+// turns in a row. Campaigns no longer queued are forgotten.
+func (f *fairShare) Turns(campaigns []uint) []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	queued := make(map[uint]bool, len(campaigns))
+	ids := make([]uint, 0, len(campaigns))
+	for _, id := range campaigns {
+		if !queued[id] {
+			queued[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	weights := make([]int, len(ids))
+	total := 0
+	for i, id := range ids {
+		weights[i] = DefaultPriority
+		if p, ok := f.priorities[id]; ok {
+			weights[i] = p
+		}
+		total += weights[i]
+	}
+	for id := range f.priorities {
+		if !queued[id] {
+			delete(f.priorities, id)
+		}
+	}
+
+	turns := make([]uint, 0, total)
+	current := make([]int, len(ids))
+	for len(turns) < total {
+		best := 0
+		for i := range ids {
+			current[i] += weights[i]
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		turns = append(turns, ids[best])
+	}
+	return turns
+}
+
+// Pass takes one pass over the campaigns, calling dispatch on each of their
+// turns. A campaign whose dispatch returns false has nothing ready and loses
+// the rest of its turns in the pass. Pass returns the number of dispatches
+// which returned true.
+func (f *fairShare) Pass(campaigns []uint, dispatch func(campaignID uint) bool) int {
+	waiting := make(map[uint]bool)
+	var dispatched int
+	for _, id := range f.Turns(campaigns) {
+		if waiting[id] {
+			continue
+		}
+		if !dispatch(id) {
+			waiting[id] = true
+			continue
+		}
+		dispatched++
+	}
+	return dispatched
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFairShareTurns(t *testing.T) {
+	f := newFairShare()
+	f.SetPriority(1, 3)
+
+	// the turns are interleaved and in proportion to the priorities, and a
+	// campaign without a priority has the default
+	turns := f.Turns([]uint{2, 1})
+	if !reflect.DeepEqual(turns, []uint{1, 1, 2, 1}) {
+		t.Errorf("unexpected turns %v", turns)
+	}
+
+	// campaigns no longer queued are forgotten
+	f.Turns([]uint{2})
+	if turns = f.Turns([]uint{1, 2}); len(turns) != 2 {
+		t.Errorf("priority of a drained campaign was kept: %v", turns)
+	}
+
+	// a priority below the default is raised to it
+	f.SetPriority(3, -1)
+	if turns = f.Turns([]uint{3, 3}); !reflect.DeepEqual(turns, []uint{3}) {
+		t.Errorf("unexpected turns %v", turns)
+	}
+}
+
+func TestFairSharePass(t *testing.T) {
+	f := newFairShare()
+
+	// a huge campaign whose tasks are all ready, a small urgent one, and one
+	// whose next task is not ready yet
+	queues := map[uint]int{1: 1000000, 2: 10, 3: 5}
+	priorities := map[uint]int{1: 1, 2: 3, 3: 2}
+	dispatched := make(map[uint]int)
+	calls := make(map[uint]int)
+	dispatch := func(campaignID uint) bool {
+		calls[campaignID]++
+		f.SetPriority(campaignID, priorities[campaignID])
+		if campaignID == 3 || queues[campaignID] == 0 {
+			return false
+		}
+		queues[campaignID]--
+		dispatched[campaignID]++
+		return true
+	}
+
+	// the first pass learns the priorities from the tasks
+	if n := f.Pass([]uint{1, 2, 3}, dispatch); n != 2 {
+		t.Fatalf("first pass dispatched %d tasks", n)
+	}
+	for pass := 1; queues[2] > 0; pass++ {
+		if pass > 4 {
+			t.Fatalf("small campaign still has %d tasks after %d passes", queues[2], pass)
+		}
+		f.Pass([]uint{1, 2, 3}, dispatch)
+	}
+
+	// both campaigns made progress, each by its share
+	if dispatched[1] == 0 || dispatched[2] != 10 {
+		t.Errorf("unexpected progress %v", dispatched)
+	}
+	if dispatched[1] > 5 {
+		t.Errorf("huge campaign took %d turns while the small one ran", dispatched[1])
+	}
+
+	// a campaign with nothing ready gives up the rest of its turns
+	passes := dispatched[1]
+	if calls[3] != passes {
+		t.Errorf("campaign with nothing ready was tried %d times in %d passes", calls[3], passes)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// ingester holds the results waiting to be batched into the database. Its
+// buffer is bounded: once it is full, the result consumer waits for room
+// instead of taking more messages, so a burst of results stays queued in
+// Pub/Sub, where it is durable, rather than in memory or in a spike of
+// database writes.
+type ingester struct {
+	mu      sync.Mutex
+	writer  *db.ResultWriter
+	results chan<- *db.Result
+
+	// backedUp is set while the buffer is full, so the condition is logged
+	// once rather than for every result
+	backedUp bool
+}
+
+// Start begins batching results into the database.
+func (i *ingester) Start(d *db.TridentDB, opts db.BatchOptions) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.writer = d.NewResultWriter(opts)
+	i.results = i.writer.Queue()
+}
+
+// Close writes the results still waiting. Nothing may be pushed once it is
+// called.
+func (i *ingester) Close() {
+	i.mu.Lock()
+	w := i.writer
+	i.mu.Unlock()
+	if w != nil {
+		w.Close()
+	}
+}
+
+// Push queues a result to be written, waiting for room in the buffer if it is
+// full. It returns an error if the context is done first, in which case the
+// result was not queued.
+func (i *ingester) Push(ctx context.Context, res *db.Result) error {
+	select {
+	case i.results <- res:
+		i.caughtUp()
+		return nil
+	default:
+	}
+
+	i.mu.Lock()
+	if !i.backedUp {
+		i.backedUp = true
+		log.Printf("result ingestion is backed up with %d results waiting to be written, holding new results in pubsub",
+			cap(i.results))
+	}
+	i.mu.Unlock()
+
+	select {
+	case i.results <- res:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// caughtUp records that the buffer is no longer full once it has drained to
+// half its size.
+func (i *ingester) caughtUp() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.backedUp && len(i.results) <= cap(i.results)/2 {
+		i.backedUp = false
+		log.Printf("result ingestion caught up, %d results waiting to be written", len(i.results))
+	}
+}
+
+// Backlog returns the number of results waiting to be written and the size of
+// the buffer, both 0 before the ingester is started.
+func (i *ingester) Backlog() (int, int) {
+	if i == nil {
+		return 0, 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.results), cap(i.results)
+}
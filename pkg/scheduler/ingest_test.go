@@ -0,0 +1,72 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestIngester(t *testing.T) {
+	results := make(chan *db.Result, 2)
+	i := &ingester{results: results}
+	ctx := context.Background()
+
+	for n := 0; n < 2; n++ {
+		if err := i.Push(ctx, &db.Result{}); err != nil {
+			t.Fatalf("push %d: unexpected error: %s", n, err)
+		}
+	}
+	if backlog, buffer := i.Backlog(); backlog != 2 || buffer != 2 {
+		t.Errorf("backlog was %d of %d", backlog, buffer)
+	}
+
+	// a full buffer holds the result until the context is done
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := i.Push(timeout, &db.Result{}); err == nil {
+		t.Fatal("push to a full buffer succeeded")
+	}
+	if !i.backedUp {
+		t.Error("full buffer was not recorded as backed up")
+	}
+
+	// and waits for room otherwise
+	done := make(chan error)
+	go func() {
+		done <- i.Push(ctx, &db.Result{})
+	}()
+	<-results
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	<-results
+	<-results
+	if err := i.Push(ctx, &db.Result{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if i.backedUp {
+		t.Error("drained buffer was still backed up")
+	}
+
+	var none *ingester
+	if backlog, buffer := none.Backlog(); backlog != 0 || buffer != 0 {
+		t.Errorf("backlog of a nil ingester was %d of %d", backlog, buffer)
+	}
+}
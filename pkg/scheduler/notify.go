@@ -0,0 +1,275 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/sign"
+)
+
+const (
+	// NotifyRetryInterval is how often undelivered notifications are retried
+	NotifyRetryInterval = time.Minute
+
+	// notifyTimeout bounds a single notification request
+	notifyTimeout = 10 * time.Second
+)
+
+// The events a Notification reports.
+const (
+	// EventResult is the notification of a valid result
+	EventResult = "result"
+
+	// EventCampaignPaused is the notification of a campaign paused by its
+	// abort-on-waf threshold
+	EventCampaignPaused = "campaign_paused"
+)
+
+// notificationStore persists the delivery state of notifications. It is
+// implemented by db.TridentDB.
+type notificationStore interface {
+	PendingNotifications() ([]db.Result, error)
+	MarkNotified(resultID uint, at time.Time) error
+}
+
+// Notification is the body POSTed to the notification webhook for each valid
+// result, and for each campaign paused by abort-on-waf. The password is never
+// sent.
+type Notification struct {
+	Event      string          `json:"event"`
+	ResultID   uint            `json:"result_id"`
+	CampaignID uint            `json:"campaign_id"`
+	Username   string          `json:"username"`
+	Status     db.ResultStatus `json:"status"`
+	MFA        bool            `json:"mfa"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Reason     string          `json:"reason,omitempty"`
+}
+
+// notifier delivers a notification of every valid result to a webhook, at
+// least once. Results are stored as pending before they are acknowledged, and
+// only marked as notified once the webhook accepts them, so a notification
+// interrupted by a restart is sent when the orchestrator starts again. Each
+// notification carries an Idempotency-Key derived from the result, so the
+// receiver can drop the duplicates a retry may cause. With a secret, each
+// notification is signed in the sign.WebhookHeader header, and is signed again
+// when it is retried. A campaign paused by abort-on-waf is notified the same
+// way, but its notification is only held in memory: the pause itself is
+// stored with the campaign, so a notification lost to a restart is lost.
+type notifier struct {
+	url    string
+	secret string
+	client *http.Client
+	store  notificationStore
+
+	// mu serializes deliveries, so a result is never sent twice at once
+	mu   sync.Mutex
+	wake chan struct{}
+
+	// events holds the pause notifications not yet delivered
+	emu    sync.Mutex
+	events []Notification
+}
+
+// newNotifier creates a notifier, returning nil if no webhook URL is set.
+func newNotifier(url, secret string, store notificationStore) *notifier {
+	if url == "" {
+		return nil
+	}
+	return &notifier{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: notifyTimeout},
+		store:  store,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Pending marks a valid result as awaiting its notification. It must be called
+// before the result is stored. A nil notifier leaves the result unchanged.
+func (n *notifier) Pending(res *db.Result) {
+	if n == nil || !res.Valid {
+		return
+	}
+	res.NotifyPending = true
+}
+
+// Paused queues the notification of a campaign paused by abort-on-waf, and
+// wakes the notifier to send it. A nil notifier does nothing.
+func (n *notifier) Paused(campaignID uint, reason string) {
+	if n == nil {
+		return
+	}
+	n.emu.Lock()
+	n.events = append(n.events, Notification{
+		Event:      EventCampaignPaused,
+		CampaignID: campaignID,
+		Timestamp:  time.Now(),
+		Reason:     reason,
+	})
+	n.emu.Unlock()
+	n.Notify()
+}
+
+// Notify wakes the notifier after pending results were stored. It never
+// blocks.
+func (n *notifier) Notify() {
+	if n == nil {
+		return
+	}
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers the pending notifications left over from a previous run, then
+// whenever it is woken by Notify and every NotifyRetryInterval, until the
+// context is cancelled. A nil notifier returns immediately.
+func (n *notifier) Run(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	ticker := time.NewTicker(NotifyRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		err := n.deliver(ctx)
+		if err != nil {
+			log.Printf("error delivering notifications: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliver sends every pending notification. A failed delivery is left pending
+// for the next attempt.
+func (n *notifier) deliver(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	failed, total := n.deliverEvents(ctx)
+
+	pending, err := n.store.PendingNotifications()
+	if err != nil {
+		return err
+	}
+	total += len(pending)
+
+	for i := range pending {
+		res := &pending[i]
+		err = n.post(ctx, idempotencyKey(res), Notification{
+			Event:      EventResult,
+			ResultID:   res.ID,
+			CampaignID: res.CampaignID,
+			Username:   res.Username,
+			Status:     res.Status,
+			MFA:        res.MFA,
+			Timestamp:  res.Timestamp,
+		})
+		if err != nil {
+			log.Printf("error notifying result id=%d: %s", res.ID, err)
+			failed++
+			continue
+		}
+		err = n.store.MarkNotified(res.ID, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d notifications were not delivered", failed, total)
+	}
+	return nil
+}
+
+// deliverEvents sends the queued pause notifications, keeping those which
+// failed for the next attempt. It returns the number which failed out of the
+// number sent.
+func (n *notifier) deliverEvents(ctx context.Context) (failed, total int) {
+	n.emu.Lock()
+	events := n.events
+	n.events = nil
+	n.emu.Unlock()
+
+	var retry []Notification
+	for _, event := range events {
+		err := n.post(ctx, eventKey(&event), event)
+		if err != nil {
+			log.Printf("error notifying %s of campaign id=%d: %s", event.Event, event.CampaignID, err)
+			retry = append(retry, event)
+		}
+	}
+
+	n.emu.Lock()
+	n.events = append(retry, n.events...)
+	n.emu.Unlock()
+	return len(retry), len(events)
+}
+
+// post sends a notification to the webhook.
+func (n *notifier) post(ctx context.Context, key string, note Notification) error {
+	body, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	if n.secret != "" {
+		req.Header.Set(sign.WebhookHeader, sign.SignWebhook(n.secret, body, time.Now()))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// idempotencyKey identifies the notification of a result across retries and
+// restarts.
+func idempotencyKey(res *db.Result) string {
+	return fmt.Sprintf("trident-result-%d", res.ID)
+}
+
+// eventKey identifies the notification of an event across retries.
+func eventKey(event *Notification) string {
+	return fmt.Sprintf("trident-campaign-%d-%s-%d", event.CampaignID, event.Event, event.Timestamp.UnixNano())
+}
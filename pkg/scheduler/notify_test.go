@@ -0,0 +1,211 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/sign"
+)
+
+// memStore is an in-memory results table standing in for the database, which
+// outlives the notifiers like the database outlives an orchestrator.
+type memStore struct {
+	mu       sync.Mutex
+	results  []db.Result
+	failMark int
+}
+
+func (m *memStore) insert(res db.Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res.ID = uint(len(m.results) + 1)
+	m.results = append(m.results, res)
+}
+
+func (m *memStore) PendingNotifications() ([]db.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending []db.Result
+	for _, res := range m.results {
+		if res.NotifyPending {
+			pending = append(pending, res)
+		}
+	}
+	return pending, nil
+}
+
+func (m *memStore) MarkNotified(resultID uint, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failMark > 0 {
+		m.failMark--
+		return errors.New("connection reset")
+	}
+	res := &m.results[resultID-1]
+	res.NotifyPending = false
+	res.NotifiedAt = &at
+	return nil
+}
+
+// webhook records the notifications it receives by idempotency key. With a
+// secret, it rejects notifications which are not signed with it.
+type webhook struct {
+	mu       sync.Mutex
+	received map[string]int
+	fail     bool
+	secret   string
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.fail {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+	if w.secret != "" && !sign.VerifyWebhook(w.secret, r.Header.Get(sign.WebhookHeader), body) {
+		http.Error(rw, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var n Notification
+	err = json.Unmarshal(body, &n)
+	if err != nil || n.Event == "" || (n.Event == EventResult && n.Username == "") {
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.received[r.Header.Get("Idempotency-Key")]++
+}
+
+func TestNotifierRestart(t *testing.T) {
+	hook := &webhook{received: make(map[string]int), secret: "s3cret"}
+	ts := httptest.NewServer(hook)
+	defer ts.Close()
+
+	store := &memStore{}
+	ctx := context.Background()
+
+	// the orchestrator crashes after writing the result, before the
+	// notification is dispatched
+	before := newNotifier(ts.URL, "s3cret", store)
+	res := db.Result{CampaignID: 1, Username: "alice@example.org", Password: "Password0",
+		Valid: true, Status: db.ResultStatusValid}
+	before.Pending(&res)
+	store.insert(res)
+
+	// an invalid result never needs a notification
+	invalid := db.Result{CampaignID: 1, Username: "bob@example.org", Status: db.ResultStatusInvalid}
+	before.Pending(&invalid)
+	store.insert(invalid)
+
+	// the webhook is down when the orchestrator restarts
+	hook.fail = true
+	after := newNotifier(ts.URL, "s3cret", store)
+	if err := after.deliver(ctx); err == nil {
+		t.Error("expected an error while the webhook is down")
+	}
+
+	// a notification signed with another secret is rejected
+	hook.fail = false
+	if err := newNotifier(ts.URL, "other", store).deliver(ctx); err == nil {
+		t.Error("expected an error with the wrong secret")
+	}
+	if pending, _ := store.PendingNotifications(); len(pending) != 1 {
+		t.Fatalf("expected the notification to stay pending, got %d", len(pending))
+	}
+
+	// the notification is delivered, but the orchestrator fails to record it
+	store.failMark = 1
+	if err := after.deliver(ctx); err == nil {
+		t.Error("expected an error recording the delivery")
+	}
+
+	// the retry sends it again with the same idempotency key
+	if err := after.deliver(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.received) != 1 || hook.received["trident-result-1"] != 2 {
+		t.Errorf("unexpected deliveries: %v", hook.received)
+	}
+	if pending, _ := store.PendingNotifications(); len(pending) != 0 {
+		t.Errorf("expected no pending notifications, got %d", len(pending))
+	}
+	if store.results[0].NotifiedAt == nil {
+		t.Error("expected the delivery time to be recorded")
+	}
+
+	// nothing is sent once delivered
+	if err := after.deliver(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if hook.received["trident-result-1"] != 2 {
+		t.Errorf("notification was sent again: %v", hook.received)
+	}
+}
+
+func TestNotifierPaused(t *testing.T) {
+	hook := &webhook{received: make(map[string]int), fail: true}
+	ts := httptest.NewServer(hook)
+	defer ts.Close()
+
+	n := newNotifier(ts.URL, "", &memStore{})
+	n.Paused(7, "paused after 80% of the last 20 results were challenged (cloudflare)")
+	key := eventKey(&n.events[0])
+
+	// the pause is kept while the webhook is down
+	if err := n.deliver(context.Background()); err == nil {
+		t.Error("expected an error while the webhook is down")
+	}
+	if len(n.events) != 1 {
+		t.Fatalf("expected the pause to stay queued, got %d", len(n.events))
+	}
+
+	hook.fail = false
+	if err := n.deliver(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.deliver(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(hook.received) != 1 || hook.received[key] != 1 {
+		t.Errorf("unexpected deliveries: %v", hook.received)
+	}
+}
+
+func TestNotifierDisabled(t *testing.T) {
+	n := newNotifier("", "", &memStore{})
+	res := db.Result{Valid: true}
+	n.Pending(&res)
+	n.Paused(1, "paused")
+	n.Notify()
+	n.Run(context.Background())
+	if res.NotifyPending {
+		t.Error("result is pending without a webhook")
+	}
+}
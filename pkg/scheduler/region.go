@@ -0,0 +1,352 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+const (
+	// RegionAttribute is the Pub/Sub message attribute carrying the worker
+	// region of a task or result. Dispatchers subscribe to their region's
+	// tasks with a subscription filter on this attribute.
+	RegionAttribute = "region"
+)
+
+var (
+	// RegionTimeout is how long a region may leave published tasks without
+	// returning a result before it is considered unhealthy
+	RegionTimeout = 5 * time.Minute
+
+	// RegionDecay is the factor a campaign's record in a region is scaled by
+	// before each new result from the region is added, so older results
+	// count for less
+	RegionDecay = 0.95
+
+	// RegionMinWeight is the lowest weight of a region, so a region the
+	// target blocked is still tried now and then and can recover
+	RegionMinWeight = 0.05
+
+	// RegionWeightsFlush is how often a campaign's record in each region is
+	// saved while it receives results
+	RegionWeightsFlush = 10 * time.Second
+)
+
+// Regions maps a worker region name (e.g. europe-west3) to the geo tags
+// describing its location (e.g. ["de", "eu"]). Tags are free-form, but ISO
+// 3166 country codes and continent names are recommended.
+type Regions map[string][]string
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (r *Regions) UnmarshalText(text []byte) error {
+	var m map[string][]string
+	if err := json.Unmarshal(text, &m); err != nil {
+		return err
+	}
+	*r = Regions(m)
+	return nil
+}
+
+// regionSelector picks a worker region for each task and tracks the health of
+// every region. A region is healthy until it has tasks outstanding for longer
+// than RegionTimeout without returning any results.
+type regionSelector struct {
+	mu      sync.Mutex
+	regions Regions
+	names   []string
+
+	// pending holds the time of the first task published to a region since
+	// the last result was received from it
+	pending map[string]time.Time
+
+	// rotations holds the regions left in the current rotation of each
+	// campaign which randomizes its worker assignment, and last the region
+	// its previous task was sent to
+	rotations map[uint][]string
+	last      map[uint]string
+
+	// weights holds the recent record of each campaign in each region, and
+	// flushed the time each campaign's record was last returned to be saved
+	weights map[uint]db.RegionWeights
+	flushed map[uint]time.Time
+}
+
+// newRegionSelector creates a regionSelector for the provided regions.
+func newRegionSelector(regions Regions) *regionSelector {
+	var names []string
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &regionSelector{
+		regions:   regions,
+		names:     names,
+		pending:   make(map[string]time.Time),
+		rotations: make(map[uint][]string),
+		last:      make(map[uint]string),
+		weights:   make(map[uint]db.RegionWeights),
+		flushed:   make(map[uint]time.Time),
+	}
+}
+
+// healthy returns true if the region has returned a result since it was
+// first sent an outstanding task, or if that task is recent.
+func (r *regionSelector) healthy(name string, now time.Time) bool {
+	t, ok := r.pending[name]
+	return !ok || now.Sub(t) < RegionTimeout
+}
+
+// candidates returns the healthy regions among the allowed ones tagged with
+// the provided geo. If no healthy region matches, every healthy allowed region
+// is returned. If no allowed region is healthy, every allowed region is
+// returned. Every region is allowed if none are listed.
+func (r *regionSelector) candidates(geo string, allowed db.WorkerRegions, now time.Time) []string {
+	var permitted, near, healthy []string
+	for _, name := range r.names {
+		if allowed.Weight(name) <= 0 {
+			continue
+		}
+		permitted = append(permitted, name)
+		if !r.healthy(name, now) {
+			continue
+		}
+		healthy = append(healthy, name)
+		for _, tag := range r.regions[name] {
+			if geo != "" && strings.EqualFold(tag, geo) {
+				near = append(near, name)
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(near) > 0:
+		return near
+	case len(healthy) > 0:
+		return healthy
+	}
+	return permitted
+}
+
+// pick returns one of the names at random in proportion to its weight.
+func pick(names []string, weight func(string) float64) string {
+	weights := make([]float64, len(names))
+	var total float64
+	for i, name := range names {
+		weights[i] = weight(name)
+		total += weights[i]
+	}
+
+	p := rand.Float64() * total // nolint:gosec
+	for i, w := range weights {
+		if p < w {
+			return names[i]
+		}
+		p -= w
+	}
+	return names[len(names)-1]
+}
+
+// Select returns the region which should receive a task for a target in the
+// provided geo, picked among the allowed regions in proportion to their
+// weights, and records that a task is outstanding in that region. An empty
+// string is returned if no allowed regions are configured.
+func (r *regionSelector) Select(geo string, allowed db.WorkerRegions) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	names := r.candidates(geo, allowed, now)
+	if len(names) == 0 {
+		return ""
+	}
+
+	name := pick(names, allowed.Weight)
+	r.publish(name, now)
+	return name
+}
+
+// Rotate is like Select, but spreads the tasks of a campaign evenly across
+// the candidate regions: each region receives one task in a random order
+// before any region receives another, and consecutive tasks are never sent to
+// the same region if another candidate is available. The weights of the
+// allowed regions are ignored.
+func (r *regionSelector) Rotate(geo string, allowed db.WorkerRegions, campaignID uint) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	names := r.candidates(geo, allowed, now)
+	if len(names) == 0 {
+		return ""
+	}
+
+	candidate := make(map[string]bool, len(names))
+	for _, name := range names {
+		candidate[name] = true
+	}
+
+	// regions which stopped being candidates are dropped from the rotation
+	var rotation []string
+	for _, name := range r.rotations[campaignID] {
+		if candidate[name] {
+			rotation = append(rotation, name)
+		}
+	}
+	if len(rotation) == 0 {
+		rotation = append(rotation, names...)
+		rand.Shuffle(len(rotation), func(i, j int) { // nolint:gosec
+			rotation[i], rotation[j] = rotation[j], rotation[i]
+		})
+		if len(rotation) > 1 && rotation[0] == r.last[campaignID] {
+			rotation[0], rotation[1] = rotation[1], rotation[0]
+		}
+	}
+
+	name := rotation[0]
+	r.rotations[campaignID] = rotation[1:]
+	r.last[campaignID] = name
+	r.publish(name, now)
+	return name
+}
+
+// regionWeight returns the weight of a region with the record: the estimated
+// fraction of its results which are not blocked, starting from one half for a
+// region without results, and never below RegionMinWeight.
+func regionWeight(s db.RegionStats) float64 {
+	w := (s.Succeeded + 1) / (s.Succeeded + s.Blocked + 2)
+	if w < RegionMinWeight {
+		return RegionMinWeight
+	}
+	return w
+}
+
+// Weighted is like Select, but also weights the candidate regions by the
+// campaign's recent record in each, so its tasks gravitate toward the regions
+// the target does not block.
+func (r *regionSelector) Weighted(geo string, allowed db.WorkerRegions, campaignID uint) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	names := r.candidates(geo, allowed, now)
+	if len(names) == 0 {
+		return ""
+	}
+
+	name := pick(names, func(name string) float64 {
+		return allowed.Weight(name) * regionWeight(r.weights[campaignID][name])
+	})
+	r.publish(name, now)
+	return name
+}
+
+// Loaded returns true if the campaign's record has been loaded or observed
+// since the orchestrator started.
+func (r *regionSelector) Loaded(campaignID uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.weights[campaignID]
+	return ok
+}
+
+// Load sets the campaign's record saved before a restart, unless results of
+// the campaign were already observed.
+func (r *regionSelector) Load(campaignID uint, weights db.RegionWeights) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.weights[campaignID]; ok {
+		return
+	}
+	if weights == nil {
+		weights = make(db.RegionWeights)
+	}
+	r.weights[campaignID] = weights
+	r.flushed[campaignID] = time.Now()
+}
+
+// Observe adds a result of the campaign from the region to the campaign's
+// record, as blocked if the target rate limited or challenged it. It returns
+// a copy of the record once every RegionWeightsFlush to be saved, or else nil.
+func (r *regionSelector) Observe(campaignID uint, name string, blocked bool) db.RegionWeights {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	weights, ok := r.weights[campaignID]
+	if !ok {
+		weights = make(db.RegionWeights)
+		r.weights[campaignID] = weights
+	}
+
+	now := time.Now()
+	s := weights[name]
+	s.Succeeded *= RegionDecay
+	s.Blocked *= RegionDecay
+	if blocked {
+		s.Blocked++
+	} else {
+		s.Succeeded++
+	}
+	s.Weight = regionWeight(s)
+	s.UpdatedAt = now
+	weights[name] = s
+
+	if now.Sub(r.flushed[campaignID]) < RegionWeightsFlush {
+		return nil
+	}
+	r.flushed[campaignID] = now
+	saved := make(db.RegionWeights, len(weights))
+	for k, v := range weights {
+		saved[k] = v
+	}
+	return saved
+}
+
+// publish records that a task is outstanding in the region. The caller must
+// hold the lock.
+func (r *regionSelector) publish(name string, now time.Time) {
+	if _, ok := r.pending[name]; !ok {
+		r.pending[name] = now
+	}
+}
+
+// Preview returns the regions Select may currently choose from for a target
+// in the provided geo, without recording an outstanding task.
+func (r *regionSelector) Preview(geo string, allowed db.WorkerRegions) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.candidates(geo, allowed, time.Now())
+}
+
+// Names returns the names of the configured regions.
+func (r *regionSelector) Names() []string {
+	return r.names
+}
+
+// Seen records that a result was received from the region.
+func (r *regionSelector) Seen(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, name)
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+func TestRegionSelect(t *testing.T) {
+	var regions Regions
+	err := regions.UnmarshalText([]byte(`{"us-central1":["us","na"],"europe-west3":["de","eu"],"europe-west1":["be","eu"]}`))
+	if err != nil {
+		t.Fatalf("error parsing regions: %s", err)
+	}
+	r := newRegionSelector(regions)
+
+	for i := 0; i < 10; i++ {
+		if got := r.Select("DE", nil); got != "europe-west3" {
+			t.Errorf("expected europe-west3 for de, got %s", got)
+		}
+		if got := r.Select("eu", nil); got != "europe-west3" && got != "europe-west1" {
+			t.Errorf("expected a europe region for eu, got %s", got)
+		}
+	}
+
+	// europe-west3 has not returned results, so fall back to any healthy region
+	r.pending["europe-west3"] = time.Now().Add(-2 * RegionTimeout)
+	for i := 0; i < 10; i++ {
+		if got := r.Select("de", nil); got == "europe-west3" {
+			t.Errorf("selected unhealthy region %s", got)
+		}
+	}
+
+	r.Seen("europe-west3")
+	if got := r.Select("de", nil); got != "europe-west3" {
+		t.Errorf("expected europe-west3 after result, got %s", got)
+	}
+
+	if got := newRegionSelector(nil).Select("de", nil); got != "" {
+		t.Errorf("expected no region when none are configured, got %s", got)
+	}
+}
+
+func TestRegionAllowed(t *testing.T) {
+	r := newRegionSelector(Regions{
+		"us-central1":  {"us"},
+		"europe-west3": {"de", "eu"},
+		"europe-west1": {"be", "eu"},
+	})
+
+	// attempts are only sent from the allowed regions, in proportion to
+	// their weights, even when the target geo prefers another region
+	allowed := db.WorkerRegions{"us-central1": 3, "europe-west1": 1}
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[r.Select("de", allowed)]++
+	}
+	if len(counts) != 2 || counts["us-central1"] < 650 || counts["us-central1"] > 850 {
+		t.Errorf("expected three quarters of the tasks in us-central1, got %v", counts)
+	}
+
+	// unhealthy allowed regions are still used rather than other regions
+	r.pending["europe-west1"] = time.Now().Add(-2 * RegionTimeout)
+	if got := r.Rotate("", db.WorkerRegions{"europe-west1": 1}, 1); got != "europe-west1" {
+		t.Errorf("expected the only allowed region, got %s", got)
+	}
+
+	if got := r.Weighted("", db.WorkerRegions{"asia-east1": 1}, 1); got != "" {
+		t.Errorf("expected no region when none of the allowed ones are configured, got %s", got)
+	}
+}
+
+func TestRegionRotate(t *testing.T) {
+	r := newRegionSelector(Regions{
+		"us-central1":  {"us"},
+		"europe-west3": {"de", "eu"},
+		"europe-west1": {"be", "eu"},
+	})
+
+	// every region receives one task of a campaign before any receives two,
+	// and a region never receives two consecutive tasks
+	last := ""
+	for round := 0; round < 5; round++ {
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			got := r.Rotate("", nil, 1)
+			if seen[got] || got == last {
+				t.Fatalf("round %d: region %s selected again", round, got)
+			}
+			seen[got] = true
+			last = got
+		}
+	}
+
+	// the rotation is limited to the regions near the target geo
+	for i := 0; i < 4; i++ {
+		if got := r.Rotate("eu", nil, 2); got != "europe-west3" && got != "europe-west1" {
+			t.Errorf("expected a europe region for eu, got %s", got)
+		}
+	}
+
+	// unhealthy regions are dropped from a rotation in progress
+	r.Rotate("", nil, 3)
+	r.pending["us-central1"] = time.Now().Add(-2 * RegionTimeout)
+	for i := 0; i < 4; i++ {
+		if got := r.Rotate("", nil, 3); got == "us-central1" {
+			t.Errorf("selected unhealthy region %s", got)
+		}
+	}
+
+	if got := newRegionSelector(nil).Rotate("de", nil, 1); got != "" {
+		t.Errorf("expected no region when none are configured, got %s", got)
+	}
+}
+
+func TestRegionWeighted(t *testing.T) {
+	r := newRegionSelector(Regions{
+		"us-central1":  {"us"},
+		"europe-west3": {"de", "eu"},
+	})
+
+	// a region the target blocks loses weight, but keeps the minimum
+	for i := 0; i < 100; i++ {
+		r.Observe(1, "us-central1", true)
+		r.Observe(1, "europe-west3", false)
+	}
+	weights := r.weights[1]
+	if w := weights["us-central1"].Weight; w != RegionMinWeight {
+		t.Errorf("expected the blocked region to have the minimum weight, got %v", w)
+	}
+	if w := weights["europe-west3"].Weight; w < 0.9 {
+		t.Errorf("expected the unblocked region to have a high weight, got %v", w)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		counts[r.Weighted("", nil, 1)]++
+	}
+	if counts["europe-west3"] < 900 || counts["us-central1"] == 0 {
+		t.Errorf("expected most tasks in the unblocked region, got %v", counts)
+	}
+
+	// recent results outweigh older ones, so a region recovers
+	for i := 0; i < 100; i++ {
+		r.Observe(1, "us-central1", false)
+	}
+	if w := r.weights[1]["us-central1"].Weight; w < 0.9 {
+		t.Errorf("expected the region to recover, got %v", w)
+	}
+
+	// a saved record is not loaded over one already observed
+	r.Load(1, nil)
+	if !r.Loaded(1) || len(r.weights[1]) != 2 {
+		t.Errorf("observed record was replaced: %v", r.weights[1])
+	}
+	r.Load(2, db.RegionWeights{"us-central1": {Blocked: 50, Weight: RegionMinWeight}})
+	if got := r.Weighted("us", nil, 2); got != "us-central1" {
+		t.Errorf("expected the only region for us, got %s", got)
+	}
+
+	// the record is returned to be saved at most every RegionWeightsFlush
+	if saved := r.Observe(3, "us-central1", false); saved == nil {
+		t.Error("expected a new record to be saved")
+	}
+	if saved := r.Observe(3, "us-central1", false); saved != nil {
+		t.Error("expected the record not to be saved again so soon")
+	}
+}
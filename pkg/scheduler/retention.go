@@ -0,0 +1,66 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// RetentionInterval is how often the results of campaigns whose
+	// retention has passed are purged
+	RetentionInterval = 10 * time.Minute
+)
+
+// PurgeExpired purges the results of every campaign whose retention has
+// passed, checking every RetentionInterval. It never returns.
+func (s *PubSubScheduler) PurgeExpired() {
+	for {
+		s.purge(time.Now())
+		time.Sleep(RetentionInterval)
+	}
+}
+
+// purge purges the results of the campaigns whose retention has passed at
+// now, and the campaigns themselves if they asked to be.
+func (s *PubSubScheduler) purge(now time.Time) {
+	campaigns, err := s.db.ExpiredCampaigns(now)
+	if err != nil {
+		log.Printf("error querying expired campaigns: %s", err)
+		return
+	}
+
+	for _, c := range campaigns {
+		n, err := s.db.PurgeResults(c, now)
+		if err != nil {
+			log.Printf("error purging campaign id=%d: %s", c.ID, err)
+			continue
+		}
+		if !c.PurgeCampaign {
+			log.Printf("campaign id=%d retention has passed, purged %d results", c.ID, n)
+			continue
+		}
+
+		// tasks left in the queue of a deleted campaign can never be
+		// published
+		err = s.cache.Del(fmt.Sprintf(CacheKeyF, c.ID)).Err()
+		if err != nil {
+			log.Printf("error clearing the queue of campaign id=%d: %s", c.ID, err)
+		}
+		log.Printf("campaign id=%d retention has passed, deleted the campaign and %d results", c.ID, n)
+	}
+}
@@ -19,12 +19,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
+	"sort"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/go-redis/redis/v7"
 
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
 )
 
 const (
@@ -33,13 +36,33 @@ const (
 
 	// CacheKeyR format string for the redis Scan function
 	CacheKeyR = "campaign*.tasks"
+
+	// AttemptsKeyF format string of the key holding the publish times of a
+	// campaign's recent tasks, used to enforce its AttemptLimit
+	AttemptsKeyF = "campaign%d.attempts"
+
+	// AttemptLimitWindow is the rolling window of a campaign's AttemptLimit
+	AttemptLimitWindow = time.Hour
+
+	// publishLead is how long before its NotBefore a task may be published
+	publishLead = 5 * time.Second
+
+	// idleBackoff is how long the producer waits after a pass over the
+	// queues in which no campaign had a task ready
+	idleBackoff = time.Second
 )
 
 // Scheduler is an interface which wraps several scheduling functions together.
 type Scheduler interface {
 	Schedule(db.Campaign) error
+	Extend(db.Campaign, []string) (Report, error)
+	Preview(db.Campaign) (Preview, error)
+	Seek(db.Campaign, int) (Report, error)
+	Stats() (Stats, error)
+	Workers() []WorkerStats
+	Regions() []string
 	ProduceTasks()
-	ConsumeResults() error
+	ConsumeResults(context.Context) error
 }
 
 // PubSubScheduler implements the scheduler interface and produces/consumes to
@@ -49,6 +72,17 @@ type PubSubScheduler struct {
 	cache *redis.Client
 	pub   *pubsub.Topic
 	sub   *pubsub.Subscription
+
+	regions *regionSelector
+	workers *workerRegistry
+	audit   *auditor
+	waf     *wafMonitor
+	limit   *concurrencyLimiter
+	fair    *fairShare
+	notify  *notifier
+	ingest  *ingester
+
+	batch db.BatchOptions
 }
 
 // Options is used to configure a PubSubScheduler.
@@ -71,12 +105,53 @@ type Options struct {
 
 	// RedisPassword is the Redis password
 	RedisPassword string
+
+	// Regions are the worker regions tasks are routed to. If empty, tasks are
+	// published without a region and any dispatcher may receive them.
+	Regions Regions
+
+	// Audit enables the append-only audit log of every guess. The password
+	// is never recorded.
+	Audit AuditMode
+
+	// AuditSecret keys the HMAC of the usernames in the AuditHashed mode,
+	// where it is required.
+	AuditSecret string
+
+	// NotifyURL is a webhook which is sent a notification of every valid
+	// result, at least once. Notifications are disabled if it is empty.
+	NotifyURL string
+
+	// NotifySecret signs the notifications with HMAC-SHA256, see
+	// sign.VerifyWebhook. They are unsigned if it is empty.
+	NotifySecret string
+
+	// IngestBatchSize is the most results written to the database in one
+	// batch, db.DefaultBatchSize if 0.
+	IngestBatchSize int
+
+	// IngestFlushInterval is the longest a result waits to be written,
+	// db.DefaultFlushInterval if 0.
+	IngestFlushInterval time.Duration
+
+	// IngestBuffer is the most results held waiting to be written. Once it
+	// is full, results are left in Pub/Sub until there is room. It defaults
+	// to IngestBatchSize.
+	IngestBuffer int
 }
 
 // NewPubSubScheduler creates a PubSubScheduler given the provided Options.
 // This call will attempt to ping the provided RedisURI and error if this
 // connection fails.
 func NewPubSubScheduler(opts Options) (*PubSubScheduler, error) {
+	err := opts.Audit.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Audit == AuditHashed && opts.AuditSecret == "" {
+		return nil, fmt.Errorf("the %q audit mode requires an audit secret", AuditHashed)
+	}
+
 	ctx := context.Background()
 	client, err := pubsub.NewClient(ctx, opts.ProjectID)
 	if err != nil {
@@ -99,10 +174,23 @@ func NewPubSubScheduler(opts Options) (*PubSubScheduler, error) {
 	}
 
 	return &PubSubScheduler{
-		db:    opts.Database,
-		cache: cache,
-		sub:   sub,
-		pub:   client.Topic(opts.TopicID),
+		db:      opts.Database,
+		cache:   cache,
+		sub:     sub,
+		pub:     client.Topic(opts.TopicID),
+		regions: newRegionSelector(opts.Regions),
+		workers: newWorkerRegistry(),
+		audit:   newAuditor(opts.Audit, opts.AuditSecret, opts.Database),
+		waf:     newWAFMonitor(),
+		limit:   newConcurrencyLimiter(),
+		fair:    newFairShare(),
+		notify:  newNotifier(opts.NotifyURL, opts.NotifySecret, opts.Database),
+		ingest:  &ingester{},
+		batch: db.BatchOptions{
+			Size:     opts.IngestBatchSize,
+			Interval: opts.IngestFlushInterval,
+			Buffer:   opts.IngestBuffer,
+		},
 	}, nil
 }
 
@@ -113,6 +201,16 @@ func (s *PubSubScheduler) pushCampaignTask(task *db.Task, campaignID uint) error
 	}).Err()
 }
 
+// ready returns true if the first task of the campaign's queue may be
+// published, so the queue is not popped only for the task to be put back.
+func (s *PubSubScheduler) ready(campaignKey string) (bool, error) {
+	z, err := s.cache.ZRangeWithScores(campaignKey, 0, 0).Result()
+	if err != nil || len(z) == 0 {
+		return false, err
+	}
+	return time.Until(time.Unix(0, int64(z[0].Score))) <= publishLead, nil
+}
+
 func (s *PubSubScheduler) popTask(task *db.Task, campaignKey string) error {
 	z, err := s.cache.BZPopMin(5*time.Second, campaignKey).Result()
 	if err != nil {
@@ -121,6 +219,215 @@ func (s *PubSubScheduler) popTask(task *db.Task, campaignKey string) error {
 	return task.UnmarshalBinary([]byte(z.Member.(string)))
 }
 
+// Report summarizes the outcome of scheduling a set of credential guesses.
+type Report struct {
+	// Scheduled is the number of tasks pushed to the schedule
+	Scheduled int `json:"scheduled"`
+
+	// Dropped is the number of tasks discarded because they would have been
+	// scheduled after the campaign's NotAfter time
+	Dropped int `json:"dropped"`
+}
+
+// FitsWindow returns true if every requested task was scheduled.
+func (r Report) FitsWindow() bool {
+	return r.Dropped == 0
+}
+
+// PlannedAttempt is a single attempt of a previewed schedule. It carries no
+// credential, only its position in the campaign's lists, so it can be shared
+// with the target's monitoring team.
+type PlannedAttempt struct {
+	// Time is when the attempt will be published
+	Time time.Time `json:"time"`
+
+	// User is the index of the attempt's username in the campaign's users
+	User int `json:"user"`
+
+	// Password is the index of the attempt's password in the passwords for
+	// that user
+	Password int `json:"password"`
+
+	// Regions are the worker regions the attempt may be sent from, empty if
+	// no regions are configured
+	Regions []string `json:"regions,omitempty"`
+}
+
+// Preview is the schedule a campaign will follow.
+type Preview struct {
+	Report
+
+	// Seed is the campaign seed the schedule was planned with
+	Seed int64 `json:"seed"`
+
+	// Attempts are the planned attempts in chronological order
+	Attempts []PlannedAttempt `json:"attempts"`
+}
+
+// attemptLimiter paces tasks so that no more than limit of them fall within
+// any AttemptLimitWindow.
+type attemptLimiter struct {
+	limit int
+
+	// times are the accepted task times in chronological order
+	times []time.Time
+}
+
+// next returns the earliest time at or after t that a task may be accepted.
+// Tasks are accepted in chronological order, so it is never before the last
+// accepted task.
+func (l *attemptLimiter) next(t time.Time) time.Time {
+	n := len(l.times)
+	if n > 0 && t.Before(l.times[n-1]) {
+		t = l.times[n-1]
+	}
+	if n >= l.limit {
+		if earliest := l.times[n-l.limit].Add(AttemptLimitWindow); t.Before(earliest) {
+			t = earliest
+		}
+	}
+	return t
+}
+
+// accept records a task at t, which must have been returned by next.
+func (l *attemptLimiter) accept(t time.Time) {
+	l.times = append(l.times, t)
+}
+
+// plan computes the tasks for the provided users, starting at the provided
+// time. For each password, every user is scheduled at the same timestamp and
+// the timestamp is then advanced by the ScheduleInterval. Rounds which would
+// fall inside a blackout are deferred until it ends. Within each password
+// the users are shuffled and each task is delayed by a random jitter, both
+// drawn from an RNG seeded with the campaign's Seed so that the same campaign
+// always yields the same schedule. Tasks which would be scheduled after the
+// NotAfter time are discarded and counted in the report.
+//
+// A campaign with per-user passwords is planned the same way, except that
+// round i tries the i-th password of each user and skips the users with fewer
+// passwords.
+//
+// If the campaign has an AttemptLimit, tasks which would exceed it are
+// deferred until the limit allows them, skipping blackouts, and the next round
+// waits a full ScheduleInterval after the last deferred task. Whichever of the
+// interval and the limit is more restrictive therefore governs.
+func plan(campaign db.Campaign, users []string, start time.Time) ([]*db.Task, Report) {
+	var tasks []*db.Task
+	var report Report
+
+	rng := rand.New(rand.NewSource(campaign.Seed)) // nolint:gosec
+	order := make([]string, len(users))
+	copy(order, users)
+
+	var limiter *attemptLimiter
+	if campaign.AttemptLimit > 0 {
+		limiter = &attemptLimiter{limit: campaign.AttemptLimit}
+	}
+
+	rounds := 0
+	for _, u := range users {
+		if n := len(campaign.PasswordsFor(u)); n > rounds {
+			rounds = n
+		}
+	}
+
+	t := start
+	for i := 0; i < rounds; i++ {
+		// deferring the whole round, rather than individual tasks, preserves
+		// the ScheduleInterval between guesses against the same user
+		t = campaign.Defer(t, campaign.Jitter)
+		if t.After(campaign.NotAfter) {
+			for _, u := range users {
+				if n := len(campaign.PasswordsFor(u)); n > i {
+					report.Dropped += n - i
+				}
+			}
+			break
+		}
+		rng.Shuffle(len(order), func(a, b int) {
+			order[a], order[b] = order[b], order[a]
+		})
+		var round []*db.Task
+		for _, u := range order {
+			passwords := campaign.PasswordsFor(u)
+			if i >= len(passwords) {
+				continue
+			}
+			notBefore := t
+			if campaign.Jitter > 0 {
+				notBefore = t.Add(time.Duration(rng.Int63n(int64(campaign.Jitter))))
+			}
+			round = append(round, &db.Task{
+				CampaignID:       campaign.ID,
+				NotBefore:        notBefore,
+				NotAfter:         campaign.NotAfter,
+				Deadline:         campaign.Deadline,
+				AttemptLimit:     campaign.AttemptLimit,
+				MaxConcurrent:    campaign.MaxConcurrent,
+				Priority:         campaign.Priority,
+				Blackouts:        campaign.Blackouts,
+				QuietHours:       campaign.QuietHours,
+				Username:         u,
+				Password:         passwords[i],
+				Provider:         campaign.Provider,
+				ProviderMetadata: campaign.ProviderMetadata,
+				TargetGeo:        campaign.TargetGeo,
+				RandomizeWorkers: campaign.RandomizeWorkers,
+				AdaptiveRegions:  campaign.AdaptiveRegions,
+				WorkerRegions:    campaign.WorkerRegions,
+				CaptureOnValid:   campaign.CaptureOnValid,
+				UsernameSalt:     campaign.UsernameSalt,
+			})
+		}
+		if limiter == nil {
+			tasks = append(tasks, round...)
+			t = t.Add(campaign.ScheduleInterval)
+			continue
+		}
+
+		next := t.Add(campaign.ScheduleInterval)
+		sort.SliceStable(round, func(a, b int) bool {
+			return round[a].NotBefore.Before(round[b].NotBefore)
+		})
+		for _, task := range round {
+			notBefore := task.NotBefore
+			for {
+				deferred := campaign.Defer(limiter.next(notBefore), 0)
+				if deferred.Equal(notBefore) {
+					break
+				}
+				notBefore = deferred
+			}
+			if notBefore.After(campaign.NotAfter) {
+				report.Dropped++
+				continue
+			}
+			limiter.accept(notBefore)
+			if !notBefore.Equal(task.NotBefore) {
+				task.NotBefore = notBefore
+				if after := notBefore.Add(campaign.ScheduleInterval); after.After(next) {
+					next = after
+				}
+			}
+			tasks = append(tasks, task)
+		}
+		t = next
+	}
+
+	report.Scheduled = len(tasks)
+	return tasks, report
+}
+
+// push adds the planned tasks to the campaign's schedule.
+func (s *PubSubScheduler) push(campaign db.Campaign, tasks []*db.Task) {
+	for _, task := range tasks {
+		err := s.pushCampaignTask(task, campaign.ID)
+		if err != nil {
+			log.Printf("error in redis push task: %s", err)
+		}
+	}
+}
+
 // Schedule accepts a campaign and computes all required tasks based on the
 // provided NotBefore, NotAfter, and ScheduleInterval values. Tasks are
 // scheduled by continuously adding the ScheduleInterval to a running timestamp
@@ -131,117 +438,624 @@ func (s *PubSubScheduler) popTask(task *db.Task, campaignKey string) error {
 // single password at a time, allowing the maximum time to pass before guessing
 // a given username again.
 func (s *PubSubScheduler) Schedule(campaign db.Campaign) error {
-	t := campaign.NotBefore
-	for _, p := range campaign.Passwords {
-		for _, u := range campaign.Users {
-			err := s.pushCampaignTask(&db.Task{
-				CampaignID:       campaign.ID,
-				NotBefore:        t,
-				NotAfter:         campaign.NotAfter,
-				Username:         u,
-				Password:         p,
-				Provider:         campaign.Provider,
-				ProviderMetadata: campaign.ProviderMetadata,
-			}, campaign.ID)
-			if err != nil {
-				log.Printf("error in redis push task: %s", err)
-			}
+	tasks, _ := plan(campaign, campaign.Users, campaign.NotBefore)
+	s.push(campaign, tasks)
+	return nil
+}
+
+// Extend schedules every campaign password for the provided users, which are
+// assumed to have been appended to an already scheduled campaign. Tasks are
+// scheduled from the later of now and NotBefore using the same pacing as
+// Schedule, so the new users never receive guesses faster than the campaign's
+// ScheduleInterval.
+func (s *PubSubScheduler) Extend(campaign db.Campaign, users []string) (Report, error) {
+	start := time.Now()
+	if campaign.NotBefore.After(start) {
+		start = campaign.NotBefore
+	}
+
+	tasks, report := plan(campaign, users, start)
+	s.push(campaign, tasks)
+	return report, nil
+}
+
+// Preview plans the campaign exactly as Schedule would and returns the
+// resulting schedule without pushing it. Each attempt lists the regions it
+// may be sent from given the current region health; the region itself is
+// picked among them when the attempt is published.
+func (s *PubSubScheduler) Preview(campaign db.Campaign) (Preview, error) {
+	tasks, report := schedule(campaign)
+	regions := s.regions.Preview(campaign.TargetGeo, campaign.WorkerRegions)
+
+	users := make(map[string]int, len(campaign.Users))
+	for i, u := range campaign.Users {
+		if _, ok := users[u]; !ok {
+			users[u] = i
 		}
-		t = t.Add(campaign.ScheduleInterval)
-		if t.After(campaign.NotAfter) {
-			return nil
+	}
+
+	preview := Preview{
+		Report:   report,
+		Seed:     campaign.Seed,
+		Attempts: make([]PlannedAttempt, 0, len(tasks)),
+	}
+	for _, task := range tasks {
+		preview.Attempts = append(preview.Attempts, PlannedAttempt{
+			Time:     task.NotBefore,
+			User:     users[task.Username],
+			Password: indexOf(campaign.PasswordsFor(task.Username), task.Password),
+			Regions:  regions,
+		})
+	}
+	return preview, nil
+}
+
+// indexOf returns the index of the first s in list, or -1 if it is not there.
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
 		}
 	}
-	return nil
+	return -1
 }
 
-func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) error {
+// storedUsername returns the username of the task as its campaign and results
+// store it, hashed if the campaign hashes usernames.
+func storedUsername(task *db.Task) string {
+	if task.UsernameSalt == "" {
+		return task.Username
+	}
+	return event.HashUsername(task.UsernameSalt, task.Username)
+}
+
+// publishTask publishes the task if it is ready, and otherwise puts it back in
+// its campaign's queue. It returns true if the task took its campaign's turn:
+// it was published, or discarded because the campaign had already made the
+// attempt or has ended.
+func (s *PubSubScheduler) publishTask(ctx context.Context, task *db.Task) (bool, error) {
 
 	taskStatus, err := s.db.GetCampaignStatus(task.CampaignID)
 	if err != nil {
-		return fmt.Errorf("Error checking campaign status during scheduling: %w", err)
+		return false, fmt.Errorf("Error checking campaign status during scheduling: %w", err)
 	}
 
 	// check if task.CampaignID belongs to a cancelled/halted Campaign. If so skip it.
-	if taskStatus == db.CampaignStatusCancelled {
+	if taskStatus.Terminal() {
 		// for now, just do nothing, let the task expire
-		return nil
+		return true, nil
 	}
 
-	if time.Until(task.NotBefore) > 5*time.Second || taskStatus == db.CampaignStatusPaused {
+	// the deadline applies to paused campaigns too, so it is checked first
+	if task.Deadline != nil && time.Now().After(*task.Deadline) {
+		return false, s.stop(task.CampaignID, db.CampaignStatusDeadlineExceeded, "deadline reached")
+	}
+
+	if time.Until(task.NotBefore) > publishLead || taskStatus == db.CampaignStatusPaused {
 		// our task was not ready or the campaign is paused, reschedule it
 		err := s.pushCampaignTask(task, task.CampaignID)
 		if err != nil {
-			return fmt.Errorf("error rescheduling task: %w", err)
-		}
-		time.Sleep(1 * time.Second)
-	} else {
-		// our task was ready, run it!
-		b, _ := json.Marshal(task)
-		publishResults := s.pub.Publish(ctx, &pubsub.Message{
-			Data: b,
-		})
-		_, err := publishResults.Get(ctx)
+			return false, fmt.Errorf("error rescheduling task: %w", err)
+		}
+		return false, nil
+	}
+
+	// the first ready task starts a campaign which was scheduled
+	if taskStatus == db.CampaignStatusScheduled {
+		activated, err := s.db.ActivateCampaign(task.CampaignID)
+		if err != nil {
+			return false, fmt.Errorf("error activating campaign: %w", err)
+		}
+		if activated {
+			log.Printf("campaign id=%d has started", task.CampaignID)
+		}
+	}
+
+	// tasks held back while the campaign was paused or throttled may
+	// only become ready inside a blackout or quiet hours
+	now := time.Now()
+	if end := task.Defer(now); end.After(now) {
+		task.NotBefore = end
+		err := s.pushCampaignTask(task, task.CampaignID)
+		if err != nil {
+			return false, fmt.Errorf("error rescheduling task after blackout: %w", err)
+		}
+		return false, nil
+	}
+
+	if task.AttemptLimit > 0 {
+		until, err := s.throttled(task)
+		if err != nil {
+			return false, fmt.Errorf("error checking attempt limit: %w", err)
+		}
+		if !until.IsZero() {
+			return false, s.throttle(task, until)
+		}
+	}
+
+	// the target's slots are shared with the other campaigns against it,
+	// so the task waits for one to free up
+	username := storedUsername(task)
+	attempt := attemptKey(task.CampaignID, username, task.Password)
+	if task.MaxConcurrent > 0 && !s.limit.Acquire(targetKey(task), attempt, task.MaxConcurrent, now) {
+		err := s.pushCampaignTask(task, task.CampaignID)
+		if err != nil {
+			return false, fmt.Errorf("error rescheduling task held by max concurrent: %w", err)
+		}
+		return false, nil
+	}
+
+	// a campaign never makes the same attempt twice, however the task
+	// came to be queued again (added users, a retry, an edit)
+	digest := task.Digest()
+	claimed, err := s.db.ClaimAttempt(task.CampaignID, digest)
+	if err != nil {
+		s.limit.Release(attempt)
+		return false, fmt.Errorf("error checking for a duplicate attempt: %w", err)
+	}
+	if !claimed {
+		s.limit.Release(attempt)
+		log.Printf("campaign id=%d suppressed a duplicate attempt", task.CampaignID)
+		return true, nil
+	}
+
+	// our task was ready, run it in a region near the target
+	b, _ := json.Marshal(task)
+	msg := &pubsub.Message{
+		Data: b,
+	}
+	var region string
+	switch {
+	case task.RandomizeWorkers:
+		region = s.regions.Rotate(task.TargetGeo, task.WorkerRegions, task.CampaignID)
+	case task.AdaptiveRegions:
+		err = s.loadRegionWeights(task.CampaignID)
+		if err != nil {
+			log.Printf("error loading region weights: %s", err)
+		}
+		region = s.regions.Weighted(task.TargetGeo, task.WorkerRegions, task.CampaignID)
+	default:
+		region = s.regions.Select(task.TargetGeo, task.WorkerRegions)
+	}
+	if region == "" && len(task.WorkerRegions) > 0 {
+		// any dispatcher could receive a task without a region, which
+		// would defeat the campaign's choice of regions
+		s.limit.Release(attempt)
+		if rerr := s.db.ReleaseAttempts(task.CampaignID, [][]byte{digest}); rerr != nil {
+			log.Printf("error releasing attempt: %s", rerr)
+		}
+		return true, fmt.Errorf("campaign id=%d dropped a task: none of its worker regions is configured",
+			task.CampaignID)
+	}
+	if region != "" {
+		msg.Attributes = map[string]string{RegionAttribute: region}
+	}
+	publishResults := s.pub.Publish(ctx, msg)
+	_, err = publishResults.Get(ctx)
+	if err != nil {
+		// the attempt was not made, so it may be again
+		s.limit.Release(attempt)
+		if rerr := s.db.ReleaseAttempts(task.CampaignID, [][]byte{digest}); rerr != nil {
+			log.Printf("error releasing attempt: %s", rerr)
+		}
+		return false, fmt.Errorf("error publishing task: %w", err)
+	}
+
+	if task.AttemptLimit > 0 {
+		err = s.recordAttempt(task)
+		if err != nil {
+			return true, fmt.Errorf("error recording attempt: %w", err)
+		}
+	}
+
+	err = s.db.AdvanceCursor(task.CampaignID, username, task.Password, region, time.Now())
+	if err != nil {
+		return true, fmt.Errorf("error advancing cursor: %w", err)
+	}
+	return true, nil
+}
+
+// loadRegionWeights loads the campaign's record in each region saved before a
+// restart, the first time the campaign needs it.
+func (s *PubSubScheduler) loadRegionWeights(campaignID uint) error {
+	if s.regions.Loaded(campaignID) {
+		return nil
+	}
+	weights, err := s.db.RegionWeights(campaignID)
+	if err != nil {
+		return err
+	}
+	s.regions.Load(campaignID, weights)
+	return nil
+}
+
+// observeRegion adds the result to its campaign's record in the region it was
+// made from, saving the record every RegionWeightsFlush so the weights survive
+// a restart. Results which failed without a verdict are not counted.
+func (s *PubSubScheduler) observeRegion(res *db.Result, region string) error {
+	if res.Status == db.ResultStatusError {
+		return nil
+	}
+	err := s.loadRegionWeights(res.CampaignID)
+	if err != nil {
+		return err
+	}
+	blocked := res.Status == db.ResultStatusRateLimited || res.Status == db.ResultStatusChallenged
+	weights := s.regions.Observe(res.CampaignID, region, blocked)
+	if weights == nil {
+		return nil
+	}
+	return s.db.SetRegionWeights(res.CampaignID, weights)
+}
+
+// throttled returns the time until which the task's campaign has reached its
+// AttemptLimit, or the zero time if the task may be published now. Plans
+// already pace tasks to the limit, so this only defers tasks which were
+// scheduled closer together later on, e.g. by adding users or resuming a
+// paused campaign.
+func (s *PubSubScheduler) throttled(task *db.Task) (time.Time, error) {
+	key := fmt.Sprintf(AttemptsKeyF, task.CampaignID)
+	now := time.Now()
+	err := s.cache.ZRemRangeByScore(key, "-inf",
+		fmt.Sprint(now.Add(-AttemptLimitWindow).UnixNano())).Err()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	n, err := s.cache.ZCard(key).Result()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n < int64(task.AttemptLimit) {
+		return time.Time{}, nil
+	}
+
+	// the oldest attempt which has to leave the window before another fits
+	oldest, err := s.cache.ZRangeWithScores(key, n-int64(task.AttemptLimit), n-int64(task.AttemptLimit)).Result()
+	if err != nil || len(oldest) == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(oldest[0].Score)).Add(AttemptLimitWindow), nil
+}
+
+// throttle reschedules a task held back by its campaign's AttemptLimit and records
+// that the campaign is throttled, so campaign describe can report it.
+func (s *PubSubScheduler) throttle(task *db.Task, until time.Time) error {
+	task.NotBefore = until
+	err := s.pushCampaignTask(task, task.CampaignID)
+	if err != nil {
+		return fmt.Errorf("error rescheduling throttled task: %w", err)
+	}
+	err = s.db.SetCampaignThrottled(task.CampaignID, until)
+	if err != nil {
+		return fmt.Errorf("error recording throttled campaign: %w", err)
+	}
+	return nil
+}
+
+// recordAttempt adds a published task to its campaign's attempt window.
+func (s *PubSubScheduler) recordAttempt(task *db.Task) error {
+	key := fmt.Sprintf(AttemptsKeyF, task.CampaignID)
+	now := time.Now()
+	err := s.cache.ZAdd(key, &redis.Z{
+		Score:  float64(now.UnixNano()),
+		Member: fmt.Sprintf("%d:%s", now.UnixNano(), storedUsername(task)),
+	}).Err()
+	if err != nil {
+		return err
+	}
+	return s.cache.Expire(key, AttemptLimitWindow).Err()
+}
+
+// stop moves a campaign to a terminal status, such as
+// CampaignStatusDeadlineExceeded, and drains its remaining tasks.
+func (s *PubSubScheduler) stop(campaignID uint, status db.CampaignStatus, reason string) error {
+	err := s.db.StopCampaign(campaignID, status, reason)
+	if err != nil {
+		return fmt.Errorf("error updating status of stopped campaign: %w", err)
+	}
+
+	n, err := s.cache.ZCard(fmt.Sprintf(CacheKeyF, campaignID)).Result()
+	if err != nil {
+		return fmt.Errorf("error counting tasks of stopped campaign: %w", err)
+	}
+	err = s.cache.Del(fmt.Sprintf(CacheKeyF, campaignID)).Err()
+	if err != nil {
+		return fmt.Errorf("error draining tasks of stopped campaign: %w", err)
+	}
+	log.Printf("campaign id=%d stopped (%s), drained %d remaining tasks", campaignID, reason, n)
+	return nil
+}
+
+// checkStopAfterValid completes the campaign once it has StopAfterValid valid
+// results. Expired credentials are not counted since they cannot be used to
+// log in.
+func (s *PubSubScheduler) checkStopAfterValid(campaignID uint) error {
+	campaign, err := s.db.DescribeCampaign(db.Query{
+		Filter: map[string]interface{}{"id": campaignID},
+	})
+	if err != nil {
+		return err
+	}
+	if campaign.StopAfterValid == 0 || campaign.Status.Terminal() {
+		return nil
+	}
+
+	stats, err := s.db.ResultStats(campaignID)
+	if err != nil {
+		return err
+	}
+	if stats[db.ResultStatusValid] < campaign.StopAfterValid {
+		return nil
+	}
+	return s.stop(campaignID, db.CampaignStatusCompleted,
+		fmt.Sprintf("stopped after %d valid credentials", stats[db.ResultStatusValid]))
+}
+
+// checkWritten runs checkStopAfterValid for each campaign with a valid result
+// in a batch written by the ingester.
+func (s *PubSubScheduler) checkWritten(results []*db.Result) {
+	checked := make(map[uint]bool)
+	for _, res := range results {
+		if res.Status != db.ResultStatusValid || checked[res.CampaignID] {
+			continue
+		}
+		checked[res.CampaignID] = true
+		err := s.checkStopAfterValid(res.CampaignID)
 		if err != nil {
-			return fmt.Errorf("error publishing task: %w", err)
+			log.Printf("error checking stop-after-valid: %s", err)
 		}
 	}
+}
+
+// checkAbortOnWAF pauses the campaign once the challenge rate of its recent
+// results reaches its AbortOnWAF threshold. The WAF of the result which
+// crossed the threshold is recorded as the reason, and sent to the
+// notification webhook.
+func (s *PubSubScheduler) checkAbortOnWAF(res *db.Result) error {
+	rate, n := s.waf.Observe(res.CampaignID, res.WAF != "")
+	if res.WAF == "" || n < wafMinResults {
+		return nil
+	}
+
+	campaign, err := s.db.DescribeCampaign(db.Query{
+		Filter: map[string]interface{}{"id": res.CampaignID},
+	})
+	if err != nil {
+		return err
+	}
+	if campaign.AbortOnWAF == 0 || rate < campaign.AbortOnWAF || campaign.Status == db.CampaignStatusPaused ||
+		campaign.Status.Terminal() {
+		return nil
+	}
+
+	reason := fmt.Sprintf("paused after %.0f%% of the last %d results were challenged (%s)",
+		rate*100, n, res.WAF)
+	err = s.db.StopCampaign(res.CampaignID, db.CampaignStatusPaused, reason)
+	if err != nil {
+		return err
+	}
+	s.waf.Reset(res.CampaignID)
+	s.notify.Paused(res.CampaignID, reason)
+	log.Printf("campaign id=%d %s", res.CampaignID, reason)
 	return nil
 }
 
+// Stats returns the depth of the task queue and the dispatchers which sent a
+// heartbeat recently.
+func (s *PubSubScheduler) Stats() (Stats, error) {
+	stats := Stats{Queues: make(map[uint]int64)}
+
+	var cursor uint64
+	for {
+		var keys []string
+		var err error
+		keys, cursor, err = s.cache.Scan(cursor, CacheKeyR, 100).Result()
+		if err != nil {
+			return stats, err
+		}
+		for _, key := range keys {
+			var campaignID uint
+			_, err = fmt.Sscanf(key, CacheKeyF, &campaignID)
+			if err != nil {
+				continue
+			}
+			n, err := s.cache.ZCard(key).Result()
+			if err != nil {
+				return stats, err
+			}
+			stats.Queues[campaignID] = n
+			stats.QueueDepth += n
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	stats.Workers, stats.WorkerCount = s.workers.List(time.Now())
+	stats.IngestBacklog, stats.IngestBuffer = s.ingest.Backlog()
+	return stats, nil
+}
+
+// Workers returns the dispatchers which sent a heartbeat within WorkerExpiry.
+func (s *PubSubScheduler) Workers() []WorkerStats {
+	workers, _ := s.workers.List(time.Now())
+	return workers
+}
+
+// Regions returns the names of the configured worker regions.
+func (s *PubSubScheduler) Regions() []string {
+	return s.regions.Names()
+}
+
 // ProduceTasks will poll the task schedule and publish tasks to pub/sub when
-// the top task is ready.
+// the top task is ready. The campaigns take turns by priority, see fairShare.
 func (s *PubSubScheduler) ProduceTasks() {
 	ctx := context.Background()
-	var cursor uint64
 	for {
-		var campaignKeys []string
-		var err error
-		campaignKeys, cursor, err = s.cache.Scan(cursor, CacheKeyR, 10).Result()
+		campaigns, err := s.queuedCampaigns()
 		if err != nil {
 			log.Printf("error fetching campaign keys: %s", err)
 		}
-		if len(campaignKeys) == 0 {
+		if len(campaigns) == 0 {
 			time.Sleep(1 * time.Second)
 			continue
 		}
-		for _, campaign := range campaignKeys {
+		dispatched := s.fair.Pass(campaigns, func(campaignID uint) bool {
+			key := fmt.Sprintf(CacheKeyF, campaignID)
+			ready, err := s.ready(key)
+			if err != nil {
+				log.Printf("error checking for a ready task: %s", err)
+			}
+			if !ready {
+				return false
+			}
+
 			var task db.Task
-			err = s.popTask(&task, campaign)
+			err = s.popTask(&task, key)
 			if err != nil {
 				log.Printf("error calling popTask: %s", err)
+				return false
 			}
-			err = s.publishTask(ctx, &task)
+			s.fair.SetPriority(campaignID, task.Priority)
+			published, err := s.publishTask(ctx, &task)
 			if err != nil {
 				log.Printf("%s", err)
 			}
+			return published
+		})
+		if dispatched == 0 {
+			time.Sleep(idleBackoff)
 		}
 	}
 }
 
+// queuedCampaigns returns the IDs of the campaigns with queued tasks.
+func (s *PubSubScheduler) queuedCampaigns() ([]uint, error) {
+	var campaigns []uint
+	var cursor uint64
+	for {
+		keys, next, err := s.cache.Scan(cursor, CacheKeyR, 100).Result()
+		if err != nil {
+			return campaigns, err
+		}
+		for _, key := range keys {
+			var campaignID uint
+			if _, err := fmt.Sscanf(key, CacheKeyF, &campaignID); err == nil {
+				campaigns = append(campaigns, campaignID)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return campaigns, nil
+		}
+	}
+}
+
+// decodeResult reads a result published by a worker and classifies it. An
+// attempt which failed without a verdict, e.g. against a server which refuses
+// password authentication, carries an ErrorCategory and is an error result
+// rather than an invalid credential.
+func decodeResult(data []byte) (db.Result, error) {
+	var res db.Result
+	err := json.Unmarshal(data, &res)
+	if err != nil {
+		return res, err
+	}
+
+	res.Status = res.Classify()
+	if res.Status == db.ResultStatusValidExpired {
+		// an expired password cannot be used to log in, so it is not
+		// notified or counted as a valid credential
+		res.Valid = false
+	}
+	return res, nil
+}
+
 // ConsumeResults will stream results from pub/sub and store them in the
-// database. Valid results are written directly to the database and invalid
-// results are batched by the db.StreamingInsertResults function.
-func (s *PubSubScheduler) ConsumeResults() error {
-	ctx := context.Background()
-	results := s.db.StreamingInsertResults()
+// database until the context is done. Valid results are written directly to
+// the database and invalid results are batched by a db.ResultWriter. While the
+// batches are backed up, results are left in pub/sub until there is room.
+// Before returning, the results still waiting are written.
+func (s *PubSubScheduler) ConsumeResults(ctx context.Context) error {
+	// valid results which could not be inserted directly count toward
+	// StopAfterValid once their batch is written
+	batch := s.batch
+	batch.Written = s.checkWritten
+	s.ingest.Start(s.db, batch)
+	defer s.ingest.Close()
+
+	// notifications left undelivered by a previous run are sent first
+	go s.notify.Run(ctx)
+
 	return s.sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
-		var res db.Result
-		err := json.Unmarshal(msg.Data, &res)
+		if _, ok := msg.Attributes[HeartbeatAttribute]; ok {
+			s.workers.Heartbeat(heartbeatStats(msg.Attributes, msg.PublishTime))
+			msg.Ack()
+			return
+		}
+
+		res, err := decodeResult(msg.Data)
 		if err != nil {
 			log.Printf("error unmarshaling: %s", err)
 			msg.Nack()
 			return
 		}
 
+		region, ok := msg.Attributes[RegionAttribute]
+		if ok {
+			s.regions.Seen(region)
+		}
+		res.Region = region
+
+		// the attempt is no longer in flight against its target
+		s.limit.Release(attemptKey(res.CampaignID, res.Username, res.Password))
+
+		if region != "" {
+			err = s.observeRegion(&res, region)
+			if err != nil {
+				log.Printf("error saving region weights: %s", err)
+			}
+		}
+
+		err = s.checkAbortOnWAF(&res)
+		if err != nil {
+			log.Printf("error checking abort-on-waf: %s", err)
+		}
+
+		err = s.audit.Record(&res, region)
+		if err != nil {
+			log.Printf("error writing audit entry: %s", err)
+			msg.Nack()
+			return
+		}
+
 		if res.Valid {
+			s.notify.Pending(&res)
 			err = s.db.InsertResult(&res)
 			if err != nil {
 				log.Printf("error inserting result into db: %s", err)
-				results <- &res
+				err = s.ingest.Push(ctx, &res)
+				if err != nil {
+					log.Printf("error queueing result: %s", err)
+					msg.Nack()
+					return
+				}
+			} else {
+				s.notify.Notify()
+				if res.Status == db.ResultStatusValid {
+					err = s.checkStopAfterValid(res.CampaignID)
+					if err != nil {
+						log.Printf("error checking stop-after-valid: %s", err)
+					}
+				}
 			}
 		} else {
-			results <- &res
+			err = s.ingest.Push(ctx, &res)
+			if err != nil {
+				log.Printf("error queueing result: %s", err)
+				msg.Nack()
+				return
+			}
 		}
 
 		// ACK only if everything else succeeded
@@ -0,0 +1,316 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/nozzle"
+)
+
+func testCampaign(seed int64) db.Campaign {
+	start := time.Date(2020, 9, 1, 9, 0, 0, 0, time.UTC)
+	c := db.Campaign{
+		NotBefore:        start,
+		NotAfter:         start.Add(24 * time.Hour),
+		ScheduleInterval: time.Hour,
+		Jitter:           10 * time.Minute,
+		Seed:             seed,
+		Passwords:        []string{"Password0", "Password1", "Password2"},
+	}
+	for i := 0; i < 20; i++ {
+		c.Users = append(c.Users, fmt.Sprintf("user%d@example.org", i))
+	}
+	return c
+}
+
+func TestPlanSeed(t *testing.T) {
+	c := testCampaign(42)
+	first, report := plan(c, c.Users, c.NotBefore)
+	if report.Scheduled != 60 || report.Dropped != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	second, _ := plan(testCampaign(42), c.Users, c.NotBefore)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("same seed yielded different schedules")
+	}
+
+	other, _ := plan(testCampaign(43), c.Users, c.NotBefore)
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("different seeds yielded identical schedules")
+	}
+
+	for _, task := range first {
+		offset := task.NotBefore.Sub(c.NotBefore) % c.ScheduleInterval
+		if offset < 0 || offset >= c.Jitter {
+			t.Errorf("task for %s has jitter %s outside [0, %s)", task.Username, offset, c.Jitter)
+		}
+	}
+}
+
+func TestPlanBlackouts(t *testing.T) {
+	c := testCampaign(42)
+	c.Blackouts = db.Blackouts{
+		{Start: c.NotBefore.Add(65 * time.Minute), End: c.NotBefore.Add(90 * time.Minute)},
+	}
+	tasks, report := plan(c, c.Users, c.NotBefore)
+	if report.Scheduled != 60 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	for _, task := range tasks {
+		for _, b := range c.Blackouts {
+			if b.Overlaps(task.NotBefore, task.NotBefore) {
+				t.Errorf("task for %s at %s falls inside blackout", task.Username, task.NotBefore)
+			}
+		}
+	}
+
+	// the second round overlaps the blackout once jitter is included, so it
+	// and every later round are deferred to the end of the blackout
+	last := tasks[len(tasks)-1]
+	if last.NotBefore.Before(c.Blackouts[0].End.Add(c.ScheduleInterval)) {
+		t.Errorf("final round at %s was not deferred", last.NotBefore)
+	}
+}
+
+func TestPlanQuietHours(t *testing.T) {
+	c := testCampaign(42)
+	c.QuietHours = &db.QuietHours{
+		Ranges: []db.QuietRange{{Start: "09:30", End: "10:30"}},
+	}
+	tasks, report := plan(c, c.Users, c.NotBefore)
+	if report.Scheduled != 60 || report.Dropped != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// the second round is deferred to the end of the quiet hours rather than
+	// dropped, and the third keeps its interval
+	quiet := db.Blackout{Start: c.NotBefore.Add(30 * time.Minute), End: c.NotBefore.Add(90 * time.Minute)}
+	rounds := make(map[time.Duration]int)
+	for _, task := range tasks {
+		if quiet.Overlaps(task.NotBefore, task.NotBefore) && !task.NotBefore.Equal(quiet.End) {
+			t.Errorf("task for %s at %s falls inside quiet hours", task.Username, task.NotBefore)
+		}
+		if task.QuietHours != c.QuietHours {
+			t.Errorf("task for %s does not carry the quiet hours", task.Username)
+		}
+		rounds[task.NotBefore.Sub(c.NotBefore).Truncate(30*time.Minute)]++
+	}
+	expected := map[time.Duration]int{0: 20, 90 * time.Minute: 20, 150 * time.Minute: 20}
+	if !reflect.DeepEqual(rounds, expected) {
+		t.Errorf("unexpected rounds: %v", rounds)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	q := &db.QuietHours{
+		Location: "America/New_York",
+		Ranges:   []db.QuietRange{{Start: "23:00", End: "02:00"}, {Start: "12:00", End: "13:00"}},
+	}
+	gap, err := q.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gap != 10*time.Hour {
+		t.Errorf("gap was %s, expected 10h", gap)
+	}
+
+	loc, _ := time.LoadLocation(q.Location)
+	var testcases = []struct {
+		at     time.Time
+		jitter time.Duration
+		want   time.Time
+	}{
+		{time.Date(2020, 9, 1, 23, 30, 0, 0, loc), 0, time.Date(2020, 9, 2, 2, 0, 0, 0, loc)},
+		{time.Date(2020, 9, 2, 1, 0, 0, 0, loc), 0, time.Date(2020, 9, 2, 2, 0, 0, 0, loc)},
+		{time.Date(2020, 9, 2, 11, 30, 0, 0, loc), 0, time.Date(2020, 9, 2, 11, 30, 0, 0, loc)},
+		{time.Date(2020, 9, 2, 11, 30, 0, 0, loc), time.Hour, time.Date(2020, 9, 2, 13, 0, 0, 0, loc)},
+		{time.Date(2020, 9, 2, 22, 30, 0, 0, loc), time.Hour, time.Date(2020, 9, 3, 2, 0, 0, 0, loc)},
+	}
+	for _, test := range testcases {
+		if got := q.Defer(test.at, test.jitter); !got.Equal(test.want) {
+			t.Errorf("Defer(%s, %s) was %s, expected %s", test.at, test.jitter, got, test.want)
+		}
+	}
+
+	for _, r := range [][]db.QuietRange{
+		{{Start: "00:00", End: "00:00"}},
+		{{Start: "1am", End: "05:00"}},
+		{{Start: "00:00", End: "12:00"}, {Start: "12:00", End: "00:00"}},
+	} {
+		if _, err := (&db.QuietHours{Ranges: r}).Validate(); err == nil {
+			t.Errorf("expected error validating %v", r)
+		}
+	}
+}
+
+func TestPlanUserPasswords(t *testing.T) {
+	c := testCampaign(42)
+	c.Users = []string{"alice@example.org", "bob@example.org", "carol@example.org"}
+	c.Passwords = nil
+	c.UserPasswords = db.UserPasswords{
+		"alice@example.org": {"Alice2019!", "Alice2020!", "Alice2021!"},
+		"bob@example.org":   {"Bob2020!"},
+	}
+	c.NotAfter = c.NotBefore.Add(90 * time.Minute)
+
+	tasks, report := plan(c, c.Users, c.NotBefore)
+	if report.Scheduled != 3 || report.Dropped != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	tried := make(map[string][]string)
+	for _, task := range tasks {
+		tried[task.Username] = append(tried[task.Username], task.Password)
+	}
+	expected := map[string][]string{
+		"alice@example.org": {"Alice2019!", "Alice2020!"},
+		"bob@example.org":   {"Bob2020!"},
+	}
+	if !reflect.DeepEqual(tried, expected) {
+		t.Errorf("tried %v, expected %v", tried, expected)
+	}
+}
+
+func TestPlanAttemptLimit(t *testing.T) {
+	c := testCampaign(42)
+	c.Jitter = 0
+	c.AttemptLimit = 15
+	c.NotAfter = c.NotBefore.Add(4 * time.Hour)
+
+	tasks, report := plan(c, c.Users, c.NotBefore)
+	if report.Scheduled+report.Dropped != 60 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// no more than 15 tasks within any hour
+	for i := c.AttemptLimit; i < len(tasks); i++ {
+		if gap := tasks[i].NotBefore.Sub(tasks[i-c.AttemptLimit].NotBefore); gap < AttemptLimitWindow {
+			t.Fatalf("tasks %d and %d are only %s apart", i-c.AttemptLimit, i, gap)
+		}
+	}
+
+	// each user still waits a full interval between guesses
+	last := make(map[string]time.Time)
+	for _, task := range tasks {
+		if prev, ok := last[task.Username]; ok && task.NotBefore.Sub(prev) < c.ScheduleInterval {
+			t.Errorf("%s was guessed %s apart", task.Username, task.NotBefore.Sub(prev))
+		}
+		last[task.Username] = task.NotBefore
+	}
+
+	// a limit above the interval's pace changes nothing
+	c.AttemptLimit = 20
+	limited, _ := plan(c, c.Users, c.NotBefore)
+	c.AttemptLimit = 0
+	unlimited, _ := plan(c, c.Users, c.NotBefore)
+	for i := range unlimited {
+		if !limited[i].NotBefore.Equal(unlimited[i].NotBefore) || limited[i].Username != unlimited[i].Username {
+			t.Fatalf("attempt limit changed task %d", i)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	s := &PubSubScheduler{
+		regions: newRegionSelector(Regions{
+			"europe-west3": {"de", "eu"},
+			"us-central1":  {"us"},
+		}),
+	}
+	c := testCampaign(42)
+	c.TargetGeo = "de"
+
+	preview, err := s.Preview(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks, report := plan(c, c.Users, c.NotBefore)
+	if preview.Report != report || preview.Seed != 42 || len(preview.Attempts) != len(tasks) {
+		t.Fatalf("unexpected preview: %+v", preview.Report)
+	}
+
+	// the preview holds the same attempts as the plan, in chronological order
+	type attempt struct {
+		t                  time.Time
+		username, password string
+	}
+	planned := make(map[attempt]int)
+	for _, task := range tasks {
+		planned[attempt{task.NotBefore, task.Username, task.Password}]++
+	}
+	for i, a := range preview.Attempts {
+		username := c.Users[a.User]
+		planned[attempt{a.Time, username, c.PasswordsFor(username)[a.Password]}]--
+		if i > 0 && a.Time.Before(preview.Attempts[i-1].Time) {
+			t.Errorf("attempt %d at %s is out of order", i, a.Time)
+		}
+		if !reflect.DeepEqual(a.Regions, []string{"europe-west3"}) {
+			t.Errorf("attempt %d has regions %v", i, a.Regions)
+		}
+	}
+	for a, n := range planned {
+		if n != 0 {
+			t.Errorf("preview and plan differ at %s", a.t)
+		}
+	}
+}
+
+func TestDecodeResult(t *testing.T) {
+	// the response a dispatcher publishes for an attempt against a mail
+	// server which does not accept password authentication
+	authErr := fmt.Errorf("error authenticating to smtp provider: %w: smtp server does not advertise AUTH",
+		nozzle.ErrAuthDisabled)
+	b, err := json.Marshal(&event.AuthResponse{
+		CampaignID:    1,
+		Username:      "alice@example.org",
+		ErrorCategory: nozzle.ErrorCategory(authErr),
+		Error:         nozzle.SanitizeError(authErr),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := decodeResult(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != db.ResultStatusError || res.Valid {
+		t.Errorf("auth disabled result was %s (valid %t), expected %s", res.Status, res.Valid, db.ResultStatusError)
+	}
+	if res.ErrorCategory != nozzle.ErrorAuthDisabled {
+		t.Errorf("error category was %q, expected %q", res.ErrorCategory, nozzle.ErrorAuthDisabled)
+	}
+
+	b, err = json.Marshal(&event.AuthResponse{CampaignID: 1, Valid: true, Expired: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = decodeResult(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != db.ResultStatusValidExpired || res.Valid {
+		t.Errorf("expired result was %s (valid %t)", res.Status, res.Valid)
+	}
+}
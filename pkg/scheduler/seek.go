@@ -0,0 +1,110 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// ErrOffsetRange is returned when seeking outside of a campaign's schedule.
+var ErrOffsetRange = errors.New("offset is outside of the campaign's schedule")
+
+// schedule plans the campaign exactly as Schedule does and returns the tasks
+// in chronological order, the order in which cursor offsets count them.
+func schedule(campaign db.Campaign) ([]*db.Task, Report) {
+	tasks, report := plan(campaign, campaign.Users, campaign.NotBefore)
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].NotBefore.Before(tasks[j].NotBefore)
+	})
+	return tasks, report
+}
+
+// seekTasks returns the tasks from the offset onwards. If the first of them
+// is due before now, they are all moved forward by the same amount so it is
+// due now, keeping their spacing. Tasks moved past notAfter are dropped and
+// counted in the report.
+func seekTasks(tasks []*db.Task, offset int, now, notAfter time.Time) ([]*db.Task, Report, error) {
+	var report Report
+	if offset < 0 || offset > len(tasks) {
+		return nil, report, fmt.Errorf("%w: %d is not within 0-%d", ErrOffsetRange, offset, len(tasks))
+	}
+
+	remaining := tasks[offset:]
+	var shift time.Duration
+	if len(remaining) > 0 && remaining[0].NotBefore.Before(now) {
+		shift = now.Sub(remaining[0].NotBefore)
+	}
+
+	kept := make([]*db.Task, 0, len(remaining))
+	for _, task := range remaining {
+		task.NotBefore = task.NotBefore.Add(shift)
+		if task.NotBefore.After(notAfter) {
+			report.Dropped++
+			continue
+		}
+		kept = append(kept, task)
+	}
+	report.Scheduled = len(kept)
+	return kept, report, nil
+}
+
+// Seek moves the campaign's cursor to the provided offset in its schedule.
+// The schedule is planned again, and the campaign's queued tasks are replaced
+// by the attempts from the offset onwards, so seeking forward skips attempts
+// and seeking back repeats them, which are exempt from duplicate suppression.
+// Attempts added to the campaign with Extend are planned with the rest of the
+// users.
+func (s *PubSubScheduler) Seek(campaign db.Campaign, offset int) (Report, error) {
+	tasks, _ := schedule(campaign)
+
+	cursor := db.Cursor{Offset: offset, UpdatedAt: time.Now()}
+	if offset > 0 && offset <= len(tasks) {
+		cursor.Username = tasks[offset-1].Username
+		cursor.Password = tasks[offset-1].Password
+	}
+
+	kept, report, err := seekTasks(tasks, offset, cursor.UpdatedAt, campaign.NotAfter)
+	if err != nil {
+		return report, err
+	}
+
+	err = s.cache.Del(fmt.Sprintf(CacheKeyF, campaign.ID)).Err()
+	if err != nil {
+		return report, fmt.Errorf("error clearing the campaign's queue: %w", err)
+	}
+
+	// seeking back repeats attempts on purpose, so they are not suppressed
+	// as duplicates
+	digests := make([][]byte, 0, len(kept))
+	for _, task := range kept {
+		digests = append(digests, task.Digest())
+	}
+	err = s.db.ReleaseAttempts(campaign.ID, digests)
+	if err != nil {
+		return report, fmt.Errorf("error releasing repeated attempts: %w", err)
+	}
+	s.push(campaign, kept)
+
+	err = s.db.SetCursor(campaign.ID, cursor)
+	if err != nil {
+		return report, fmt.Errorf("error setting cursor: %w", err)
+	}
+	return report, nil
+}
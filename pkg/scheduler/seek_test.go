@@ -0,0 +1,78 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSeekTasks(t *testing.T) {
+	c := testCampaign(42)
+	tasks, _ := schedule(c)
+	for i := 1; i < len(tasks); i++ {
+		if tasks[i].NotBefore.Before(tasks[i-1].NotBefore) {
+			t.Fatalf("schedule is not in chronological order at %d", i)
+		}
+	}
+
+	// seeking forward before the campaign starts keeps the planned times
+	planned := tasks[20].NotBefore
+	kept, report, err := seekTasks(tasks, 20, c.NotBefore.Add(-time.Hour), c.NotAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Scheduled != 40 || report.Dropped != 0 || len(kept) != 40 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if !kept[0].NotBefore.Equal(planned) {
+		t.Errorf("first task was moved from %s to %s", planned, kept[0].NotBefore)
+	}
+
+	// seeking back during the campaign repeats attempts from now on, and
+	// drops the ones moved past the end of the window
+	tasks, _ = schedule(c)
+	now := c.NotAfter.Add(-90 * time.Minute)
+	kept, report, err = seekTasks(tasks, 0, now, c.NotAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kept[0].NotBefore.Equal(now) {
+		t.Errorf("first task is due at %s, expected %s", kept[0].NotBefore, now)
+	}
+	if report.Scheduled+report.Dropped != 60 || report.Dropped == 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	for _, task := range kept {
+		if task.NotBefore.After(c.NotAfter) {
+			t.Errorf("task for %s at %s is after the window", task.Username, task.NotBefore)
+		}
+	}
+
+	// seeking to the end skips every remaining attempt
+	tasks, _ = schedule(c)
+	kept, _, err = seekTasks(tasks, 60, now, c.NotAfter)
+	if err != nil || len(kept) != 0 {
+		t.Errorf("expected no tasks, got %d (%v)", len(kept), err)
+	}
+
+	for _, offset := range []int{-1, 61} {
+		_, _, err = seekTasks(tasks, offset, now, c.NotAfter)
+		if !errors.Is(err, ErrOffsetRange) {
+			t.Errorf("offset %d: expected ErrOffsetRange, got %v", offset, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sync"
+)
+
+const (
+	// wafWindow is the number of recent results of a campaign its challenge
+	// rate is computed over
+	wafWindow = 50
+
+	// wafMinResults is the number of results a campaign needs before its
+	// challenge rate can pause it, so a single early challenge does not
+	wafMinResults = 10
+)
+
+// wafMonitor tracks the rate at which each campaign's recent results were
+// challenged by a WAF or captcha.
+type wafMonitor struct {
+	mu        sync.Mutex
+	campaigns map[uint]*wafResults
+}
+
+// wafResults is a ring of a campaign's most recent results, true for the
+// challenged ones.
+type wafResults struct {
+	challenged [wafWindow]bool
+	next       int
+	n          int
+}
+
+func newWAFMonitor() *wafMonitor {
+	return &wafMonitor{
+		campaigns: make(map[uint]*wafResults),
+	}
+}
+
+// Observe records a result of the campaign and returns the challenge rate over
+// its recent results along with the number of results the rate covers.
+func (m *wafMonitor) Observe(campaignID uint, challenged bool) (float64, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.campaigns[campaignID]
+	if !ok {
+		r = &wafResults{}
+		m.campaigns[campaignID] = r
+	}
+	r.challenged[r.next] = challenged
+	r.next = (r.next + 1) % wafWindow
+	if r.n < wafWindow {
+		r.n++
+	}
+
+	var count int
+	for _, c := range r.challenged[:r.n] {
+		if c {
+			count++
+		}
+	}
+	return float64(count) / float64(r.n), r.n
+}
+
+// Reset forgets the campaign's results, e.g. once it has been paused, so a
+// resumed campaign is judged on new results only.
+func (m *wafMonitor) Reset(campaignID uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.campaigns, campaignID)
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"testing"
+)
+
+func TestWAFMonitor(t *testing.T) {
+	m := newWAFMonitor()
+	for i := 0; i < 9; i++ {
+		m.Observe(1, false)
+	}
+	rate, n := m.Observe(1, true)
+	if rate != 0.1 || n != 10 {
+		t.Errorf("rate was %v over %d results", rate, n)
+	}
+
+	// only the most recent results count
+	for i := 0; i < wafWindow; i++ {
+		rate, n = m.Observe(1, i%2 == 0)
+	}
+	if rate != 0.5 || n != wafWindow {
+		t.Errorf("rate was %v over %d results", rate, n)
+	}
+
+	if rate, n = m.Observe(2, true); rate != 1 || n != 1 {
+		t.Errorf("other campaign rate was %v over %d results", rate, n)
+	}
+
+	m.Reset(1)
+	if rate, n = m.Observe(1, false); rate != 0 || n != 1 {
+		t.Errorf("rate after reset was %v over %d results", rate, n)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// HeartbeatAttribute is the Pub/Sub message attribute carrying the name
+	// of the dispatcher which sent a heartbeat. Heartbeats are published to
+	// the result topic without a body.
+	HeartbeatAttribute = "heartbeat"
+
+	// EgressIPAttribute is the heartbeat attribute carrying the address the
+	// dispatcher's attempts leave from, if it reports one
+	EgressIPAttribute = "egress_ip"
+
+	// InFlightAttribute is the heartbeat attribute carrying the number of
+	// tasks the dispatcher was working on when it sent the heartbeat
+	InFlightAttribute = "in_flight"
+
+	// BackoffsAttribute is the heartbeat attribute carrying the number of
+	// times the dispatcher held a provider's tasks because it asked for a
+	// delay in a Retry-After header
+	BackoffsAttribute = "backoffs"
+
+	// BackoffUntilAttribute is the heartbeat attribute carrying the RFC 3339
+	// time the dispatcher's last backoff in effect ends, if one is
+	BackoffUntilAttribute = "backoff_until"
+
+	// BreakerTripsAttribute is the heartbeat attribute carrying the number
+	// of times one of the dispatcher's circuit breakers opened
+	BreakerTripsAttribute = "breaker_trips"
+
+	// BreakerOpenAttribute is the heartbeat attribute carrying the
+	// comma-separated providers whose breaker is open or half-open, if any
+	BreakerOpenAttribute = "breaker_open"
+)
+
+var (
+	// WorkerTimeout is how long a dispatcher may go without a heartbeat
+	// before it is no longer counted as a live worker
+	WorkerTimeout = 2 * time.Minute
+
+	// WorkerExpiry is how long a dispatcher is listed after its last
+	// heartbeat
+	WorkerExpiry = time.Hour
+)
+
+// Stats describes the orchestrator's task queue and the dispatchers serving
+// it, to tell a backed-up queue apart from a lack of workers.
+type Stats struct {
+	// QueueDepth is the number of tasks scheduled but not yet published
+	QueueDepth int64 `json:"queue_depth"`
+
+	// Queues holds the QueueDepth of each campaign with scheduled tasks
+	Queues map[uint]int64 `json:"queues"`
+
+	// WorkerCount is the number of dispatchers which sent a heartbeat within
+	// WorkerTimeout
+	WorkerCount int `json:"worker_count"`
+
+	// Workers lists the dispatchers seen within WorkerExpiry
+	Workers []WorkerStats `json:"workers"`
+
+	// IngestBacklog is the number of results received but not yet written
+	// to the database, out of at most IngestBuffer
+	IngestBacklog int `json:"ingest_backlog"`
+	IngestBuffer  int `json:"ingest_buffer"`
+}
+
+// WorkerStats describes a single dispatcher, as of its last heartbeat.
+type WorkerStats struct {
+	Name          string    `json:"name"`
+	Region        string    `json:"region,omitempty"`
+	EgressIP      string    `json:"egress_ip,omitempty"`
+	InFlight      int       `json:"in_flight"`
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Live          bool      `json:"live"`
+
+	// Backoffs is the number of times the dispatcher honored a provider's
+	// Retry-After, and BackoffUntil when the last one in effect ends
+	Backoffs     int        `json:"backoffs,omitempty"`
+	BackoffUntil *time.Time `json:"backoff_until,omitempty"`
+
+	// BreakerTrips is the number of times one of the dispatcher's circuit
+	// breakers opened, and BreakersOpen the providers whose breaker is not
+	// closed
+	BreakerTrips int      `json:"breaker_trips,omitempty"`
+	BreakersOpen []string `json:"breakers_open,omitempty"`
+}
+
+// heartbeatStats returns the WorkerStats reported by the attributes of a
+// heartbeat message published at t.
+func heartbeatStats(attrs map[string]string, t time.Time) WorkerStats {
+	// a malformed count is reported as zero rather than dropping the
+	// heartbeat
+	inFlight, _ := strconv.Atoi(attrs[InFlightAttribute])
+	backoffs, _ := strconv.Atoi(attrs[BackoffsAttribute])
+	trips, _ := strconv.Atoi(attrs[BreakerTripsAttribute])
+	stats := WorkerStats{
+		Name:          attrs[HeartbeatAttribute],
+		Region:        attrs[RegionAttribute],
+		EgressIP:      attrs[EgressIPAttribute],
+		InFlight:      inFlight,
+		LastHeartbeat: t,
+		Backoffs:      backoffs,
+		BreakerTrips:  trips,
+	}
+	if open := attrs[BreakerOpenAttribute]; open != "" {
+		stats.BreakersOpen = strings.Split(open, ",")
+	}
+	if until, err := time.Parse(time.RFC3339, attrs[BackoffUntilAttribute]); err == nil {
+		stats.BackoffUntil = &until
+	}
+	return stats
+}
+
+// workerRegistry tracks the dispatchers by their heartbeats.
+type workerRegistry struct {
+	mu      sync.Mutex
+	workers map[string]WorkerStats
+}
+
+// newWorkerRegistry creates an empty workerRegistry.
+func newWorkerRegistry() *workerRegistry {
+	return &workerRegistry{workers: make(map[string]WorkerStats)}
+}
+
+// Heartbeat records a heartbeat sent by a dispatcher.
+func (w *workerRegistry) Heartbeat(worker WorkerStats) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// heartbeats may be delivered out of order
+	if last, ok := w.workers[worker.Name]; ok && last.LastHeartbeat.After(worker.LastHeartbeat) {
+		return
+	}
+	w.workers[worker.Name] = worker
+}
+
+// List returns the dispatchers seen within WorkerExpiry sorted by name, and
+// the number of them which are live. Expired dispatchers are forgotten.
+func (w *workerRegistry) List(now time.Time) ([]WorkerStats, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var live int
+	workers := make([]WorkerStats, 0, len(w.workers))
+	for name, worker := range w.workers {
+		age := now.Sub(worker.LastHeartbeat)
+		if age > WorkerExpiry {
+			delete(w.workers, name)
+			continue
+		}
+		worker.Live = age <= WorkerTimeout
+		if worker.Live {
+			live++
+		}
+		workers = append(workers, worker)
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].Name < workers[j].Name
+	})
+	return workers, live
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWorkerRegistry(t *testing.T) {
+	now := time.Now()
+	w := newWorkerRegistry()
+	w.Heartbeat(heartbeatStats(map[string]string{
+		HeartbeatAttribute:    "dispatcher-b",
+		RegionAttribute:       "europe-west3",
+		EgressIPAttribute:     "203.0.113.10",
+		InFlightAttribute:     "4",
+		BackoffsAttribute:     "2",
+		BackoffUntilAttribute: now.Add(time.Minute).UTC().Format(time.RFC3339),
+		BreakerTripsAttribute: "3",
+		BreakerOpenAttribute:  "okta,o365",
+	}, now.Add(-30*time.Second)))
+	w.Heartbeat(WorkerStats{Name: "dispatcher-a", Region: "us-central1", LastHeartbeat: now.Add(-5 * time.Minute)})
+	w.Heartbeat(WorkerStats{Name: "dispatcher-c", LastHeartbeat: now.Add(-2 * time.Hour)})
+
+	// a delayed heartbeat does not move the last heartbeat back
+	w.Heartbeat(WorkerStats{Name: "dispatcher-b", Region: "europe-west3", LastHeartbeat: now.Add(-time.Minute)})
+
+	workers, live := w.List(now)
+	if live != 1 {
+		t.Errorf("expected 1 live worker, got %d", live)
+	}
+	if len(workers) != 2 {
+		t.Fatalf("expected 2 workers, got %+v", workers)
+	}
+	if workers[0].Name != "dispatcher-a" || workers[0].Live {
+		t.Errorf("unexpected worker: %+v", workers[0])
+	}
+	b := workers[1]
+	if b.Name != "dispatcher-b" || !b.Live || b.Region != "europe-west3" || b.EgressIP != "203.0.113.10" ||
+		b.InFlight != 4 || !b.LastHeartbeat.Equal(now.Add(-30*time.Second)) || b.Backoffs != 2 ||
+		b.BackoffUntil == nil || !b.BackoffUntil.Equal(now.Add(time.Minute).Truncate(time.Second)) ||
+		b.BreakerTrips != 3 || !reflect.DeepEqual(b.BreakersOpen, []string{"okta", "o365"}) {
+		t.Errorf("unexpected worker: %+v", b)
+	}
+}
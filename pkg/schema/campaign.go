@@ -0,0 +1,203 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+// Campaign is the JSON Schema of the POST /campaign request body. Any change
+// to the campaign API must be reflected here and in
+// docs/campaign.schema.json, and incompatible changes must bump the version
+// in the $id.
+const Campaign = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/praetorian-inc/trident/docs/campaign.schema.json#v1",
+  "title": "Campaign",
+  "description": "A request to create a password spraying campaign",
+  "type": "object",
+  "required": ["not_before", "not_after", "schedule_interval", "users", "provider"],
+  "oneOf": [
+    {"required": ["passwords"], "not": {"required": ["user_passwords"]}},
+    {"required": ["user_passwords"], "not": {"required": ["passwords"]}}
+  ],
+  "additionalProperties": false,
+  "properties": {
+    "name": {
+      "description": "a unique name which can be used in place of the campaign ID, generated if omitted",
+      "type": "string",
+      "pattern": "^[a-z][a-z0-9-]*$",
+      "maxLength": 64
+    },
+    "not_before": {
+      "description": "requests will not start before this time",
+      "type": "string",
+      "format": "date-time"
+    },
+    "not_after": {
+      "description": "requests will not be made after this time",
+      "type": "string",
+      "format": "date-time"
+    },
+    "deadline": {
+      "description": "the campaign is stopped at this time even if tasks remain",
+      "type": ["string", "null"],
+      "format": "date-time"
+    },
+    "status": {
+      "description": "the initial status of the campaign",
+      "enum": ["Active", "Paused"]
+    },
+    "schedule_interval": {
+      "description": "nanoseconds between guesses against a single user",
+      "type": "integer",
+      "minimum": 0
+    },
+    "jitter": {
+      "description": "maximum random delay in nanoseconds added to each request",
+      "type": "integer",
+      "minimum": 0
+    },
+    "attempt_limit": {
+      "description": "the maximum number of requests in any hour across all users, 0 for no limit",
+      "type": "integer",
+      "minimum": 0
+    },
+    "max_concurrent": {
+      "description": "the maximum number of requests in flight at once against the provider, shared with every campaign against it, 0 for no limit",
+      "type": "integer",
+      "minimum": 0
+    },
+    "priority": {
+      "description": "the campaign's share of the orchestrator's dispatch turns relative to the other campaigns, 0 for the default of 1",
+      "type": "integer",
+      "minimum": 0,
+      "maximum": 10
+    },
+    "stop_after_valid": {
+      "description": "the campaign is completed once this many credentials are valid, 0 to run every task",
+      "type": "integer",
+      "minimum": 0
+    },
+    "abort_on_waf": {
+      "description": "the campaign is paused once this fraction of its recent results were challenged by a WAF or captcha, 0 to never pause",
+      "type": "number",
+      "minimum": 0,
+      "maximum": 1
+    },
+    "seed": {
+      "description": "seed of the ordering and jitter RNG, 0 for a random seed",
+      "type": "integer"
+    },
+    "hash_usernames": {
+      "description": "whether the usernames are stored hashed with a salt generated by the orchestrator, in the campaign and its results. Tasks queued in Redis still hold the plaintext usernames until they are sent to the workers, which can be the end of the campaign",
+      "type": "boolean"
+    },
+    "users": {
+      "description": "the usernames to guess",
+      "type": "array",
+      "minItems": 1,
+      "items": {"type": "string", "minLength": 1}
+    },
+    "passwords": {
+      "description": "the passwords to guess",
+      "type": "array",
+      "minItems": 1,
+      "items": {"type": "string"}
+    },
+    "user_passwords": {
+      "description": "the passwords to guess for each user, instead of passwords",
+      "type": "object",
+      "minProperties": 1,
+      "additionalProperties": {
+        "type": "array",
+        "minItems": 1,
+        "items": {"type": "string"}
+      }
+    },
+    "provider": {
+      "description": "the name of the nozzle for the authentication provider",
+      "type": "string",
+      "minLength": 1
+    },
+    "provider_metadata": {
+      "description": "the nozzle configuration options",
+      "type": ["object", "null"],
+      "additionalProperties": {"type": ["string", "number", "boolean"]}
+    },
+    "target_geo": {
+      "description": "the geo tag used to prefer nearby worker regions",
+      "type": "string"
+    },
+    "randomize_workers": {
+      "description": "whether attempts are rotated evenly through the worker regions",
+      "type": "boolean"
+    },
+    "worker_regions": {
+      "description": "the worker regions attempts may be sent from, each mapped to its relative share of the attempts",
+      "type": ["object", "null"],
+      "additionalProperties": {"type": "number", "exclusiveMinimum": 0}
+    },
+    "adaptive_regions": {
+      "description": "whether worker regions are weighted by the campaign's recent success and block rate in each",
+      "type": "boolean"
+    },
+    "capture_on_valid": {
+      "description": "whether the provider's response to a valid credential is stored with the result",
+      "type": "boolean"
+    },
+    "retain": {
+      "description": "nanoseconds after the end of the window (or the deadline, if earlier) at which the results are purged, 0 to keep them",
+      "type": "integer",
+      "minimum": 0
+    },
+    "purge_campaign": {
+      "description": "whether the campaign itself is deleted along with its results once retain has passed",
+      "type": "boolean"
+    },
+    "blackouts": {
+      "description": "periods during which no requests may be made",
+      "type": ["array", "null"],
+      "items": {
+        "type": "object",
+        "required": ["start", "end"],
+        "additionalProperties": false,
+        "properties": {
+          "start": {"type": "string", "format": "date-time"},
+          "end": {"type": "string", "format": "date-time"}
+        }
+      }
+    },
+    "quiet_hours": {
+      "description": "recurring daily periods during which no requests may be made",
+      "type": "object",
+      "required": ["ranges"],
+      "additionalProperties": false,
+      "properties": {
+        "location": {"type": "string"},
+        "ranges": {
+          "type": "array",
+          "minItems": 1,
+          "items": {
+            "type": "object",
+            "required": ["start", "end"],
+            "additionalProperties": false,
+            "properties": {
+              "start": {"type": "string", "pattern": "^([01][0-9]|2[0-3]):[0-5][0-9]$"},
+              "end": {"type": "string", "pattern": "^([01][0-9]|2[0-3]):[0-5][0-9]$"}
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
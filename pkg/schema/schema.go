@@ -0,0 +1,70 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schema holds the JSON Schemas of the orchestrator API request
+// bodies. The client validates a request before sending it and the
+// orchestrator validates it again on receipt, so both sides share a single
+// contract. The schemas are published in the docs directory.
+package schema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ValidationError lists every field of a request body which does not conform
+// to the schema.
+type ValidationError struct {
+	Fields []string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("request does not match schema: %s", strings.Join(e.Fields, "; "))
+}
+
+var campaign = mustCompile(Campaign)
+
+// mustCompile compiles a schema and panics if it is invalid.
+func mustCompile(src string) *gojsonschema.Schema {
+	s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(src))
+	if err != nil {
+		panic(fmt.Sprintf("schema: invalid schema: %s", err))
+	}
+	return s
+}
+
+// ValidateCampaign validates a campaign creation request body against the
+// Campaign schema.
+func ValidateCampaign(body []byte) error {
+	return validate(campaign, body)
+}
+
+func validate(s *gojsonschema.Schema, body []byte) error {
+	result, err := s.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	var fields []string
+	for _, e := range result.Errors() {
+		fields = append(fields, fmt.Sprintf("%s: %s", e.Field(), e.Description()))
+	}
+	return &ValidationError{Fields: fields}
+}
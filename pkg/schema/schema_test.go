@@ -0,0 +1,167 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestPublished ensures the published schema matches the one used for
+// validation.
+func TestPublished(t *testing.T) {
+	b, err := ioutil.ReadFile("../../docs/campaign.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != Campaign {
+		t.Errorf("docs/campaign.schema.json is out of date")
+	}
+}
+
+func TestValidateCampaign(t *testing.T) {
+	var testcases = []struct {
+		name  string
+		body  string
+		field string
+	}{
+		{"valid", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"status": "Active",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta",
+			"provider_metadata": {"subdomain": "example", "port": 8443, "starttls": true},
+			"retain": 604800000000000,
+			"purge_campaign": true,
+			"blackouts": [{"start": "2020-08-28T12:00:00Z", "end": "2020-08-28T13:00:00Z"}],
+			"quiet_hours": {"location": "America/New_York", "ranges": [{"start": "01:00", "end": "05:00"}]}
+		}`, ""},
+		{"missing provider", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"]
+		}`, "provider"},
+		{"bad time", `{
+			"not_before": "tomorrow",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta"
+		}`, "not_before"},
+		{"no users", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": [],
+			"passwords": ["Password1"],
+			"provider": "okta"
+		}`, "users"},
+		{"user passwords", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"user_passwords": {"alice@example.org": ["Alice2020!"]},
+			"provider": "okta"
+		}`, ""},
+		{"passwords and user passwords", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"user_passwords": {"alice@example.org": ["Alice2020!"]},
+			"provider": "okta"
+		}`, "(root)"},
+		{"no passwords", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"provider": "okta"
+		}`, "(root)"},
+		{"non-scalar metadata", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "ldap",
+			"provider_metadata": {"starttls": {"enabled": true}}
+		}`, "provider_metadata.starttls"},
+		{"unknown field", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta",
+			"interval": "1h"
+		}`, "interval"},
+		{"negative retain", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta",
+			"retain": -1
+		}`, "retain"},
+		{"bad quiet hours", `{
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta",
+			"quiet_hours": {"ranges": [{"start": "1am", "end": "05:00"}]}
+		}`, "quiet_hours"},
+		{"numeric name", `{
+			"name": "42",
+			"not_before": "2020-08-28T00:00:00Z",
+			"not_after": "2020-08-29T00:00:00Z",
+			"schedule_interval": 3600000000000,
+			"users": ["alice@example.org"],
+			"passwords": ["Password1"],
+			"provider": "okta"
+		}`, "name"},
+	}
+	for _, test := range testcases {
+		err := ValidateCampaign([]byte(test.body))
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err)
+			}
+			continue
+		}
+
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: expected validation error, got %v", test.name, err)
+			continue
+		}
+		if !strings.Contains(verr.Error(), test.field) {
+			t.Errorf("%s: expected error for %s, got %s", test.name, test.field, verr)
+		}
+	}
+}
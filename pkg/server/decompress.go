@@ -0,0 +1,49 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Decompress is a middleware which transparently decompresses request bodies
+// sent with Content-Encoding: gzip, so handlers always read plain JSON. The
+// size limits of the handlers apply to the decompressed body. Requests with
+// any other encoding are refused with 415 so clients can fall back to an
+// uncompressed body.
+func Decompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+		case "", "identity":
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Request body is not valid gzip", http.StatusBadRequest)
+				return
+			}
+			defer zr.Close() // nolint:errcheck
+
+			r.Body = zr
+			r.ContentLength = -1
+			r.Header.Del("Content-Encoding")
+		default:
+			http.Error(w, "Content-Encoding must be gzip or identity", http.StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
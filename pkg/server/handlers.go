@@ -15,15 +15,27 @@
 package server
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
 	"github.com/praetorian-inc/trident/pkg/parse"
 	"github.com/praetorian-inc/trident/pkg/scheduler"
+	"github.com/praetorian-inc/trident/pkg/schema"
 )
 
 // Server carries context for the http handlers to work from. it keeps track of
@@ -31,19 +43,132 @@ import (
 type Server struct {
 	DB  db.Datastore
 	Sch scheduler.Scheduler
+
+	// MaxBodySize bounds the decompressed body of a campaign request in
+	// bytes, DefaultMaxBodySize if 0. A negative size disables the limit.
+	MaxBodySize int64
+}
+
+// DefaultMaxBodySize is the default Server.MaxBodySize, room for campaigns of
+// millions of users and passwords.
+const DefaultMaxBodySize = 256 << 20
+
+// maxBodySize returns the limit on the body of a campaign request, or 0 if it
+// is not limited.
+func (s *Server) maxBodySize() int64 {
+	switch {
+	case s.MaxBodySize == 0:
+		return DefaultMaxBodySize
+	case s.MaxBodySize < 0:
+		return 0
+	}
+	return s.MaxBodySize
+}
+
+// randomSeed returns a non-zero seed for a campaign's scheduling RNG.
+func randomSeed() (int64, error) {
+	for {
+		var b [8]byte
+		_, err := rand.Read(b[:])
+		if err != nil {
+			return 0, err
+		}
+		if seed := int64(binary.LittleEndian.Uint64(b[:]) >> 1); seed != 0 {
+			return seed, nil
+		}
+	}
+}
+
+// randomSalt returns a random salt for a campaign's hashed usernames.
+func randomSalt() (string, error) {
+	var b [16]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// hashUsers returns the event.HashUsername of each of the users.
+func hashUsers(salt string, users []string) []string {
+	hashed := make([]string, len(users))
+	for i, u := range users {
+		hashed[i] = event.HashUsername(salt, u)
+	}
+	return hashed
 }
 
 // HealthzHandler is for k8s health checking, this always returns 200
 func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {}
 
-// CampaignHandler receives data from the user about the desired campaign
-// configuration. it then inserts the associated metadata into the db and
-// schedules the campaign.
-func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
-	log.Info("creating campaign")
-	var c db.Campaign
+// StatsHandler returns the depth of the task queue and the dispatchers
+// serving it via JSON.
+func (s *Server) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.Sch.Stats()
+	if err != nil {
+		log.Printf("error querying scheduler stats: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&stats)
+	if err != nil {
+		log.Printf("error encoding stats: %s", err)
+		return
+	}
+}
+
+// WorkersHandler returns the dispatchers which sent a heartbeat recently via
+// JSON.
+func (s *Server) WorkersHandler(w http.ResponseWriter, r *http.Request) {
+	workers := s.Sch.Workers()
+
+	err := json.NewEncoder(w).Encode(&workers)
+	if err != nil {
+		log.Printf("error encoding workers: %s", err)
+		return
+	}
+}
+
+// stringMetadata converts the number and boolean provider options, which the
+// schema allows, to the strings the nozzles are configured with.
+func stringMetadata(metadata json.RawMessage) (json.RawMessage, error) {
+	if len(metadata) == 0 || string(metadata) == "null" {
+		return metadata, nil
+	}
+
+	var opts map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(metadata))
+	d.UseNumber()
+	err := d.Decode(&opts)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := make(map[string]string, len(opts))
+	for k, v := range opts {
+		switch v := v.(type) {
+		case string:
+			converted[k] = v
+		case json.Number:
+			converted[k] = v.String()
+		case bool:
+			converted[k] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("option %s must be a string, number, or boolean", k)
+		}
+	}
+	return json.Marshal(converted)
+}
 
-	err := parse.DecodeJSONBody(w, r, &c)
+// decodeCampaign validates and decodes a campaign request, assigning a random
+// seed if none was provided. If the request is invalid, an error is written to
+// the client and false is returned.
+func (s *Server) decodeCampaign(w http.ResponseWriter, r *http.Request, c *db.Campaign) bool {
+	err := parse.ValidateJSONBody(w, r, s.maxBodySize(), schema.ValidateCampaign)
+	if err == nil {
+		err = parse.DecodeJSONBody(w, r, c)
+	}
 	if err != nil {
 		var mr *parse.MalformedRequest
 		if errors.As(err, &mr) {
@@ -52,18 +177,253 @@ func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
 			log.Errorf("unknown error decoding json: %s", err)
 			http.Error(w, http.StatusText(500), 500)
 		}
+		return false
+	}
+
+	if c.Deadline != nil && !c.Deadline.After(c.NotBefore) {
+		http.Error(w, "deadline must be after notbefore", http.StatusBadRequest)
+		return false
+	}
+
+	for _, b := range c.Blackouts {
+		if !b.End.After(b.Start) {
+			http.Error(w, "blackout must end after it starts", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if c.RandomizeWorkers && c.AdaptiveRegions {
+		http.Error(w, "randomize_workers cannot be combined with adaptive_regions", http.StatusBadRequest)
+		return false
+	}
+
+	// workers take the provider options as strings
+	c.ProviderMetadata, err = stringMetadata(c.ProviderMetadata)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("provider_metadata: %s", err), http.StatusBadRequest)
+		return false
+	}
+
+	if len(c.WorkerRegions) > 0 {
+		configured := make(map[string]bool)
+		for _, name := range s.Sch.Regions() {
+			configured[name] = true
+		}
+		for name := range c.WorkerRegions {
+			if !configured[name] {
+				http.Error(w, fmt.Sprintf("worker region %q is not configured on the orchestrator", name),
+					http.StatusBadRequest)
+				return false
+			}
+		}
+	}
+
+	if c.QuietHours != nil {
+		gap, err := c.QuietHours.Validate()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+		if c.Jitter >= gap {
+			http.Error(w, "jitter must be shorter than the gaps between quiet hours", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	// record a random seed so the schedule can always be reproduced
+	if c.Seed == 0 {
+		c.Seed, err = randomSeed()
+		if err != nil {
+			log.Errorf("error generating seed: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return false
+		}
+	}
+
+	if !c.HashUsernames {
+		return true
+	}
+	if c.CaptureOnValid {
+		http.Error(w, "hash_usernames cannot be combined with capture_on_valid", http.StatusBadRequest)
+		return false
+	}
+	c.UsernameSalt, err = randomSalt()
+	if err != nil {
+		log.Errorf("error generating username salt: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return false
+	}
+	return true
+}
+
+// nameCampaign generates a name for a campaign created without one, or
+// ensures the provided name is not already in use. It writes the error
+// response and returns false if the campaign cannot be created.
+func (s *Server) nameCampaign(w http.ResponseWriter, c *db.Campaign) bool {
+	var err error
+	if c.Name == "" {
+		c.Name, err = generateName(s.DB)
+		if err != nil {
+			log.Errorf("error generating campaign name: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return false
+		}
+		return true
+	}
+
+	id, err := s.DB.CampaignID(c.Name)
+	if err != nil {
+		log.Errorf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return false
+	}
+	if id != 0 {
+		http.Error(w, fmt.Sprintf("campaign name %q is already used by campaign %d", c.Name, id),
+			http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// maxIdempotencyKey bounds the length of an Idempotency-Key header.
+const maxIdempotencyKey = 255
+
+// bodyDigest reads the request body, up to the same limit as decodeCampaign,
+// and returns its SHA-256 digest. The body is replaced so it can still be
+// decoded.
+func (s *Server) bodyDigest(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := parse.ReadBody(w, r, s.maxBodySize())
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	return sum[:], nil
+}
+
+// replayCampaign writes the campaign created by an earlier request with the
+// same Idempotency-Key in place of creating another.
+func (s *Server) replayCampaign(w http.ResponseWriter, id uint) {
+	log.WithFields(log.Fields{
+		"campaign_id": id,
+	}).Info("returning campaign created with the same idempotency key")
+
+	c, err := s.DB.DescribeCampaign(db.Query{Filter: map[string]interface{}{"id": id}})
+	if err != nil {
+		log.Errorf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 
-	err = s.DB.InsertCampaign(&c)
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	err = json.NewEncoder(w).Encode(&c)
+	if err != nil {
+		log.Errorf("error encoding campaign for return: %s", err)
+	}
+}
+
+// CampaignHandler receives data from the user about the desired campaign
+// configuration. it then inserts the associated metadata into the db and
+// schedules the campaign. A request with an Idempotency-Key header which was
+// already used within the db.IdempotencyKeyTTL returns the campaign created by
+// the first request, so a create which timed out may be retried safely.
+func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
+	log.Info("creating campaign")
+	var c db.Campaign
+
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKey {
+		http.Error(w, fmt.Sprintf("Idempotency-Key must not be longer than %d characters", maxIdempotencyKey),
+			http.StatusBadRequest)
+		return
+	}
+	var digest []byte
+	if key != "" {
+		var err error
+		digest, err = s.bodyDigest(w, r)
+		if err != nil {
+			var mr *parse.MalformedRequest
+			if errors.As(err, &mr) {
+				http.Error(w, mr.Msg, mr.Status)
+			} else {
+				log.Errorf("error reading request body: %s", err)
+				http.Error(w, http.StatusText(500), 500)
+			}
+			return
+		}
+	}
+
+	if !s.decodeCampaign(w, r, &c) {
+		return
+	}
+
+	if key != "" {
+		id, err := s.DB.IdempotentCampaign(key, digest)
+		if errors.Is(err, db.ErrIdempotencyKeyReused) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if err != nil {
+			log.Errorf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		if id != 0 {
+			s.replayCampaign(w, id)
+			return
+		}
+	}
+
+	if !s.nameCampaign(w, &c) {
+		return
+	}
+
+	// a campaign which has not started yet is distinguished from a running one
+	if (c.Status == "" || c.Status == db.CampaignStatusActive) && c.NotBefore.After(time.Now()) {
+		c.Status = db.CampaignStatusScheduled
+	}
+
+	// a campaign which hashes usernames is stored without the plaintext, which
+	// is only used to schedule it. The queued tasks still hold the plaintext
+	// in Redis until they are published
+	scheduled := c
+	if c.HashUsernames {
+		c.Users = hashUsers(c.UsernameSalt, c.Users)
+		if c.UserPasswords != nil {
+			c.UserPasswords = make(db.UserPasswords, len(scheduled.UserPasswords))
+			for u, p := range scheduled.UserPasswords {
+				c.UserPasswords[event.HashUsername(c.UsernameSalt, u)] = p
+			}
+		}
+	}
+
+	var err error
+	if key == "" {
+		err = s.DB.InsertCampaign(&c)
+	} else {
+		var id uint
+		id, err = s.DB.InsertCampaignOnce(&c, key, digest)
+		if err == nil && id != 0 {
+			// a concurrent request with the key created the campaign first
+			s.replayCampaign(w, id)
+			return
+		}
+	}
+	if errors.Is(err, db.ErrIdempotencyKeyReused) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"campaign": c,
 		}).Errorf("error inserting campaign: %s", err)
+		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 
-	go s.Sch.Schedule(c) // nolint:errcheck
+	scheduled.Model = c.Model
+	go s.Sch.Schedule(scheduled) // nolint:errcheck
 
 	w.Header().Add("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(&c)
@@ -75,6 +435,46 @@ func (s *Server) CampaignHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CampaignPreviewHandler receives the same request as CampaignHandler and
+// returns the schedule the campaign would follow via JSON, without creating
+// it. Creating the campaign with the returned seed reproduces the schedule.
+// The optional limit query parameter returns only the first attempts of the
+// schedule; the counts still cover all of it.
+func (s *Server) CampaignPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	var c db.Campaign
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("limit must be a non-negative integer, got %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	if !s.decodeCampaign(w, r, &c) {
+		return
+	}
+
+	preview, err := s.Sch.Preview(c)
+	if err != nil {
+		log.Errorf("error previewing schedule: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if limit > 0 && limit < len(preview.Attempts) {
+		preview.Attempts = preview.Attempts[:limit]
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&preview)
+	if err != nil {
+		log.Errorf("error encoding schedule preview: %s", err)
+		return
+	}
+}
+
 // ResultsHandler takes a user defined database query (returned fields + filter)
 // and applies it, returning the results in JSON
 func (s *Server) ResultsHandler(w http.ResponseWriter, r *http.Request) {
@@ -107,12 +507,46 @@ func (s *Server) ResultsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// CampaignListHandler accepts no parameters and returns the list of active campaigns
-// via JSON
+// CampaignListHandler returns the list of campaigns via JSON. The optional
+// status, since, and until query parameters limit it to the campaigns with
+// that status, and whose window overlaps the range between the RFC3339 times.
 func (s *Server) CampaignListHandler(w http.ResponseWriter, r *http.Request) {
-	var campaigns []db.Campaign
+	var filter db.CampaignFilter
+
+	query := r.URL.Query()
+	if status := query.Get("status"); status != "" {
+		for _, st := range db.CampaignStatuses {
+			if strings.EqualFold(status, string(st)) {
+				filter.Status = st
+			}
+		}
+		if filter.Status == "" {
+			http.Error(w, fmt.Sprintf("unknown campaign status %q", status), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if v := query.Get(param.name); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("%s is not an RFC3339 time: %s", param.name, err), http.StatusBadRequest)
+				return
+			}
+			*param.t = t
+		}
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		http.Error(w, "until must not be before since", http.StatusBadRequest)
+		return
+	}
 
-	campaigns, err := s.DB.ListCampaign()
+	campaigns, err := s.DB.ListCampaign(filter)
 	if err != nil {
 		log.Printf("error querying database: %s", err)
 		http.Error(w, http.StatusText(500), 500)
@@ -127,6 +561,47 @@ func (s *Server) CampaignListHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CampaignResolveHandler returns the ID of the campaign with the provided
+// name via JSON, so clients can refer to campaigns by name.
+func (s *Server) CampaignResolveHandler(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Name string `json:"name"`
+	}
+
+	err := parse.DecodeJSONBody(w, r, &q)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	id, err := s.DB.CampaignID(q.Name)
+	if err != nil {
+		log.Errorf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if id == 0 {
+		http.Error(w, fmt.Sprintf("no campaign is named %q", q.Name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":   id,
+		"name": q.Name,
+	})
+	if err != nil {
+		log.Errorf("error encoding campaign id: %s", err)
+		return
+	}
+}
+
 // CampaignDescribeHandler takes a user-defined DB query with the campaignID, then
 // returns the parameters of that campaign via JSON
 func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request) {
@@ -151,6 +626,22 @@ func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, http.StatusText(500), 500)
 	}
 
+	campaign.Stats, err = s.DB.ResultStats(campaign.ID)
+	if err != nil {
+		log.Printf("error querying result stats: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	if campaign.Stats[db.ResultStatusError] > 0 {
+		campaign.Errors, err = s.DB.ErrorStats(campaign.ID)
+		if err != nil {
+			log.Printf("error querying error stats: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
 	err = json.NewEncoder(w).Encode(&campaign)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -160,6 +651,44 @@ func (s *Server) CampaignDescribeHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// AuditHandler returns the audit log of the provided campaign ID via JSON, or
+// of every campaign if no ID is provided. The audit log is empty unless the
+// orchestrator was started with audit logging enabled.
+func (s *Server) AuditHandler(w http.ResponseWriter, r *http.Request) {
+	type AuditRequest struct {
+		CampaignID uint `json:"campaign_id"`
+	}
+
+	var postBody AuditRequest
+
+	err := parse.DecodeJSONBody(w, r, &postBody)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	entries, err := s.DB.SelectAuditEntries(postBody.CampaignID)
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&entries)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"campaign": postBody.CampaignID,
+		}).Errorf("error encoding audit entries: %s", err)
+		return
+	}
+}
+
 // StatusUpdateHandler takes a campaignID from the user, then
 // sets its status based on the post body content.
 func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
@@ -182,6 +711,21 @@ func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// resuming a campaign which has not started yet makes it scheduled again
+	if postBody.Status == db.CampaignStatusActive {
+		campaign, err := s.DB.DescribeCampaign(db.Query{
+			Filter: map[string]interface{}{"id": postBody.ID},
+		})
+		if err != nil {
+			log.Printf("error querying database: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		if campaign.NotBefore.After(time.Now()) {
+			postBody.Status = db.CampaignStatusScheduled
+		}
+	}
+
 	err = s.DB.UpdateCampaignStatus(postBody.ID, postBody.Status)
 	if err != nil {
 		log.Printf("error updating database: %s", err)
@@ -190,3 +734,248 @@ func (s *Server) StatusUpdateHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("campaign id=%d status has been set to %s", postBody.ID, postBody.Status)
 }
+
+// StatusUpdateAllHandler pauses every active or scheduled campaign, or resumes
+// every paused campaign, based on the post body content. It returns the number
+// of campaigns updated via JSON. This is the emergency stop for an engagement,
+// so the requesting user and the time are logged.
+func (s *Server) StatusUpdateAllHandler(w http.ResponseWriter, r *http.Request) {
+	var postBody struct {
+		Status db.CampaignStatus
+	}
+
+	err := parse.DecodeJSONBody(w, r, &postBody)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	var count int64
+	switch postBody.Status {
+	case db.CampaignStatusPaused:
+		count, err = s.DB.PauseAllCampaigns()
+	case db.CampaignStatusActive:
+		count, err = s.DB.ResumeAllCampaigns()
+	default:
+		http.Error(w, fmt.Sprintf("all campaigns may only be set to %s or %s",
+			db.CampaignStatusPaused, db.CampaignStatusActive), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Errorf("error updating database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"actor":     actor(r),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"count":     count,
+	}).Warnf("status of all campaigns has been set to %s", postBody.Status)
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"count": count,
+	})
+	if err != nil {
+		log.Errorf("error encoding count: %s", err)
+		return
+	}
+}
+
+// actor returns the identity of the user making the request: the email address
+// Cloudflare Access adds to authenticated requests, or the remote address.
+func actor(r *http.Request) string {
+	if email := r.Header.Get("Cf-Access-Authenticated-User-Email"); email != "" {
+		return email
+	}
+	return r.RemoteAddr
+}
+
+// CampaignUsersHandler appends users to a campaign which has not stopped for
+// good, i.e. one that is scheduled, active or paused. Users already present
+// in the campaign are ignored. Every campaign password is scheduled for the
+// new users within the campaign's remaining window and a report of the
+// scheduled (and dropped) attempts is returned via JSON.
+func (s *Server) CampaignUsersHandler(w http.ResponseWriter, r *http.Request) {
+	type CampaignUsersRequest struct {
+		ID    uint
+		Users []string
+	}
+
+	type CampaignUsersResponse struct {
+		scheduler.Report
+		Added      int  `json:"added"`
+		Duplicates int  `json:"duplicates"`
+		FitsWindow bool `json:"fits_window"`
+	}
+
+	var postBody CampaignUsersRequest
+
+	err := parse.DecodeJSONBody(w, r, &postBody)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	campaign, err := s.DB.DescribeCampaign(db.Query{
+		Filter: map[string]interface{}{"id": postBody.ID},
+	})
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	// users can only join a campaign which is running or may run again
+	if campaign.Status.Terminal() {
+		http.Error(w, fmt.Sprintf("campaign is %s", campaign.Status), http.StatusConflict)
+		return
+	}
+	if campaign.UserPasswords != nil {
+		http.Error(w, "campaign has per-user passwords", http.StatusConflict)
+		return
+	}
+
+	existing := make(map[string]bool, len(campaign.Users))
+	for _, u := range campaign.Users {
+		existing[u] = true
+	}
+
+	// the users of a campaign which hashes usernames are compared and stored
+	// by their hash, and scheduled by their plaintext
+	var users, stored []string
+	for _, u := range postBody.Users {
+		key := u
+		if campaign.HashUsernames {
+			key = event.HashUsername(campaign.UsernameSalt, u)
+		}
+		if u == "" || existing[key] {
+			continue
+		}
+		existing[key] = true
+		users = append(users, u)
+		stored = append(stored, key)
+	}
+
+	res := CampaignUsersResponse{
+		Added:      len(users),
+		Duplicates: len(postBody.Users) - len(users),
+	}
+
+	if len(users) > 0 {
+		campaign.Users = append(campaign.Users, stored...)
+		err = s.DB.UpdateCampaign(&campaign)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"campaign": campaign.ID,
+			}).Errorf("error updating campaign: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+
+		res.Report, err = s.Sch.Extend(campaign, users)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"campaign": campaign.ID,
+			}).Errorf("error scheduling new users: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+	res.FitsWindow = res.Report.FitsWindow()
+
+	log.Infof("campaign id=%d added %d users, scheduled %d attempts",
+		campaign.ID, res.Added, res.Scheduled)
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&res)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"results": res,
+		}).Errorf("error encoding results: %s", err)
+		return
+	}
+}
+
+// CampaignSeekHandler moves the cursor of a campaign to the provided offset in
+// its schedule, replacing its queued tasks with the attempts from there on.
+func (s *Server) CampaignSeekHandler(w http.ResponseWriter, r *http.Request) {
+	type CampaignSeekRequest struct {
+		ID     uint
+		Offset int
+	}
+
+	var postBody CampaignSeekRequest
+
+	err := parse.DecodeJSONBody(w, r, &postBody)
+	if err != nil {
+		var mr *parse.MalformedRequest
+		if errors.As(err, &mr) {
+			http.Error(w, mr.Msg, mr.Status)
+		} else {
+			log.Errorf("unknown error decoding json: %s", err)
+			http.Error(w, http.StatusText(500), 500)
+		}
+		return
+	}
+
+	campaign, err := s.DB.DescribeCampaign(db.Query{
+		Filter: map[string]interface{}{"id": postBody.ID},
+	})
+	if err != nil {
+		log.Printf("error querying database: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	if campaign.Status.Terminal() {
+		http.Error(w, fmt.Sprintf("campaign is %s", campaign.Status), http.StatusConflict)
+		return
+	}
+
+	if campaign.HashUsernames {
+		http.Error(w, "campaign stores hashed usernames, so its schedule cannot be planned again",
+			http.StatusConflict)
+		return
+	}
+
+	report, err := s.Sch.Seek(campaign, postBody.Offset)
+	if errors.Is(err, scheduler.ErrOffsetRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.WithFields(log.Fields{
+			"campaign": campaign.ID,
+		}).Errorf("error seeking campaign: %s", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"actor":     actor(r),
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"scheduled": report.Scheduled,
+	}).Warnf("campaign id=%d cursor has been moved to %d", campaign.ID, postBody.Offset)
+
+	w.Header().Add("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&report)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"results": report,
+		}).Errorf("error encoding results: %s", err)
+		return
+	}
+}
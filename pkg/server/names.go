@@ -0,0 +1,77 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/praetorian-inc/trident/pkg/db"
+)
+
+// nameAttempts is the number of random names tried before a numeric suffix
+// is added to make a generated name unique
+const nameAttempts = 10
+
+var adjectives = []string{
+	"amber", "bold", "brave", "brisk", "calm", "clever", "crimson", "dusty",
+	"eager", "fancy", "fierce", "gentle", "golden", "hidden", "hollow", "humble",
+	"icy", "jolly", "keen", "lively", "lucky", "mellow", "misty", "nimble",
+	"noble", "quiet", "rapid", "rusty", "silent", "silver", "steady", "sunny",
+	"swift", "tidy", "velvet", "wild", "witty", "young", "zesty", "zippy",
+}
+
+var nouns = []string{
+	"badger", "beacon", "canyon", "cedar", "comet", "coral", "falcon", "fjord",
+	"forest", "glacier", "harbor", "heron", "island", "jaguar", "lagoon", "lantern",
+	"lynx", "maple", "meadow", "otter", "owl", "panda", "pebble", "pine",
+	"prairie", "raven", "reef", "river", "sparrow", "spruce", "summit", "thunder",
+	"tiger", "tundra", "valley", "walrus", "willow", "wolf", "yak", "zephyr",
+}
+
+// randomName returns a random adjective-noun name such as "brave-otter".
+func randomName() (string, error) {
+	seed, err := randomSeed()
+	if err != nil {
+		return "", err
+	}
+	n := int(seed % int64(len(adjectives)*len(nouns)))
+	return adjectives[n/len(nouns)] + "-" + nouns[n%len(nouns)], nil
+}
+
+// generateName returns a random name which no campaign in the datastore uses.
+func generateName(ds db.Datastore) (string, error) {
+	for i := 0; i < 2*nameAttempts; i++ {
+		name, err := randomName()
+		if err != nil {
+			return "", err
+		}
+		if i >= nameAttempts {
+			seed, err := randomSeed()
+			if err != nil {
+				return "", err
+			}
+			name = fmt.Sprintf("%s-%d", name, seed%1000)
+		}
+
+		id, err := ds.CampaignID(name)
+		if err != nil {
+			return "", err
+		}
+		if id == 0 {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to generate an unused campaign name")
+}
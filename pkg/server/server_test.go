@@ -16,13 +16,19 @@ package server
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/praetorian-inc/trident/pkg/db"
+	"github.com/praetorian-inc/trident/pkg/event"
+	"github.com/praetorian-inc/trident/pkg/scheduler"
 )
 
 type mockDB struct{}
@@ -36,6 +42,20 @@ func (m *mockDB) InsertCampaign(c *db.Campaign) error {
 	return nil
 }
 
+func (m *mockDB) IdempotentCampaign(key string, digest []byte) (uint, error) {
+	switch key {
+	case "replayed-key":
+		return 7, nil
+	case "reused-key":
+		return 0, db.ErrIdempotencyKeyReused
+	}
+	return 0, nil
+}
+
+func (m *mockDB) InsertCampaignOnce(c *db.Campaign, key string, digest []byte) (uint, error) {
+	return 0, nil
+}
+
 func (m *mockDB) UpdateCampaign(c *db.Campaign) error {
 	return nil
 }
@@ -44,6 +64,14 @@ func (m *mockDB) UpdateCampaignStatus(campaignID uint, status db.CampaignStatus)
 	return nil
 }
 
+func (m *mockDB) PauseAllCampaigns() (int64, error) {
+	return 3, nil
+}
+
+func (m *mockDB) ResumeAllCampaigns() (int64, error) {
+	return 2, nil
+}
+
 func (m *mockDB) SelectResults(q db.Query) ([]db.Result, error) {
 	var results []db.Result
 
@@ -85,7 +113,10 @@ func (m *mockDB) InsertResult(r *db.Result) error {
 	return nil
 }
 
-func (m *mockDB) ListCampaign() ([]db.Campaign, error) {
+func (m *mockDB) ListCampaign(filter db.CampaignFilter) ([]db.Campaign, error) {
+	if filter.Status == db.CampaignStatusCancelled {
+		return nil, nil
+	}
 	return []db.Campaign{
 		{Provider: "okta", ProviderMetadata: json.RawMessage(`{"subdomain": "example"}`)},
 		{Provider: "adfs", ProviderMetadata: json.RawMessage(`{"domain": "adfs.example.com"}`)},
@@ -93,13 +124,57 @@ func (m *mockDB) ListCampaign() ([]db.Campaign, error) {
 }
 
 func (m *mockDB) DescribeCampaign(query db.Query) (db.Campaign, error) {
+	// campaign 19 has completed, 20 has not started and 21 predates the
+	// Status column, every other campaign is active
+	status := db.CampaignStatus(db.CampaignStatusActive)
+	switch id, _ := query.Filter["id"].(uint); id {
+	case 19:
+		status = db.CampaignStatusCompleted
+	case 20:
+		status = db.CampaignStatusScheduled
+	case 21:
+		status = ""
+	}
 	return db.Campaign{
+		Status:           status,
+		Users:            []string{"alice@example.org"},
+		Passwords:        []string{"Password0", "Password1"},
 		Provider:         "okta",
 		ProviderMetadata: json.RawMessage(`{"subdomain":"example"}`),
 	}, nil
 
 }
 
+func (m *mockDB) ResultStats(campaignID uint) (map[db.ResultStatus]int, error) {
+	return map[db.ResultStatus]int{
+		db.ResultStatusValid:        1,
+		db.ResultStatusValidExpired: 1,
+		db.ResultStatusInvalid:      2,
+		db.ResultStatusError:        3,
+	}, nil
+}
+
+func (m *mockDB) ErrorStats(campaignID uint) (map[string]int, error) {
+	return map[string]int{
+		"dns":     1,
+		"timeout": 2,
+	}, nil
+}
+
+func (m *mockDB) CampaignID(name string) (uint, error) {
+	if name == "brave-otter" {
+		return 7, nil
+	}
+	return 0, nil
+}
+
+func (m *mockDB) SelectAuditEntries(campaignID uint) ([]db.AuditEntry, error) {
+	return []db.AuditEntry{
+		{CampaignID: campaignID, Username: "alice@example.org", Provider: "okta", Result: db.ResultStatusInvalid},
+		{CampaignID: campaignID, Username: "alice@example.org", Provider: "okta", Result: db.ResultStatusValid},
+	}, nil
+}
+
 func (m *mockDB) Close() error {
 	return nil
 }
@@ -110,10 +185,55 @@ func (m *mockScheduler) Schedule(c db.Campaign) error {
 	return nil
 }
 
+func (m *mockScheduler) Extend(c db.Campaign, users []string) (scheduler.Report, error) {
+	return scheduler.Report{Scheduled: len(users) * len(c.Passwords)}, nil
+}
+
+func (m *mockScheduler) Preview(c db.Campaign) (scheduler.Preview, error) {
+	preview := scheduler.Preview{Seed: c.Seed}
+	for i := 0; i < len(c.Users)*len(c.Passwords); i++ {
+		preview.Attempts = append(preview.Attempts, scheduler.PlannedAttempt{
+			Time:     c.NotBefore.Add(time.Duration(i/len(c.Users)) * c.ScheduleInterval),
+			User:     i % len(c.Users),
+			Password: i / len(c.Users),
+		})
+	}
+	preview.Scheduled = len(preview.Attempts)
+	return preview, nil
+}
+
+func (m *mockScheduler) Seek(c db.Campaign, offset int) (scheduler.Report, error) {
+	attempts := len(c.Users) * len(c.Passwords)
+	if offset > attempts {
+		return scheduler.Report{}, fmt.Errorf("%w: %d", scheduler.ErrOffsetRange, offset)
+	}
+	return scheduler.Report{Scheduled: attempts - offset}, nil
+}
+
+func (m *mockScheduler) Stats() (scheduler.Stats, error) {
+	return scheduler.Stats{
+		QueueDepth:  42,
+		Queues:      map[uint]int64{1: 40, 2: 2},
+		WorkerCount: 1,
+		Workers:     m.Workers(),
+	}, nil
+}
+
+func (m *mockScheduler) Workers() []scheduler.WorkerStats {
+	return []scheduler.WorkerStats{
+		{Name: "dispatcher-1", Region: "us-central1", EgressIP: "203.0.113.10", InFlight: 3,
+			LastHeartbeat: time.Now(), Live: true},
+	}
+}
+
+func (m *mockScheduler) Regions() []string {
+	return []string{"europe-west3", "us-central1"}
+}
+
 func (m *mockScheduler) ProduceTasks() {
 }
 
-func (m *mockScheduler) ConsumeResults() error {
+func (m *mockScheduler) ConsumeResults(ctx context.Context) error {
 	return nil
 }
 
@@ -144,6 +264,63 @@ func TestHealthzHandler(t *testing.T) {
 	}
 }
 
+func TestStatsHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("GET", "/stats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.StatsHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var stats scheduler.Stats
+	err = json.NewDecoder(rr.Body).Decode(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.QueueDepth != 42 || stats.Queues[1] != 40 || stats.WorkerCount != 1 ||
+		len(stats.Workers) != 1 || stats.Workers[0].Name != "dispatcher-1" {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestWorkersHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("GET", "/workers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.WorkersHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var workers []scheduler.WorkerStats
+	err = json.NewDecoder(rr.Body).Decode(&workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 1 || workers[0].EgressIP != "203.0.113.10" || workers[0].InFlight != 3 {
+		t.Errorf("unexpected workers: %+v", workers)
+	}
+}
+
 func TestCancelHandler(t *testing.T) {
 	s := initServer()
 
@@ -187,6 +364,8 @@ func TestCampaignHandler(t *testing.T) {
 		"provider":          "okta",
 		"provider_metadata": map[string]interface{}{
 			"subdomain": "dev-634850",
+			"port":      8443,
+			"starttls":  true,
 		},
 	})
 
@@ -209,6 +388,561 @@ func TestCampaignHandler(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
+
+	var c db.Campaign
+	err = json.NewDecoder(rr.Body).Decode(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(c.Name, "-") {
+		t.Errorf("expected a generated adjective-noun name, got %q", c.Name)
+	}
+	if got := string(c.ProviderMetadata); got != `{"port":"8443","starttls":"true","subdomain":"dev-634850"}` {
+		t.Errorf("provider options were not converted to strings: %s", got)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	s := initServer()
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"schedule_interval": 500000000,
+		"users":             []string{"alice@example.org"},
+		"passwords":         []string{"Password0"},
+		"provider":          "okta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(requestBody); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var testcases = []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+	}{
+		{"gzip", "gzip", compressed.Bytes(), http.StatusOK},
+		{"identity", "", requestBody, http.StatusOK},
+		{"invalid gzip", "gzip", requestBody, http.StatusBadRequest},
+		{"unsupported", "br", compressed.Bytes(), http.StatusUnsupportedMediaType},
+	}
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.encoding != "" {
+			req.Header.Set("Content-Encoding", test.encoding)
+		}
+
+		rr := httptest.NewRecorder()
+		Decompress(http.HandlerFunc(s.CampaignHandler)).ServeHTTP(rr, req)
+		if rr.Code != test.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v (%s)",
+				test.name, rr.Code, test.status, rr.Body)
+		}
+	}
+
+	// the limit applies to the decompressed body
+	s.MaxBodySize = int64(len(requestBody)) - 1
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	Decompress(http.HandlerFunc(s.CampaignHandler)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code for a large body: got %v want %v",
+			rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestCampaignHandlerScheduled(t *testing.T) {
+	s := initServer()
+
+	notBefore := time.Now().Add(time.Hour)
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        notBefore,
+		"not_after":         notBefore.Add(24 * time.Hour),
+		"status":            db.CampaignStatusActive,
+		"schedule_interval": int64(time.Hour),
+		"users":             []string{"alice@example.org"},
+		"passwords":         []string{"Password0"},
+		"provider":          "okta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusOK)
+	}
+
+	var c db.Campaign
+	err = json.NewDecoder(rr.Body).Decode(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Status != db.CampaignStatusScheduled {
+		t.Errorf("expected status %s, got %s", db.CampaignStatusScheduled, c.Status)
+	}
+}
+
+func TestCampaignPreviewHandler(t *testing.T) {
+	s := initServer()
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"schedule_interval": int64(time.Hour),
+		"users":             []string{"alice@example.org", "bob@example.org"},
+		"passwords":         []string{"Password0", "Password1"},
+		"provider":          "okta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/campaign/preview", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.CampaignPreviewHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if strings.Contains(rr.Body.String(), "Password") || strings.Contains(rr.Body.String(), "alice") {
+		t.Errorf("preview leaks credentials: %s", rr.Body.String())
+	}
+
+	var preview scheduler.Preview
+	err = json.Unmarshal(rr.Body.Bytes(), &preview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Seed == 0 {
+		t.Errorf("preview was not assigned a seed")
+	}
+	if len(preview.Attempts) != 4 {
+		t.Errorf("expected 4 planned attempts, got %d", len(preview.Attempts))
+	}
+
+	for _, tc := range []struct {
+		limit    string
+		status   int
+		attempts int
+	}{
+		{"2", http.StatusOK, 2},
+		{"10", http.StatusOK, 4},
+		{"-1", http.StatusBadRequest, 0},
+		{"all", http.StatusBadRequest, 0},
+	} {
+		req, err = http.NewRequest("POST", "/campaign/preview?limit="+tc.limit, bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.status {
+			t.Errorf("limit %s: expected status %d, got %d", tc.limit, tc.status, rr.Code)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+
+		var limited scheduler.Preview
+		err = json.Unmarshal(rr.Body.Bytes(), &limited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(limited.Attempts) != tc.attempts || limited.Scheduled != 4 {
+			t.Errorf("limit %s: expected %d of 4 attempts, got %d of %d",
+				tc.limit, tc.attempts, len(limited.Attempts), limited.Scheduled)
+		}
+	}
+}
+
+func TestCampaignHandlerDeadline(t *testing.T) {
+	s := initServer()
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"deadline":          "2020-08-27T00:00:00Z",
+		"schedule_interval": 500000000,
+		"users":             []string{"alice@example.org"},
+		"passwords":         []string{"Password0"},
+		"provider":          "okta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.CampaignHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+}
+
+func TestCampaignHandlerName(t *testing.T) {
+	s := initServer()
+
+	for name, want := range map[string]int{
+		"brave-otter": http.StatusConflict,
+		"q3-external": http.StatusOK,
+	} {
+		requestBody, err := json.Marshal(map[string]interface{}{
+			"name":              name,
+			"not_before":        "2020-08-28T00:00:00Z",
+			"not_after":         "2020-08-29T00:00:00Z",
+			"schedule_interval": 500000000,
+			"users":             []string{"alice@example.org"},
+			"passwords":         []string{"Password0"},
+			"provider":          "okta",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.CampaignHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				name, status, want)
+		}
+	}
+}
+
+func TestCampaignHandlerHashUsernames(t *testing.T) {
+	s := initServer()
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"schedule_interval": 500000000,
+		"users":             []string{"alice@example.org", "bob@example.org"},
+		"passwords":         []string{"Password0"},
+		"provider":          "okta",
+		"hash_usernames":    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.CampaignHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var c db.Campaign
+	err = json.Unmarshal(rr.Body.Bytes(), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.UsernameSalt == "" {
+		t.Fatal("campaign has no username salt")
+	}
+	if strings.Contains(rr.Body.String(), "alice@example.org") {
+		t.Error("stored campaign holds a plaintext username")
+	}
+	for i, u := range []string{"alice@example.org", "bob@example.org"} {
+		if len(c.Users) != 2 || c.Users[i] != event.HashUsername(c.UsernameSalt, u) {
+			t.Errorf("user %d is stored as %v", i, c.Users)
+		}
+	}
+}
+
+func TestCampaignListHandlerFilter(t *testing.T) {
+	s := initServer()
+
+	var testcases = []struct {
+		query     string
+		status    int
+		campaigns int
+	}{
+		{"", http.StatusOK, 2},
+		{"?status=active&since=2020-08-28T00:00:00Z&until=2020-08-29T00:00:00Z", http.StatusOK, 2},
+		{"?status=Cancelled", http.StatusOK, 0},
+		{"?status=finished", http.StatusBadRequest, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+		{"?since=2020-08-29T00:00:00Z&until=2020-08-28T00:00:00Z", http.StatusBadRequest, 0},
+	}
+	for _, test := range testcases {
+		req, err := http.NewRequest("GET", "/list"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.CampaignListHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.query, status, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var campaigns []db.Campaign
+		err = json.Unmarshal(rr.Body.Bytes(), &campaigns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(campaigns) != test.campaigns {
+			t.Errorf("%s: expected %d campaigns, got %d", test.query, test.campaigns, len(campaigns))
+		}
+	}
+}
+
+func TestCampaignHandlerWorkerRegions(t *testing.T) {
+	s := initServer()
+
+	for region, want := range map[string]int{
+		"europe-west3": http.StatusOK,
+		"asia-east1":   http.StatusBadRequest,
+	} {
+		requestBody, err := json.Marshal(map[string]interface{}{
+			"not_before":        "2020-08-28T00:00:00Z",
+			"not_after":         "2020-08-29T00:00:00Z",
+			"schedule_interval": 500000000,
+			"users":             []string{"alice@example.org"},
+			"passwords":         []string{"Password0"},
+			"provider":          "okta",
+			"worker_regions":    map[string]float64{region: 2},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.CampaignHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				region, status, want)
+		}
+	}
+}
+
+func TestCampaignHandlerIdempotencyKey(t *testing.T) {
+	s := initServer()
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"name":              "brave-otter",
+		"not_before":        "2020-08-28T00:00:00Z",
+		"not_after":         "2020-08-29T00:00:00Z",
+		"schedule_interval": 500000000,
+		"users":             []string{"alice@example.org"},
+		"passwords":         []string{"Password0", "Password1"},
+		"provider":          "okta",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testcases = []struct {
+		key      string
+		status   int
+		replayed bool
+	}{
+		// the replayed campaign already holds the name, it is not a conflict
+		{"replayed-key", http.StatusOK, true},
+		{"reused-key", http.StatusUnprocessableEntity, false},
+		{"new-key", http.StatusConflict, false},
+		{strings.Repeat("k", 256), http.StatusBadRequest, false},
+	}
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Idempotency-Key", test.key)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%.16s: handler returned wrong status code: got %v want %v: %s",
+				test.key, rr.Code, test.status, rr.Body)
+			continue
+		}
+		if replayed := rr.Header().Get("Idempotent-Replayed") == "true"; replayed != test.replayed {
+			t.Errorf("%.16s: replayed was %t", test.key, replayed)
+		}
+		if test.replayed {
+			var c db.Campaign
+			if err = json.NewDecoder(rr.Body).Decode(&c); err != nil {
+				t.Fatal(err)
+			}
+			if len(c.Users) != 1 || c.Users[0] != "alice@example.org" {
+				t.Errorf("expected the existing campaign, got %+v", c)
+			}
+		}
+	}
+
+	// the digest of a keyed request obeys the same limit as the body itself
+	s.MaxBodySize = int64(len(requestBody)) - 1
+	req, err := http.NewRequest("POST", "/campaign", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", "oversized-key")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.CampaignHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: handler returned wrong status code: got %v want %v",
+			rr.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestCampaignResolveHandler(t *testing.T) {
+	s := initServer()
+
+	for name, want := range map[string]int{
+		"brave-otter": http.StatusOK,
+		"calm-heron":  http.StatusNotFound,
+	} {
+		req, err := http.NewRequest("POST", "/campaign/resolve",
+			strings.NewReader(fmt.Sprintf(`{"name": %q}`, name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.CampaignResolveHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				name, status, want)
+			continue
+		}
+		if want != http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			ID uint `json:"id"`
+		}
+		err = json.NewDecoder(rr.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.ID != 7 {
+			t.Errorf("%s: resolved to %d, expected 7", name, resp.ID)
+		}
+	}
+}
+
+func TestStatusUpdateAllHandler(t *testing.T) {
+	s := initServer()
+
+	var testcases = []struct {
+		status string
+		code   int
+		count  int64
+	}{
+		{"Paused", http.StatusOK, 3},
+		{"Active", http.StatusOK, 2},
+		{"Cancelled", http.StatusBadRequest, 0},
+	}
+	for _, test := range testcases {
+		req, err := http.NewRequest("POST", "/campaign/status/all",
+			strings.NewReader(fmt.Sprintf(`{"Status": %q}`, test.status)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(s.StatusUpdateAllHandler).ServeHTTP(rr, req)
+
+		if rr.Code != test.code {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v",
+				test.status, rr.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			Count int64 `json:"count"`
+		}
+		err = json.NewDecoder(rr.Body).Decode(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Count != test.count {
+			t.Errorf("%s: count was %d, expected %d", test.status, resp.Count, test.count)
+		}
+	}
 }
 
 func TestResultsHandler(t *testing.T) {
@@ -239,3 +973,220 @@ func TestResultsHandler(t *testing.T) {
 			status, http.StatusOK)
 	}
 }
+
+func TestAuditHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("POST", "/audit", strings.NewReader(`{"campaign_id": 3}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.AuditHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var entries []map[string]interface{}
+	err = json.Unmarshal(rr.Body.Bytes(), &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0]["campaign_id"] != float64(3) {
+		t.Errorf("unexpected audit entries: %v", entries)
+	}
+	for _, e := range entries {
+		if _, ok := e["password"]; ok {
+			t.Errorf("audit entry contains a password: %v", e)
+		}
+	}
+}
+
+func TestCampaignDescribeHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("POST", "/campaign/describe", strings.NewReader(`{"Filter": {"id": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.CampaignDescribeHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var campaign db.Campaign
+	err = json.Unmarshal(rr.Body.Bytes(), &campaign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if campaign.Stats[db.ResultStatusError] != 3 {
+		t.Errorf("unexpected stats: %v", campaign.Stats)
+	}
+	if campaign.Errors["dns"] != 1 || campaign.Errors["timeout"] != 2 {
+		t.Errorf("unexpected error breakdown: %v", campaign.Errors)
+	}
+}
+
+func TestCampaignUsersHandler(t *testing.T) {
+	s := initServer()
+
+	// a completed campaign cannot take new users
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"ID":    19,
+		"Users": []string{"bob@example.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "/campaign/users", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(s.CampaignUsersHandler).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code for a completed campaign: got %v want %v",
+			status, http.StatusConflict)
+	}
+
+	requestBody, err = json.Marshal(map[string]interface{}{
+		"ID":    18,
+		"Users": []string{"alice@example.org", "bob@example.org", "bob@example.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("POST", "/campaign/users", bytes.NewBuffer(requestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler := http.HandlerFunc(s.CampaignUsersHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	// Check the status code is what we expect.
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var res map[string]interface{}
+	err = json.NewDecoder(rr.Body).Decode(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alice is already in the campaign and bob is listed twice
+	if res["added"] != 1.0 || res["duplicates"] != 2.0 || res["scheduled"] != 2.0 {
+		t.Errorf("handler returned unexpected report: %v", res)
+	}
+
+	// a scheduled campaign and one without a status will still run
+	for _, id := range []uint{20, 21} {
+		requestBody, err = json.Marshal(map[string]interface{}{
+			"ID":    id,
+			"Users": []string{"bob@example.org"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err = http.NewRequest("POST", "/campaign/users", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("handler returned wrong status code for campaign %d: got %v want %v",
+				id, status, http.StatusOK)
+		}
+	}
+}
+
+func TestCampaignSeekHandler(t *testing.T) {
+	s := initServer()
+
+	var testcases = []struct {
+		offset    int
+		status    int
+		scheduled int
+	}{
+		{1, http.StatusOK, 1},
+		{0, http.StatusOK, 2},
+		{3, http.StatusBadRequest, 0},
+	}
+	for _, test := range testcases {
+		requestBody, err := json.Marshal(map[string]interface{}{
+			"ID":     18,
+			"Offset": test.offset,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", "/campaign/seek", bytes.NewBuffer(requestBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(s.CampaignSeekHandler)
+
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("offset %d: handler returned wrong status code: got %v want %v",
+				test.offset, status, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var report scheduler.Report
+		err = json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Scheduled != test.scheduled {
+			t.Errorf("offset %d: scheduled %d attempts, expected %d",
+				test.offset, report.Scheduled, test.scheduled)
+		}
+	}
+}
+
+func TestWebUIHandler(t *testing.T) {
+	s := initServer()
+
+	req, err := http.NewRequest("GET", "/ui", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(s.WebUIHandler)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("handler returned wrong content type: %s", ct)
+	}
+}
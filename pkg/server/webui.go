@@ -0,0 +1,123 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// WebUIHandler serves the read-only dashboard. The page is a single static
+// asset which renders the /list, /describe, and /results JSON APIs, so it is
+// protected by the same authentication as the rest of the API.
+func (s *Server) WebUIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; "+
+		"script-src 'unsafe-inline'; style-src 'unsafe-inline'; connect-src 'self'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	_, err := w.Write([]byte(webUI))
+	if err != nil {
+		log.Errorf("error writing web ui: %s", err)
+	}
+}
+
+// webUI renders every value with textContent since results contain usernames
+// and passwords supplied by the operator.
+const webUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>trident</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+th { background: #eee; }
+progress { width: 10em; }
+</style>
+</head>
+<body>
+<h1>trident</h1>
+<h2>Campaigns</h2>
+<table id="campaigns">
+<tr><th>ID</th><th>Provider</th><th>Status</th><th>Progress</th><th>Valid</th><th>Created</th></tr>
+</table>
+<h2>Valid credentials</h2>
+<table id="results">
+<tr><th>Campaign</th><th>Username</th><th>Password</th><th>Status</th><th>Time</th></tr>
+</table>
+<script>
+"use strict";
+
+function row(table, values) {
+  var tr = document.createElement("tr");
+  values.forEach(function (v) {
+    var td = document.createElement("td");
+    if (v instanceof Node) {
+      td.appendChild(v);
+    } else {
+      td.textContent = v;
+    }
+    tr.appendChild(td);
+  });
+  document.getElementById(table).appendChild(tr);
+}
+
+function post(path, body) {
+  return fetch(path, {
+    method: "POST",
+    credentials: "same-origin",
+    headers: {"Content-Type": "application/json"},
+    body: JSON.stringify(body)
+  }).then(function (r) { return r.json(); });
+}
+
+function progress(c) {
+  var stats = c.stats || {};
+  var done = Object.keys(stats).reduce(function (n, k) { return n + stats[k]; }, 0);
+  var total = (c.users || []).length * (c.passwords || []).length;
+  var bar = document.createElement("progress");
+  bar.max = total || 1;
+  bar.value = done;
+  bar.title = done + " / " + total;
+  return bar;
+}
+
+fetch("/list", {credentials: "same-origin"})
+  .then(function (r) { return r.json(); })
+  .then(function (campaigns) {
+    campaigns.forEach(function (c) {
+      post("/describe", {Filter: {id: c.id}}).then(function (d) {
+        var stats = d.stats || {};
+        var valid = (stats.valid || 0) + " (+" + (stats.valid_expired || 0) + " expired)";
+        row("campaigns", [c.id, c.provider, c.status || "Active", progress(d), valid, c.created_at]);
+      });
+    });
+  });
+
+post("/results", {
+  ReturnedFields: ["campaign_id", "username", "password", "status", "timestamp"],
+  Filter: {valid: true}
+}).then(function (results) {
+  results.forEach(function (r) {
+    row("results", [r.campaign_id, r.username, r.password, r.status, r.timestamp]);
+  });
+});
+</script>
+</body>
+</html>
+`
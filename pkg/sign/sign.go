@@ -0,0 +1,148 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sign creates and checks detached ed25519 signatures of exported
+// results, so the recipient of an export can verify it was produced by the
+// holder of the signing key and has not been altered since. It also signs the
+// orchestrator's webhooks with a shared secret, and VerifyWebhook lets a
+// receiver written in Go check them.
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrInvalidSignature is returned by Verify when the signature, or the export
+// it covers, has been altered or was signed with another key.
+var ErrInvalidSignature = errors.New("signature is invalid")
+
+// Payload is the signed statement about an export.
+type Payload struct {
+	// CampaignIDs are the campaigns the export holds results of, empty if
+	// it was not limited to particular campaigns
+	CampaignIDs []uint `json:"campaign_ids"`
+
+	// SignedAt is the time the export was signed
+	SignedAt time.Time `json:"signed_at"`
+
+	// SHA256 is the hex encoded SHA-256 digest of the export
+	SHA256 string `json:"sha256"`
+}
+
+// Signature is the detached signature file written next to an export. The
+// signature covers the exact bytes of Payload.
+type Signature struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature []byte          `json:"signature"`
+}
+
+// Sign returns the detached signature of export, stating the campaigns it
+// holds and the signing time.
+func Sign(key ed25519.PrivateKey, export []byte, campaignIDs []uint, now time.Time) ([]byte, error) {
+	ids := append([]uint{}, campaignIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	digest := sha256.Sum256(export)
+	payload, err := json.Marshal(Payload{
+		CampaignIDs: ids,
+		SignedAt:    now.UTC(),
+		SHA256:      hex.EncodeToString(digest[:]),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(Signature{
+		Payload:   payload,
+		Signature: ed25519.Sign(key, payload),
+	}, "", "  ")
+}
+
+// Verify checks the detached signature of export and returns the signed
+// payload.
+func Verify(key ed25519.PublicKey, export, signature []byte) (*Payload, error) {
+	var sig Signature
+	err := json.Unmarshal(signature, &sig)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signature: %w", err)
+	}
+
+	// compact the payload, which is indented along with the signature file
+	var payload bytes.Buffer
+	err = json.Compact(&payload, sig.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signature payload: %w", err)
+	}
+	if !ed25519.Verify(key, payload.Bytes(), sig.Signature) {
+		return nil, ErrInvalidSignature
+	}
+
+	var p Payload
+	err = json.Unmarshal(payload.Bytes(), &p)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signature payload: %w", err)
+	}
+
+	digest := sha256.Sum256(export)
+	if p.SHA256 != hex.EncodeToString(digest[:]) {
+		return nil, ErrInvalidSignature
+	}
+	return &p, nil
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS #8 ed25519 private key, such as
+// one generated by `openssl genpkey -algorithm ed25519`.
+func ParsePrivateKey(b []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is a %T, not ed25519", key)
+	}
+	return priv, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX ed25519 public key, such as one
+// generated by `openssl pkey -pubout`.
+func ParsePublicKey(b []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is a %T, not ed25519", key)
+	}
+	return pub, nil
+}
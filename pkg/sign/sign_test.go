@@ -0,0 +1,107 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export := []byte("id,username,valid\n1,alice@example.org,true\n")
+	now := time.Date(2020, 8, 28, 12, 0, 0, 0, time.UTC)
+	sig, err := Sign(priv, export, []uint{7, 3}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Verify(pub, export, sig)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(p.CampaignIDs) != 2 || p.CampaignIDs[0] != 3 || p.CampaignIDs[1] != 7 {
+		t.Errorf("unexpected campaign ids: %v", p.CampaignIDs)
+	}
+	if !p.SignedAt.Equal(now) {
+		t.Errorf("signed at %s, expected %s", p.SignedAt, now)
+	}
+
+	var testcases = []struct {
+		name   string
+		key    ed25519.PublicKey
+		export []byte
+		sig    []byte
+	}{
+		{"tampered export", pub, bytes.Replace(export, []byte("true"), []byte("fals"), 1), sig},
+		{"tampered payload", pub, export, bytes.Replace(sig, []byte("7"), []byte("8"), 1)},
+		{"other key", otherPub, export, sig},
+	}
+	for _, test := range testcases {
+		_, err := Verify(test.key, test.export, test.sig)
+		if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected invalid signature, got %v", test.name, err)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedPriv, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+	if err != nil {
+		t.Fatalf("unexpected error parsing private key: %s", err)
+	}
+	if !bytes.Equal(parsedPriv, priv) {
+		t.Errorf("parsed private key does not match")
+	}
+
+	b, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedPub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+	if err != nil {
+		t.Fatalf("unexpected error parsing public key: %s", err)
+	}
+	if !bytes.Equal(parsedPub, pub) {
+		t.Errorf("parsed public key does not match")
+	}
+
+	_, err = ParsePublicKey([]byte("not a key"))
+	if err == nil {
+		t.Errorf("expected error parsing invalid public key")
+	}
+}
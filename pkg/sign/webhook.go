@@ -0,0 +1,146 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookHeader is the header holding the signature of a webhook
+	WebhookHeader = "Trident-Signature"
+
+	// WebhookScheme is the key of the signatures in WebhookHeader
+	WebhookScheme = "v1"
+)
+
+// WebhookTolerance is how far the timestamp of a webhook signature may be
+// from the receiver's clock before VerifyWebhook rejects it, which limits how
+// long a captured request can be replayed.
+var WebhookTolerance = 5 * time.Minute
+
+// WebhookSignature is the parsed value of WebhookHeader, which has the form
+//
+//	t=<timestamp>,v1=<signature>
+//
+// where the timestamp is the signing time in seconds since the Unix epoch and
+// the signature is the hex encoded HMAC-SHA256, keyed with the shared secret,
+// of the timestamp, a period, and the exact bytes of the request body:
+//
+//	<timestamp>.<body>
+//
+// The body is signed as sent, so a receiver must verify the raw body before
+// decoding it. The header may hold several v1 signatures, such as while the
+// secret is rotated, and a receiver accepts the request if any of them
+// matches. Keys other than t and v1 are ignored.
+type WebhookSignature struct {
+	Timestamp  time.Time
+	Signatures [][]byte
+}
+
+// webhookMAC returns the HMAC-SHA256 of the timestamp and body.
+func webhookMAC(secret string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + ".")) // nolint:errcheck
+	mac.Write(body)                                           // nolint:errcheck
+	return mac.Sum(nil)
+}
+
+// SignWebhook returns the WebhookHeader value signing the body with the
+// shared secret at the given time.
+func SignWebhook(secret string, body []byte, now time.Time) string {
+	timestamp := now.Unix()
+	return fmt.Sprintf("t=%d,%s=%s", timestamp, WebhookScheme,
+		hex.EncodeToString(webhookMAC(secret, timestamp, body)))
+}
+
+// ParseWebhookSignature parses the value of WebhookHeader. It does not check
+// the signatures.
+func ParseWebhookSignature(header string) (*WebhookSignature, error) {
+	var sig WebhookSignature
+	var hasTimestamp bool
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("webhook signature %q is not a list of key=value pairs", header)
+		}
+		switch kv[0] {
+		case "t":
+			if hasTimestamp {
+				return nil, fmt.Errorf("webhook signature has more than one timestamp")
+			}
+			seconds, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing webhook signature timestamp: %w", err)
+			}
+			sig.Timestamp = time.Unix(seconds, 0)
+			hasTimestamp = true
+		case WebhookScheme:
+			mac, err := hex.DecodeString(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("error parsing webhook signature: %w", err)
+			}
+			sig.Signatures = append(sig.Signatures, mac)
+		}
+	}
+	if !hasTimestamp {
+		return nil, fmt.Errorf("webhook signature has no timestamp")
+	}
+	if len(sig.Signatures) == 0 {
+		return nil, fmt.Errorf("webhook signature has no %s signature", WebhookScheme)
+	}
+	return &sig, nil
+}
+
+// Verify reports whether any of the signatures is that of the body under the
+// shared secret, and the timestamp is within WebhookTolerance of now.
+func (s *WebhookSignature) Verify(secret string, body []byte, now time.Time) bool {
+	if secret == "" {
+		return false
+	}
+	if skew := now.Sub(s.Timestamp); skew > WebhookTolerance || skew < -WebhookTolerance {
+		return false
+	}
+	expected := webhookMAC(secret, s.Timestamp.Unix(), body)
+	for _, mac := range s.Signatures {
+		if hmac.Equal(mac, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyWebhook reports whether signatureHeader, the value of WebhookHeader,
+// is a valid and current signature of the request body under the shared
+// secret. A receiver should reject the request unless it returns true:
+//
+//	body, err := ioutil.ReadAll(r.Body)
+//	if err != nil || !sign.VerifyWebhook(secret, r.Header.Get(sign.WebhookHeader), body) {
+//		http.Error(w, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+func VerifyWebhook(secret, signatureHeader string, body []byte) bool {
+	sig, err := ParseWebhookSignature(signatureHeader)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(secret, body, time.Now())
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Praetorian Security, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sign
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	body := []byte(`{"result_id":1,"username":"alice@example.org"}`)
+	now := time.Now()
+	header := SignWebhook("s3cret", body, now)
+	if !strings.HasPrefix(header, "t=") || !strings.Contains(header, ",v1=") {
+		t.Fatalf("unexpected header %q", header)
+	}
+	if !VerifyWebhook("s3cret", header, body) {
+		t.Error("expected the signature to verify")
+	}
+
+	var testcases = []struct {
+		name   string
+		secret string
+		header string
+		body   string
+	}{
+		{"wrong secret", "other", header, string(body)},
+		{"altered body", "s3cret", header, `{"result_id":2,"username":"alice@example.org"}`},
+		{"empty secret", "", SignWebhook("", body, now), string(body)},
+		{"stale", "s3cret", SignWebhook("s3cret", body, now.Add(-time.Hour)), string(body)},
+		{"future", "s3cret", SignWebhook("s3cret", body, now.Add(time.Hour)), string(body)},
+		{"no timestamp", "s3cret", header[strings.Index(header, ",")+1:], string(body)},
+		{"malformed", "s3cret", "v1", string(body)},
+		{"empty", "s3cret", "", string(body)},
+	}
+	for _, test := range testcases {
+		if VerifyWebhook(test.secret, test.header, []byte(test.body)) {
+			t.Errorf("%s: expected the signature to be rejected", test.name)
+		}
+	}
+
+	// any of several signatures may match, and unknown schemes are ignored
+	rotated := SignWebhook("old", body, now) + ",v0=ignored," + header[strings.Index(header, ",")+1:]
+	if !VerifyWebhook("s3cret", rotated, body) {
+		t.Errorf("expected %q to verify", rotated)
+	}
+}
+
+// TestWebhookCanonical checks the signature against one computed by hand, so
+// the documented scheme stays the one that is implemented.
+func TestWebhookCanonical(t *testing.T) {
+	// echo -n '1600000000.{}' | openssl dgst -sha256 -hmac key
+	header := SignWebhook("key", []byte("{}"), time.Unix(1600000000, 0))
+	expected := "t=1600000000,v1=" +
+		"068d0b330e50151f36064ff01089c224ffc1fc64ce4803e1296c5ba8e88de732"
+	if header != expected {
+		t.Errorf("signature was %s, expected %s", header, expected)
+	}
+
+	sig, err := ParseWebhookSignature(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sig.Timestamp.Equal(time.Unix(1600000000, 0)) || len(sig.Signatures) != 1 {
+		t.Errorf("unexpected signature %+v", sig)
+	}
+}
@@ -47,7 +47,13 @@ func NewWebhookServer() (*Server, error) {
 func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {}
 
 func httperr(w http.ResponseWriter, err error) {
-	res := event.ErrorResponse{ErrorMsg: err.Error()}
+	categorized(w, "", err.Error())
+}
+
+// categorized writes an ErrorResponse for a failed attempt so the dispatcher
+// can record it as an error result.
+func categorized(w http.ResponseWriter, category, msg string) {
+	res := event.ErrorResponse{ErrorMsg: msg, Category: category}
 	w.WriteHeader(500)
 	json.NewEncoder(w).Encode(&res) // nolint:errcheck,gosec
 }
@@ -65,22 +71,34 @@ func (s *Server) EventHandler(w http.ResponseWriter, r *http.Request) {
 
 	noz, err := nozzle.Open(req.Provider, req.ProviderMetadata)
 	if err != nil {
-		httperr(w, fmt.Errorf("error opening nozzle: %w", err))
+		categorized(w, nozzle.ErrorConfig, nozzle.SanitizeError(
+			fmt.Errorf("error opening nozzle: %w", err), req.Password))
 		return
 	}
 
 	ts := time.Now()
 	res, err := noz.Login(req.Username, req.Password)
+	latency := time.Since(ts)
 	if err != nil {
-		httperr(w, fmt.Errorf("error authenticating to %s provider: %w", req.Provider, err))
+		categorized(w, nozzle.ErrorCategory(err), nozzle.SanitizeError(
+			fmt.Errorf("error authenticating to %s provider: %w", req.Provider, err), req.Password))
 		return
 	}
 
+	// the response to a valid credential is only returned if the campaign
+	// opted in, and never with the password in it
+	if req.CaptureOnValid && res.Valid && res.Capture != nil {
+		nozzle.RedactCapture(res.Capture, req.Password)
+	} else {
+		res.Capture = nil
+	}
+
 	// fill in generic AuthResult values
 	res.CampaignID = req.CampaignID
 	res.Username = req.Username
 	res.Password = req.Password
 	res.Timestamp = ts
+	res.Latency = latency
 	res.IP = s.ip
 
 	json.NewEncoder(w).Encode(&res) // nolint:errcheck,gosec